@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
@@ -9,12 +11,16 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime/debug"
 	"syscall"
 	"time"
 
 	"github.com/lomik/carbon-clickhouse/carbon"
 	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/replay"
+	"github.com/lomik/carbon-clickhouse/uploader"
+	"github.com/lomik/carbon-clickhouse/writer"
 	"github.com/lomik/zapwriter"
 	"go.uber.org/zap"
 
@@ -39,9 +45,195 @@ func httpServe(addr string) (func(), error) {
 	return func() { listener.Close() }, nil
 }
 
+// repair is "carbon-clickhouse repair" subcommand
+func repair(args []string) {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	outDir := fs.String("out-dir", "", "Directory for repaired files. Damaged files are replaced in place if empty")
+	zstdDict := fs.String("zstd-dictionary", "", "zstd dictionary for files compressed with dictionary")
+	encryptionKeys := fs.String("encryption-key-file", "", "Encryption keys for encrypted files. Repaired files are encrypted with last key")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s repair [options] file...\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Recover valid records from corrupted chunk files. Report of every file is printed to stdout in JSON")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	if *zstdDict != "" {
+		if _, err := RowBinary.LoadZstdDictionary(*zstdDict); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var key *RowBinary.EncryptionKey
+	if *encryptionKeys != "" {
+		var err error
+		if key, err = RowBinary.LoadEncryptionKeys(*encryptionKeys); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	failed := false
+	enc := json.NewEncoder(os.Stdout)
+
+	for _, filename := range fs.Args() {
+		var report *RowBinary.RepairReport
+		var err error
+
+		if *outDir == "" {
+			report, err = RowBinary.RepairEncryptedFile(filename, key)
+		} else {
+			report, err = repairTo(filename, filepath.Join(*outDir, RowBinary.RepairedFilename(filepath.Base(filename))), key)
+		}
+
+		if err != nil {
+			failed = true
+			fmt.Fprintf(os.Stderr, "%s: %s\n", filename, err.Error())
+		}
+		enc.Encode(report)
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func repairTo(filename string, output string, key *RowBinary.EncryptionKey) (*RowBinary.RepairReport, error) {
+	out, err := os.Create(output)
+	if err != nil {
+		return &RowBinary.RepairReport{Filename: filename}, err
+	}
+
+	report, err := RowBinary.RepairTo(filename, out, key)
+	report.Output = output
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return report, err
+}
+
+// replayCmd is "carbon-clickhouse replay" subcommand
+func replayCmd(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	configFile := fs.String("config", "/etc/carbon-clickhouse/carbon-clickhouse.conf", "Filename of config")
+	uploaderName := fs.String("uploader", "", "Name of uploader ([upload.<name>] section of config)")
+	from := fs.String("from", "", "Start of time range of archived chunks (RFC3339, 2006-01-02 or unix timestamp)")
+	until := fs.String("until", "", "End of time range of archived chunks, now by default")
+	archivePath := fs.String("archive-path", "", "Archive directory, data.archive-path of config by default")
+	rate := fs.Int("rate", 0, "Limit of uploaded metrics per second, 0 - unlimited")
+	retries := fs.Int("retries", 3, "Retries of failed chunk upload before stop")
+	progressFile := fs.String("progress", "", "File with list of uploaded chunks. Uploaded chunks are skipped, replay can be resumed with same file")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s replay -uploader <name> [options] (-from <time> [-until <time>] | file...)\n", os.Args[0])
+		fmt.Fprintln(fs.Output(), "Upload archived or external chunks with uploader of config. Data directory and upload status of live chunks are not changed")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *uploaderName == "" || (*from == "") == (fs.NArg() == 0) {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := carbon.ReadConfig(*configFile, false)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	upConfig, ok := cfg.Upload[*uploaderName]
+	if !ok {
+		log.Fatalf("uploader %#v not found in config", *uploaderName)
+	}
+
+	logging := zapwriter.NewConfig()
+	logging.File = "stderr"
+	if err = zapwriter.ApplyConfig([]zapwriter.Config{logging}); err != nil {
+		log.Fatal(err)
+	}
+
+	files := fs.Args()
+	if *from != "" {
+		if *archivePath == "" {
+			*archivePath = cfg.Data.ArchivePath
+		}
+		if *archivePath == "" {
+			log.Fatal("archive path is not set")
+		}
+		fromTime, err := replay.ParseTime(*from)
+		if err != nil {
+			log.Fatal(err)
+		}
+		untilTime := time.Now()
+		if *until != "" {
+			if untilTime, err = replay.ParseTime(*until); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if files, err = writer.ArchivedChunks(*archivePath, fromTime, untilTime); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if cfg.Data.CompDict != "" {
+		if _, err = RowBinary.LoadZstdDictionary(cfg.Data.CompDict); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if cfg.Data.EncryptKeys != "" {
+		if _, err = RowBinary.LoadEncryptionKeys(cfg.Data.EncryptKeys); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// upload status of replay is kept in memory
+	up, err := uploader.New(os.TempDir(), *uploaderName, upConfig, nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	r := up.(uploader.Replayer)
+	if err = r.StartReplay(); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGTERM, syscall.SIGINT)
+		<-c
+		cancel()
+	}()
+
+	report, err := replay.Run(ctx, r, files, replay.Options{
+		Rate:       *rate,
+		Retries:    *retries,
+		RetryDelay: time.Second,
+		Progress:   *progressFile,
+	})
+	r.Stop()
+
+	json.NewEncoder(os.Stdout).Encode(report)
+	if err != nil {
+		os.Exit(1)
+	}
+}
+
 func main() {
 	var err error
 
+	if len(os.Args) > 1 && os.Args[1] == "repair" {
+		repair(os.Args[2:])
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		replayCmd(os.Args[2:])
+		return
+	}
+
 	/* CONFIG start */
 
 	configFile := flag.String("config", "/etc/carbon-clickhouse/carbon-clickhouse.conf", "Filename of config")
@@ -51,6 +243,8 @@ func main() {
 	printVersion := flag.Bool("version", false, "Print version")
 	cat := flag.String("cat", "", "Print RowBinary file in TabSeparated format")
 	bincat := flag.String("recover", "", "Read all good records from corrupted data file. Write binary data to stdout")
+	zstdDict := flag.String("zstd-dictionary", "", "zstd dictionary for -cat and -recover of files compressed with dictionary")
+	encryptionKeys := flag.String("encryption-key-file", "", "Encryption keys for -cat and -recover of encrypted files")
 
 	flag.Parse()
 
@@ -59,6 +253,18 @@ func main() {
 		return
 	}
 
+	if *zstdDict != "" {
+		if _, err = RowBinary.LoadZstdDictionary(*zstdDict); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *encryptionKeys != "" {
+		if _, err = RowBinary.LoadEncryptionKeys(*encryptionKeys); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	if *cat != "" {
 		reader, err := RowBinary.NewReader(*cat, false)
 		if err != nil {
@@ -68,6 +274,9 @@ func main() {
 		for {
 			metric, err := reader.ReadRecord()
 			if err != nil {
+				if n := reader.CorruptedBlocks(); n > 0 {
+					fmt.Fprintf(os.Stderr, "%d corrupted blocks skipped\n", n)
+				}
 				if err == io.EOF {
 					return
 				}
@@ -90,7 +299,9 @@ func main() {
 			log.Fatal(err)
 		}
 
-		io.Copy(os.Stdout, reader)
+		if _, err = io.Copy(os.Stdout, reader); err != nil {
+			log.Fatal(err)
+		}
 		return
 	}
 
@@ -13,7 +13,9 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/backpressure"
 	"github.com/lomik/carbon-clickhouse/receiver"
+	"github.com/lomik/carbon-clickhouse/state"
 	"github.com/lomik/carbon-clickhouse/uploader"
 	"github.com/lomik/carbon-clickhouse/writer"
 	"github.com/lomik/zapwriter"
@@ -24,12 +26,18 @@ type App struct {
 	Config           *Config
 	Writer           *writer.Writer
 	Uploaders        map[string]uploader.Uploader
+	State            state.State
 	UDP              receiver.Receiver
 	TCP              receiver.Receiver
 	Pickle           receiver.Receiver
+	Http             receiver.Receiver
+	Protobuf         receiver.Receiver
 	Grpc             receiver.Receiver
 	Prometheus       receiver.Receiver
 	TelegrafHttpJson receiver.Receiver
+	Datadog          receiver.Receiver
+	Collectd         receiver.Receiver
+	Backpressure     *backpressure.Backpressure
 	Collector        *Collector // (!!!) Should be re-created on every change config/modules
 	writeChan        chan *RowBinary.WriteBuffer
 	exit             chan bool
@@ -111,6 +119,18 @@ func (app *App) stopListeners() {
 		logger.Debug("finished", zap.String("module", "pickle"))
 	}
 
+	if app.Http != nil {
+		app.Http.Stop()
+		app.Http = nil
+		logger.Debug("finished", zap.String("module", "http"))
+	}
+
+	if app.Protobuf != nil {
+		app.Protobuf.Stop()
+		app.Protobuf = nil
+		logger.Debug("finished", zap.String("module", "protobuf"))
+	}
+
 	if app.UDP != nil {
 		app.UDP.Stop()
 		app.UDP = nil
@@ -134,6 +154,18 @@ func (app *App) stopListeners() {
 		app.TelegrafHttpJson = nil
 		logger.Debug("finished", zap.String("module", "telegraf_http_json"))
 	}
+
+	if app.Datadog != nil {
+		app.Datadog.Stop()
+		app.Datadog = nil
+		logger.Debug("finished", zap.String("module", "datadog"))
+	}
+
+	if app.Collectd != nil {
+		app.Collectd.Stop()
+		app.Collectd = nil
+		logger.Debug("finished", zap.String("module", "collectd"))
+	}
 }
 
 func (app *App) stopAll() {
@@ -141,6 +173,12 @@ func (app *App) stopAll() {
 
 	app.stopListeners()
 
+	if app.Backpressure != nil {
+		app.Backpressure.Stop()
+		app.Backpressure = nil
+		logger.Debug("finished", zap.String("module", "backpressure"))
+	}
+
 	if app.Collector != nil {
 		app.Collector.Stop()
 		app.Collector = nil
@@ -161,6 +199,14 @@ func (app *App) stopAll() {
 		app.Uploaders = nil
 	}
 
+	if app.State != nil {
+		if err := app.State.Close(); err != nil {
+			logger.Error("state close failed", zap.Error(err))
+		}
+		app.State = nil
+		logger.Debug("finished", zap.String("module", "state"))
+	}
+
 	if app.exit != nil {
 		close(app.exit)
 		app.exit = nil
@@ -190,41 +236,48 @@ func (app *App) Start() (err error) {
 
 	runtime.GOMAXPROCS(conf.Common.MaxCPU)
 
-	app.writeChan = make(chan *RowBinary.WriteBuffer)
+	app.writeChan = make(chan *RowBinary.WriteBuffer, conf.Backpressure.QueueSize)
 
 	/* WRITER start */
 	uploaders := make([]string, 0, len(conf.Upload))
 	for t := range conf.Upload {
 		uploaders = append(uploaders, t)
 	}
+	// status of shards is removed with chunk, but isn't required for chunk removal
+	stateUploaders := append([]string(nil), uploaders...)
+	for t, c := range conf.Upload {
+		stateUploaders = append(stateUploaders, uploader.ShardStateNames(t, c)...)
+	}
 
 	conf.Data.AutoInterval.SetDefault(conf.Data.FileInterval.Value())
 
-	if err := os.MkdirAll(conf.Data.Path, 0755); err != nil {
-		return err
-	}
+	paths := conf.Data.Paths
+	if len(paths) == 1 {
+		if err := os.MkdirAll(paths[0], 0755); err != nil {
+			return err
+		}
 
-	app.Writer = writer.New(
-		app.writeChan,
-		conf.Data.Path,
-		conf.Data.ChunkMaxSize.Value(),
-		conf.Data.AutoInterval,
-		conf.Data.CompAlgo.CompAlgo,
-		conf.Data.CompLevel,
-		uploaders,
-		nil,
-	)
-	app.Writer.Start()
-	/* WRITER end */
+		if app.State, err = state.New(conf.Data.StateBackend, paths[0], stateUploaders); err != nil {
+			return err
+		}
+	} else {
+		// unavailable disk is skipped
+		st, err := state.NewMulti(conf.Data.StateBackend, paths, stateUploaders)
+		if err != nil {
+			return err
+		}
+		app.State = st
+		paths = st.Paths()
+	}
 
-	/* UPLOADER start */
+	// uploaders are started after writer
 	app.Uploaders = make(map[string]uploader.Uploader)
 	for uploaderName, uploaderConfig := range conf.Upload {
-		uploaderDir := filepath.Join(conf.Data.Path, uploaderName)
+		uploaderDir := filepath.Join(paths[0], uploaderName)
 		if err := os.MkdirAll(uploaderDir, 0755); err != nil {
 			return err
 		}
-		up, err := uploader.New(uploaderDir, uploaderName, uploaderConfig)
+		up, err := uploader.New(uploaderDir, uploaderName, uploaderConfig, app.State)
 		if err != nil {
 			return err
 		}
@@ -240,6 +293,89 @@ func (app *App) Start() (err error) {
 		}
 	}
 
+	fastPaths := make(map[string]writer.FastPath)
+	notifiers := make(map[string]writer.Notifier)
+	for uploaderName, up := range app.Uploaders {
+		if fp, ok := up.(writer.FastPath); ok && conf.Upload[uploaderName].FastPath {
+			fastPaths[uploaderName] = fp
+		}
+		if n, ok := up.(writer.Notifier); ok {
+			notifiers[uploaderName] = n
+		}
+	}
+
+	var compDict []byte
+	if conf.Data.CompDict != "" {
+		if compDict, err = RowBinary.LoadZstdDictionary(conf.Data.CompDict); err != nil {
+			return err
+		}
+	}
+
+	var encryptionKey *RowBinary.EncryptionKey
+	if conf.Data.EncryptKeys != "" {
+		if encryptionKey, err = RowBinary.LoadEncryptionKeys(conf.Data.EncryptKeys); err != nil {
+			return err
+		}
+	}
+
+	app.Writer = writer.New(
+		app.writeChan,
+		paths[0],
+		conf.Data.ChunkMaxSize.Value(),
+		conf.Data.AutoInterval,
+		conf.Data.CompAlgo.CompAlgo,
+		conf.Data.CompLevel,
+		compDict,
+		uploaders,
+		nil,
+		writer.DiskQuota(
+			conf.Data.MaxDiskUsage.Value(),
+			conf.Data.MinFreeSpace.Value(),
+			conf.Data.Overflow,
+		),
+		writer.Fsync(conf.Data.Fsync, conf.Data.FsyncPeriod.Value()),
+		writer.ChunkFormat(conf.Data.ChunkFormat),
+		writer.Encryption(encryptionKey),
+		writer.RepairOnStart(conf.Data.Repair),
+		writer.Threads(conf.Data.Threads),
+		writer.DataPaths(paths, conf.Data.Placement),
+		writer.Archive(
+			conf.Data.ArchivePath,
+			conf.Data.ArchiveRet.Value(),
+			conf.Data.ArchiveComp != "",
+			conf.Data.archiveComp.CompAlgo,
+			conf.Data.ArchiveLevel,
+		),
+		writer.Align(conf.Data.Align),
+		writer.Routes(conf.Data.routes...),
+		writer.State(app.State),
+		writer.FastPaths(fastPaths),
+		writer.Notify(notifiers),
+	)
+	app.Writer.Start()
+	/* WRITER end */
+
+	/* BACKPRESSURE start */
+	if conf.Backpressure.Enabled {
+		app.Backpressure = backpressure.New(
+			conf.Backpressure.RetryAfter.Value(),
+			conf.Backpressure.HTTPStatus,
+			backpressure.Check{
+				Name:  "queue",
+				Value: func() int { return len(app.writeChan) },
+				Limit: conf.Backpressure.MaxQueue,
+			},
+			backpressure.Check{
+				Name:  "unhandled",
+				Value: app.Writer.Unhandled,
+				Limit: conf.Backpressure.MaxUnhandled,
+			},
+		)
+		app.Backpressure.Start()
+	}
+	/* BACKPRESSURE end */
+
+	/* UPLOADER start */
 	for _, uploader := range app.Uploaders {
 		uploader.Start()
 	}
@@ -252,6 +388,7 @@ func (app *App) Start() (err error) {
 			app.Config.TagDesc,
 			receiver.ParseThreads(runtime.GOMAXPROCS(-1)*2),
 			receiver.WriteChan(app.writeChan),
+			receiver.Backpressure(app.Backpressure),
 			receiver.DropFuture(uint32(conf.Tcp.DropFuture.Value().Seconds())),
 			receiver.DropPast(uint32(conf.Tcp.DropPast.Value().Seconds())),
 			receiver.DropLongerThan(conf.Tcp.DropLongerThan),
@@ -271,6 +408,7 @@ func (app *App) Start() (err error) {
 			app.Config.TagDesc,
 			receiver.ParseThreads(runtime.GOMAXPROCS(-1)*2),
 			receiver.WriteChan(app.writeChan),
+			receiver.Backpressure(app.Backpressure),
 			receiver.DropFuture(uint32(conf.Udp.DropFuture.Value().Seconds())),
 			receiver.DropPast(uint32(conf.Udp.DropPast.Value().Seconds())),
 			receiver.DropLongerThan(conf.Udp.DropLongerThan),
@@ -289,6 +427,7 @@ func (app *App) Start() (err error) {
 			app.Config.TagDesc,
 			receiver.ParseThreads(runtime.GOMAXPROCS(-1)*2),
 			receiver.WriteChan(app.writeChan),
+			receiver.Backpressure(app.Backpressure),
 			receiver.DropFuture(uint32(conf.Pickle.DropFuture.Value().Seconds())),
 			receiver.DropPast(uint32(conf.Pickle.DropPast.Value().Seconds())),
 			receiver.DropLongerThan(conf.Pickle.DropLongerThan),
@@ -301,14 +440,61 @@ func (app *App) Start() (err error) {
 		http.HandleFunc("/debug/receive/pickle/dropped/", app.Pickle.DroppedHandler)
 	}
 
+	if conf.Http.Enabled {
+		app.Http, err = receiver.New(
+			"http://"+conf.Http.Listen,
+			app.Config.TagDesc,
+			receiver.WriteChan(app.writeChan),
+			receiver.Backpressure(app.Backpressure),
+			receiver.DropFuture(uint32(conf.Http.DropFuture.Value().Seconds())),
+			receiver.DropPast(uint32(conf.Http.DropPast.Value().Seconds())),
+			receiver.DropLongerThan(conf.Http.DropLongerThan),
+			receiver.SyncWrite(conf.Http.SyncWrite),
+			receiver.MaxBodySize(conf.Http.MaxBodySize.Value()),
+		)
+
+		if err != nil {
+			return
+		}
+
+		http.HandleFunc("/debug/receive/http/dropped/", app.Http.DroppedHandler)
+	}
+
+	if conf.Protobuf.Enabled {
+		app.Protobuf, err = receiver.New(
+			"protobuf://"+conf.Protobuf.Listen,
+			app.Config.TagDesc,
+			receiver.ParseThreads(runtime.GOMAXPROCS(-1)*2),
+			receiver.WriteChan(app.writeChan),
+			receiver.Backpressure(app.Backpressure),
+			receiver.DropFuture(uint32(conf.Protobuf.DropFuture.Value().Seconds())),
+			receiver.DropPast(uint32(conf.Protobuf.DropPast.Value().Seconds())),
+			receiver.DropLongerThan(conf.Protobuf.DropLongerThan),
+		)
+
+		if err != nil {
+			return
+		}
+
+		http.HandleFunc("/debug/receive/protobuf/dropped/", app.Protobuf.DroppedHandler)
+	}
+
 	if conf.Grpc.Enabled {
 		app.Grpc, err = receiver.New(
 			"grpc://"+conf.Grpc.Listen,
 			app.Config.TagDesc,
 			receiver.WriteChan(app.writeChan),
+			receiver.Backpressure(app.Backpressure),
 			receiver.DropFuture(uint32(conf.Grpc.DropFuture.Value().Seconds())),
 			receiver.DropPast(uint32(conf.Grpc.DropPast.Value().Seconds())),
 			receiver.DropLongerThan(conf.Grpc.DropLongerThan),
+			receiver.GrpcMaxMessageSize(int(conf.Grpc.MaxMessageSize.Value())),
+			receiver.GrpcKeepalive(
+				conf.Grpc.KeepaliveTime.Value(),
+				conf.Grpc.KeepaliveTimeout.Value(),
+				conf.Grpc.KeepaliveMinTime.Value(),
+			),
+			receiver.GrpcCompression(conf.Grpc.Compression),
 		)
 
 		if err != nil {
@@ -323,9 +509,11 @@ func (app *App) Start() (err error) {
 			"prometheus://"+conf.Prometheus.Listen,
 			app.Config.TagDesc,
 			receiver.WriteChan(app.writeChan),
+			receiver.Backpressure(app.Backpressure),
 			receiver.DropFuture(uint32(conf.Prometheus.DropFuture.Value().Seconds())),
 			receiver.DropPast(uint32(conf.Prometheus.DropPast.Value().Seconds())),
 			receiver.DropLongerThan(conf.Prometheus.DropLongerThan),
+			receiver.SyncWrite(conf.Prometheus.SyncWrite),
 		)
 
 		if err != nil {
@@ -340,10 +528,12 @@ func (app *App) Start() (err error) {
 			"telegraf+http+json://"+conf.TelegrafHttpJson.Listen,
 			app.Config.TagDesc,
 			receiver.WriteChan(app.writeChan),
+			receiver.Backpressure(app.Backpressure),
 			receiver.DropFuture(uint32(conf.TelegrafHttpJson.DropFuture.Value().Seconds())),
 			receiver.DropPast(uint32(conf.TelegrafHttpJson.DropPast.Value().Seconds())),
 			receiver.DropLongerThan(conf.TelegrafHttpJson.DropLongerThan),
 			receiver.ConcatChar(conf.TelegrafHttpJson.Concat),
+			receiver.SyncWrite(conf.TelegrafHttpJson.SyncWrite),
 		)
 
 		if err != nil {
@@ -352,6 +542,48 @@ func (app *App) Start() (err error) {
 
 		http.HandleFunc("/debug/receive/telegraf_http_json/dropped/", app.TelegrafHttpJson.DroppedHandler)
 	}
+
+	if conf.Datadog.Enabled {
+		app.Datadog, err = receiver.New(
+			"datadog://"+conf.Datadog.Listen,
+			app.Config.TagDesc,
+			receiver.WriteChan(app.writeChan),
+			receiver.Backpressure(app.Backpressure),
+			receiver.DropFuture(uint32(conf.Datadog.DropFuture.Value().Seconds())),
+			receiver.DropPast(uint32(conf.Datadog.DropPast.Value().Seconds())),
+			receiver.DropLongerThan(conf.Datadog.DropLongerThan),
+			receiver.MaxBodySize(conf.Datadog.MaxBodySize.Value()),
+		)
+
+		if err != nil {
+			return
+		}
+
+		http.HandleFunc("/debug/receive/datadog/dropped/", app.Datadog.DroppedHandler)
+	}
+
+	if conf.Collectd.Enabled {
+		app.Collectd, err = receiver.New(
+			"collectd://"+conf.Collectd.Listen,
+			app.Config.TagDesc,
+			receiver.ParseThreads(runtime.GOMAXPROCS(-1)*2),
+			receiver.WriteChan(app.writeChan),
+			receiver.Backpressure(app.Backpressure),
+			receiver.DropFuture(uint32(conf.Collectd.DropFuture.Value().Seconds())),
+			receiver.DropPast(uint32(conf.Collectd.DropPast.Value().Seconds())),
+			receiver.DropLongerThan(conf.Collectd.DropLongerThan),
+			receiver.CollectdTemplate(conf.Collectd.Template),
+			receiver.CollectdSecurityLevel(conf.Collectd.SecurityLevel),
+			receiver.CollectdAuthFile(conf.Collectd.AuthFile),
+			receiver.CollectdTypesDB(conf.Collectd.TypesDB),
+		)
+
+		if err != nil {
+			return
+		}
+
+		http.HandleFunc("/debug/receive/collectd/dropped/", app.Collectd.DroppedHandler)
+	}
 	/* RECEIVER end */
 
 	/* COLLECTOR start */
@@ -85,6 +85,10 @@ func NewCollector(app *App) *Collector {
 		c.stats = append(c.stats, moduleCallback("writer", app.Writer))
 	}
 
+	if app.Backpressure != nil {
+		c.stats = append(c.stats, moduleCallback("backpressure", app.Backpressure))
+	}
+
 	if app.TCP != nil {
 		c.stats = append(c.stats, moduleCallback("tcp", app.TCP))
 	}
@@ -93,6 +97,14 @@ func NewCollector(app *App) *Collector {
 		c.stats = append(c.stats, moduleCallback("pickle", app.Pickle))
 	}
 
+	if app.Http != nil {
+		c.stats = append(c.stats, moduleCallback("http", app.Http))
+	}
+
+	if app.Protobuf != nil {
+		c.stats = append(c.stats, moduleCallback("protobuf", app.Protobuf))
+	}
+
 	if app.UDP != nil {
 		c.stats = append(c.stats, moduleCallback("udp", app.UDP))
 	}
@@ -105,6 +117,14 @@ func NewCollector(app *App) *Collector {
 		c.stats = append(c.stats, moduleCallback("telegraf_http_json", app.TelegrafHttpJson))
 	}
 
+	if app.Datadog != nil {
+		c.stats = append(c.stats, moduleCallback("datadog", app.Datadog))
+	}
+
+	if app.Collectd != nil {
+		c.stats = append(c.stats, moduleCallback("collectd", app.Collectd))
+	}
+
 	for n, u := range app.Uploaders {
 		c.stats = append(c.stats, moduleCallback(fmt.Sprintf("upload.%s", n), u))
 	}
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"net/http"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -11,7 +13,10 @@ import (
 	rb "github.com/lomik/carbon-clickhouse/helper/RowBinary"
 	"github.com/lomik/carbon-clickhouse/helper/config"
 	"github.com/lomik/carbon-clickhouse/helper/tags"
+	"github.com/lomik/carbon-clickhouse/receiver"
+	"github.com/lomik/carbon-clickhouse/state"
 	"github.com/lomik/carbon-clickhouse/uploader"
+	"github.com/lomik/carbon-clickhouse/writer"
 	"github.com/lomik/zapwriter"
 )
 
@@ -57,12 +62,35 @@ type pickleConfig struct {
 	DropLongerThan uint16           `toml:"drop-longer-than"`
 }
 
-type grpcConfig struct {
+type httpConfig struct {
 	Listen         string           `toml:"listen"`
 	Enabled        bool             `toml:"enabled"`
 	DropFuture     *config.Duration `toml:"drop-future"`
 	DropPast       *config.Duration `toml:"drop-past"`
 	DropLongerThan uint16           `toml:"drop-longer-than"`
+	SyncWrite      bool             `toml:"sync-write"`
+	MaxBodySize    config.Size      `toml:"max-body-size"`
+}
+
+type protobufConfig struct {
+	Listen         string           `toml:"listen"`
+	Enabled        bool             `toml:"enabled"`
+	DropFuture     *config.Duration `toml:"drop-future"`
+	DropPast       *config.Duration `toml:"drop-past"`
+	DropLongerThan uint16           `toml:"drop-longer-than"`
+}
+
+type grpcConfig struct {
+	Listen           string           `toml:"listen"`
+	Enabled          bool             `toml:"enabled"`
+	DropFuture       *config.Duration `toml:"drop-future"`
+	DropPast         *config.Duration `toml:"drop-past"`
+	DropLongerThan   uint16           `toml:"drop-longer-than"`
+	MaxMessageSize   config.Size      `toml:"max-message-size"`
+	KeepaliveTime    *config.Duration `toml:"keepalive-time"`
+	KeepaliveTimeout *config.Duration `toml:"keepalive-timeout"`
+	KeepaliveMinTime *config.Duration `toml:"keepalive-min-time"`
+	Compression      string           `toml:"compression"`
 }
 
 type promConfig struct {
@@ -71,6 +99,7 @@ type promConfig struct {
 	DropFuture     *config.Duration `toml:"drop-future"`
 	DropPast       *config.Duration `toml:"drop-past"`
 	DropLongerThan uint16           `toml:"drop-longer-than"`
+	SyncWrite      bool             `toml:"sync-write"`
 }
 
 type telegrafHttpJsonConfig struct {
@@ -80,6 +109,28 @@ type telegrafHttpJsonConfig struct {
 	DropPast       *config.Duration `toml:"drop-past"`
 	DropLongerThan uint16           `toml:"drop-longer-than"`
 	Concat         string           `toml:"concat"`
+	SyncWrite      bool             `toml:"sync-write"`
+}
+
+type datadogConfig struct {
+	Listen         string           `toml:"listen"`
+	Enabled        bool             `toml:"enabled"`
+	DropFuture     *config.Duration `toml:"drop-future"`
+	DropPast       *config.Duration `toml:"drop-past"`
+	DropLongerThan uint16           `toml:"drop-longer-than"`
+	MaxBodySize    config.Size      `toml:"max-body-size"`
+}
+
+type collectdConfig struct {
+	Listen         string           `toml:"listen"`
+	Enabled        bool             `toml:"enabled"`
+	DropFuture     *config.Duration `toml:"drop-future"`
+	DropPast       *config.Duration `toml:"drop-past"`
+	DropLongerThan uint16           `toml:"drop-longer-than"`
+	Template       string           `toml:"template"`
+	SecurityLevel  string           `toml:"security-level"`
+	AuthFile       string           `toml:"auth-file"`
+	TypesDB        []string         `toml:"typesdb"`
 }
 
 type pprofConfig struct {
@@ -87,14 +138,54 @@ type pprofConfig struct {
 	Enabled bool   `toml:"enabled"`
 }
 
+type backpressureConfig struct {
+	Enabled      bool             `toml:"enabled"`
+	QueueSize    int              `toml:"queue-size"`
+	MaxQueue     int              `toml:"max-queue"`
+	MaxUnhandled int              `toml:"max-unhandled"`
+	RetryAfter   *config.Duration `toml:"retry-after"`
+	HTTPStatus   int              `toml:"http-status"`
+}
+
+type routeConfig struct {
+	Name       string   `toml:"name"`
+	Uploaders  []string `toml:"uploaders"`
+	MetricType string   `toml:"metric-type"`
+	Prefix     string   `toml:"prefix"`
+	Glob       string   `toml:"glob"`
+	Tag        string   `toml:"tag"`
+}
+
 type dataConfig struct {
 	Path         string                    `toml:"path"`
+	Paths        []string                  `toml:"paths"`
+	Placement    string                    `toml:"placement"`
 	ChunkMaxSize config.Size               `toml:"chunk-max-size"`
 	FileInterval *config.Duration          `toml:"chunk-interval"`
 	AutoInterval *config.ChunkAutoInterval `toml:"chunk-auto-interval"`
+	Align        bool                      `toml:"chunk-align"`
 	CompAlgo     *config.Compression       `toml:"compression"`
 	CompLevel    int                       `toml:"compression-level"`
+	CompDict     string                    `toml:"compression-dictionary"`
+	MaxDiskUsage config.Size               `toml:"max-disk-usage"`
+	MinFreeSpace config.Size               `toml:"min-free-space"`
+	Overflow     string                    `toml:"overflow-policy"`
+	Fsync        string                    `toml:"fsync"`
+	FsyncPeriod  *config.Duration          `toml:"fsync-interval"`
+	ChunkFormat  string                    `toml:"chunk-format"`
+	EncryptKeys  string                    `toml:"encryption-key-file"`
+	Repair       bool                      `toml:"repair-on-start"`
+	Threads      int                       `toml:"writer-threads"`
+	Route        []routeConfig             `toml:"route"`
+	StateBackend string                    `toml:"state-backend"`
+	ArchivePath  string                    `toml:"archive-path"`
+	ArchiveRet   *config.Duration          `toml:"archive-retention"`
+	ArchiveComp  string                    `toml:"archive-compression"`
+	ArchiveLevel int                       `toml:"archive-compression-level"`
 	UTCDate      bool                      `toml:"utc-date"`
+
+	routes      []*writer.Route
+	archiveComp config.Compression
 }
 
 // Config ...
@@ -105,10 +196,15 @@ type Config struct {
 	Udp              udpConfig                   `toml:"udp"`
 	Tcp              tcpConfig                   `toml:"tcp"`
 	Pickle           pickleConfig                `toml:"pickle"`
+	Http             httpConfig                  `toml:"http"`
+	Protobuf         protobufConfig              `toml:"protobuf"`
 	Grpc             grpcConfig                  `toml:"grpc"`
 	Prometheus       promConfig                  `toml:"prometheus"`
 	TelegrafHttpJson telegrafHttpJsonConfig      `toml:"telegraf_http_json"`
+	Datadog          datadogConfig               `toml:"datadog"`
+	Collectd         collectdConfig              `toml:"collectd"`
 	Pprof            pprofConfig                 `toml:"pprof"`
+	Backpressure     backpressureConfig          `toml:"backpressure"`
 	Logging          []zapwriter.Config          `toml:"logging"`
 	TagDesc          tags.TagConfig              `toml:"convert_to_tagged"`
 }
@@ -127,13 +223,23 @@ func NewConfig() *Config {
 		},
 		Logging: nil,
 		Data: dataConfig{
-			Path: "/data/carbon-clickhouse/",
+			Path:      "/data/carbon-clickhouse/",
+			Placement: writer.PlacementRoundRobin,
 			FileInterval: &config.Duration{
 				Duration: time.Second,
 			},
 			AutoInterval: config.NewChunkAutoInterval(),
 			CompAlgo:     &config.Compression{CompAlgo: config.CompAlgoNone},
 			CompLevel:    0,
+			Overflow:     writer.OverflowBackpressure,
+			Fsync:        writer.FsyncNever,
+			FsyncPeriod: &config.Duration{
+				Duration: time.Second,
+			},
+			ChunkFormat:  writer.ChunkFormatLegacy,
+			Threads:      1,
+			StateBackend: state.BackendSymlink,
+			ArchiveRet:   &config.Duration{},
 		},
 		Udp: udpConfig{
 			Listen:         ":2003",
@@ -160,12 +266,33 @@ func NewConfig() *Config {
 			DropPast:       &config.Duration{},
 			DropLongerThan: 0,
 		},
-		Grpc: grpcConfig{
-			Listen:         ":2005",
+		Http: httpConfig{
+			Listen:         ":2010",
 			Enabled:        false,
 			DropFuture:     &config.Duration{},
 			DropPast:       &config.Duration{},
 			DropLongerThan: 0,
+			SyncWrite:      false,
+			MaxBodySize:    64 * 1024 * 1024,
+		},
+		Protobuf: protobufConfig{
+			Listen:         ":2009",
+			Enabled:        false,
+			DropFuture:     &config.Duration{},
+			DropPast:       &config.Duration{},
+			DropLongerThan: 0,
+		},
+		Grpc: grpcConfig{
+			Listen:           ":2005",
+			Enabled:          false,
+			DropFuture:       &config.Duration{},
+			DropPast:         &config.Duration{},
+			DropLongerThan:   0,
+			MaxMessageSize:   0,
+			KeepaliveTime:    &config.Duration{},
+			KeepaliveTimeout: &config.Duration{},
+			KeepaliveMinTime: &config.Duration{},
+			Compression:      "",
 		},
 		Prometheus: promConfig{
 			Listen:         ":2006",
@@ -173,6 +300,7 @@ func NewConfig() *Config {
 			DropFuture:     &config.Duration{},
 			DropPast:       &config.Duration{},
 			DropLongerThan: 0,
+			SyncWrite:      false,
 		},
 		TelegrafHttpJson: telegrafHttpJsonConfig{
 			Listen:         ":2007",
@@ -181,11 +309,36 @@ func NewConfig() *Config {
 			DropPast:       &config.Duration{},
 			DropLongerThan: 0,
 			Concat:         "_",
+			SyncWrite:      false,
+		},
+		Datadog: datadogConfig{
+			Listen:         ":2008",
+			Enabled:        false,
+			DropFuture:     &config.Duration{},
+			DropPast:       &config.Duration{},
+			DropLongerThan: 0,
+			MaxBodySize:    64 * 1024 * 1024,
+		},
+		Collectd: collectdConfig{
+			Listen:         ":25826",
+			Enabled:        false,
+			DropFuture:     &config.Duration{},
+			DropPast:       &config.Duration{},
+			DropLongerThan: 0,
+			Template:       receiver.CollectdDefaultTemplate,
+			SecurityLevel:  "none",
 		},
 		Pprof: pprofConfig{
 			Listen:  "localhost:7007",
 			Enabled: false,
 		},
+		Backpressure: backpressureConfig{
+			Enabled: false,
+			RetryAfter: &config.Duration{
+				Duration: 5 * time.Second,
+			},
+			HTTPStatus: http.StatusTooManyRequests,
+		},
 		TagDesc: tags.TagConfig{
 			Enabled: false,
 		},
@@ -297,6 +450,92 @@ func ReadConfig(filename string, exactConfig bool) (*Config, error) {
 		}
 	}
 
+	switch cfg.Data.Overflow {
+	case writer.OverflowBackpressure, writer.OverflowDropOldest:
+	default:
+		return nil, fmt.Errorf("unknown data.overflow-policy %#v", cfg.Data.Overflow)
+	}
+
+	switch cfg.Data.Fsync {
+	case writer.FsyncNever, writer.FsyncOnRotate, writer.FsyncInterval, writer.FsyncOnConfirm:
+	default:
+		return nil, fmt.Errorf("unknown data.fsync %#v", cfg.Data.Fsync)
+	}
+
+	switch cfg.Data.ChunkFormat {
+	case writer.ChunkFormatLegacy, writer.ChunkFormatV2:
+	default:
+		return nil, fmt.Errorf("unknown data.chunk-format %#v", cfg.Data.ChunkFormat)
+	}
+	if cfg.Data.EncryptKeys != "" && cfg.Data.ChunkFormat != writer.ChunkFormatV2 {
+		return nil, fmt.Errorf("data.encryption-key-file requires data.chunk-format %#v", writer.ChunkFormatV2)
+	}
+
+	if cfg.Backpressure.QueueSize < 0 {
+		return nil, fmt.Errorf("backpressure.queue-size must be non-negative")
+	}
+	if cfg.Backpressure.MaxQueue > cfg.Backpressure.QueueSize {
+		return nil, fmt.Errorf("backpressure.max-queue must be less than or equal to backpressure.queue-size")
+	}
+	switch cfg.Backpressure.HTTPStatus {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+	default:
+		return nil, fmt.Errorf("unknown backpressure.http-status %d, must be 429 or 503", cfg.Backpressure.HTTPStatus)
+	}
+
+	if cfg.Data.ArchiveComp != "" {
+		if err := cfg.Data.archiveComp.UnmarshalText([]byte(cfg.Data.ArchiveComp)); err != nil {
+			return nil, fmt.Errorf("data.archive-compression: %s", err.Error())
+		}
+	}
+
+	switch cfg.Data.StateBackend {
+	case state.BackendSymlink, state.BackendJournal:
+	default:
+		return nil, fmt.Errorf("unknown data.state-backend %#v", cfg.Data.StateBackend)
+	}
+
+	if len(cfg.Data.Paths) == 0 {
+		cfg.Data.Paths = []string{cfg.Data.Path}
+	}
+	seenPaths := make(map[string]bool)
+	for _, p := range cfg.Data.Paths {
+		if seenPaths[filepath.Clean(p)] {
+			return nil, fmt.Errorf("duplicate data.paths %#v", p)
+		}
+		seenPaths[filepath.Clean(p)] = true
+	}
+
+	switch cfg.Data.Placement {
+	case writer.PlacementRoundRobin, writer.PlacementFreeSpace:
+	default:
+		return nil, fmt.Errorf("unknown data.placement %#v", cfg.Data.Placement)
+	}
+
+	if cfg.Data.Threads < 1 {
+		return nil, fmt.Errorf("data.writer-threads must be greater than 0")
+	}
+
+	routeNames := make(map[string]bool)
+	for _, rc := range cfg.Data.Route {
+		if routeNames[rc.Name] {
+			return nil, fmt.Errorf("duplicate data.route %#v", rc.Name)
+		}
+		routeNames[rc.Name] = true
+
+		for _, u := range rc.Uploaders {
+			if _, ok := cfg.Upload[u]; !ok {
+				return nil, fmt.Errorf("data.route %#v: unknown uploader %#v", rc.Name, u)
+			}
+		}
+
+		r, err := writer.NewRoute(rc.Name, rc.Uploaders, rc.MetricType, rc.Prefix, rc.Glob, rc.Tag)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Data.routes = append(cfg.Data.routes, r)
+	}
+
 	if cfg.Data.UTCDate {
 		rb.SetUTCDate()
 	}
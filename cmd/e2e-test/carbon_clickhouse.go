@@ -22,7 +22,7 @@ type CarbonClickhouse struct {
 	cmd        *exec.Cmd `toml:"-"`
 }
 
-func (c *CarbonClickhouse) Start(clickhouseURL string, clickhouseTLSURL string) error {
+func (c *CarbonClickhouse) Start(clickhouseURL string, clickhouseTLSURL string, clickhouseNativeURL string) error {
 	if c.cmd != nil {
 		return fmt.Errorf("carbon-clickhouse already started")
 	}
@@ -53,17 +53,19 @@ func (c *CarbonClickhouse) Start(clickhouseURL string, clickhouseTLSURL string)
 		return err
 	}
 	param := struct {
-		CLICKHOUSE_URL     string
-		CLICKHOUSE_TLS_URL string
-		CCH_STORE_DIR      string
-		CCH_ADDR           string
-		TEST_DIR           string
+		CLICKHOUSE_URL        string
+		CLICKHOUSE_TLS_URL    string
+		CLICKHOUSE_NATIVE_URL string
+		CCH_STORE_DIR         string
+		CCH_ADDR              string
+		TEST_DIR              string
 	}{
-		CLICKHOUSE_URL:     clickhouseURL,
-		CLICKHOUSE_TLS_URL: clickhouseTLSURL,
-		CCH_STORE_DIR:      c.storeDir,
-		CCH_ADDR:           c.address,
-		TEST_DIR:           c.TestDir,
+		CLICKHOUSE_URL:        clickhouseURL,
+		CLICKHOUSE_TLS_URL:    clickhouseTLSURL,
+		CLICKHOUSE_NATIVE_URL: clickhouseNativeURL,
+		CCH_STORE_DIR:         c.storeDir,
+		CCH_ADDR:              c.address,
+		TEST_DIR:              c.TestDir,
 	}
 
 	c.configFile = path.Join(c.storeDir, "carbon-clickhouse.conf")
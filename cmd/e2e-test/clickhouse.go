@@ -27,11 +27,13 @@ type Clickhouse struct {
 
 	TZ string `toml:"tz"` // override timezone
 
-	httpAddress  string `toml:"-"`
-	httpsAddress string `toml:"-"`
-	url          string `toml:"-"`
-	tlsurl       string `toml:"-"`
-	container    string `toml:"-"`
+	httpAddress   string `toml:"-"`
+	httpsAddress  string `toml:"-"`
+	nativeAddress string `toml:"-"`
+	url           string `toml:"-"`
+	tlsurl        string `toml:"-"`
+	nativeurl     string `toml:"-"`
+	container     string `toml:"-"`
 }
 
 func (c *Clickhouse) CheckConfig(rootDir string) error {
@@ -76,6 +78,13 @@ func (c *Clickhouse) Start() (string, error) {
 	port := strings.Split(c.httpAddress, ":")[1]
 	c.url = "http://" + c.httpAddress
 
+	c.nativeAddress, err = getFreeTCPPort("")
+	if err != nil {
+		return "", err
+	}
+	nativePort := strings.Split(c.nativeAddress, ":")[1]
+	c.nativeurl = "clickhouse://" + c.nativeAddress
+
 	c.container = ClickhouseContainerName
 
 	// tz, _ := localTZLocationName()
@@ -84,6 +93,7 @@ func (c *Clickhouse) Start() (string, error) {
 		"--name", c.container,
 		"--ulimit", "nofile=262144:262144",
 		"-p", port + ":8123",
+		"-p", nativePort + ":9000",
 		// "-e", "TZ=" + tz, // workaround for TZ=":/etc/localtime"
 		"-v", c.Dir + "/config.xml:/etc/clickhouse-server/config.d/config.xml",
 		"-v", c.Dir + "/rollup.xml:/etc/clickhouse-server/config.d/rollup.xml",
@@ -157,6 +167,10 @@ func (c *Clickhouse) TLSURL() string {
 	return c.tlsurl
 }
 
+func (c *Clickhouse) NativeURL() string {
+	return c.nativeurl
+}
+
 func (c *Clickhouse) Container() string {
 	return c.container
 }
@@ -183,7 +183,7 @@ func testCarbonClickhouse(
 			return false
 		}
 	}
-	err = cch.Start(clickhouse.URL(), tlsurl)
+	err = cch.Start(clickhouse.URL(), tlsurl, clickhouse.NativeURL())
 	if err != nil {
 		logger.Error("starting carbon-clickhouse",
 			zap.String("config", test.name),
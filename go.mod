@@ -4,10 +4,12 @@ go 1.23.0
 
 require (
 	github.com/BurntSushi/toml v0.3.1
+	github.com/go-faster/city v1.0.1
 	github.com/gogo/protobuf v1.3.2
 	github.com/golang/protobuf v1.5.4
 	github.com/golang/snappy v0.0.0-20170215233205-553a64147049
 	github.com/json-iterator/go v1.1.12
+	github.com/klauspost/compress v1.17.11
 	github.com/lomik/graphite-pickle v0.0.0-20171221213606-614e8df42119
 	github.com/lomik/zapwriter v0.0.0-20170315193840-d4499a33b592
 	github.com/msaf1980/go-stringutils v0.1.4
@@ -17,6 +19,7 @@ require (
 	go.uber.org/zap v1.7.1
 	golang.org/x/net v0.38.0
 	google.golang.org/grpc v1.56.3
+	google.golang.org/protobuf v1.33.0
 )
 
 require (
@@ -33,6 +36,5 @@ require (
 	golang.org/x/sys v0.31.0 // indirect
 	golang.org/x/text v0.23.0 // indirect
 	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
-	google.golang.org/protobuf v1.33.0 // indirect
 	gopkg.in/yaml.v3 v3.0.0 // indirect
 )
@@ -1,30 +1,21 @@
 // Code generated by protoc-gen-gogo. DO NOT EDIT.
 // source: carbon.proto
 
-/*
-	Package carbon is a generated protocol buffer package.
-
-	It is generated from these files:
-		carbon.proto
-
-	It has these top-level messages:
-		Point
-		Metric
-		Payload
-*/
 package carbon
 
-import proto "github.com/gogo/protobuf/proto"
-import fmt "fmt"
-import math "math"
-import google_protobuf "github.com/golang/protobuf/ptypes/empty"
-
-import context "golang.org/x/net/context"
-import grpc "google.golang.org/grpc"
-
-import binary "encoding/binary"
-
-import io "io"
+import (
+	context "context"
+	encoding_binary "encoding/binary"
+	fmt "fmt"
+	proto "github.com/gogo/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	io "io"
+	math "math"
+	math_bits "math/bits"
+)
 
 // Reference imports to suppress errors if they are not otherwise used.
 var _ = proto.Marshal
@@ -35,17 +26,48 @@ var _ = math.Inf
 // is compatible with the proto package it is being compiled against.
 // A compilation error at this line likely means your copy of the
 // proto package needs to be updated.
-const _ = proto.GoGoProtoPackageIsVersion2 // please upgrade the proto package
+const _ = proto.GoGoProtoPackageIsVersion3 // please upgrade the proto package
 
 type Point struct {
-	Timestamp uint32  `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
-	Value     float64 `protobuf:"fixed64,2,opt,name=value,proto3" json:"value,omitempty"`
+	Timestamp            uint32   `protobuf:"varint,1,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Value                float64  `protobuf:"fixed64,2,opt,name=value,proto3" json:"value,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Point) Reset()         { *m = Point{} }
+func (m *Point) String() string { return proto.CompactTextString(m) }
+func (*Point) ProtoMessage()    {}
+func (*Point) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f8da78a6aab8bd10, []int{0}
+}
+func (m *Point) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *Point) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_Point.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *Point) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Point.Merge(m, src)
+}
+func (m *Point) XXX_Size() int {
+	return m.Size()
+}
+func (m *Point) XXX_DiscardUnknown() {
+	xxx_messageInfo_Point.DiscardUnknown(m)
 }
 
-func (m *Point) Reset()                    { *m = Point{} }
-func (m *Point) String() string            { return proto.CompactTextString(m) }
-func (*Point) ProtoMessage()               {}
-func (*Point) Descriptor() ([]byte, []int) { return fileDescriptorCarbon, []int{0} }
+var xxx_messageInfo_Point proto.InternalMessageInfo
 
 func (m *Point) GetTimestamp() uint32 {
 	if m != nil {
@@ -63,13 +85,47 @@ func (m *Point) GetValue() float64 {
 
 type Metric struct {
 	Metric string   `protobuf:"bytes,1,opt,name=metric,proto3" json:"metric,omitempty"`
-	Points []*Point `protobuf:"bytes,2,rep,name=points" json:"points,omitempty"`
+	Points []*Point `protobuf:"bytes,2,rep,name=points,proto3" json:"points,omitempty"`
+	// Optional labels. Metric with labels is stored as tagged series
+	// (metric?label1=value1&label2=value2, like in prometheus receiver), metric field is used as name
+	Labels               map[string]string `protobuf:"bytes,3,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *Metric) Reset()         { *m = Metric{} }
+func (m *Metric) String() string { return proto.CompactTextString(m) }
+func (*Metric) ProtoMessage()    {}
+func (*Metric) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f8da78a6aab8bd10, []int{1}
+}
+func (m *Metric) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *Metric) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_Metric.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *Metric) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Metric.Merge(m, src)
+}
+func (m *Metric) XXX_Size() int {
+	return m.Size()
+}
+func (m *Metric) XXX_DiscardUnknown() {
+	xxx_messageInfo_Metric.DiscardUnknown(m)
 }
 
-func (m *Metric) Reset()                    { *m = Metric{} }
-func (m *Metric) String() string            { return proto.CompactTextString(m) }
-func (*Metric) ProtoMessage()               {}
-func (*Metric) Descriptor() ([]byte, []int) { return fileDescriptorCarbon, []int{1} }
+var xxx_messageInfo_Metric proto.InternalMessageInfo
 
 func (m *Metric) GetMetric() string {
 	if m != nil {
@@ -85,14 +141,54 @@ func (m *Metric) GetPoints() []*Point {
 	return nil
 }
 
+func (m *Metric) GetLabels() map[string]string {
+	if m != nil {
+		return m.Labels
+	}
+	return nil
+}
+
 type Payload struct {
-	Metrics []*Metric `protobuf:"bytes,1,rep,name=metrics" json:"metrics,omitempty"`
+	Metrics []*Metric `protobuf:"bytes,1,rep,name=metrics,proto3" json:"metrics,omitempty"`
+	// Optional payload identifier, returned in StoreStream acknowledgement
+	Id                   uint64   `protobuf:"varint,2,opt,name=id,proto3" json:"id,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Payload) Reset()         { *m = Payload{} }
+func (m *Payload) String() string { return proto.CompactTextString(m) }
+func (*Payload) ProtoMessage()    {}
+func (*Payload) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f8da78a6aab8bd10, []int{2}
+}
+func (m *Payload) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *Payload) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_Payload.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *Payload) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Payload.Merge(m, src)
+}
+func (m *Payload) XXX_Size() int {
+	return m.Size()
+}
+func (m *Payload) XXX_DiscardUnknown() {
+	xxx_messageInfo_Payload.DiscardUnknown(m)
 }
 
-func (m *Payload) Reset()                    { *m = Payload{} }
-func (m *Payload) String() string            { return proto.CompactTextString(m) }
-func (*Payload) ProtoMessage()               {}
-func (*Payload) Descriptor() ([]byte, []int) { return fileDescriptorCarbon, []int{2} }
+var xxx_messageInfo_Payload proto.InternalMessageInfo
 
 func (m *Payload) GetMetrics() []*Metric {
 	if m != nil {
@@ -101,10 +197,104 @@ func (m *Payload) GetMetrics() []*Metric {
 	return nil
 }
 
+func (m *Payload) GetId() uint64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+type Ack struct {
+	// Payload identifier
+	Id uint64 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Empty if payload successfully written to drive
+	Error                string   `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Ack) Reset()         { *m = Ack{} }
+func (m *Ack) String() string { return proto.CompactTextString(m) }
+func (*Ack) ProtoMessage()    {}
+func (*Ack) Descriptor() ([]byte, []int) {
+	return fileDescriptor_f8da78a6aab8bd10, []int{3}
+}
+func (m *Ack) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *Ack) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_Ack.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *Ack) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Ack.Merge(m, src)
+}
+func (m *Ack) XXX_Size() int {
+	return m.Size()
+}
+func (m *Ack) XXX_DiscardUnknown() {
+	xxx_messageInfo_Ack.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Ack proto.InternalMessageInfo
+
+func (m *Ack) GetId() uint64 {
+	if m != nil {
+		return m.Id
+	}
+	return 0
+}
+
+func (m *Ack) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterType((*Point)(nil), "Point")
 	proto.RegisterType((*Metric)(nil), "Metric")
+	proto.RegisterMapType((map[string]string)(nil), "Metric.LabelsEntry")
 	proto.RegisterType((*Payload)(nil), "Payload")
+	proto.RegisterType((*Ack)(nil), "Ack")
+}
+
+func init() { proto.RegisterFile("carbon.proto", fileDescriptor_f8da78a6aab8bd10) }
+
+var fileDescriptor_f8da78a6aab8bd10 = []byte{
+	// 347 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x51, 0x4d, 0x4a, 0xc3, 0x40,
+	0x18, 0xed, 0x24, 0x6d, 0x6a, 0xbe, 0x56, 0x91, 0x51, 0x4a, 0xa8, 0x12, 0x6a, 0xdc, 0x04, 0x0a,
+	0x53, 0xa9, 0x1b, 0xff, 0x36, 0x55, 0xba, 0x53, 0x28, 0xe9, 0x09, 0x26, 0xe9, 0x58, 0x42, 0x93,
+	0x4c, 0x98, 0x4c, 0x85, 0x9c, 0xc1, 0x8b, 0x78, 0x14, 0x97, 0x1e, 0x41, 0x7a, 0x12, 0xc9, 0x64,
+	0x82, 0x75, 0xe7, 0xee, 0x7b, 0xef, 0xfb, 0xde, 0x7b, 0x1f, 0x3c, 0xe8, 0x47, 0x54, 0x84, 0x3c,
+	0x23, 0xb9, 0xe0, 0x92, 0x0f, 0xcf, 0xd6, 0x9c, 0xaf, 0x13, 0x36, 0x51, 0x28, 0xdc, 0xbe, 0x4e,
+	0x58, 0x9a, 0xcb, 0xb2, 0x5e, 0x7a, 0xf7, 0xd0, 0x59, 0xf0, 0x38, 0x93, 0xf8, 0x1c, 0x6c, 0x19,
+	0xa7, 0xac, 0x90, 0x34, 0xcd, 0x1d, 0x34, 0x42, 0xfe, 0x61, 0xf0, 0x4b, 0xe0, 0x53, 0xe8, 0xbc,
+	0xd1, 0x64, 0xcb, 0x1c, 0x63, 0x84, 0x7c, 0x14, 0xd4, 0xc0, 0xfb, 0x40, 0x60, 0xbd, 0x30, 0x29,
+	0xe2, 0x08, 0x0f, 0xc0, 0x4a, 0xd5, 0xa4, 0xb4, 0x76, 0xa0, 0x11, 0x76, 0xc1, 0xca, 0x2b, 0xff,
+	0xc2, 0x31, 0x46, 0xa6, 0xdf, 0x9b, 0x5a, 0x44, 0xc5, 0x05, 0x9a, 0xc5, 0x63, 0xb0, 0x12, 0x1a,
+	0xb2, 0xa4, 0x70, 0x4c, 0xb5, 0x3f, 0x21, 0xb5, 0x21, 0x79, 0x56, 0xec, 0x3c, 0x93, 0xa2, 0x0c,
+	0xf4, 0xc9, 0xf0, 0x16, 0x7a, 0x7b, 0x34, 0x3e, 0x06, 0x73, 0xc3, 0x4a, 0x1d, 0x58, 0x8d, 0x7f,
+	0xdf, 0xb4, 0xf5, 0x9b, 0x77, 0xc6, 0x0d, 0xf2, 0x1e, 0xa0, 0xbb, 0xa0, 0x65, 0xc2, 0xe9, 0x0a,
+	0x5f, 0x40, 0xb7, 0x7e, 0xae, 0x70, 0x90, 0xca, 0xec, 0xea, 0xcc, 0xa0, 0xe1, 0xf1, 0x11, 0x18,
+	0xf1, 0x4a, 0x99, 0xb4, 0x03, 0x23, 0x5e, 0x79, 0x63, 0x30, 0x67, 0xd1, 0x46, 0xd3, 0xa8, 0xa1,
+	0xab, 0x38, 0x26, 0x04, 0x17, 0x4d, 0x9c, 0x02, 0xd3, 0x77, 0x04, 0xd6, 0x93, 0x2a, 0x00, 0x8f,
+	0xa1, 0xb3, 0x94, 0x5c, 0x30, 0x7c, 0x40, 0x74, 0xfa, 0x70, 0x40, 0xea, 0x3a, 0x48, 0x53, 0x07,
+	0x99, 0x57, 0x75, 0x78, 0x2d, 0x3c, 0x01, 0x5b, 0x1d, 0x2f, 0xcb, 0x2c, 0xfa, 0x97, 0xe0, 0x12,
+	0x7a, 0xb5, 0x40, 0x0a, 0x46, 0xd3, 0x3d, 0x49, 0x9b, 0xcc, 0xa2, 0x8d, 0xd7, 0xf2, 0xd1, 0x15,
+	0x7a, 0xec, 0x7f, 0xee, 0x5c, 0xf4, 0xb5, 0x73, 0xd1, 0xf7, 0xce, 0x45, 0xa1, 0xa5, 0x4c, 0xae,
+	0x7f, 0x06, 0x00, 0xd2, 0x2d, 0x3f, 0x0e, 0x22, 0x02, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -115,14 +305,19 @@ var _ grpc.ClientConn
 // is compatible with the grpc package it is being compiled against.
 const _ = grpc.SupportPackageIsVersion4
 
-// Client API for Carbon service
-
+// CarbonClient is the client API for Carbon service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
 type CarbonClient interface {
 	// Store parses request, sends them to internal queue and returns response.
 	// Data may be lost during server restart.
-	Store(ctx context.Context, in *Payload, opts ...grpc.CallOption) (*google_protobuf.Empty, error)
+	Store(ctx context.Context, in *Payload, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	// StoreSync returns response only after data has written to drive.
-	StoreSync(ctx context.Context, in *Payload, opts ...grpc.CallOption) (*google_protobuf.Empty, error)
+	StoreSync(ctx context.Context, in *Payload, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// StoreStream receives payloads over one long-lived stream.
+	// Ack for every payload is sent only after data has written to drive (like StoreSync), in order of payloads.
+	// Up to 64 payloads are written concurrently, client may send next payloads without waiting for acks.
+	StoreStream(ctx context.Context, opts ...grpc.CallOption) (Carbon_StoreStreamClient, error)
 }
 
 type carbonClient struct {
@@ -133,32 +328,80 @@ func NewCarbonClient(cc *grpc.ClientConn) CarbonClient {
 	return &carbonClient{cc}
 }
 
-func (c *carbonClient) Store(ctx context.Context, in *Payload, opts ...grpc.CallOption) (*google_protobuf.Empty, error) {
-	out := new(google_protobuf.Empty)
-	err := grpc.Invoke(ctx, "/Carbon/Store", in, out, c.cc, opts...)
+func (c *carbonClient) Store(ctx context.Context, in *Payload, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/Carbon/Store", in, out, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func (c *carbonClient) StoreSync(ctx context.Context, in *Payload, opts ...grpc.CallOption) (*google_protobuf.Empty, error) {
-	out := new(google_protobuf.Empty)
-	err := grpc.Invoke(ctx, "/Carbon/StoreSync", in, out, c.cc, opts...)
+func (c *carbonClient) StoreSync(ctx context.Context, in *Payload, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/Carbon/StoreSync", in, out, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-// Server API for Carbon service
+func (c *carbonClient) StoreStream(ctx context.Context, opts ...grpc.CallOption) (Carbon_StoreStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Carbon_serviceDesc.Streams[0], "/Carbon/StoreStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &carbonStoreStreamClient{stream}
+	return x, nil
+}
+
+type Carbon_StoreStreamClient interface {
+	Send(*Payload) error
+	Recv() (*Ack, error)
+	grpc.ClientStream
+}
+
+type carbonStoreStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *carbonStoreStreamClient) Send(m *Payload) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *carbonStoreStreamClient) Recv() (*Ack, error) {
+	m := new(Ack)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
 
+// CarbonServer is the server API for Carbon service.
 type CarbonServer interface {
 	// Store parses request, sends them to internal queue and returns response.
 	// Data may be lost during server restart.
-	Store(context.Context, *Payload) (*google_protobuf.Empty, error)
+	Store(context.Context, *Payload) (*emptypb.Empty, error)
 	// StoreSync returns response only after data has written to drive.
-	StoreSync(context.Context, *Payload) (*google_protobuf.Empty, error)
+	StoreSync(context.Context, *Payload) (*emptypb.Empty, error)
+	// StoreStream receives payloads over one long-lived stream.
+	// Ack for every payload is sent only after data has written to drive (like StoreSync), in order of payloads.
+	// Up to 64 payloads are written concurrently, client may send next payloads without waiting for acks.
+	StoreStream(Carbon_StoreStreamServer) error
+}
+
+// UnimplementedCarbonServer can be embedded to have forward compatible implementations.
+type UnimplementedCarbonServer struct {
+}
+
+func (*UnimplementedCarbonServer) Store(ctx context.Context, req *Payload) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Store not implemented")
+}
+func (*UnimplementedCarbonServer) StoreSync(ctx context.Context, req *Payload) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StoreSync not implemented")
+}
+func (*UnimplementedCarbonServer) StoreStream(srv Carbon_StoreStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method StoreStream not implemented")
 }
 
 func RegisterCarbonServer(s *grpc.Server, srv CarbonServer) {
@@ -201,6 +444,32 @@ func _Carbon_StoreSync_Handler(srv interface{}, ctx context.Context, dec func(in
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Carbon_StoreStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(CarbonServer).StoreStream(&carbonStoreStreamServer{stream})
+}
+
+type Carbon_StoreStreamServer interface {
+	Send(*Ack) error
+	Recv() (*Payload, error)
+	grpc.ServerStream
+}
+
+type carbonStoreStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *carbonStoreStreamServer) Send(m *Ack) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *carbonStoreStreamServer) Recv() (*Payload, error) {
+	m := new(Payload)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 var _Carbon_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "Carbon",
 	HandlerType: (*CarbonServer)(nil),
@@ -214,14 +483,21 @@ var _Carbon_serviceDesc = grpc.ServiceDesc{
 			Handler:    _Carbon_StoreSync_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StoreStream",
+			Handler:       _Carbon_StoreStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
 	Metadata: "carbon.proto",
 }
 
 func (m *Point) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
-	n, err := m.MarshalTo(dAtA)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
 	if err != nil {
 		return nil, err
 	}
@@ -229,28 +505,37 @@ func (m *Point) Marshal() (dAtA []byte, err error) {
 }
 
 func (m *Point) MarshalTo(dAtA []byte) (int, error) {
-	var i int
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *Point) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
 	_ = i
 	var l int
 	_ = l
-	if m.Timestamp != 0 {
-		dAtA[i] = 0x8
-		i++
-		i = encodeVarintCarbon(dAtA, i, uint64(m.Timestamp))
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
 	}
 	if m.Value != 0 {
+		i -= 8
+		encoding_binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.Value))))
+		i--
 		dAtA[i] = 0x11
-		i++
-		binary.LittleEndian.PutUint64(dAtA[i:], uint64(math.Float64bits(float64(m.Value))))
-		i += 8
 	}
-	return i, nil
+	if m.Timestamp != 0 {
+		i = encodeVarintCarbon(dAtA, i, uint64(m.Timestamp))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
 }
 
 func (m *Metric) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
-	n, err := m.MarshalTo(dAtA)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
 	if err != nil {
 		return nil, err
 	}
@@ -258,35 +543,66 @@ func (m *Metric) Marshal() (dAtA []byte, err error) {
 }
 
 func (m *Metric) MarshalTo(dAtA []byte) (int, error) {
-	var i int
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *Metric) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
 	_ = i
 	var l int
 	_ = l
-	if len(m.Metric) > 0 {
-		dAtA[i] = 0xa
-		i++
-		i = encodeVarintCarbon(dAtA, i, uint64(len(m.Metric)))
-		i += copy(dAtA[i:], m.Metric)
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
 	}
-	if len(m.Points) > 0 {
-		for _, msg := range m.Points {
+	if len(m.Labels) > 0 {
+		for k := range m.Labels {
+			v := m.Labels[k]
+			baseI := i
+			i -= len(v)
+			copy(dAtA[i:], v)
+			i = encodeVarintCarbon(dAtA, i, uint64(len(v)))
+			i--
 			dAtA[i] = 0x12
-			i++
-			i = encodeVarintCarbon(dAtA, i, uint64(msg.Size()))
-			n, err := msg.MarshalTo(dAtA[i:])
-			if err != nil {
-				return 0, err
+			i -= len(k)
+			copy(dAtA[i:], k)
+			i = encodeVarintCarbon(dAtA, i, uint64(len(k)))
+			i--
+			dAtA[i] = 0xa
+			i = encodeVarintCarbon(dAtA, i, uint64(baseI-i))
+			i--
+			dAtA[i] = 0x1a
+		}
+	}
+	if len(m.Points) > 0 {
+		for iNdEx := len(m.Points) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.Points[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintCarbon(dAtA, i, uint64(size))
 			}
-			i += n
+			i--
+			dAtA[i] = 0x12
 		}
 	}
-	return i, nil
+	if len(m.Metric) > 0 {
+		i -= len(m.Metric)
+		copy(dAtA[i:], m.Metric)
+		i = encodeVarintCarbon(dAtA, i, uint64(len(m.Metric)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
 }
 
 func (m *Payload) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
-	n, err := m.MarshalTo(dAtA)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
 	if err != nil {
 		return nil, err
 	}
@@ -294,35 +610,95 @@ func (m *Payload) Marshal() (dAtA []byte, err error) {
 }
 
 func (m *Payload) MarshalTo(dAtA []byte) (int, error) {
-	var i int
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *Payload) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
 	_ = i
 	var l int
 	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if m.Id != 0 {
+		i = encodeVarintCarbon(dAtA, i, uint64(m.Id))
+		i--
+		dAtA[i] = 0x10
+	}
 	if len(m.Metrics) > 0 {
-		for _, msg := range m.Metrics {
-			dAtA[i] = 0xa
-			i++
-			i = encodeVarintCarbon(dAtA, i, uint64(msg.Size()))
-			n, err := msg.MarshalTo(dAtA[i:])
-			if err != nil {
-				return 0, err
+		for iNdEx := len(m.Metrics) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.Metrics[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintCarbon(dAtA, i, uint64(size))
 			}
-			i += n
+			i--
+			dAtA[i] = 0xa
 		}
 	}
-	return i, nil
+	return len(dAtA) - i, nil
+}
+
+func (m *Ack) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Ack) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *Ack) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.XXX_unrecognized != nil {
+		i -= len(m.XXX_unrecognized)
+		copy(dAtA[i:], m.XXX_unrecognized)
+	}
+	if len(m.Error) > 0 {
+		i -= len(m.Error)
+		copy(dAtA[i:], m.Error)
+		i = encodeVarintCarbon(dAtA, i, uint64(len(m.Error)))
+		i--
+		dAtA[i] = 0x12
+	}
+	if m.Id != 0 {
+		i = encodeVarintCarbon(dAtA, i, uint64(m.Id))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
 }
 
 func encodeVarintCarbon(dAtA []byte, offset int, v uint64) int {
+	offset -= sovCarbon(v)
+	base := offset
 	for v >= 1<<7 {
 		dAtA[offset] = uint8(v&0x7f | 0x80)
 		v >>= 7
 		offset++
 	}
 	dAtA[offset] = uint8(v)
-	return offset + 1
+	return base
 }
 func (m *Point) Size() (n int) {
+	if m == nil {
+		return 0
+	}
 	var l int
 	_ = l
 	if m.Timestamp != 0 {
@@ -331,10 +707,16 @@ func (m *Point) Size() (n int) {
 	if m.Value != 0 {
 		n += 9
 	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
 	return n
 }
 
 func (m *Metric) Size() (n int) {
+	if m == nil {
+		return 0
+	}
 	var l int
 	_ = l
 	l = len(m.Metric)
@@ -347,10 +729,24 @@ func (m *Metric) Size() (n int) {
 			n += 1 + l + sovCarbon(uint64(l))
 		}
 	}
+	if len(m.Labels) > 0 {
+		for k, v := range m.Labels {
+			_ = k
+			_ = v
+			mapEntrySize := 1 + len(k) + sovCarbon(uint64(len(k))) + 1 + len(v) + sovCarbon(uint64(len(v)))
+			n += mapEntrySize + 1 + sovCarbon(uint64(mapEntrySize))
+		}
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
 	return n
 }
 
 func (m *Payload) Size() (n int) {
+	if m == nil {
+		return 0
+	}
 	var l int
 	_ = l
 	if len(m.Metrics) > 0 {
@@ -359,19 +755,37 @@ func (m *Payload) Size() (n int) {
 			n += 1 + l + sovCarbon(uint64(l))
 		}
 	}
+	if m.Id != 0 {
+		n += 1 + sovCarbon(uint64(m.Id))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
+	}
 	return n
 }
 
-func sovCarbon(x uint64) (n int) {
-	for {
-		n++
-		x >>= 7
-		if x == 0 {
-			break
-		}
+func (m *Ack) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Id != 0 {
+		n += 1 + sovCarbon(uint64(m.Id))
+	}
+	l = len(m.Error)
+	if l > 0 {
+		n += 1 + l + sovCarbon(uint64(l))
+	}
+	if m.XXX_unrecognized != nil {
+		n += len(m.XXX_unrecognized)
 	}
 	return n
 }
+
+func sovCarbon(x uint64) (n int) {
+	return (math_bits.Len64(x|1) + 6) / 7
+}
 func sozCarbon(x uint64) (n int) {
 	return sovCarbon(uint64((x << 1) ^ uint64((int64(x) >> 63))))
 }
@@ -390,7 +804,7 @@ func (m *Point) Unmarshal(dAtA []byte) error {
 			}
 			b := dAtA[iNdEx]
 			iNdEx++
-			wire |= (uint64(b) & 0x7F) << shift
+			wire |= uint64(b&0x7F) << shift
 			if b < 0x80 {
 				break
 			}
@@ -418,7 +832,7 @@ func (m *Point) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.Timestamp |= (uint32(b) & 0x7F) << shift
+				m.Timestamp |= uint32(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
@@ -431,7 +845,7 @@ func (m *Point) Unmarshal(dAtA []byte) error {
 			if (iNdEx + 8) > l {
 				return io.ErrUnexpectedEOF
 			}
-			v = uint64(binary.LittleEndian.Uint64(dAtA[iNdEx:]))
+			v = uint64(encoding_binary.LittleEndian.Uint64(dAtA[iNdEx:]))
 			iNdEx += 8
 			m.Value = float64(math.Float64frombits(v))
 		default:
@@ -440,12 +854,13 @@ func (m *Point) Unmarshal(dAtA []byte) error {
 			if err != nil {
 				return err
 			}
-			if skippy < 0 {
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
 				return ErrInvalidLengthCarbon
 			}
 			if (iNdEx + skippy) > l {
 				return io.ErrUnexpectedEOF
 			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
 			iNdEx += skippy
 		}
 	}
@@ -470,7 +885,7 @@ func (m *Metric) Unmarshal(dAtA []byte) error {
 			}
 			b := dAtA[iNdEx]
 			iNdEx++
-			wire |= (uint64(b) & 0x7F) << shift
+			wire |= uint64(b&0x7F) << shift
 			if b < 0x80 {
 				break
 			}
@@ -498,7 +913,7 @@ func (m *Metric) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				stringLen |= (uint64(b) & 0x7F) << shift
+				stringLen |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
@@ -508,6 +923,9 @@ func (m *Metric) Unmarshal(dAtA []byte) error {
 				return ErrInvalidLengthCarbon
 			}
 			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthCarbon
+			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
@@ -527,7 +945,7 @@ func (m *Metric) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= (int(b) & 0x7F) << shift
+				msglen |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
@@ -536,6 +954,9 @@ func (m *Metric) Unmarshal(dAtA []byte) error {
 				return ErrInvalidLengthCarbon
 			}
 			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthCarbon
+			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
@@ -544,18 +965,146 @@ func (m *Metric) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Labels", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbon
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthCarbon
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthCarbon
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if m.Labels == nil {
+				m.Labels = make(map[string]string)
+			}
+			var mapkey string
+			var mapvalue string
+			for iNdEx < postIndex {
+				entryPreIndex := iNdEx
+				var wire uint64
+				for shift := uint(0); ; shift += 7 {
+					if shift >= 64 {
+						return ErrIntOverflowCarbon
+					}
+					if iNdEx >= l {
+						return io.ErrUnexpectedEOF
+					}
+					b := dAtA[iNdEx]
+					iNdEx++
+					wire |= uint64(b&0x7F) << shift
+					if b < 0x80 {
+						break
+					}
+				}
+				fieldNum := int32(wire >> 3)
+				if fieldNum == 1 {
+					var stringLenmapkey uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowCarbon
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapkey |= uint64(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapkey := int(stringLenmapkey)
+					if intStringLenmapkey < 0 {
+						return ErrInvalidLengthCarbon
+					}
+					postStringIndexmapkey := iNdEx + intStringLenmapkey
+					if postStringIndexmapkey < 0 {
+						return ErrInvalidLengthCarbon
+					}
+					if postStringIndexmapkey > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapkey = string(dAtA[iNdEx:postStringIndexmapkey])
+					iNdEx = postStringIndexmapkey
+				} else if fieldNum == 2 {
+					var stringLenmapvalue uint64
+					for shift := uint(0); ; shift += 7 {
+						if shift >= 64 {
+							return ErrIntOverflowCarbon
+						}
+						if iNdEx >= l {
+							return io.ErrUnexpectedEOF
+						}
+						b := dAtA[iNdEx]
+						iNdEx++
+						stringLenmapvalue |= uint64(b&0x7F) << shift
+						if b < 0x80 {
+							break
+						}
+					}
+					intStringLenmapvalue := int(stringLenmapvalue)
+					if intStringLenmapvalue < 0 {
+						return ErrInvalidLengthCarbon
+					}
+					postStringIndexmapvalue := iNdEx + intStringLenmapvalue
+					if postStringIndexmapvalue < 0 {
+						return ErrInvalidLengthCarbon
+					}
+					if postStringIndexmapvalue > l {
+						return io.ErrUnexpectedEOF
+					}
+					mapvalue = string(dAtA[iNdEx:postStringIndexmapvalue])
+					iNdEx = postStringIndexmapvalue
+				} else {
+					iNdEx = entryPreIndex
+					skippy, err := skipCarbon(dAtA[iNdEx:])
+					if err != nil {
+						return err
+					}
+					if (skippy < 0) || (iNdEx+skippy) < 0 {
+						return ErrInvalidLengthCarbon
+					}
+					if (iNdEx + skippy) > postIndex {
+						return io.ErrUnexpectedEOF
+					}
+					iNdEx += skippy
+				}
+			}
+			m.Labels[mapkey] = mapvalue
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipCarbon(dAtA[iNdEx:])
 			if err != nil {
 				return err
 			}
-			if skippy < 0 {
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
 				return ErrInvalidLengthCarbon
 			}
 			if (iNdEx + skippy) > l {
 				return io.ErrUnexpectedEOF
 			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
 			iNdEx += skippy
 		}
 	}
@@ -580,7 +1129,7 @@ func (m *Payload) Unmarshal(dAtA []byte) error {
 			}
 			b := dAtA[iNdEx]
 			iNdEx++
-			wire |= (uint64(b) & 0x7F) << shift
+			wire |= uint64(b&0x7F) << shift
 			if b < 0x80 {
 				break
 			}
@@ -608,7 +1157,7 @@ func (m *Payload) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= (int(b) & 0x7F) << shift
+				msglen |= int(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
@@ -617,6 +1166,9 @@ func (m *Payload) Unmarshal(dAtA []byte) error {
 				return ErrInvalidLengthCarbon
 			}
 			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthCarbon
+			}
 			if postIndex > l {
 				return io.ErrUnexpectedEOF
 			}
@@ -625,18 +1177,140 @@ func (m *Payload) Unmarshal(dAtA []byte) error {
 				return err
 			}
 			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Id", wireType)
+			}
+			m.Id = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbon
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Id |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipCarbon(dAtA[iNdEx:])
 			if err != nil {
 				return err
 			}
-			if skippy < 0 {
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
 				return ErrInvalidLengthCarbon
 			}
 			if (iNdEx + skippy) > l {
 				return io.ErrUnexpectedEOF
 			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *Ack) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowCarbon
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Ack: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Ack: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Id", wireType)
+			}
+			m.Id = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbon
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Id |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Error", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowCarbon
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthCarbon
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthCarbon
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Error = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipCarbon(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthCarbon
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.XXX_unrecognized = append(m.XXX_unrecognized, dAtA[iNdEx:iNdEx+skippy]...)
 			iNdEx += skippy
 		}
 	}
@@ -649,6 +1323,7 @@ func (m *Payload) Unmarshal(dAtA []byte) error {
 func skipCarbon(dAtA []byte) (n int, err error) {
 	l := len(dAtA)
 	iNdEx := 0
+	depth := 0
 	for iNdEx < l {
 		var wire uint64
 		for shift := uint(0); ; shift += 7 {
@@ -680,10 +1355,8 @@ func skipCarbon(dAtA []byte) (n int, err error) {
 					break
 				}
 			}
-			return iNdEx, nil
 		case 1:
 			iNdEx += 8
-			return iNdEx, nil
 		case 2:
 			var length int
 			for shift := uint(0); ; shift += 7 {
@@ -700,75 +1373,34 @@ func skipCarbon(dAtA []byte) (n int, err error) {
 					break
 				}
 			}
-			iNdEx += length
 			if length < 0 {
 				return 0, ErrInvalidLengthCarbon
 			}
-			return iNdEx, nil
+			iNdEx += length
 		case 3:
-			for {
-				var innerWire uint64
-				var start int = iNdEx
-				for shift := uint(0); ; shift += 7 {
-					if shift >= 64 {
-						return 0, ErrIntOverflowCarbon
-					}
-					if iNdEx >= l {
-						return 0, io.ErrUnexpectedEOF
-					}
-					b := dAtA[iNdEx]
-					iNdEx++
-					innerWire |= (uint64(b) & 0x7F) << shift
-					if b < 0x80 {
-						break
-					}
-				}
-				innerWireType := int(innerWire & 0x7)
-				if innerWireType == 4 {
-					break
-				}
-				next, err := skipCarbon(dAtA[start:])
-				if err != nil {
-					return 0, err
-				}
-				iNdEx = start + next
-			}
-			return iNdEx, nil
+			depth++
 		case 4:
-			return iNdEx, nil
+			if depth == 0 {
+				return 0, ErrUnexpectedEndOfGroupCarbon
+			}
+			depth--
 		case 5:
 			iNdEx += 4
-			return iNdEx, nil
 		default:
 			return 0, fmt.Errorf("proto: illegal wireType %d", wireType)
 		}
+		if iNdEx < 0 {
+			return 0, ErrInvalidLengthCarbon
+		}
+		if depth == 0 {
+			return iNdEx, nil
+		}
 	}
-	panic("unreachable")
+	return 0, io.ErrUnexpectedEOF
 }
 
 var (
-	ErrInvalidLengthCarbon = fmt.Errorf("proto: negative length found during unmarshaling")
-	ErrIntOverflowCarbon   = fmt.Errorf("proto: integer overflow")
+	ErrInvalidLengthCarbon        = fmt.Errorf("proto: negative length found during unmarshaling")
+	ErrIntOverflowCarbon          = fmt.Errorf("proto: integer overflow")
+	ErrUnexpectedEndOfGroupCarbon = fmt.Errorf("proto: unexpected end of group")
 )
-
-func init() { proto.RegisterFile("carbon.proto", fileDescriptorCarbon) }
-
-var fileDescriptorCarbon = []byte{
-	// 249 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x8f, 0xc1, 0x4a, 0xc3, 0x40,
-	0x10, 0x86, 0xdd, 0x4a, 0x36, 0x66, 0xac, 0x97, 0x45, 0x4a, 0xa8, 0x12, 0x62, 0x4e, 0x01, 0x65,
-	0x03, 0xf5, 0xe8, 0x45, 0x14, 0x8f, 0x42, 0xd9, 0x3e, 0xc1, 0x26, 0x6e, 0x4b, 0x20, 0xc9, 0x84,
-	0x64, 0x2b, 0xe4, 0x0d, 0x3d, 0xfa, 0x08, 0x92, 0x27, 0x91, 0xce, 0x26, 0x78, 0xf5, 0xb6, 0xff,
-	0x3f, 0xf3, 0xcd, 0xf2, 0xc1, 0xb2, 0xd0, 0x5d, 0x8e, 0x8d, 0x6c, 0x3b, 0xb4, 0xb8, 0xbe, 0x39,
-	0x20, 0x1e, 0x2a, 0x93, 0x51, 0xca, 0x8f, 0xfb, 0xcc, 0xd4, 0xad, 0x1d, 0xdc, 0x30, 0x79, 0x02,
-	0x6f, 0x8b, 0x65, 0x63, 0xc5, 0x2d, 0x04, 0xb6, 0xac, 0x4d, 0x6f, 0x75, 0xdd, 0x86, 0x2c, 0x66,
-	0xe9, 0x95, 0xfa, 0x2b, 0xc4, 0x35, 0x78, 0x9f, 0xba, 0x3a, 0x9a, 0x70, 0x11, 0xb3, 0x94, 0x29,
-	0x17, 0x92, 0x67, 0xe0, 0xef, 0xc6, 0x76, 0x65, 0x21, 0x56, 0xc0, 0x6b, 0x7a, 0x11, 0x1a, 0xa8,
-	0x29, 0x89, 0x08, 0x78, 0x7b, 0x3a, 0xdf, 0x87, 0x8b, 0xf8, 0x3c, 0xbd, 0xdc, 0x70, 0x49, 0xbf,
-	0xa9, 0xa9, 0x4d, 0x1e, 0xc0, 0xdf, 0xea, 0xa1, 0x42, 0xfd, 0x21, 0xee, 0xc0, 0x77, 0x50, 0x1f,
-	0x32, 0xda, 0xf5, 0xa5, 0x3b, 0xae, 0xe6, 0x7e, 0xb3, 0x07, 0xfe, 0x4a, 0x66, 0xe2, 0x1e, 0xbc,
-	0x9d, 0xc5, 0xce, 0x88, 0x0b, 0x39, 0xf1, 0xeb, 0x95, 0x74, 0x9e, 0x72, 0xf6, 0x94, 0x6f, 0x27,
-	0xcf, 0xe4, 0x4c, 0x64, 0x10, 0xd0, 0xf2, 0x6e, 0x68, 0x8a, 0xff, 0x00, 0x2f, 0xcb, 0xaf, 0x31,
-	0x62, 0xdf, 0x63, 0xc4, 0x7e, 0xc6, 0x88, 0xe5, 0x9c, 0xe6, 0x8f, 0xbf, 0x01, 0x00, 0x00, 0xff,
-	0xff, 0x4c, 0xfa, 0xce, 0x0d, 0x56, 0x01, 0x00, 0x00,
-}
@@ -0,0 +1,583 @@
+package RowBinary
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+
+	"github.com/lomik/carbon-clickhouse/helper/config"
+)
+
+// Chunk file format version 2 (legacy files without header are version 1):
+//
+//	header{ChunkHeaderSize} block{chunkBlockHeaderSize + payload}...
+//
+// Header is written on create and rewritten with final stats on close.
+// Every block contains whole records, compressed independently and protected with CRC32C,
+// so corrupted block can be skipped without loss of the rest of file.
+// Compressed payload of block is optionally encrypted with AES-GCM (see LoadEncryptionKeys).
+const (
+	ChunkVersion    = 2
+	ChunkHeaderSize = 512
+	// ChunkBlockSize is max size of uncompressed records in block
+	ChunkBlockSize = 1024 * 1024
+
+	chunkBlockHeaderSize = 24
+	chunkMaxHostLen      = 255
+	chunkFlagFinished    = 1
+	chunkFlagEncrypted   = 2
+	chunkKeyIDOffset     = 288 // after host
+
+	chunkBlockFlagEncrypted = 1
+)
+
+var (
+	// legacy files start with uvarint length of name, which is never zero
+	chunkMagic      = []byte{0x00, 'C', 'C', 'K'}
+	chunkBlockMagic = []byte{0xC4, 'B', 'L', 'K'}
+	crc32c          = crc32.MakeTable(crc32.Castagnoli)
+
+	errChunkHeaderChecksum = errors.New("chunk header checksum mismatch")
+)
+
+// ChunkHeader describes chunk file. Stats are valid only in finished (properly closed) chunk
+type ChunkHeader struct {
+	Version      uint16
+	Compression  config.CompAlgo
+	Finished     bool
+	Created      time.Time
+	Host         string
+	Records      uint64
+	MinTimestamp uint32
+	MaxTimestamp uint32
+	// KeyID is id of encryption key of chunk, 0 - not encrypted
+	KeyID uint32
+}
+
+func (h *ChunkHeader) marshal(b []byte) {
+	for i := range b[:ChunkHeaderSize] {
+		b[i] = 0
+	}
+	copy(b[0:4], chunkMagic)
+	binary.LittleEndian.PutUint16(b[4:], h.Version)
+	b[6] = byte(h.Compression)
+	if h.Finished {
+		b[7] |= chunkFlagFinished
+	}
+	binary.LittleEndian.PutUint64(b[8:], uint64(h.Created.UnixNano()))
+	binary.LittleEndian.PutUint64(b[16:], h.Records)
+	binary.LittleEndian.PutUint32(b[24:], h.MinTimestamp)
+	binary.LittleEndian.PutUint32(b[28:], h.MaxTimestamp)
+	host := h.Host
+	if len(host) > chunkMaxHostLen {
+		host = host[:chunkMaxHostLen]
+	}
+	b[32] = byte(len(host))
+	copy(b[33:], host)
+	if h.KeyID != 0 {
+		b[7] |= chunkFlagEncrypted
+		binary.LittleEndian.PutUint32(b[chunkKeyIDOffset:], h.KeyID)
+	}
+	binary.LittleEndian.PutUint32(b[ChunkHeaderSize-4:], crc32.Checksum(b[:ChunkHeaderSize-4], crc32c))
+}
+
+func (h *ChunkHeader) unmarshal(b []byte) error {
+	if !bytes.Equal(b[0:4], chunkMagic) {
+		return errors.New("not a chunk header")
+	}
+	if binary.LittleEndian.Uint32(b[ChunkHeaderSize-4:]) != crc32.Checksum(b[:ChunkHeaderSize-4], crc32c) {
+		return errChunkHeaderChecksum
+	}
+	h.Version = binary.LittleEndian.Uint16(b[4:])
+	h.Compression = config.CompAlgo(b[6])
+	h.Finished = b[7]&chunkFlagFinished != 0
+	h.Created = time.Unix(0, int64(binary.LittleEndian.Uint64(b[8:])))
+	h.Records = binary.LittleEndian.Uint64(b[16:])
+	h.MinTimestamp = binary.LittleEndian.Uint32(b[24:])
+	h.MaxTimestamp = binary.LittleEndian.Uint32(b[28:])
+	h.Host = string(b[33 : 33+int(b[32])])
+	if b[7]&chunkFlagEncrypted != 0 {
+		h.KeyID = binary.LittleEndian.Uint32(b[chunkKeyIDOffset:])
+	}
+	return nil
+}
+
+// ReadChunkHeader reads header of chunk file. Returns nil header for legacy file
+func ReadChunkHeader(filename string) (*ChunkHeader, error) {
+	fd, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	return readChunkHeader(bufio.NewReader(fd))
+}
+
+// readChunkHeader consumes header from r. Nothing is consumed from legacy file
+func readChunkHeader(r *bufio.Reader) (*ChunkHeader, error) {
+	magic, err := r.Peek(len(chunkMagic))
+	if err != nil || !bytes.Equal(magic, chunkMagic) {
+		// legacy or empty file
+		return nil, nil
+	}
+
+	var b [ChunkHeaderSize]byte
+	if _, err = io.ReadFull(r, b[:]); err != nil {
+		return nil, fmt.Errorf("chunk header truncated: %s", err.Error())
+	}
+
+	h := &ChunkHeader{}
+	if err = h.unmarshal(b[:]); err != nil {
+		if err != errChunkHeaderChecksum {
+			return nil, err
+		}
+		// header is informational only, blocks are self-contained
+		h.Version = ChunkVersion
+	}
+	return h, nil
+}
+
+// chunkFile is *os.File, header is rewritten on close
+type chunkFile interface {
+	io.Writer
+	io.WriterAt
+}
+
+// ChunkWriter writes records in chunk format version 2
+type ChunkWriter struct {
+	f        chunkFile
+	header   ChunkHeader
+	hdr      [ChunkHeaderSize]byte
+	algo     config.CompAlgo
+	level    int
+	zstd     *zstd.Encoder
+	buf      []byte // uncompressed data, complete records in buf[:parsed]
+	parsed   int
+	records  uint32 // records in buf[:parsed]
+	block    []byte
+	key      *EncryptionKey
+	sealed   []byte
+	closed   bool
+	hasStats bool
+}
+
+// NewChunkWriter writes header of unfinished chunk to f
+func NewChunkWriter(f chunkFile, compAlgo config.CompAlgo, compLevel int, zstdDict []byte) (*ChunkWriter, error) {
+	return NewEncryptedChunkWriter(f, compAlgo, compLevel, zstdDict, nil)
+}
+
+// NewEncryptedChunkWriter writes chunk with blocks encrypted by key. Nil key disables encryption
+func NewEncryptedChunkWriter(f chunkFile, compAlgo config.CompAlgo, compLevel int, zstdDict []byte, key *EncryptionKey) (*ChunkWriter, error) {
+	host, _ := os.Hostname()
+
+	w := &ChunkWriter{
+		f:     f,
+		algo:  compAlgo,
+		level: compLevel,
+		key:   key,
+		buf:   make([]byte, 0, ChunkBlockSize+WriteBufferSize),
+		header: ChunkHeader{
+			Version:     ChunkVersion,
+			Compression: compAlgo,
+			Created:     time.Now(),
+			Host:        host,
+		},
+	}
+	if key != nil {
+		w.header.KeyID = key.ID
+	}
+
+	if compAlgo == config.CompAlgoZstd {
+		level := zstd.SpeedDefault
+		if compLevel > 0 {
+			level = zstd.EncoderLevelFromZstd(compLevel)
+		}
+		opts := []zstd.EOption{zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1)}
+		if len(zstdDict) > 0 {
+			opts = append(opts, zstd.WithEncoderDict(zstdDict))
+		}
+		var err error
+		if w.zstd, err = zstd.NewWriter(nil, opts...); err != nil {
+			return nil, err
+		}
+	}
+
+	w.header.marshal(w.hdr[:])
+	if _, err := f.Write(w.hdr[:]); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// writeHeader rewrites header in place
+func (w *ChunkWriter) writeHeader() error {
+	w.header.marshal(w.hdr[:])
+	_, err := w.f.WriteAt(w.hdr[:], 0)
+	return err
+}
+
+// parse counts complete records and timestamps range
+func (w *ChunkWriter) parse() error {
+	for w.parsed < len(w.buf) {
+		namelen, n := binary.Uvarint(w.buf[w.parsed:])
+		if n < 0 {
+			return errors.New("invalid record")
+		}
+		if n == 0 {
+			// incomplete
+			return nil
+		}
+		end := w.parsed + n + int(namelen) + 18
+		if end > len(w.buf) {
+			return nil
+		}
+
+		ts := binary.LittleEndian.Uint32(w.buf[end-10 : end-6])
+		if !w.hasStats || ts < w.header.MinTimestamp {
+			w.header.MinTimestamp = ts
+		}
+		if !w.hasStats || ts > w.header.MaxTimestamp {
+			w.header.MaxTimestamp = ts
+		}
+		w.hasStats = true
+
+		w.records++
+		w.parsed = end
+	}
+	return nil
+}
+
+func (w *ChunkWriter) compress(src []byte) (config.CompAlgo, []byte, error) {
+	switch w.algo {
+	case config.CompAlgoLZ4:
+		// dst shorter than bound: zero size means incompressible data
+		dst := w.block[chunkBlockHeaderSize:cap(w.block)]
+		if len(dst) > len(src) {
+			dst = dst[:len(src)]
+		}
+		var n int
+		var err error
+		if w.level > 0 {
+			n, err = lz4.CompressBlockHC(src, dst, w.level)
+		} else {
+			n, err = lz4.CompressBlock(src, dst, nil)
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+		if n > 0 {
+			return config.CompAlgoLZ4, dst[:n], nil
+		}
+	case config.CompAlgoZstd:
+		return config.CompAlgoZstd, w.zstd.EncodeAll(src, w.block[chunkBlockHeaderSize:chunkBlockHeaderSize]), nil
+	case config.CompAlgoSnappy:
+		return config.CompAlgoSnappy, snappy.Encode(w.block[chunkBlockHeaderSize:cap(w.block)], src), nil
+	}
+	return config.CompAlgoNone, src, nil
+}
+
+// writeBlock writes complete records from buffer as one block
+func (w *ChunkWriter) writeBlock() error {
+	if w.parsed == 0 {
+		return nil
+	}
+
+	src := w.buf[:w.parsed]
+	if need := chunkBlockHeaderSize + snappy.MaxEncodedLen(len(src)); cap(w.block) < need {
+		w.block = make([]byte, chunkBlockHeaderSize, need)
+	}
+
+	algo, payload, err := w.compress(src)
+	if err != nil {
+		return err
+	}
+
+	size := len(payload)
+	if w.key != nil {
+		size += chunkEncryptionSize
+	}
+
+	// block header: magic{4}, algo{1}, flags{1}, reserved{2}, payload size{4}, raw size{4}, records{4}, crc{4}
+	hdr := w.block[:chunkBlockHeaderSize]
+	copy(hdr[0:4], chunkBlockMagic)
+	hdr[4] = byte(algo)
+	hdr[5], hdr[6], hdr[7] = 0, 0, 0
+	if w.key != nil {
+		hdr[5] = chunkBlockFlagEncrypted
+	}
+	binary.LittleEndian.PutUint32(hdr[8:], uint32(size))
+	binary.LittleEndian.PutUint32(hdr[12:], uint32(len(src)))
+	binary.LittleEndian.PutUint32(hdr[16:], w.records)
+
+	if w.key != nil {
+		if payload, err = w.key.seal(w.sealed, payload, hdr[4:20]); err != nil {
+			return err
+		}
+		w.sealed = payload
+	}
+	crc := crc32.Update(crc32.Checksum(hdr[4:20], crc32c), crc32c, payload)
+	binary.LittleEndian.PutUint32(hdr[20:], crc)
+
+	if _, err = w.f.Write(hdr); err != nil {
+		return err
+	}
+	if _, err = w.f.Write(payload); err != nil {
+		return err
+	}
+
+	w.header.Records += uint64(w.records)
+	w.records = 0
+	n := copy(w.buf, w.buf[w.parsed:])
+	w.buf = w.buf[:n]
+	w.parsed = 0
+
+	return nil
+}
+
+func (w *ChunkWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	written := len(p)
+
+	for len(p) > 0 {
+		n := len(p)
+		if free := cap(w.buf) - len(w.buf); n > free {
+			n = free
+		}
+		w.buf = append(w.buf, p[:n]...)
+		p = p[n:]
+
+		if err := w.parse(); err != nil {
+			return 0, err
+		}
+		if w.parsed >= ChunkBlockSize || len(w.buf) == cap(w.buf) {
+			if w.parsed == 0 {
+				return 0, errors.New("record is too long")
+			}
+			if err := w.writeBlock(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return written, nil
+}
+
+// Flush writes all complete records as block
+func (w *ChunkWriter) Flush() error {
+	if w.closed {
+		return os.ErrClosed
+	}
+	return w.writeBlock()
+}
+
+// Close flushes data and rewrites header with final stats. File is not closed
+func (w *ChunkWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+
+	err := w.writeBlock()
+	if err == nil && len(w.buf) > 0 {
+		err = fmt.Errorf("incomplete record dropped, %d bytes", len(w.buf))
+	}
+
+	w.closed = true
+	if w.zstd != nil {
+		w.zstd.Close()
+	}
+
+	w.header.Finished = true
+	if herr := w.writeHeader(); err == nil {
+		err = herr
+	}
+
+	return err
+}
+
+// chunkReader returns uncompressed records from valid blocks of chunk file.
+// On corrupted block it resyncs to next block magic
+type chunkReader struct {
+	r         *bufio.Reader
+	data      []byte // uncompressed records of current block
+	raw       []byte
+	zstd      *zstd.Decoder
+	plain     []byte // decrypted payload
+	corrupted int
+	// keyErr is error of missing or wrong encryption key, read is stopped
+	keyErr error
+	eof    bool
+}
+
+// max compressed and encrypted block with header after magic
+var chunkMaxBlockSize = chunkBlockHeaderSize + snappy.MaxEncodedLen(ChunkBlockSize+WriteBufferSize) + chunkEncryptionSize
+
+func newChunkReader(r io.Reader) *chunkReader {
+	return &chunkReader{r: bufio.NewReaderSize(r, chunkMaxBlockSize)}
+}
+
+// findBlock consumes bytes until block magic
+func (c *chunkReader) findBlock() error {
+	matched := 0
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b == chunkBlockMagic[matched] {
+			matched++
+			if matched == len(chunkBlockMagic) {
+				return nil
+			}
+		} else if b == chunkBlockMagic[0] {
+			matched = 1
+		} else {
+			matched = 0
+		}
+	}
+}
+
+func (c *chunkReader) decompress(algo config.CompAlgo, payload []byte, rawSize int) ([]byte, error) {
+	if cap(c.raw) < rawSize {
+		c.raw = make([]byte, rawSize)
+	}
+	raw := c.raw[:rawSize]
+
+	switch algo {
+	case config.CompAlgoNone:
+		if len(payload) != rawSize {
+			return nil, errors.New("block size mismatch")
+		}
+		copy(raw, payload)
+		return raw, nil
+	case config.CompAlgoLZ4:
+		n, err := lz4.UncompressBlock(payload, raw)
+		if err != nil {
+			return nil, err
+		}
+		return raw[:n], nil
+	case config.CompAlgoZstd:
+		if c.zstd == nil {
+			zstdDicts.RLock()
+			dicts := zstdDicts.dicts
+			zstdDicts.RUnlock()
+
+			d, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderDicts(dicts...))
+			if err != nil {
+				return nil, err
+			}
+			c.zstd = d
+		}
+		return c.zstd.DecodeAll(payload, raw[:0])
+	case config.CompAlgoSnappy:
+		return snappy.Decode(raw, payload)
+	}
+	return nil, fmt.Errorf("unknown block compression %d", algo)
+}
+
+// nextBlock reads next valid block. Corrupted blocks are counted and skipped.
+// Block is peeked and consumed only if valid, so resync continues right after magic of corrupted block
+func (c *chunkReader) nextBlock() error {
+	const hdrSize = chunkBlockHeaderSize - 4 // after magic
+
+	for {
+		if err := c.findBlock(); err != nil {
+			return io.EOF
+		}
+
+		// algo{1}, flags{1}, reserved{2}, payload size{4}, raw size{4}, records{4}, crc{4}
+		hdr, err := c.r.Peek(hdrSize)
+		if err != nil {
+			// truncated tail of unfinished chunk
+			c.corrupted++
+			return io.EOF
+		}
+
+		algo := config.CompAlgo(hdr[0])
+		flags := hdr[1]
+		size := int(binary.LittleEndian.Uint32(hdr[4:]))
+		rawSize := int(binary.LittleEndian.Uint32(hdr[8:]))
+		crc := binary.LittleEndian.Uint32(hdr[16:])
+
+		if rawSize > ChunkBlockSize+WriteBufferSize || hdrSize+size > chunkMaxBlockSize {
+			c.corrupted++
+			continue
+		}
+
+		block, err := c.r.Peek(hdrSize + size)
+		if err != nil || crc32.Update(crc32.Checksum(block[:16], crc32c), crc32c, block[hdrSize:]) != crc {
+			c.corrupted++
+			continue
+		}
+
+		payload := block[hdrSize:]
+		if flags&chunkBlockFlagEncrypted != 0 {
+			// block is valid, so decryption error is wrong key, not corruption
+			if payload, err = openBlock(c.plain, payload, block[:16]); err != nil {
+				c.keyErr = err
+				return err
+			}
+			c.plain = payload
+		}
+
+		raw, err := c.decompress(algo, payload, rawSize)
+		if err != nil || len(raw) != rawSize {
+			c.corrupted++
+			continue
+		}
+
+		c.r.Discard(hdrSize + size)
+		c.data = raw
+		return nil
+	}
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.data) == 0 {
+		if c.eof {
+			return 0, io.EOF
+		}
+		if err := c.nextBlock(); err != nil {
+			c.eof = true
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.data)
+	c.data = c.data[n:]
+	return n, nil
+}
+
+// chunkBlock reads records of current block of chunk only, so corrupted record doesn't affect next blocks
+type chunkBlock struct {
+	c *chunkReader
+}
+
+func (b chunkBlock) Read(p []byte) (int, error) {
+	if len(b.c.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, b.c.data)
+	b.c.data = b.c.data[n:]
+	return n, nil
+}
+
+func (c *chunkReader) Close() error {
+	if c.zstd != nil {
+		c.zstd.Close()
+	}
+	return nil
+}
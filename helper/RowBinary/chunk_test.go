@@ -0,0 +1,212 @@
+package RowBinary
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lomik/carbon-clickhouse/helper/config"
+)
+
+// writeChunk writes legacy test data as chunk, split to blocks of ~blockSize bytes
+func writeChunk(t *testing.T, filename string, algo config.CompAlgo, data []byte, blockSize int) {
+	f, err := os.Create(filename)
+	require.NoError(t, err)
+	defer f.Close()
+
+	w, err := NewChunkWriter(f, algo, 0, nil)
+	require.NoError(t, err)
+
+	// odd writes, records are split between Write calls
+	for p := data; len(p) > 0; {
+		n := 1000
+		if n > len(p) {
+			n = len(p)
+		}
+		_, err = w.Write(p[:n])
+		require.NoError(t, err)
+		p = p[n:]
+
+		if len(w.buf) >= blockSize {
+			require.NoError(t, w.Flush())
+		}
+	}
+
+	require.NoError(t, w.Close())
+}
+
+func TestChunkWriterReader(t *testing.T) {
+	r, err := NewReader("testdata/default.1559465733030407809", false)
+	require.NoError(t, err)
+	want, err := io.ReadAll(r)
+	require.NoError(t, err)
+	r.Close()
+	require.NotEmpty(t, want)
+
+	tests := []struct {
+		name string
+		algo config.CompAlgo
+	}{
+		{"none", config.CompAlgoNone},
+		{"lz4", config.CompAlgoLZ4},
+		{"zstd", config.CompAlgoZstd},
+		{"snappy", config.CompAlgoSnappy},
+	}
+
+	for _, tt := range tests {
+		algo := tt.algo
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "default.1")
+			writeChunk(t, filename, algo, want, len(want))
+
+			r, err := NewReader(filename, false)
+			require.NoError(t, err)
+			defer r.Close()
+
+			h := r.Header()
+			require.NotNil(t, h)
+			assert.Equal(t, uint16(ChunkVersion), h.Version)
+			assert.Equal(t, algo, h.Compression)
+			assert.True(t, h.Finished)
+
+			var records uint64
+			min, max := uint32(0xFFFFFFFF), uint32(0)
+			var got []byte
+			for {
+				_, err := r.ReadRecord()
+				if err != nil {
+					assert.Equal(t, io.EOF, err)
+					break
+				}
+				got = append(got, r.line[:r.size]...)
+				records++
+				if r.Timestamp() < min {
+					min = r.Timestamp()
+				}
+				if r.Timestamp() > max {
+					max = r.Timestamp()
+				}
+			}
+
+			assert.Equal(t, want, got)
+			assert.Equal(t, records, h.Records)
+			assert.Equal(t, min, h.MinTimestamp)
+			assert.Equal(t, max, h.MaxTimestamp)
+			assert.Equal(t, 0, r.CorruptedBlocks())
+		})
+	}
+}
+
+func TestChunkReaderCorrupted(t *testing.T) {
+	r, err := NewReader("testdata/default.1559465733030407809", false)
+	require.NoError(t, err)
+	want, err := io.ReadAll(r)
+	require.NoError(t, err)
+	r.Close()
+
+	filename := filepath.Join(t.TempDir(), "default.1")
+	writeChunk(t, filename, config.CompAlgoNone, want, len(want)/4)
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+
+	// damage payload of second block
+	blocks := []int{}
+	for i := ChunkHeaderSize; ; {
+		p := bytes.Index(data[i:], chunkBlockMagic)
+		if p < 0 {
+			break
+		}
+		blocks = append(blocks, i+p)
+		i += p + len(chunkBlockMagic)
+	}
+	require.True(t, len(blocks) >= 3)
+	data[blocks[1]+chunkBlockHeaderSize+10] ^= 0xFF
+	// and truncate last block
+	data = data[:len(data)-5]
+	require.NoError(t, os.WriteFile(filename, data, 0644))
+
+	r, err = NewReader(filename, false)
+	require.NoError(t, err)
+	defer r.Close()
+
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, 2, r.CorruptedBlocks())
+
+	// uncompressed payloads of all blocks except corrupted and truncated
+	var expected []byte
+	for i, b := range blocks[:len(blocks)-1] {
+		if i == 1 {
+			continue
+		}
+		expected = append(expected, data[b+chunkBlockHeaderSize:blocks[i+1]]...)
+	}
+	assert.Equal(t, expected, got)
+	assert.Less(t, len(got), len(want))
+}
+
+func TestReadChunkHeaderLegacy(t *testing.T) {
+	h, err := ReadChunkHeader("testdata/default.1559465733030407809")
+	require.NoError(t, err)
+	assert.Nil(t, h)
+}
+
+func TestChunkReaderCorruptedRecord(t *testing.T) {
+	r, err := NewReader("testdata/default.1559465733030407809", false)
+	require.NoError(t, err)
+	want, err := io.ReadAll(r)
+	require.NoError(t, err)
+	r.Close()
+
+	filename := filepath.Join(t.TempDir(), "default.1")
+	writeChunk(t, filename, config.CompAlgoNone, want, len(want)/4)
+
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+
+	blocks := []int{}
+	for i := ChunkHeaderSize; ; {
+		p := bytes.Index(data[i:], chunkBlockMagic)
+		if p < 0 {
+			break
+		}
+		blocks = append(blocks, i+p)
+		i += p + len(chunkBlockMagic)
+	}
+	require.True(t, len(blocks) >= 3)
+	blocks = append(blocks, len(data))
+
+	// out of range length of name in second block with valid checksum
+	b := blocks[1]
+	payload := data[b+chunkBlockHeaderSize : blocks[2]]
+	copy(payload, []byte{0xFF, 0xFF, 0x7F})
+	crc := crc32.Update(crc32.Checksum(data[b+4:b+20], crc32c), crc32c, payload)
+	binary.LittleEndian.PutUint32(data[b+20:], crc)
+	require.NoError(t, os.WriteFile(filename, data, 0644))
+
+	r, err = NewReader(filename, false)
+	require.NoError(t, err)
+	defer r.Close()
+
+	// reader resyncs to next block
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, 1, r.CorruptedBlocks())
+
+	var expected []byte
+	for i, b := range blocks[:len(blocks)-1] {
+		if i == 1 {
+			continue
+		}
+		expected = append(expected, data[b+chunkBlockHeaderSize:blocks[i+1]]...)
+	}
+	assert.Equal(t, expected, got)
+}
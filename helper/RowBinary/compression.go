@@ -0,0 +1,82 @@
+package RowBinary
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+)
+
+const (
+	ZstdExtension   = ".zst"
+	SnappyExtension = ".sz"
+)
+
+var zstdDicts struct {
+	sync.RWMutex
+	dicts [][]byte
+}
+
+// LoadZstdDictionary reads trained zstd dictionary from file and registers it for decompression of chunk files.
+// Decoder selects dictionary by ID from frame header, so files compressed with previously loaded dictionaries stay readable
+func LoadZstdDictionary(filename string) ([]byte, error) {
+	dict, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// validate
+	d, err := zstd.NewReader(nil, zstd.WithDecoderDicts(dict))
+	if err != nil {
+		return nil, err
+	}
+	d.Close()
+
+	zstdDicts.Lock()
+	defer zstdDicts.Unlock()
+
+	for _, d := range zstdDicts.dicts {
+		if bytes.Equal(d, dict) {
+			// already loaded (config reload)
+			return dict, nil
+		}
+	}
+	zstdDicts.dicts = append(zstdDicts.dicts, dict)
+
+	return dict, nil
+}
+
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return nil
+}
+
+// decompressReader returns reader of uncompressed data. Compression is detected by file extension
+func decompressReader(filename string, r io.Reader) (io.Reader, io.Closer, error) {
+	switch {
+	case strings.HasSuffix(filename, lz4.Extension):
+		return lz4.NewReader(r), nil, nil
+	case strings.HasSuffix(filename, ZstdExtension):
+		zstdDicts.RLock()
+		dicts := zstdDicts.dicts
+		zstdDicts.RUnlock()
+
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderDicts(dicts...))
+		if err != nil {
+			return nil, nil, err
+		}
+		return d, zstdReadCloser{d}, nil
+	case strings.HasSuffix(filename, SnappyExtension):
+		return snappy.NewReader(r), nil, nil
+	}
+	return r, nil, nil
+}
@@ -0,0 +1,77 @@
+package RowBinary
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewReaderCompression(t *testing.T) {
+	raw, err := os.ReadFile("testdata/default.1559465733030407809")
+	require.NoError(t, err)
+
+	r, err := NewReader("testdata/default.1559465733030407809", false)
+	require.NoError(t, err)
+	want, err := io.ReadAll(r)
+	require.NoError(t, err)
+	r.Close()
+
+	dict, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       1,
+		Contents: [][]byte{raw[:len(raw)/2], raw[len(raw)/2:]},
+		History:  raw[:4096],
+		Offsets:  [3]int{1, 4, 8},
+	})
+	require.NoError(t, err)
+
+	dictFile := filepath.Join(t.TempDir(), "dict")
+	require.NoError(t, os.WriteFile(dictFile, dict, 0644))
+	_, err = LoadZstdDictionary(dictFile)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		extension string
+		writer    func(w io.Writer) io.WriteCloser
+	}{
+		{"lz4", lz4.Extension, func(w io.Writer) io.WriteCloser { return lz4.NewWriter(w) }},
+		{"zstd", ZstdExtension, func(w io.Writer) io.WriteCloser {
+			zw, _ := zstd.NewWriter(w)
+			return zw
+		}},
+		{"zstd with dictionary", ZstdExtension, func(w io.Writer) io.WriteCloser {
+			zw, _ := zstd.NewWriter(w, zstd.WithEncoderDict(dict))
+			return zw
+		}},
+		{"snappy", SnappyExtension, func(w io.Writer) io.WriteCloser { return snappy.NewBufferedWriter(w) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var compressed bytes.Buffer
+			cw := tt.writer(&compressed)
+			_, err := cw.Write(raw)
+			require.NoError(t, err)
+			require.NoError(t, cw.Close())
+
+			filename := filepath.Join(t.TempDir(), "default.1559465733030407809"+tt.extension)
+			require.NoError(t, os.WriteFile(filename, compressed.Bytes(), 0644))
+
+			r, err := NewReader(filename, false)
+			require.NoError(t, err)
+			defer r.Close()
+
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package RowBinary
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Encrypted block payload: key id{4}, nonce{12}, AES-GCM sealed compressed data with tag{16}.
+// Block header (after magic) is authenticated as additional data
+const (
+	chunkKeyIDSize      = 4
+	chunkNonceSize      = 12
+	chunkEncryptionSize = chunkKeyIDSize + chunkNonceSize + 16
+)
+
+var encryptionKeys struct {
+	sync.RWMutex
+	keys map[uint32]cipher.AEAD
+}
+
+// EncryptionKey is AES-GCM key of chunk files
+type EncryptionKey struct {
+	ID   uint32
+	aead cipher.AEAD
+}
+
+func newEncryptionKey(id uint32, key []byte) (*EncryptionKey, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptionKey{ID: id, aead: aead}, nil
+}
+
+func decodeKey(s string) ([]byte, error) {
+	if key, err := hex.DecodeString(s); err == nil {
+		return key, nil
+	}
+	return base64.StdEncoding.DecodeString(s)
+}
+
+// parseEncryptionKeys parses key file. Every line is "<id> <key>", id is non-zero number,
+// key is hex or base64 encoded 16, 24 or 32 bytes (AES-128, AES-192 or AES-256). Empty lines and # comments are ignored
+func parseEncryptionKeys(filename string) ([]*EncryptionKey, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []*EncryptionKey
+	seen := make(map[uint32]bool)
+
+	s := bufio.NewScanner(f)
+	for lineNo := 1; s.Scan(); lineNo++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: \"<id> <key>\" expected", filename, lineNo)
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil || id == 0 {
+			return nil, fmt.Errorf("%s:%d: invalid key id %#v", filename, lineNo, fields[0])
+		}
+		if seen[uint32(id)] {
+			return nil, fmt.Errorf("%s:%d: duplicate key id %d", filename, lineNo, id)
+		}
+		seen[uint32(id)] = true
+
+		raw, err := decodeKey(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: key is not hex or base64 encoded", filename, lineNo)
+		}
+		key, err := newEncryptionKey(uint32(id), raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", filename, lineNo, err.Error())
+		}
+		keys = append(keys, key)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no keys", filename)
+	}
+	return keys, nil
+}
+
+// LoadEncryptionKeys reads key file and registers all keys for decryption of chunk files.
+// Last key of file is returned for encryption of new chunks. Rotation: append new key to file
+// and keep old keys while chunks encrypted with them exist
+func LoadEncryptionKeys(filename string) (*EncryptionKey, error) {
+	keys, err := parseEncryptionKeys(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	encryptionKeys.Lock()
+	defer encryptionKeys.Unlock()
+
+	if encryptionKeys.keys == nil {
+		encryptionKeys.keys = make(map[uint32]cipher.AEAD)
+	}
+	for _, k := range keys {
+		encryptionKeys.keys[k.ID] = k.aead
+	}
+
+	return keys[len(keys)-1], nil
+}
+
+func encryptionKey(id uint32) cipher.AEAD {
+	encryptionKeys.RLock()
+	defer encryptionKeys.RUnlock()
+	return encryptionKeys.keys[id]
+}
+
+// seal encrypts payload to dst (key id, nonce, sealed data). ad is authenticated block header
+func (k *EncryptionKey) seal(dst []byte, payload []byte, ad []byte) ([]byte, error) {
+	if need := chunkEncryptionSize + len(payload); cap(dst) < need {
+		dst = make([]byte, 0, need)
+	}
+	dst = binary.LittleEndian.AppendUint32(dst[:0], k.ID)
+	nonce := dst[chunkKeyIDSize : chunkKeyIDSize+chunkNonceSize]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return k.aead.Seal(dst[:chunkKeyIDSize+chunkNonceSize], nonce, payload, ad), nil
+}
+
+// ErrEncryptionKey is returned on read of block encrypted with unknown or wrong key
+var ErrEncryptionKey = errors.New("encryption key")
+
+// openBlock decrypts payload of encrypted block to dst
+func openBlock(dst []byte, payload []byte, ad []byte) ([]byte, error) {
+	if len(payload) < chunkEncryptionSize {
+		return nil, errors.New("encrypted block is too short")
+	}
+	id := binary.LittleEndian.Uint32(payload)
+	aead := encryptionKey(id)
+	if aead == nil {
+		return nil, fmt.Errorf("%w %d is not loaded", ErrEncryptionKey, id)
+	}
+	data, err := aead.Open(dst[:0], payload[chunkKeyIDSize:chunkKeyIDSize+chunkNonceSize], payload[chunkKeyIDSize+chunkNonceSize:], ad)
+	if err != nil {
+		return nil, fmt.Errorf("block decryption failed, wrong %w %d", ErrEncryptionKey, id)
+	}
+	return data, nil
+}
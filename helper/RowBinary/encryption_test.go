@@ -0,0 +1,187 @@
+package RowBinary
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lomik/carbon-clickhouse/helper/config"
+)
+
+func writeKeyFile(t *testing.T, dir string, lines ...string) string {
+	filename := filepath.Join(dir, "keys")
+	require.NoError(t, os.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0600))
+	return filename
+}
+
+func writeEncryptedChunk(t *testing.T, filename string, algo config.CompAlgo, data []byte, key *EncryptionKey) {
+	f, err := os.Create(filename)
+	require.NoError(t, err)
+	defer f.Close()
+
+	w, err := NewEncryptedChunkWriter(f, algo, 0, nil, key)
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+}
+
+func testRecords(t *testing.T) []byte {
+	r, err := NewReader("testdata/default.1559465733030407809", false)
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NotEmpty(t, data)
+	return data
+}
+
+func TestParseEncryptionKeys(t *testing.T) {
+	key16 := bytes.Repeat([]byte{1}, 16)
+	key32 := bytes.Repeat([]byte{2}, 32)
+
+	tests := []struct {
+		name    string
+		lines   []string
+		ids     []uint32
+		wantErr string
+	}{
+		{"hex and base64", []string{"# comment", "", "1 " + hex.EncodeToString(key16), "2 " + base64.StdEncoding.EncodeToString(key32)}, []uint32{1, 2}, ""},
+		{"empty", []string{"# no keys"}, nil, "no keys"},
+		{"zero id", []string{"0 " + hex.EncodeToString(key16)}, nil, "invalid key id"},
+		{"duplicate id", []string{"1 " + hex.EncodeToString(key16), "1 " + hex.EncodeToString(key32)}, nil, "duplicate key id"},
+		{"bad encoding", []string{"1 !!!"}, nil, "not hex or base64"},
+		{"bad size", []string{"1 " + hex.EncodeToString(key16[:10])}, nil, "invalid key size"},
+		{"no key", []string{"1"}, nil, "expected"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := parseEncryptionKeys(writeKeyFile(t, t.TempDir(), tt.lines...))
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			var ids []uint32
+			for _, k := range keys {
+				ids = append(ids, k.ID)
+			}
+			assert.Equal(t, tt.ids, ids)
+		})
+	}
+}
+
+func TestEncryptedChunkRotation(t *testing.T) {
+	want := testRecords(t)
+	dir := t.TempDir()
+
+	key1 := hex.EncodeToString(bytes.Repeat([]byte{0x11}, 32))
+	key2 := hex.EncodeToString(bytes.Repeat([]byte{0x12}, 32))
+
+	active, err := LoadEncryptionKeys(writeKeyFile(t, dir, "101 "+key1))
+	require.NoError(t, err)
+	require.Equal(t, uint32(101), active.ID)
+	old := filepath.Join(dir, "default.1")
+	writeEncryptedChunk(t, old, config.CompAlgoLZ4, want, active)
+
+	// rotation: new key appended, last key is active
+	active, err = LoadEncryptionKeys(writeKeyFile(t, dir, "101 "+key1, "102 "+key2))
+	require.NoError(t, err)
+	require.Equal(t, uint32(102), active.ID)
+	cur := filepath.Join(dir, "default.2")
+	writeEncryptedChunk(t, cur, config.CompAlgoNone, want, active)
+
+	for filename, keyID := range map[string]uint32{old: 101, cur: 102} {
+		raw, err := os.ReadFile(filename)
+		require.NoError(t, err)
+		// metric names are not stored in clear text
+		assert.False(t, bytes.Contains(raw, want[1:30]), filename)
+
+		h, err := ReadChunkHeader(filename)
+		require.NoError(t, err)
+		assert.Equal(t, keyID, h.KeyID)
+
+		r, err := NewReader(filename, false)
+		require.NoError(t, err)
+		got, err := io.ReadAll(r)
+		r.Close()
+		require.NoError(t, err)
+		assert.Equal(t, want, got, filename)
+		assert.Equal(t, 0, r.CorruptedBlocks())
+	}
+}
+
+func TestEncryptedChunkKeyErrors(t *testing.T) {
+	want := testRecords(t)
+	dir := t.TempDir()
+
+	key, err := newEncryptionKey(201, bytes.Repeat([]byte{0x21}, 16))
+	require.NoError(t, err)
+	filename := filepath.Join(dir, "default.1")
+	writeEncryptedChunk(t, filename, config.CompAlgoZstd, want, key)
+
+	// key is not loaded
+	r, err := NewReader(filename, false)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	r.Close()
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrEncryptionKey))
+
+	// repair doesn't treat unreadable chunk as damaged
+	_, err = RepairFile(filename)
+	assert.True(t, errors.Is(err, ErrEncryptionKey))
+	_, err = os.Stat(filename)
+	require.NoError(t, err)
+
+	// same id, other key material
+	_, err = LoadEncryptionKeys(writeKeyFile(t, dir, "201 "+hex.EncodeToString(bytes.Repeat([]byte{0x22}, 16))))
+	require.NoError(t, err)
+	r, err = NewReader(filename, false)
+	require.NoError(t, err)
+	_, err = io.ReadAll(r)
+	r.Close()
+	assert.True(t, errors.Is(err, ErrEncryptionKey))
+}
+
+func TestRepairEncryptedFile(t *testing.T) {
+	want := testRecords(t)
+	dir := t.TempDir()
+
+	key, err := LoadEncryptionKeys(writeKeyFile(t, dir, "301 "+hex.EncodeToString(bytes.Repeat([]byte{0x31}, 32))))
+	require.NoError(t, err)
+
+	// damaged legacy chunk: truncated last record
+	filename := filepath.Join(dir, "default.1")
+	require.NoError(t, os.WriteFile(filename, want[:len(want)-5], 0644))
+
+	report, err := RepairEncryptedFile(filename, key)
+	require.NoError(t, err)
+	require.True(t, report.Truncated)
+	require.Equal(t, filename, report.Output)
+
+	h, err := ReadChunkHeader(filename)
+	require.NoError(t, err)
+	require.NotNil(t, h)
+	assert.Equal(t, uint32(301), h.KeyID)
+	assert.Equal(t, report.Recovered, h.Records)
+
+	r, err := NewReader(filename, false)
+	require.NoError(t, err)
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(want, got))
+	assert.Less(t, len(want)-len(got), 100)
+}
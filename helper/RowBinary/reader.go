@@ -9,15 +9,19 @@ import (
 	"io"
 	"math"
 	"os"
-	"strings"
 	"time"
-
-	"github.com/pierrec/lz4"
 )
 
+// maxNameLen is max length of name of record which fits into line buffer with length and values
+const maxNameLen = len(Reader{}.line) - binary.MaxVarintLen64 - 18
+
+// errNameTooLong is returned for corrupted length of name
+var errNameTooLong = errors.New("name too long")
+
 // Read all good records from unfinished RowBinary file.
 type Reader struct {
 	fd          *os.File
+	decomp      io.Closer
 	reader      *bufio.Reader
 	offset      int
 	size        int
@@ -25,6 +29,10 @@ type Reader struct {
 	line        [524288]byte
 	isReverse   bool
 	zeroVersion bool
+	header      *ChunkHeader
+	chunk       *chunkReader
+	stream      bool
+	err         error // read error of stream
 }
 
 func (r *Reader) SetZeroVersion(v bool) {
@@ -111,7 +119,9 @@ func (r *Reader) readRecord() ([]byte, error) {
 		return nil, err
 	}
 
-	// TODO: check namelen
+	if namelen > uint64(maxNameLen) {
+		return nil, errNameTooLong
+	}
 	r.size = binary.PutUvarint(r.line[:], namelen)
 
 	n, err := io.ReadFull(r.reader, r.line[r.size:r.size+int(namelen)])
@@ -159,7 +169,29 @@ func (r *Reader) ReadRecord() ([]byte, error) {
 	}
 
 	p, err := r.readRecord()
+	for err != nil && r.chunk != nil && r.chunk.keyErr == nil {
+		// end of block or corrupted record in valid block: rest of block is skipped
+		if err != io.EOF {
+			r.chunk.corrupted++
+		}
+		r.chunk.data = nil
+		r.reader.Reset(chunkBlock{r.chunk})
+		if err = r.chunk.nextBlock(); err != nil {
+			if err == io.EOF {
+				r.chunk.eof = true
+			}
+			break
+		}
+		p, err = r.readRecord()
+	}
 	if err != nil {
+		if r.stream && err != io.EOF {
+			r.err = err
+		}
+		if r.chunk != nil && r.chunk.keyErr != nil {
+			// chunk can't be read without key, it is not the end of data
+			r.err = r.chunk.keyErr
+		}
 		r.eof = true
 		r.size = 0
 		r.offset = 0
@@ -169,7 +201,12 @@ func (r *Reader) ReadRecord() ([]byte, error) {
 }
 
 func (r *Reader) Close() {
-	r.fd.Close()
+	if r.decomp != nil {
+		r.decomp.Close()
+	}
+	if r.fd != nil {
+		r.fd.Close()
+	}
 }
 
 func (r *Reader) Read(p []byte) (int, error) {
@@ -188,6 +225,10 @@ func (r *Reader) Read(p []byte) (int, error) {
 		} else {
 			_, err := r.ReadRecord()
 			if err != nil {
+				if r.chunk != nil && r.chunk.keyErr != nil && readed == 0 {
+					// chunk can't be read without key, it is not the end of data
+					return 0, r.chunk.keyErr
+				}
 				if readed > 0 {
 					return readed, nil
 				} else {
@@ -198,19 +239,70 @@ func (r *Reader) Read(p []byte) (int, error) {
 	}
 }
 
+// Header returns header of chunk file. Nil for legacy files
+func (r *Reader) Header() *ChunkHeader {
+	return r.header
+}
+
+// CorruptedBlocks returns count of skipped corrupted blocks (chunk format version 2 only)
+func (r *Reader) CorruptedBlocks() int {
+	if r.chunk == nil {
+		return 0
+	}
+	return r.chunk.corrupted
+}
+
+// NewStreamReader reads uncompressed records (legacy chunk format) from r.
+// Unlike file, stream is not truncated on error: read error is available by Err
+func NewStreamReader(r io.Reader, reverse bool) *Reader {
+	return &Reader{
+		isReverse: reverse,
+		reader:    bufio.NewReader(r),
+		stream:    true,
+	}
+}
+
+// Err returns read error of stream or error of encryption key (see ErrEncryptionKey). Other errors of file
+// are treated as end of valid records
+func (r *Reader) Err() error {
+	return r.err
+}
+
 func NewReader(filename string, reverse bool) (*Reader, error) {
 	fd, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	var rdr io.Reader = fd
-	if strings.HasSuffix(filename, lz4.Extension) {
-		rdr = lz4.NewReader(fd)
+	br := bufio.NewReader(fd)
+	header, err := readChunkHeader(br)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	if header != nil {
+		// compression is described by header and blocks, file extension is ignored
+		chunk := newChunkReader(br)
+		return &Reader{
+			fd:        fd,
+			decomp:    chunk,
+			isReverse: reverse,
+			reader:    bufio.NewReader(chunkBlock{chunk}),
+			header:    header,
+			chunk:     chunk,
+		}, nil
+	}
+
+	rdr, decomp, err := decompressReader(filename, br)
+	if err != nil {
+		fd.Close()
+		return nil, err
 	}
 
 	return &Reader{
 		fd:        fd,
+		decomp:    decomp,
 		isReverse: reverse,
 		reader:    bufio.NewReader(rdr),
 	}, nil
@@ -1,11 +1,48 @@
 package RowBinary
 
 import (
+	"bytes"
 	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+func TestReaderNameTooLong(t *testing.T) {
+	r, err := NewReader("testdata/default.1559465733030407809", false)
+	require.NoError(t, err)
+	want, err := io.ReadAll(r)
+	require.NoError(t, err)
+	r.Close()
+
+	// corrupted length of name after valid records
+	data := append(append([]byte(nil), want...), 0xFF, 0xFF, 0x7F)
+	data = append(data, want[:100]...)
+	filename := filepath.Join(t.TempDir(), "default.1")
+	require.NoError(t, os.WriteFile(filename, data, 0644))
+
+	r, err = NewReader(filename, false)
+	require.NoError(t, err)
+	defer r.Close()
+
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	_, err = r.ReadRecord()
+	assert.Equal(t, io.EOF, err)
+
+	// stream reports corruption
+	sr := NewStreamReader(bytes.NewReader(data), false)
+	_, err = io.ReadAll(sr)
+	require.NoError(t, err)
+	assert.Equal(t, errNameTooLong, sr.Err())
+}
+
 func BenchmarkReadFileDirect(b *testing.B) {
 	b.ReportAllocs()
 	b.ResetTimer()
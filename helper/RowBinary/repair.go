@@ -0,0 +1,230 @@
+package RowBinary
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pierrec/lz4"
+
+	"github.com/lomik/carbon-clickhouse/helper/config"
+)
+
+const (
+	// max length of name accepted by Reader
+	repairMaxNameLen   = len(Reader{}.line) - 32
+	repairMaxRecordLen = binary.MaxVarintLen64 + repairMaxNameLen + 18
+)
+
+// RepairReport describes result of chunk file repair
+type RepairReport struct {
+	Filename string `json:"filename"`
+	Output   string `json:"output,omitempty"`
+	// Recovered is count of valid records written to output
+	Recovered uint64 `json:"recovered"`
+	// CorruptedRegions is count of skipped ranges of garbage. Every range contains at least one lost record
+	CorruptedRegions uint64 `json:"corrupted_regions"`
+	LostBytes        uint64 `json:"lost_bytes"`
+	// CorruptedBlocks is count of skipped blocks of chunk format version 2
+	CorruptedBlocks int `json:"corrupted_blocks,omitempty"`
+	// Truncated is set if file ends with incomplete record
+	Truncated bool `json:"truncated"`
+	// ReadError is error of decompression. Data after it is lost
+	ReadError string `json:"read_error,omitempty"`
+}
+
+// Damaged returns true if some data was lost
+func (r *RepairReport) Damaged() bool {
+	return r.CorruptedRegions > 0 || r.CorruptedBlocks > 0 || r.Truncated || r.ReadError != ""
+}
+
+type recordCheck int
+
+const (
+	recordValid recordCheck = iota
+	recordInvalid
+	recordShort // not enough data
+)
+
+// checkRecord validates plausibility of record at the start of buf. Returns length of valid record
+func checkRecord(buf []byte) (int, recordCheck) {
+	namelen, n := binary.Uvarint(buf)
+	if n == 0 {
+		return 0, recordShort
+	}
+	if n < 0 || namelen == 0 || namelen > uint64(repairMaxNameLen) {
+		return 0, recordInvalid
+	}
+
+	end := n + int(namelen) + 18
+	for _, c := range buf[n:min(len(buf), n+int(namelen))] {
+		if c < 0x20 || c == 0x7f {
+			return 0, recordInvalid
+		}
+	}
+	if end > len(buf) {
+		return 0, recordShort
+	}
+
+	ts := binary.LittleEndian.Uint32(buf[end-10 : end-6])
+	days := binary.LittleEndian.Uint16(buf[end-6 : end-4])
+	if ts == 0 {
+		return 0, recordInvalid
+	}
+	// utc-date setting of writer is unknown
+	if days != TimestampToDays(ts) && days != UTCTimestampToDays(ts) && days != PrecalcTimestampToDays(ts) {
+		return 0, recordInvalid
+	}
+
+	return end, recordValid
+}
+
+// repairStream copies plausible records from r to out. After garbage record is accepted
+// only if next record is valid too (or it is the last record)
+func repairStream(r io.Reader, out io.Writer, report *RepairReport) error {
+	br := bufio.NewReaderSize(r, 2*repairMaxRecordLen)
+	resync := false
+
+	for {
+		buf, err := br.Peek(2 * repairMaxRecordLen)
+		if len(buf) == 0 {
+			if err != nil && err != io.EOF {
+				report.ReadError = err.Error()
+			}
+			return nil
+		}
+		eof := err != nil
+		if eof && err != io.EOF {
+			report.ReadError = err.Error()
+		}
+
+		n, check := checkRecord(buf)
+		if check == recordValid && resync && n < len(buf) {
+			if _, next := checkRecord(buf[n:]); next == recordInvalid || (next == recordShort && !eof) {
+				check = recordInvalid
+			}
+		}
+
+		switch {
+		case check == recordValid:
+			if _, err := out.Write(buf[:n]); err != nil {
+				return err
+			}
+			report.Recovered++
+			resync = false
+			br.Discard(n)
+		case check == recordShort && eof:
+			// incomplete record at the end of file
+			report.Truncated = true
+			report.LostBytes += uint64(len(buf))
+			br.Discard(len(buf))
+		default:
+			if !resync {
+				report.CorruptedRegions++
+				resync = true
+			}
+			report.LostBytes++
+			br.Discard(1)
+		}
+	}
+}
+
+// Repair reads all plausible records from chunk file and writes them to output as uncompressed legacy chunk
+func Repair(filename string, out io.Writer) (*RepairReport, error) {
+	report := &RepairReport{Filename: filename}
+
+	r, err := NewReader(filename, false)
+	if err != nil {
+		return report, err
+	}
+	defer r.Close()
+
+	// records of all blocks as one stream, records are checked by repairStream
+	var src io.Reader = r.reader
+	if r.chunk != nil {
+		src = r.chunk
+	}
+	if err = repairStream(src, out, report); err != nil {
+		return report, err
+	}
+	report.CorruptedBlocks = r.CorruptedBlocks()
+	if r.chunk != nil && r.chunk.keyErr != nil {
+		// data is not damaged, it can't be read without key
+		return report, r.chunk.keyErr
+	}
+
+	return report, nil
+}
+
+// RepairedFilename is name of uncompressed repaired chunk
+func RepairedFilename(filename string) string {
+	for _, ext := range []string{lz4.Extension, ZstdExtension, SnappyExtension} {
+		if strings.HasSuffix(filename, ext) {
+			return strings.TrimSuffix(filename, ext)
+		}
+	}
+	return filename
+}
+
+// RepairFile repairs chunk in place. Damaged file is replaced by uncompressed repaired one (see RepairedFilename)
+func RepairFile(filename string) (*RepairReport, error) {
+	return RepairEncryptedFile(filename, nil)
+}
+
+// RepairEncryptedFile repairs chunk in place like RepairFile. If key is not nil, repaired
+// file is uncompressed chunk of format version 2 encrypted with key
+func RepairEncryptedFile(filename string, key *EncryptionKey) (*RepairReport, error) {
+	dir, name := filepath.Split(filename)
+	tmp, err := os.CreateTemp(dir, ".repair."+name+".")
+	if err != nil {
+		return &RepairReport{Filename: filename}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	report, err := RepairTo(filename, tmp, key)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || !report.Damaged() {
+		return report, err
+	}
+
+	report.Output = RepairedFilename(filename)
+	if err = os.Rename(tmp.Name(), report.Output); err != nil {
+		return report, err
+	}
+	if report.Output != filename {
+		err = os.Remove(filename)
+	}
+
+	return report, err
+}
+
+// RepairTo writes repaired chunk to out file. If key is not nil, output is encrypted
+// uncompressed chunk of format version 2, otherwise uncompressed legacy chunk
+func RepairTo(filename string, out *os.File, key *EncryptionKey) (*RepairReport, error) {
+	if key == nil {
+		bw := bufio.NewWriterSize(out, 1024*1024)
+		report, err := Repair(filename, bw)
+		if err == nil {
+			err = bw.Flush()
+		}
+		return report, err
+	}
+
+	cw, err := NewEncryptedChunkWriter(out, config.CompAlgoNone, 0, nil, key)
+	if err != nil {
+		return &RepairReport{Filename: filename}, err
+	}
+	report, err := Repair(filename, cw)
+	if cerr := cw.Close(); err == nil {
+		err = cerr
+	}
+	return report, err
+}
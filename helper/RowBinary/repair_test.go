@@ -0,0 +1,62 @@
+package RowBinary
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairFile(t *testing.T) {
+	r, err := NewReader("testdata/default.1559465733030407809", false)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	r.Close()
+
+	// record offsets
+	var offsets []int
+	for p := 0; p < len(data); {
+		n, check := checkRecord(data[p:])
+		require.Equal(t, recordValid, check)
+		offsets = append(offsets, p)
+		p += n
+	}
+	require.True(t, len(offsets) > 20)
+
+	// damage records 10 and 11, truncate last record
+	damaged := make([]byte, len(data))
+	copy(damaged, data)
+	for i := offsets[10] + 3; i < offsets[12]-2; i++ {
+		damaged[i] = 0x01
+	}
+	damaged = damaged[:len(damaged)-5]
+
+	filename := filepath.Join(t.TempDir(), "default.1")
+	require.NoError(t, os.WriteFile(filename, damaged, 0644))
+
+	report, err := RepairFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, filename, report.Output)
+	assert.Equal(t, uint64(len(offsets)-3), report.Recovered)
+	assert.Equal(t, uint64(1), report.CorruptedRegions)
+	assert.True(t, report.Truncated)
+	assert.True(t, report.Damaged())
+
+	repaired, err := os.ReadFile(filename)
+	require.NoError(t, err)
+
+	expected := append([]byte{}, data[:offsets[10]]...)
+	expected = append(expected, data[offsets[12]:offsets[len(offsets)-1]]...)
+	assert.True(t, bytes.Equal(expected, repaired))
+
+	// repaired file is clean
+	report, err = RepairFile(filename)
+	require.NoError(t, err)
+	assert.False(t, report.Damaged())
+	assert.Equal(t, "", report.Output)
+}
@@ -42,6 +42,11 @@ func GetWriterBufferWithConfirm(wg *sync.WaitGroup, errorChan chan error) *Write
 	return b
 }
 
+// GetPart returns empty buffer with confirmation of wb. Waiter of wb confirmation also waits for all parts
+func (wb *WriteBuffer) GetPart() *WriteBuffer {
+	return GetWriterBufferWithConfirm(wb.wg, wb.errorChan)
+}
+
 func (wb *WriteBuffer) ConfirmRequired() bool {
 	return wb.wg != nil
 }
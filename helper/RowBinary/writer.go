@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/binary"
 	"io"
+	"sync"
 	"time"
 )
 
@@ -14,6 +15,8 @@ type Writer struct {
 	pointsWritten uint32
 	writeErrors   uint32
 	now           uint32
+	wg            *sync.WaitGroup
+	errorChan     chan error
 }
 
 func WriteUint16(w io.Writer, value uint16) error {
@@ -50,6 +53,14 @@ func NewWriter(ctx context.Context, writeChan chan *WriteBuffer) *Writer {
 	}
 }
 
+// NewWriterWithConfirm creates Writer with confirmable buffers. Use Wait after Flush for wait until all points are written to disk
+func NewWriterWithConfirm(ctx context.Context, writeChan chan *WriteBuffer) *Writer {
+	w := NewWriter(ctx, writeChan)
+	w.wg = new(sync.WaitGroup)
+	w.errorChan = make(chan error, 1)
+	return w
+}
+
 func (w *Writer) Now() uint32 {
 	return w.now
 }
@@ -57,13 +68,18 @@ func (w *Writer) Now() uint32 {
 func (w *Writer) Flush() {
 	if w.wb != nil {
 		if w.wb.Empty() {
+			if w.wb.ConfirmRequired() {
+				w.wb.Confirm()
+			}
 			w.wb.Release()
 		} else {
 			select {
 			case w.writeChan <- w.wb:
 				// pass
 			case <-w.ctx.Done():
-				// pass
+				if w.wb.ConfirmRequired() {
+					w.wb.Fail(w.ctx.Err())
+				}
 			}
 		}
 		w.wb = nil
@@ -72,7 +88,7 @@ func (w *Writer) Flush() {
 
 func (w *Writer) WritePoint(metric string, value float64, timestamp int64) {
 	if w.wb == nil {
-		w.wb = GetWriteBuffer()
+		w.wb = GetWriterBufferWithConfirm(w.wg, w.errorChan)
 	}
 	if !w.wb.CanWriteGraphitePoint(len(metric)) {
 		w.Flush()
@@ -81,7 +97,7 @@ func (w *Writer) WritePoint(metric string, value float64, timestamp int64) {
 			return
 			// return fmt.Error("metric too long (%d bytes)", len(name))
 		}
-		w.wb = GetWriteBuffer()
+		w.wb = GetWriterBufferWithConfirm(w.wg, w.errorChan)
 	}
 
 	w.wb.WriteGraphitePoint(
@@ -96,7 +112,7 @@ func (w *Writer) WritePoint(metric string, value float64, timestamp int64) {
 
 func (w *Writer) WritePointTagged(metric []string, value float64, timestamp int64) {
 	if w.wb == nil {
-		w.wb = GetWriteBuffer()
+		w.wb = GetWriterBufferWithConfirm(w.wg, w.errorChan)
 	}
 	l := len(metric) - 1
 	for i := 0; i < len(metric); i++ {
@@ -109,7 +125,7 @@ func (w *Writer) WritePointTagged(metric []string, value float64, timestamp int6
 			return
 			// return fmt.Error("metric too long (%d bytes)", len(name))
 		}
-		w.wb = GetWriteBuffer()
+		w.wb = GetWriterBufferWithConfirm(w.wg, w.errorChan)
 	}
 
 	w.wb.WriteGraphitePointTagged(
@@ -122,6 +138,22 @@ func (w *Writer) WritePointTagged(metric []string, value float64, timestamp int6
 	w.pointsWritten++
 }
 
+// Wait blocks until all flushed buffers are confirmed by writer. Returns first write error
+func (w *Writer) Wait() error {
+	if w.wg == nil {
+		return nil
+	}
+
+	w.wg.Wait()
+
+	select {
+	case err := <-w.errorChan:
+		return err
+	default:
+		return nil
+	}
+}
+
 func (w *Writer) PointsWritten() uint32 {
 	return w.pointsWritten
 }
@@ -0,0 +1,160 @@
+// Package backpressure signals overload of writer to receivers
+package backpressure
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/lomik/zapwriter"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/lomik/carbon-clickhouse/helper/stop"
+)
+
+// Check is overload condition. Backpressure is activated if value reaches limit
+// and released if value of every check is below 90% of limit
+type Check struct {
+	Name  string
+	Value func() int
+	Limit int
+}
+
+// Backpressure is shared state of overload. Nil Backpressure is never active
+type Backpressure struct {
+	stop.Struct
+	active     uint32
+	checks     []Check
+	retryAfter time.Duration
+	httpStatus int
+	interval   time.Duration
+	logger     *zap.Logger
+	since      time.Time
+	stat       struct {
+		activations uint32
+		activeTime  uint64 // milliseconds
+	}
+}
+
+// New creates Backpressure. Checks with zero limit are ignored
+func New(retryAfter time.Duration, httpStatus int, checks ...Check) *Backpressure {
+	b := &Backpressure{
+		retryAfter: retryAfter,
+		httpStatus: httpStatus,
+		interval:   100 * time.Millisecond,
+		logger:     zapwriter.Logger("backpressure"),
+	}
+	for _, c := range checks {
+		if c.Limit > 0 {
+			b.checks = append(b.checks, c)
+		}
+	}
+	return b
+}
+
+// Active returns true if receivers should stop accepting data
+func (b *Backpressure) Active() bool {
+	return b != nil && atomic.LoadUint32(&b.active) != 0
+}
+
+// RetryAfter returns delay for clients
+func (b *Backpressure) RetryAfter() time.Duration {
+	return b.retryAfter
+}
+
+// RetryAfterSeconds returns value of Retry-After header
+func (b *Backpressure) RetryAfterSeconds() string {
+	s := int(b.retryAfter.Seconds())
+	if s < 1 {
+		s = 1
+	}
+	return fmt.Sprintf("%d", s)
+}
+
+// HTTPStatus returns status code of rejected requests
+func (b *Backpressure) HTTPStatus() int {
+	return b.httpStatus
+}
+
+// Wait blocks while backpressure is active. Returns false if ctx is done
+func (b *Backpressure) Wait(ctx context.Context) bool {
+	for b.Active() {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(b.interval):
+		}
+	}
+	return true
+}
+
+func (b *Backpressure) check() {
+	active := atomic.LoadUint32(&b.active) != 0
+
+	fields := make([]zapcore.Field, 0, 2*len(b.checks))
+	var overloaded, released = false, true
+	for _, c := range b.checks {
+		v := c.Value()
+		fields = append(fields, zap.Int(c.Name, v), zap.Int(c.Name+"_limit", c.Limit))
+		if v >= c.Limit {
+			overloaded = true
+		}
+		if v*10 >= c.Limit*9 {
+			released = false
+		}
+	}
+
+	switch {
+	case !active && overloaded:
+		b.since = time.Now()
+		atomic.StoreUint32(&b.active, 1)
+		atomic.AddUint32(&b.stat.activations, 1)
+		b.logger.Warn("backpressure activated", fields...)
+	case active && released:
+		atomic.StoreUint32(&b.active, 0)
+		d := time.Since(b.since)
+		atomic.AddUint64(&b.stat.activeTime, uint64(d.Milliseconds()))
+		b.logger.Info("backpressure released", append(fields, zap.Duration("duration", d))...)
+	case active:
+		now := time.Now()
+		atomic.AddUint64(&b.stat.activeTime, uint64(now.Sub(b.since).Milliseconds()))
+		b.since = now
+	}
+}
+
+func (b *Backpressure) Start() error {
+	return b.StartFunc(func() error {
+		if len(b.checks) == 0 {
+			return nil
+		}
+		b.Go(func(ctx context.Context) {
+			ticker := time.NewTicker(b.interval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					b.check()
+				}
+			}
+		})
+		return nil
+	})
+}
+
+func (b *Backpressure) Stat(send func(metric string, value float64)) {
+	send("active", float64(atomic.LoadUint32(&b.active)))
+	send("activations", float64(atomic.SwapUint32(&b.stat.activations, 0)))
+	send("activeTime_ms", float64(atomic.SwapUint64(&b.stat.activeTime, 0)))
+}
+
+// Stop releases receivers
+func (b *Backpressure) Stop() {
+	b.StopFunc(func() {
+		atomic.StoreUint32(&b.active, 0)
+	})
+}
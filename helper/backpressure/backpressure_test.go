@@ -0,0 +1,76 @@
+package backpressure
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackpressure(t *testing.T) {
+	assert := assert.New(t)
+
+	var nilBp *Backpressure
+	assert.False(nilBp.Active())
+
+	queue, unhandled := 0, 0
+	b := New(1500*time.Millisecond, 503,
+		Check{Name: "queue", Value: func() int { return queue }, Limit: 10},
+		Check{Name: "unhandled", Value: func() int { return unhandled }, Limit: 100},
+		Check{Name: "disabled", Value: func() int { return 1 << 30 }, Limit: 0},
+	)
+	assert.Len(b.checks, 2)
+	assert.Equal("1", b.RetryAfterSeconds())
+	assert.Equal(503, b.HTTPStatus())
+
+	b.check()
+	assert.False(b.Active())
+
+	unhandled = 100
+	b.check()
+	assert.True(b.Active())
+
+	// hysteresis, released only below 90% of every limit
+	unhandled = 95
+	b.check()
+	assert.True(b.Active())
+
+	unhandled = 10
+	queue = 9
+	b.check()
+	assert.True(b.Active())
+
+	queue = 8
+	b.check()
+	assert.False(b.Active())
+
+	var stat = make(map[string]float64)
+	b.Stat(func(metric string, value float64) { stat[metric] = value })
+	assert.Equal(float64(0), stat["active"])
+	assert.Equal(float64(1), stat["activations"])
+}
+
+func TestBackpressureWait(t *testing.T) {
+	assert := assert.New(t)
+
+	overloaded := true
+	b := New(time.Second, 429, Check{Name: "queue", Value: func() int {
+		if overloaded {
+			return 1
+		}
+		return 0
+	}, Limit: 1})
+	b.interval = time.Millisecond
+	b.check()
+	assert.True(b.Active())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.False(b.Wait(ctx))
+
+	b.Start()
+	b.Stop()
+	assert.False(b.Active())
+	assert.True(b.Wait(context.Background()))
+}
@@ -0,0 +1,505 @@
+package clickhouse
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultPort       = "9000"
+	DefaultSecurePort = "9440"
+
+	// defaultBlockRows is default max_insert_block_size of ClickHouse
+	defaultBlockRows = 1048449
+	// maxIdleConns is count of connections kept for next inserts
+	maxIdleConns = 8
+	// maxIdleTime is time after which idle connection isn't used
+	maxIdleTime = time.Minute
+)
+
+// Client sends INSERT over native protocol. URL format:
+// clickhouse://[user[:password]@]host[:port][/database][?compress=lz4|zstd|none&setting=value...]
+// Other parameters are settings of queries
+type Client struct {
+	addr        string
+	database    string
+	user        string
+	password    string
+	compression Compression
+	settings    url.Values
+	tls         *tls.Config
+	timeout     time.Duration
+
+	mu   sync.Mutex
+	idle []*conn
+}
+
+// NewClient creates client for URL with clickhouse scheme. TLS is used if tlsConfig isn't nil
+func NewClient(rawURL string, tlsConfig *tls.Config, timeout time.Duration) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "clickhouse" {
+		return nil, fmt.Errorf("unsupported scheme %#v of native protocol", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("host is not specified in %#v", rawURL)
+	}
+
+	c := &Client{
+		addr:     u.Host,
+		database: strings.Trim(u.Path, "/"),
+		user:     "default",
+		settings: u.Query(),
+		tls:      tlsConfig,
+		timeout:  timeout,
+	}
+
+	if u.Port() == "" {
+		if tlsConfig != nil {
+			c.addr = net.JoinHostPort(u.Hostname(), DefaultSecurePort)
+		} else {
+			c.addr = net.JoinHostPort(u.Hostname(), DefaultPort)
+		}
+	}
+
+	if u.User != nil {
+		c.user = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+
+	// same parameters as in HTTP interface
+	for _, p := range []struct {
+		name  string
+		value *string
+	}{{"user", &c.user}, {"password", &c.password}, {"database", &c.database}} {
+		if v := c.settings.Get(p.name); v != "" {
+			*p.value = v
+		}
+		c.settings.Del(p.name)
+	}
+
+	c.compression, err = ParseCompression(c.settings.Get("compress"))
+	if err != nil {
+		return nil, err
+	}
+	c.settings.Del("compress")
+
+	if c.tls != nil && c.tls.ServerName == "" {
+		c.tls = c.tls.Clone()
+		c.tls.ServerName = u.Hostname()
+	}
+
+	return c, nil
+}
+
+// Addr returns address of server
+func (c *Client) Addr() string {
+	return c.addr
+}
+
+// Insert sends rows in RowBinary format, rows are sent in Native blocks of max_insert_block_size rows.
+// Query is "INSERT INTO table (columns)", order of columns is order of values in rows.
+// Settings are added to settings of URL
+func (c *Client) Insert(query string, data io.Reader, settings url.Values) error {
+	blockRows := defaultBlockRows
+	if v := settings.Get("max_insert_block_size"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 && n < int64(^uint(0)>>1) {
+			blockRows = int(n)
+		}
+	}
+
+	cn, err := c.conn()
+	if err != nil {
+		return err
+	}
+
+	if err = cn.insert(query+" VALUES", c.querySettings(settings), data, blockRows); err != nil {
+		cn.Close()
+		return err
+	}
+
+	c.release(cn)
+	return nil
+}
+
+// Close closes idle connections
+func (c *Client) Close() {
+	c.mu.Lock()
+	idle := c.idle
+	c.idle = nil
+	c.mu.Unlock()
+
+	for _, cn := range idle {
+		cn.Close()
+	}
+}
+
+func (c *Client) querySettings(settings url.Values) [][2]string {
+	merged := make(map[string]string, len(c.settings)+len(settings))
+	for k, v := range c.settings {
+		merged[k] = v[0]
+	}
+	for k, v := range settings {
+		merged[k] = v[0]
+	}
+
+	res := make([][2]string, 0, len(merged))
+	for k, v := range merged {
+		res = append(res, [2]string{k, v})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i][0] < res[j][0] })
+	return res
+}
+
+// conn returns idle connection or new one
+func (c *Client) conn() (*conn, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	for len(c.idle) > 0 {
+		cn := c.idle[len(c.idle)-1]
+		c.idle = c.idle[:len(c.idle)-1]
+		if now.Sub(cn.used) < maxIdleTime {
+			c.mu.Unlock()
+			return cn, nil
+		}
+		cn.Close()
+	}
+	c.mu.Unlock()
+
+	return c.dial()
+}
+
+func (c *Client) release(cn *conn) {
+	cn.used = time.Now()
+
+	c.mu.Lock()
+	if len(c.idle) < maxIdleConns {
+		c.idle = append(c.idle, cn)
+		cn = nil
+	}
+	c.mu.Unlock()
+
+	if cn != nil {
+		cn.Close()
+	}
+}
+
+func (c *Client) dial() (*conn, error) {
+	dialer := &net.Dialer{Timeout: c.timeout}
+
+	var nc net.Conn
+	var err error
+	if c.tls != nil {
+		nc, err = tls.DialWithDialer(dialer, "tcp", c.addr, c.tls)
+	} else {
+		nc, err = dialer.Dial("tcp", c.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cn := &conn{
+		Conn:        nc,
+		compression: c.compression,
+		timeout:     c.timeout,
+	}
+	cn.r = bufio.NewReader(cn)
+
+	if err := cn.hello(c.database, c.user, c.password); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return cn, nil
+}
+
+// conn is connection to ClickHouse server
+type conn struct {
+	net.Conn
+	r           *bufio.Reader
+	compression Compression
+	revision    uint64
+	buf         []byte
+	block       []byte
+	used        time.Time
+	timeout     time.Duration // of every read and write, long INSERT isn't limited
+}
+
+func (cn *conn) Read(b []byte) (int, error) {
+	if cn.timeout > 0 {
+		cn.SetReadDeadline(time.Now().Add(cn.timeout))
+	}
+	return cn.Conn.Read(b)
+}
+
+func (cn *conn) Write(b []byte) (int, error) {
+	if cn.timeout > 0 {
+		cn.SetWriteDeadline(time.Now().Add(cn.timeout))
+	}
+	return cn.Conn.Write(b)
+}
+
+func (cn *conn) hello(database, user, password string) error {
+	b := binary.AppendUvarint(cn.buf[:0], clientHello)
+	b = appendString(b, "carbon-clickhouse")
+	b = binary.AppendUvarint(b, 1) // client version major
+	b = binary.AppendUvarint(b, 0) // client version minor
+	b = binary.AppendUvarint(b, protocolRevision)
+	b = appendString(b, database)
+	b = appendString(b, user)
+	b = appendString(b, password)
+	cn.buf = b
+
+	if _, err := cn.Write(b); err != nil {
+		return err
+	}
+
+	code, err := readUvarint(cn.r)
+	if err != nil {
+		return err
+	}
+	switch code {
+	case serverHello:
+	case serverException:
+		return readException(cn.r)
+	default:
+		return fmt.Errorf("unexpected packet %d in handshake", code)
+	}
+
+	// name, version major, minor
+	if _, err := readString(cn.r); err != nil {
+		return err
+	}
+	if err := skipUvarints(cn.r, 2); err != nil {
+		return err
+	}
+	if cn.revision, err = readUvarint(cn.r); err != nil {
+		return err
+	}
+	if cn.revision > protocolRevision {
+		cn.revision = protocolRevision
+	}
+	if cn.revision < protocolRevision {
+		return fmt.Errorf("server protocol revision %d is not supported", cn.revision)
+	}
+
+	// timezone, display name, version patch
+	if _, err := readString(cn.r); err != nil {
+		return err
+	}
+	if _, err := readString(cn.r); err != nil {
+		return err
+	}
+	return skipUvarints(cn.r, 1)
+}
+
+func (cn *conn) compressed() bool {
+	return cn.compression != CompressionNone
+}
+
+// blockReader returns reader of block of server data packet
+func (cn *conn) blockReader() reader {
+	if cn.compressed() {
+		return &frameReader{r: cn.r}
+	}
+	return cn.r
+}
+
+func (cn *conn) sendQuery(query string, settings [][2]string) error {
+	hostname, _ := os.Hostname()
+
+	b := binary.AppendUvarint(cn.buf[:0], clientQuery)
+	b = appendString(b, "") // query id
+
+	// client info
+	b = append(b, queryKindInitial)
+	b = appendString(b, "")                     // initial user
+	b = appendString(b, "")                     // initial query id
+	b = appendString(b, "[::ffff:127.0.0.1]:0") // initial address
+	b = append(b, interfaceTCP)
+	b = appendString(b, "")                  // os user
+	b = appendString(b, hostname)            // client hostname
+	b = appendString(b, "carbon-clickhouse") // client name
+	b = binary.AppendUvarint(b, 1)           // version major
+	b = binary.AppendUvarint(b, 0)           // version minor
+	b = binary.AppendUvarint(b, protocolRevision)
+	b = appendString(b, "")        // quota key
+	b = binary.AppendUvarint(b, 0) // version patch
+
+	for _, s := range settings {
+		b = appendString(b, s[0])
+		b = binary.AppendUvarint(b, settingImportant)
+		b = appendString(b, s[1])
+	}
+	b = appendString(b, "") // end of settings
+
+	b = binary.AppendUvarint(b, stageComplete)
+	if cn.compressed() {
+		b = binary.AppendUvarint(b, 1)
+	} else {
+		b = binary.AppendUvarint(b, 0)
+	}
+	b = appendString(b, query)
+	cn.buf = b
+
+	if _, err := cn.Write(b); err != nil {
+		return err
+	}
+
+	// end of external tables
+	return cn.sendBlock(nil, 0, nil)
+}
+
+// sendBlock sends data packet with block of rows
+func (cn *conn) sendBlock(h *blockHeader, rows int, columns []column) error {
+	block := appendBlockInfo(cn.block[:0])
+	if h == nil {
+		block = binary.AppendUvarint(block, 0)
+	} else {
+		block = binary.AppendUvarint(block, uint64(len(h.names)))
+	}
+	block = binary.AppendUvarint(block, uint64(rows))
+	if h != nil {
+		for i, c := range columns {
+			block = appendString(block, h.names[i])
+			block = appendString(block, h.types[i])
+			if rows > 0 {
+				block = c.appendPrefix(block)
+				block = c.appendData(block)
+			}
+		}
+	}
+	cn.block = block
+
+	b := binary.AppendUvarint(cn.buf[:0], clientData)
+	b = appendString(b, "") // table name
+	if cn.compressed() {
+		var err error
+		b, err = appendFrames(b, cn.compression, block)
+		if err != nil {
+			return err
+		}
+	} else {
+		b = append(b, block...)
+	}
+	cn.buf = b
+
+	_, err := cn.Write(b)
+	return err
+}
+
+// receive reads packets of server until data packet or end of stream. Header of data packet is returned
+func (cn *conn) receive() (*blockHeader, error) {
+	for {
+		code, err := readUvarint(cn.r)
+		if err != nil {
+			return nil, err
+		}
+
+		switch code {
+		case serverData:
+			// table name
+			if _, err := readString(cn.r); err != nil {
+				return nil, err
+			}
+			return readBlockHeader(cn.blockReader())
+		case serverException:
+			return nil, readException(cn.r)
+		case serverProgress:
+			err = readProgress(cn.r)
+		case serverProfileInfo:
+			err = readProfileInfo(cn.r)
+		case serverTableColumns:
+			err = readTableColumns(cn.r)
+		case serverEndOfStream:
+			return nil, nil
+		default:
+			return nil, fmt.Errorf("unexpected packet %d", code)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (cn *conn) insert(query string, settings [][2]string, data io.Reader, blockRows int) error {
+	if err := cn.sendQuery(query, settings); err != nil {
+		return err
+	}
+
+	h, err := cn.receive()
+	if err != nil {
+		return err
+	}
+	if h == nil {
+		return fmt.Errorf("no structure of table in response to INSERT")
+	}
+
+	columns := make([]column, len(h.types))
+	for i, t := range h.types {
+		if columns[i], err = newColumn(t); err != nil {
+			return fmt.Errorf("column %s: %w", h.names[i], err)
+		}
+	}
+
+	r := bufio.NewReaderSize(data, 65536)
+	rows := 0
+	for {
+		if _, err := r.Peek(1); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		for _, c := range columns {
+			if err := c.read(r); err != nil {
+				return err
+			}
+		}
+		rows++
+
+		if rows >= blockRows {
+			if err := cn.sendBlock(h, rows, columns); err != nil {
+				return err
+			}
+			for _, c := range columns {
+				c.reset()
+			}
+			rows = 0
+		}
+	}
+
+	if rows > 0 {
+		if err := cn.sendBlock(h, rows, columns); err != nil {
+			return err
+		}
+	}
+
+	// end of data
+	if err := cn.sendBlock(nil, 0, nil); err != nil {
+		return err
+	}
+
+	for {
+		h, err := cn.receive()
+		if err != nil {
+			return err
+		}
+		if h == nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package clickhouse
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// column converts values of RowBinary rows to column of Native block
+type column interface {
+	// read appends value of next row
+	read(r *bufio.Reader) error
+	// appendDefault appends default value (for NULL of Nullable)
+	appendDefault()
+	// appendPrefix appends serialization state written before column data
+	appendPrefix(b []byte) []byte
+	// appendData appends column data
+	appendData(b []byte) []byte
+	reset()
+}
+
+// newColumn creates column of ClickHouse type. Types with same RowBinary and Native encoding of values are supported,
+// also Array, Nullable and LowCardinality of them
+func newColumn(typ string) (column, error) {
+	typ = strings.TrimSpace(typ)
+
+	if inner, ok := typeArgs(typ, "LowCardinality"); ok {
+		if strings.HasPrefix(inner, "Nullable(") {
+			return nil, fmt.Errorf("unsupported column type %s", typ)
+		}
+		value, err := newColumn(inner)
+		if err != nil {
+			return nil, err
+		}
+		return newLowCardinality(value)
+	}
+
+	if inner, ok := typeArgs(typ, "Nullable"); ok {
+		nested, err := newColumn(inner)
+		if err != nil {
+			return nil, err
+		}
+		return &nullableColumn{nested: nested}, nil
+	}
+
+	if inner, ok := typeArgs(typ, "Array"); ok {
+		nested, err := newColumn(inner)
+		if err != nil {
+			return nil, err
+		}
+		return &arrayColumn{nested: nested}, nil
+	}
+
+	if args, ok := typeArgs(typ, "SimpleAggregateFunction"); ok {
+		if i := strings.Index(args, ","); i >= 0 {
+			return newColumn(args[i+1:])
+		}
+	}
+
+	if typ == "String" {
+		return &stringColumn{}, nil
+	}
+
+	if size := fixedSize(typ); size > 0 {
+		return &fixedColumn{size: size}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported column type %s", typ)
+}
+
+// typeArgs returns arguments of parametric type
+func typeArgs(typ string, name string) (string, bool) {
+	if strings.HasPrefix(typ, name+"(") && strings.HasSuffix(typ, ")") {
+		return strings.TrimSpace(typ[len(name)+1 : len(typ)-1]), true
+	}
+	return "", false
+}
+
+// fixedSize returns size of value of fixed size type or 0
+func fixedSize(typ string) int {
+	switch typ {
+	case "UInt8", "Int8", "Bool":
+		return 1
+	case "UInt16", "Int16", "Date":
+		return 2
+	case "UInt32", "Int32", "Float32", "DateTime", "Date32", "IPv4":
+		return 4
+	case "UInt64", "Int64", "Float64":
+		return 8
+	case "UInt128", "Int128", "UUID", "IPv6":
+		return 16
+	case "UInt256", "Int256":
+		return 32
+	}
+
+	if args, ok := typeArgs(typ, "FixedString"); ok {
+		n, err := strconv.Atoi(args)
+		if err != nil || n <= 0 {
+			return 0
+		}
+		return n
+	}
+
+	switch {
+	case strings.HasPrefix(typ, "DateTime("):
+		return 4
+	case strings.HasPrefix(typ, "DateTime64("):
+		return 8
+	case strings.HasPrefix(typ, "Enum8("):
+		return 1
+	case strings.HasPrefix(typ, "Enum16("):
+		return 2
+	case strings.HasPrefix(typ, "Decimal32("):
+		return 4
+	case strings.HasPrefix(typ, "Decimal64("):
+		return 8
+	case strings.HasPrefix(typ, "Decimal128("):
+		return 16
+	case strings.HasPrefix(typ, "Decimal256("):
+		return 32
+	}
+
+	if args, ok := typeArgs(typ, "Decimal"); ok {
+		p, err := strconv.Atoi(strings.TrimSpace(strings.Split(args, ",")[0]))
+		if err != nil {
+			return 0
+		}
+		switch {
+		case p <= 9:
+			return 4
+		case p <= 18:
+			return 8
+		case p <= 38:
+			return 16
+		case p <= 76:
+			return 32
+		}
+	}
+
+	return 0
+}
+
+// readEOF converts EOF inside of row to error
+func readEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+type fixedColumn struct {
+	size int
+	data []byte
+}
+
+func (c *fixedColumn) read(r *bufio.Reader) error {
+	offset := len(c.data)
+	c.data = append(c.data, make([]byte, c.size)...)
+	_, err := io.ReadFull(r, c.data[offset:])
+	return readEOF(err)
+}
+
+func (c *fixedColumn) appendDefault() {
+	c.data = append(c.data, make([]byte, c.size)...)
+}
+
+func (c *fixedColumn) appendPrefix(b []byte) []byte { return b }
+
+func (c *fixedColumn) appendData(b []byte) []byte { return append(b, c.data...) }
+
+func (c *fixedColumn) reset() { c.data = c.data[:0] }
+
+type stringColumn struct {
+	data []byte
+}
+
+func (c *stringColumn) read(r *bufio.Reader) error {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return readEOF(err)
+	}
+	if n > 1<<30 {
+		return fmt.Errorf("string of %d bytes is too long", n)
+	}
+	c.data = binary.AppendUvarint(c.data, n)
+	offset := len(c.data)
+	c.data = append(c.data, make([]byte, n)...)
+	_, err = io.ReadFull(r, c.data[offset:])
+	return readEOF(err)
+}
+
+func (c *stringColumn) appendDefault() { c.data = append(c.data, 0) }
+
+func (c *stringColumn) appendPrefix(b []byte) []byte { return b }
+
+func (c *stringColumn) appendData(b []byte) []byte { return append(b, c.data...) }
+
+func (c *stringColumn) reset() { c.data = c.data[:0] }
+
+// arrayColumn is offsets of row ends and nested column of all elements
+type arrayColumn struct {
+	offsets []uint64
+	nested  column
+}
+
+func (c *arrayColumn) read(r *bufio.Reader) error {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return readEOF(err)
+	}
+	if n > 1<<30 {
+		return fmt.Errorf("array of %d elements is too long", n)
+	}
+	for i := uint64(0); i < n; i++ {
+		if err := c.nested.read(r); err != nil {
+			return readEOF(err)
+		}
+	}
+	c.offsets = append(c.offsets, c.last()+n)
+	return nil
+}
+
+func (c *arrayColumn) last() uint64 {
+	if len(c.offsets) == 0 {
+		return 0
+	}
+	return c.offsets[len(c.offsets)-1]
+}
+
+func (c *arrayColumn) appendDefault() { c.offsets = append(c.offsets, c.last()) }
+
+func (c *arrayColumn) appendPrefix(b []byte) []byte { return c.nested.appendPrefix(b) }
+
+func (c *arrayColumn) appendData(b []byte) []byte {
+	for _, o := range c.offsets {
+		b = binary.LittleEndian.AppendUint64(b, o)
+	}
+	return c.nested.appendData(b)
+}
+
+func (c *arrayColumn) reset() {
+	c.offsets = c.offsets[:0]
+	c.nested.reset()
+}
+
+// nullableColumn is null map and nested column with default values in place of NULL
+type nullableColumn struct {
+	nulls  []byte
+	nested column
+}
+
+func (c *nullableColumn) read(r *bufio.Reader) error {
+	isNull, err := r.ReadByte()
+	if err != nil {
+		return readEOF(err)
+	}
+	c.nulls = append(c.nulls, isNull)
+	if isNull != 0 {
+		c.nested.appendDefault()
+		return nil
+	}
+	return c.nested.read(r)
+}
+
+func (c *nullableColumn) appendDefault() {
+	c.nulls = append(c.nulls, 1)
+	c.nested.appendDefault()
+}
+
+func (c *nullableColumn) appendPrefix(b []byte) []byte { return c.nested.appendPrefix(b) }
+
+func (c *nullableColumn) appendData(b []byte) []byte {
+	b = append(b, c.nulls...)
+	return c.nested.appendData(b)
+}
+
+func (c *nullableColumn) reset() {
+	c.nulls = c.nulls[:0]
+	c.nested.reset()
+}
+
+// LowCardinality serialization: shared dictionaries with additional keys, every block has own dictionary
+const (
+	lowCardinalityVersion       = 1
+	lowCardinalityUpdateAll     = 1<<9 | 1<<10 // has additional keys, need update dictionary
+	lowCardinalityKeyUInt8      = 0
+	lowCardinalityKeyUInt16     = 1
+	lowCardinalityKeyUInt32     = 2
+	lowCardinalityKeyUInt64     = 3
+	lowCardinalityMaxDictionary = 1 << 20
+)
+
+// rawColumn is column of values appended in encoded form
+type rawColumn interface {
+	column
+	appendRaw(raw []byte)
+}
+
+func (c *fixedColumn) appendRaw(raw []byte) { c.data = append(c.data, raw...) }
+
+func (c *stringColumn) appendRaw(raw []byte) { c.data = append(c.data, raw...) }
+
+// lowCardinalityColumn is dictionary of values and keys of rows
+type lowCardinalityColumn struct {
+	value column // encoder of one value
+	dict  rawColumn
+	index map[string]uint64
+	keys  []uint64
+	raw   []byte
+}
+
+func newLowCardinality(value column) (column, error) {
+	var dict rawColumn
+	switch v := value.(type) {
+	case *stringColumn:
+		dict = &stringColumn{}
+	case *fixedColumn:
+		dict = &fixedColumn{size: v.size}
+	default:
+		return nil, fmt.Errorf("unsupported type of LowCardinality")
+	}
+	return &lowCardinalityColumn{value: value, dict: dict, index: make(map[string]uint64)}, nil
+}
+
+func (c *lowCardinalityColumn) read(r *bufio.Reader) error {
+	c.value.reset()
+	if err := c.value.read(r); err != nil {
+		return err
+	}
+	c.add()
+	return nil
+}
+
+func (c *lowCardinalityColumn) appendDefault() {
+	c.value.reset()
+	c.value.appendDefault()
+	c.add()
+}
+
+// add appends key of value to rows
+func (c *lowCardinalityColumn) add() {
+	c.raw = c.value.appendData(c.raw[:0])
+	key, ok := c.index[string(c.raw)]
+	if !ok {
+		key = uint64(len(c.index))
+		c.index[string(c.raw)] = key
+		c.dict.appendRaw(c.raw)
+	}
+	c.keys = append(c.keys, key)
+}
+
+func (c *lowCardinalityColumn) appendPrefix(b []byte) []byte {
+	return binary.LittleEndian.AppendUint64(b, lowCardinalityVersion)
+}
+
+func (c *lowCardinalityColumn) appendData(b []byte) []byte {
+	keyType := uint64(lowCardinalityKeyUInt64)
+	switch n := uint64(len(c.index)); {
+	case n <= 1<<8:
+		keyType = lowCardinalityKeyUInt8
+	case n <= 1<<16:
+		keyType = lowCardinalityKeyUInt16
+	case n <= 1<<32:
+		keyType = lowCardinalityKeyUInt32
+	}
+
+	b = binary.LittleEndian.AppendUint64(b, lowCardinalityUpdateAll|keyType)
+	b = binary.LittleEndian.AppendUint64(b, uint64(len(c.index)))
+	b = c.dict.appendData(b)
+	b = binary.LittleEndian.AppendUint64(b, uint64(len(c.keys)))
+	for _, k := range c.keys {
+		switch keyType {
+		case lowCardinalityKeyUInt8:
+			b = append(b, byte(k))
+		case lowCardinalityKeyUInt16:
+			b = binary.LittleEndian.AppendUint16(b, uint16(k))
+		case lowCardinalityKeyUInt32:
+			b = binary.LittleEndian.AppendUint32(b, uint32(k))
+		default:
+			b = binary.LittleEndian.AppendUint64(b, k)
+		}
+	}
+	return b
+}
+
+func (c *lowCardinalityColumn) reset() {
+	c.dict.reset()
+	c.keys = c.keys[:0]
+	if len(c.index) > lowCardinalityMaxDictionary {
+		c.index = make(map[string]uint64)
+	} else {
+		for k := range c.index {
+			delete(c.index, k)
+		}
+	}
+}
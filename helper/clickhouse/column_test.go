@@ -0,0 +1,82 @@
+package clickhouse
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rbString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func encodeColumn(t *testing.T, typ string, rows int, rowBinary []byte) []byte {
+	c, err := newColumn(typ)
+	require.NoError(t, err)
+	r := bufio.NewReader(bytes.NewReader(rowBinary))
+	for i := 0; i < rows; i++ {
+		require.NoError(t, c.read(r), typ)
+	}
+	_, err = r.ReadByte()
+	require.Error(t, err, "all data is read")
+	return c.appendData(c.appendPrefix(nil))
+}
+
+func TestColumnEncoding(t *testing.T) {
+	var rb []byte
+	for _, s := range []string{"foo", "bar", "foo", "foo", "baz"} {
+		rb = rbString(rb, s)
+	}
+	// String: same as RowBinary
+	assert.Equal(t, rb, encodeColumn(t, "String", 5, rb))
+
+	// LowCardinality(String): version, dictionary of block, keys
+	assert.Equal(t, []byte{
+		1, 0, 0, 0, 0, 0, 0, 0,
+		0, 6, 0, 0, 0, 0, 0, 0,
+		3, 0, 0, 0, 0, 0, 0, 0,
+		3, 'f', 'o', 'o', 3, 'b', 'a', 'r', 3, 'b', 'a', 'z',
+		5, 0, 0, 0, 0, 0, 0, 0,
+		0, 1, 0, 0, 2,
+	}, encodeColumn(t, "LowCardinality(String)", 5, rb))
+
+	// Array(String): offsets, then elements
+	rb = rbString(binary.AppendUvarint(nil, 2), "a")
+	rb = rbString(rb, "b")
+	rb = binary.AppendUvarint(rb, 0)
+	rb = rbString(binary.AppendUvarint(rb, 1), "c")
+	assert.Equal(t, []byte{
+		2, 0, 0, 0, 0, 0, 0, 0,
+		2, 0, 0, 0, 0, 0, 0, 0,
+		3, 0, 0, 0, 0, 0, 0, 0,
+		1, 'a', 1, 'b', 1, 'c',
+	}, encodeColumn(t, "Array(String)", 3, rb))
+
+	// Nullable(UInt16): null map, then values with default in place of NULL
+	assert.Equal(t, []byte{0, 1, 0, 7, 0, 0, 0, 9, 0},
+		encodeColumn(t, "Nullable(UInt16)", 3, []byte{0, 7, 0, 1, 0, 9, 0}))
+
+	// fixed size values
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8}, encodeColumn(t, "DateTime('UTC')", 2, []byte{1, 2, 3, 4, 5, 6, 7, 8}))
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8}, encodeColumn(t, "Decimal(10, 2)", 1, []byte{1, 2, 3, 4, 5, 6, 7, 8}))
+	assert.Equal(t, []byte{1, 2, 3}, encodeColumn(t, "FixedString(3)", 1, []byte{1, 2, 3}))
+}
+
+func TestColumnUnsupported(t *testing.T) {
+	for _, typ := range []string{"Map(String, String)", "Tuple(String, UInt8)", "LowCardinality(Nullable(String))", "Object('json')"} {
+		_, err := newColumn(typ)
+		assert.Error(t, err, typ)
+	}
+}
+
+func TestColumnUnexpectedEOF(t *testing.T) {
+	c, err := newColumn("Array(String)")
+	require.NoError(t, err)
+	rb := rbString(binary.AppendUvarint(nil, 2), "a")
+	assert.Error(t, c.read(bufio.NewReader(bytes.NewReader(rb))))
+}
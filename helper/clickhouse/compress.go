@@ -0,0 +1,186 @@
+package clickhouse
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/go-faster/city"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+)
+
+// Compression is method of compression of data blocks
+type Compression byte
+
+const (
+	CompressionNone Compression = 0x02
+	CompressionLZ4  Compression = 0x82
+	CompressionZstd Compression = 0x90
+)
+
+const (
+	checksumSize    = 16
+	frameHeaderSize = 1 + 4 + 4 // method, compressed size with header, decompressed size
+	// maxFrameSize is size of data compressed to one frame
+	maxFrameSize = 1 << 20
+	// maxFrameDataSize protects from allocation of broken frame size
+	maxFrameDataSize = 1 << 30
+)
+
+// ParseCompression parses name of compression method
+func ParseCompression(s string) (Compression, error) {
+	switch s {
+	case "", "lz4":
+		return CompressionLZ4, nil
+	case "zstd":
+		return CompressionZstd, nil
+	case "none":
+		return CompressionNone, nil
+	}
+	return 0, fmt.Errorf("unknown compression %#v, expected lz4, zstd or none", s)
+}
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionLZ4:
+		return "lz4"
+	case CompressionZstd:
+		return "zstd"
+	}
+	return "none"
+}
+
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+)
+
+// appendFrames appends data compressed to frames with checksums
+func appendFrames(dst []byte, method Compression, data []byte) ([]byte, error) {
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxFrameSize {
+			n = maxFrameSize
+		}
+
+		var err error
+		dst, err = appendFrame(dst, method, data[:n])
+		if err != nil {
+			return nil, err
+		}
+		data = data[n:]
+	}
+	return dst, nil
+}
+
+func appendFrame(dst []byte, method Compression, data []byte) ([]byte, error) {
+	start := len(dst)
+	dst = append(dst, make([]byte, checksumSize+frameHeaderSize)...)
+	dst[start+checksumSize] = byte(method)
+
+	switch method {
+	case CompressionLZ4:
+		bound := lz4.CompressBlockBound(len(data))
+		offset := len(dst)
+		dst = append(dst, make([]byte, bound)...)
+		n, err := lz4.CompressBlock(data, dst[offset:], nil)
+		if err != nil {
+			return nil, err
+		}
+		dst = dst[:offset+n]
+	case CompressionZstd:
+		dst = zstdEncoder.EncodeAll(data, dst)
+	default:
+		dst = append(dst, data...)
+	}
+
+	frame := dst[start+checksumSize:]
+	binary.LittleEndian.PutUint32(frame[1:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(frame[5:], uint32(len(data)))
+
+	h := city.CH128(frame)
+	binary.LittleEndian.PutUint64(dst[start:], h.Low)
+	binary.LittleEndian.PutUint64(dst[start+8:], h.High)
+
+	return dst, nil
+}
+
+// frameReader reads data of compressed frames
+type frameReader struct {
+	r    *bufio.Reader
+	raw  []byte
+	data []byte
+	pos  int
+}
+
+func (f *frameReader) next() error {
+	var header [checksumSize + frameHeaderSize]byte
+	if _, err := io.ReadFull(f.r, header[:]); err != nil {
+		return err
+	}
+
+	frameSize := binary.LittleEndian.Uint32(header[checksumSize+1:])
+	dataSize := binary.LittleEndian.Uint32(header[checksumSize+5:])
+	if frameSize < frameHeaderSize || frameSize > maxFrameDataSize || dataSize > maxFrameDataSize {
+		return fmt.Errorf("broken compressed frame: size %d, data size %d", frameSize, dataSize)
+	}
+
+	f.raw = append(f.raw[:0], header[checksumSize:]...)
+	f.raw = append(f.raw, make([]byte, frameSize-frameHeaderSize)...)
+	if _, err := io.ReadFull(f.r, f.raw[frameHeaderSize:]); err != nil {
+		return err
+	}
+
+	h := city.CH128(f.raw)
+	if h.Low != binary.LittleEndian.Uint64(header[0:]) || h.High != binary.LittleEndian.Uint64(header[8:]) {
+		return fmt.Errorf("checksum mismatch of compressed frame")
+	}
+
+	payload := f.raw[frameHeaderSize:]
+	var err error
+	switch Compression(f.raw[0]) {
+	case CompressionLZ4:
+		f.data = append(f.data[:0], make([]byte, dataSize)...)
+		var n int
+		n, err = lz4.UncompressBlock(payload, f.data)
+		f.data = f.data[:n]
+	case CompressionZstd:
+		f.data, err = zstdDecoder.DecodeAll(payload, f.data[:0])
+	case CompressionNone:
+		f.data = append(f.data[:0], payload...)
+	default:
+		err = fmt.Errorf("unknown compression method 0x%x", f.raw[0])
+	}
+	if err != nil {
+		return err
+	}
+	if len(f.data) != int(dataSize) {
+		return fmt.Errorf("decompressed %d bytes of frame, expected %d", len(f.data), dataSize)
+	}
+	f.pos = 0
+	return nil
+}
+
+func (f *frameReader) Read(p []byte) (int, error) {
+	for f.pos >= len(f.data) {
+		if err := f.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, f.data[f.pos:])
+	f.pos += n
+	return n, nil
+}
+
+func (f *frameReader) ReadByte() (byte, error) {
+	for f.pos >= len(f.data) {
+		if err := f.next(); err != nil {
+			return 0, err
+		}
+	}
+	b := f.data[f.pos]
+	f.pos++
+	return b, nil
+}
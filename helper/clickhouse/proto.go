@@ -0,0 +1,239 @@
+package clickhouse
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// protocolRevision is revision of native protocol implemented by client. Server uses min of client and server revisions
+const protocolRevision = 54429
+
+// client packets
+const (
+	clientHello = 0
+	clientQuery = 1
+	clientData  = 2
+)
+
+// server packets
+const (
+	serverHello        = 0
+	serverData         = 1
+	serverException    = 2
+	serverProgress     = 3
+	serverEndOfStream  = 5
+	serverProfileInfo  = 6
+	serverTableColumns = 11
+)
+
+const (
+	queryKindInitial  = 1
+	interfaceTCP      = 1
+	stageComplete     = 2
+	settingImportant  = 0x01
+	blockInfoOverflow = 1
+	blockInfoBucket   = 2
+)
+
+// Exception is error returned by ClickHouse server
+type Exception struct {
+	Code    int32
+	Name    string
+	Message string
+	Nested  *Exception
+}
+
+func (e *Exception) Error() string {
+	var sb strings.Builder
+	for ex := e; ex != nil; ex = ex.Nested {
+		if ex != e {
+			sb.WriteString(": ")
+		}
+		fmt.Fprintf(&sb, "clickhouse exception code %d, %s: %s", ex.Code, ex.Name, ex.Message)
+	}
+	return sb.String()
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// reader is source of server packets, plain or decompressed
+type reader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func readUvarint(r reader) (uint64, error) {
+	return binary.ReadUvarint(r)
+}
+
+func readString(r reader) (string, error) {
+	n, err := readUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > 1<<30 {
+		return "", fmt.Errorf("string of %d bytes is too long", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func readBool(r reader) (bool, error) {
+	b, err := r.ReadByte()
+	return b != 0, err
+}
+
+func readInt32(r reader) (int32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(b[:])), nil
+}
+
+func readException(r reader) error {
+	e := &Exception{}
+	code, err := readInt32(r)
+	if err != nil {
+		return err
+	}
+	e.Code = code
+	if e.Name, err = readString(r); err != nil {
+		return err
+	}
+	if e.Message, err = readString(r); err != nil {
+		return err
+	}
+	// stack trace
+	if _, err = readString(r); err != nil {
+		return err
+	}
+	nested, err := readBool(r)
+	if err != nil {
+		return err
+	}
+	if nested {
+		err = readException(r)
+		if ex, ok := err.(*Exception); ok {
+			e.Nested = ex
+		} else {
+			return err
+		}
+	}
+	return e
+}
+
+// skipUvarints reads n numbers of packet not used by client
+func skipUvarints(r reader, n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := readUvarint(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readProgress(r reader) error {
+	// rows, bytes, total rows, written rows, written bytes
+	return skipUvarints(r, 5)
+}
+
+func readProfileInfo(r reader) error {
+	// rows, blocks, bytes, applied limit, rows before limit, calculated rows before limit
+	if err := skipUvarints(r, 3); err != nil {
+		return err
+	}
+	if _, err := readBool(r); err != nil {
+		return err
+	}
+	if err := skipUvarints(r, 1); err != nil {
+		return err
+	}
+	_, err := readBool(r)
+	return err
+}
+
+func readTableColumns(r reader) error {
+	// external table name, columns description
+	if _, err := readString(r); err != nil {
+		return err
+	}
+	_, err := readString(r)
+	return err
+}
+
+// blockHeader is column names and types of block without data
+type blockHeader struct {
+	names []string
+	types []string
+}
+
+// readBlockHeader reads block without rows
+func readBlockHeader(r reader) (*blockHeader, error) {
+	for {
+		field, err := readUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if field == 0 {
+			break
+		}
+		switch field {
+		case blockInfoOverflow:
+			_, err = readBool(r)
+		case blockInfoBucket:
+			_, err = readInt32(r)
+		default:
+			err = fmt.Errorf("unknown block info field %d", field)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	columns, err := readUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := readUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if rows != 0 {
+		return nil, fmt.Errorf("unexpected block with %d rows", rows)
+	}
+	if columns > 1<<16 {
+		return nil, fmt.Errorf("block with %d columns", columns)
+	}
+
+	h := &blockHeader{}
+	for i := uint64(0); i < columns; i++ {
+		name, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		typ, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		h.names = append(h.names, name)
+		h.types = append(h.types, typ)
+	}
+	return h, nil
+}
+
+func appendBlockInfo(b []byte) []byte {
+	b = binary.AppendUvarint(b, blockInfoOverflow)
+	b = append(b, 0)
+	b = binary.AppendUvarint(b, blockInfoBucket)
+	b = binary.LittleEndian.AppendUint32(b, 0xffffffff) // -1
+	return binary.AppendUvarint(b, 0)
+}
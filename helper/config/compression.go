@@ -11,11 +11,15 @@ type CompAlgo int
 const (
 	CompAlgoNone CompAlgo = iota
 	CompAlgoLZ4
+	CompAlgoZstd
+	CompAlgoSnappy
 )
 
 var compressionMap = map[string]CompAlgo{
-	"none": CompAlgoNone,
-	"lz4":  CompAlgoLZ4,
+	"none":   CompAlgoNone,
+	"lz4":    CompAlgoLZ4,
+	"zstd":   CompAlgoZstd,
+	"snappy": CompAlgoSnappy,
 }
 
 var compressionMapReversed = map[CompAlgo]string{}
@@ -0,0 +1,197 @@
+package clickhousetest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/go-faster/city"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+)
+
+// client packets
+const (
+	clientHello = 0
+	clientQuery = 1
+	clientData  = 2
+)
+
+// server packets
+const (
+	serverHello        = 0
+	serverData         = 1
+	serverException    = 2
+	serverProgress     = 3
+	serverEndOfStream  = 5
+	serverTableColumns = 11
+)
+
+const blockInfoOverflow = 1
+
+// compression methods of frames
+const (
+	methodNone = 0x02
+	methodLZ4  = 0x82
+	methodZstd = 0x90
+)
+
+// checksum and header of frame: method, compressed size with header, decompressed size
+const (
+	checksumSize    = 16
+	frameHeaderSize = 9
+	maxFrameSize    = 1 << 30
+)
+
+// reader is source of client packets, plain or decompressed
+type reader interface {
+	io.Reader
+	io.ByteReader
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendBlockInfo(b []byte) []byte {
+	b = binary.AppendUvarint(b, 1) // is_overflows
+	b = append(b, 0)
+	b = binary.AppendUvarint(b, 2) // bucket_num
+	b = binary.LittleEndian.AppendUint32(b, 0xffffffff)
+	return binary.AppendUvarint(b, 0)
+}
+
+func readUvarint(r reader) (uint64, error) {
+	return binary.ReadUvarint(r)
+}
+
+func readString(r reader) (string, error) {
+	n, err := readUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > maxFrameSize {
+		return "", fmt.Errorf("string of %d bytes is too long", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func readInt32(r reader) (int32, error) {
+	var v int32
+	err := binary.Read(r, binary.LittleEndian, &v)
+	return v, err
+}
+
+func skipUvarints(r reader, n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := readUvarint(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendFrame appends block compressed by LZ4 to frame with checksum, like server does by default
+func appendFrame(dst []byte, data []byte) ([]byte, error) {
+	compressed := make([]byte, lz4.CompressBlockBound(len(data)))
+	n, err := lz4.CompressBlock(data, compressed, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+n)
+	frame[0] = methodLZ4
+	binary.LittleEndian.PutUint32(frame[1:], uint32(frameHeaderSize+n))
+	binary.LittleEndian.PutUint32(frame[5:], uint32(len(data)))
+	frame = append(frame, compressed[:n]...)
+
+	h := city.CH128(frame)
+	dst = binary.LittleEndian.AppendUint64(dst, h.Low)
+	dst = binary.LittleEndian.AppendUint64(dst, h.High)
+	return append(dst, frame...), nil
+}
+
+// frameReader reads data of compressed frames of client
+type frameReader struct {
+	r    *bufio.Reader
+	data []byte
+	pos  int
+}
+
+func (f *frameReader) next() error {
+	var header [checksumSize + frameHeaderSize]byte
+	if _, err := io.ReadFull(f.r, header[:]); err != nil {
+		return err
+	}
+	size := binary.LittleEndian.Uint32(header[checksumSize+1:])
+	dataSize := binary.LittleEndian.Uint32(header[checksumSize+5:])
+	if size < frameHeaderSize || size > maxFrameSize || dataSize > maxFrameSize {
+		return fmt.Errorf("broken frame: size %d, data size %d", size, dataSize)
+	}
+
+	frame := make([]byte, size)
+	copy(frame, header[checksumSize:])
+	if _, err := io.ReadFull(f.r, frame[frameHeaderSize:]); err != nil {
+		return err
+	}
+	h := city.CH128(frame)
+	if h.Low != binary.LittleEndian.Uint64(header[:]) || h.High != binary.LittleEndian.Uint64(header[8:]) {
+		return fmt.Errorf("checksum mismatch")
+	}
+
+	payload := frame[frameHeaderSize:]
+	var err error
+	switch frame[0] {
+	case methodLZ4:
+		f.data = make([]byte, dataSize)
+		var n int
+		n, err = lz4.UncompressBlock(payload, f.data)
+		f.data = f.data[:n]
+	case methodZstd:
+		var d *zstd.Decoder
+		if d, err = zstd.NewReader(nil); err == nil {
+			f.data, err = d.DecodeAll(payload, nil)
+			d.Close()
+		}
+	case methodNone:
+		f.data = payload
+	default:
+		err = fmt.Errorf("unknown compression method 0x%x", frame[0])
+	}
+	if err != nil {
+		return err
+	}
+	if len(f.data) != int(dataSize) {
+		return fmt.Errorf("decompressed %d bytes, expected %d", len(f.data), dataSize)
+	}
+	f.pos = 0
+	return nil
+}
+
+func (f *frameReader) Read(p []byte) (int, error) {
+	for f.pos >= len(f.data) {
+		if err := f.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, f.data[f.pos:])
+	f.pos += n
+	return n, nil
+}
+
+func (f *frameReader) ReadByte() (byte, error) {
+	for f.pos >= len(f.data) {
+		if err := f.next(); err != nil {
+			return 0, err
+		}
+	}
+	b := f.data[f.pos]
+	f.pos++
+	return b, nil
+}
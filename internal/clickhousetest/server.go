@@ -0,0 +1,454 @@
+// Package clickhousetest provides native protocol server of ClickHouse for tests.
+// Protocol is implemented independently of client in helper/clickhouse
+package clickhousetest
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Columns are types of columns of graphite tables known by Server
+var Columns = map[string]string{
+	"Path":      "String",
+	"Value":     "Float64",
+	"Time":      "UInt32",
+	"Date":      "Date",
+	"Timestamp": "UInt32",
+	"Level":     "UInt32",
+	"Version":   "UInt32",
+	"Tag1":      "String",
+	"Tags":      "Array(String)",
+}
+
+// Insert is INSERT received by Server
+type Insert struct {
+	Query       string
+	User        string
+	Database    string
+	Settings    map[string]string
+	Compression bool
+	Columns     []string
+	Rows        [][]interface{}
+	Blocks      int
+}
+
+// Server is native protocol server for tests. INSERT into columns of Columns is accepted
+type Server struct {
+	Addr string
+
+	mu        sync.Mutex
+	l         net.Listener
+	conns     map[net.Conn]bool
+	inserts   []*Insert
+	exception string
+	wg        sync.WaitGroup
+}
+
+var insertColumns = regexp.MustCompile(`^INSERT INTO \S+ \(([^)]*)\) VALUES$`)
+
+// NewServer starts server on random local port
+func NewServer() (*Server, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{Addr: l.Addr().String(), l: l, conns: make(map[net.Conn]bool)}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// URL returns URL of server
+func (s *Server) URL(params string) string {
+	if params != "" {
+		return "clickhouse://" + s.Addr + "/?" + params
+	}
+	return "clickhouse://" + s.Addr
+}
+
+// Close stops server
+func (s *Server) Close() {
+	s.l.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Inserts returns completed inserts
+func (s *Server) Inserts() []*Insert {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Insert(nil), s.inserts...)
+}
+
+// SetException sets message of exception returned on INSERT. Empty message disables exceptions
+func (s *Server) SetException(message string) {
+	s.mu.Lock()
+	s.exception = message
+	s.mu.Unlock()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		c, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns[c] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(c)
+
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+			c.Close()
+		}()
+	}
+}
+
+func (s *Server) handle(c net.Conn) {
+	r := bufio.NewReader(c)
+
+	code, err := readUvarint(r)
+	if err != nil || code != clientHello {
+		return
+	}
+	if _, err := readString(r); err != nil {
+		return
+	}
+	if err := skipUvarints(r, 3); err != nil {
+		return
+	}
+	database, err := readString(r)
+	if err != nil {
+		return
+	}
+	user, err := readString(r)
+	if err != nil {
+		return
+	}
+	if _, err := readString(r); err != nil {
+		return
+	}
+
+	b := binary.AppendUvarint(nil, serverHello)
+	b = appendString(b, "ClickHouse")
+	b = binary.AppendUvarint(b, 23)
+	b = binary.AppendUvarint(b, 8)
+	b = binary.AppendUvarint(b, 54460)
+	b = appendString(b, "UTC")
+	b = appendString(b, "test")
+	b = binary.AppendUvarint(b, 1)
+	if _, err := c.Write(b); err != nil {
+		return
+	}
+
+	for {
+		ins := &Insert{Database: database, User: user, Settings: make(map[string]string)}
+		if err := s.query(c, r, ins); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) query(c net.Conn, r *bufio.Reader, ins *Insert) error {
+	code, err := readUvarint(r)
+	if err != nil {
+		return err
+	}
+	if code != clientQuery {
+		return fmt.Errorf("unexpected packet %d", code)
+	}
+
+	// query id, client info
+	if _, err := readString(r); err != nil {
+		return err
+	}
+	if _, err := r.ReadByte(); err != nil {
+		return err
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := readString(r); err != nil {
+			return err
+		}
+	}
+	if _, err := r.ReadByte(); err != nil {
+		return err
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := readString(r); err != nil {
+			return err
+		}
+	}
+	if err := skipUvarints(r, 3); err != nil {
+		return err
+	}
+	if _, err := readString(r); err != nil {
+		return err
+	}
+	if err := skipUvarints(r, 1); err != nil {
+		return err
+	}
+
+	for {
+		name, err := readString(r)
+		if err != nil {
+			return err
+		}
+		if name == "" {
+			break
+		}
+		if err := skipUvarints(r, 1); err != nil {
+			return err
+		}
+		if ins.Settings[name], err = readString(r); err != nil {
+			return err
+		}
+	}
+
+	if err := skipUvarints(r, 1); err != nil {
+		return err
+	}
+	compression, err := readUvarint(r)
+	if err != nil {
+		return err
+	}
+	ins.Compression = compression != 0
+	if ins.Query, err = readString(r); err != nil {
+		return err
+	}
+
+	blockReader := func() reader {
+		if ins.Compression {
+			return &frameReader{r: r}
+		}
+		return r
+	}
+	send := func(b []byte) error {
+		_, err := c.Write(b)
+		return err
+	}
+	sendBlock := func(b []byte, block []byte) error {
+		if ins.Compression {
+			var err error
+			if b, err = appendFrame(b, block); err != nil {
+				return err
+			}
+		} else {
+			b = append(b, block...)
+		}
+		return send(b)
+	}
+
+	// external tables
+	if _, _, err := readData(r, blockReader); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	exception := s.exception
+	s.mu.Unlock()
+
+	m := insertColumns.FindStringSubmatch(ins.Query)
+	if m == nil && exception == "" {
+		exception = "only INSERT with columns is supported"
+	}
+	var header []byte
+	if exception == "" {
+		header = appendBlockInfo(nil)
+		ins.Columns = strings.Split(m[1], ",")
+		header = binary.AppendUvarint(header, uint64(len(ins.Columns)))
+		header = binary.AppendUvarint(header, 0)
+		for i, name := range ins.Columns {
+			ins.Columns[i] = strings.TrimSpace(name)
+			typ, ok := Columns[ins.Columns[i]]
+			if !ok {
+				exception = "unknown column " + ins.Columns[i]
+				break
+			}
+			header = appendString(header, ins.Columns[i])
+			header = appendString(header, typ)
+		}
+	}
+
+	if exception != "" {
+		b := binary.AppendUvarint(nil, serverException)
+		b = binary.LittleEndian.AppendUint32(b, 1000)
+		b = appendString(b, "DB::Exception")
+		b = appendString(b, exception)
+		b = appendString(b, "")
+		b = append(b, 0)
+		return send(b)
+	}
+
+	b := binary.AppendUvarint(nil, serverTableColumns)
+	b = appendString(b, "")
+	b = appendString(b, "columns format version: 1")
+	b = binary.AppendUvarint(b, serverData)
+	b = appendString(b, "")
+	if err := sendBlock(b, header); err != nil {
+		return err
+	}
+
+	for {
+		rows, columns, err := readData(r, blockReader)
+		if err != nil {
+			return err
+		}
+		if columns == 0 {
+			break
+		}
+		ins.Rows = append(ins.Rows, rows...)
+		ins.Blocks++
+	}
+
+	s.mu.Lock()
+	s.inserts = append(s.inserts, ins)
+	s.mu.Unlock()
+
+	b = binary.AppendUvarint(nil, serverProgress)
+	b = binary.AppendUvarint(b, 0)
+	b = binary.AppendUvarint(b, 0)
+	b = binary.AppendUvarint(b, 0)
+	b = binary.AppendUvarint(b, uint64(len(ins.Rows)))
+	b = binary.AppendUvarint(b, 0)
+	b = binary.AppendUvarint(b, serverEndOfStream)
+	return send(b)
+}
+
+// readData reads data packet of client and decodes values of block
+func readData(r *bufio.Reader, blockReader func() reader) ([][]interface{}, int, error) {
+	code, err := readUvarint(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	if code != clientData {
+		return nil, 0, fmt.Errorf("unexpected packet %d", code)
+	}
+	if _, err := readString(r); err != nil {
+		return nil, 0, err
+	}
+
+	br := blockReader()
+	for {
+		field, err := readUvarint(br)
+		if err != nil {
+			return nil, 0, err
+		}
+		if field == 0 {
+			break
+		}
+		if field == blockInfoOverflow {
+			_, err = br.ReadByte()
+		} else {
+			_, err = readInt32(br)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	columns, err := readUvarint(br)
+	if err != nil {
+		return nil, 0, err
+	}
+	rowCount, err := readUvarint(br)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows := make([][]interface{}, rowCount)
+	for i := uint64(0); i < columns; i++ {
+		if _, err := readString(br); err != nil {
+			return nil, 0, err
+		}
+		typ, err := readString(br)
+		if err != nil {
+			return nil, 0, err
+		}
+		if rowCount == 0 {
+			continue
+		}
+		values, err := readColumn(br, typ, int(rowCount))
+		if err != nil {
+			return nil, 0, err
+		}
+		for j := range rows {
+			rows[j] = append(rows[j], values[j])
+		}
+	}
+
+	return rows, int(columns), nil
+}
+
+func readColumn(r reader, typ string, rows int) ([]interface{}, error) {
+	values := make([]interface{}, rows)
+	var err error
+	switch typ {
+	case "String":
+		for i := range values {
+			if values[i], err = readString(r); err != nil {
+				return nil, err
+			}
+		}
+	case "Array(String)":
+		offsets := make([]uint64, rows)
+		if err := binary.Read(r, binary.LittleEndian, offsets); err != nil {
+			return nil, err
+		}
+		var prev uint64
+		for i, o := range offsets {
+			items := make([]string, 0, o-prev)
+			for ; prev < o; prev++ {
+				s, err := readString(r)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, s)
+			}
+			values[i] = items
+		}
+	case "Float64":
+		for i := range values {
+			var v uint64
+			if err := binary.Read(r, binary.LittleEndian, &v); err != nil {
+				return nil, err
+			}
+			values[i] = math.Float64frombits(v)
+		}
+	case "UInt32":
+		for i := range values {
+			var v uint32
+			if err := binary.Read(r, binary.LittleEndian, &v); err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+	case "Date":
+		for i := range values {
+			var v uint16
+			if err := binary.Read(r, binary.LittleEndian, &v); err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+	default:
+		return nil, fmt.Errorf("unsupported type %s", typ)
+	}
+	return values, nil
+}
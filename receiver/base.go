@@ -1,13 +1,16 @@
 package receiver
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/backpressure"
 	"github.com/lomik/carbon-clickhouse/helper/stop"
 	"github.com/lomik/carbon-clickhouse/helper/tags"
 	"go.uber.org/zap"
@@ -27,6 +30,10 @@ type Base struct {
 		futureDropped      uint64 // atomic
 		pastDropped        uint64 // atomic
 		tooLongDropped     uint64 // atomic
+		// backpressure
+		backpressureRejected uint64 // atomic
+		backpressureDropped  uint64 // atomic
+		backpressurePaused   uint64 // atomic
 	}
 	droppedList        [droppedListSize]string
 	droppedListNext    int
@@ -40,12 +47,65 @@ type Base struct {
 	logger             *zap.Logger
 	Tags               tags.TagConfig
 	concatCharacter    string
+	syncWrite          bool
+	maxBodySize        int64 // limit of HTTP request body, after decompression too
+	// collectd options
+	collectdTemplate      string
+	collectdSecurityLevel string
+	collectdAuthFile      string
+	collectdTypesDB       []string
+	// grpc options
+	grpcMaxMessageSize   int
+	grpcKeepaliveTime    time.Duration
+	grpcKeepaliveTimeout time.Duration
+	grpcKeepaliveMinTime time.Duration
+	grpcCompression      string
+	backpressure         *backpressure.Backpressure
 }
 
 // func NewBase(logger *zap.Logger, config tags.TagConfig) Base {
 // 	return Base{logger: logger, Tags: config}
 // }
 
+// newWriter creates RowBinary.Writer, with confirmable buffers if sync-write enabled
+func (base *Base) newWriter(ctx context.Context) *RowBinary.Writer {
+	if base.syncWrite {
+		return RowBinary.NewWriterWithConfirm(ctx, base.writeChan)
+	}
+	return RowBinary.NewWriter(ctx, base.writeChan)
+}
+
+// rejectOverloaded responds with Retry-After if backpressure is active
+func (base *Base) rejectOverloaded(w http.ResponseWriter) bool {
+	if !base.backpressure.Active() {
+		return false
+	}
+	atomic.AddUint64(&base.stat.backpressureRejected, 1)
+	w.Header().Set("Retry-After", base.backpressure.RetryAfterSeconds())
+	http.Error(w, "overloaded, retry later", base.backpressure.HTTPStatus())
+	return true
+}
+
+// dropOverloaded checks received packet should be dropped by backpressure
+func (base *Base) dropOverloaded() bool {
+	if !base.backpressure.Active() {
+		return false
+	}
+	atomic.AddUint64(&base.stat.backpressureDropped, 1)
+	return true
+}
+
+// pauseOverloaded blocks reading of connection while backpressure is active
+func (base *Base) pauseOverloaded() {
+	if !base.backpressure.Active() {
+		return
+	}
+	atomic.AddUint64(&base.stat.backpressurePaused, 1)
+	base.WithCtx(func(ctx context.Context) {
+		base.backpressure.Wait(ctx)
+	})
+}
+
 func sendUint64Counter(send func(metric string, value float64), metric string, value *uint64) {
 	v := atomic.LoadUint64(value)
 	atomic.AddUint64(value, -v)
@@ -143,6 +203,12 @@ func (base *Base) SendStat(send func(metric string, value float64), fields ...st
 			sendUint64Counter(send, f, &base.stat.pastDropped)
 		case "tooLongDropped":
 			sendUint64Counter(send, f, &base.stat.tooLongDropped)
+		case "backpressureRejected":
+			sendUint64Counter(send, f, &base.stat.backpressureRejected)
+		case "backpressureDropped":
+			sendUint64Counter(send, f, &base.stat.backpressureDropped)
+		case "backpressurePaused":
+			sendUint64Counter(send, f, &base.stat.backpressurePaused)
 		case "errors":
 			sendUint64Counter(send, f, &base.stat.errors)
 		case "active":
@@ -0,0 +1,265 @@
+package receiver
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/tags"
+)
+
+// CollectdDefaultTemplate is metric naming template like in collectd write_graphite plugin
+const CollectdDefaultTemplate = "{host}.{plugin}.{plugin_instance}.{type}.{type_instance}.{ds}"
+
+const collectdRateTTL = time.Hour
+
+// Collectd receive metrics from collectd network plugin (binary protocol over UDP)
+type Collectd struct {
+	Base
+	conn      *net.UDPConn
+	parseChan chan *Buffer
+	parser    collectdParser
+	template  *collectdTemplate
+	types     map[string][]string
+	rates     *collectdRates
+}
+
+// Addr returns binded socket address. For bind port 0 in tests
+func (rcv *Collectd) Addr() net.Addr {
+	if rcv.conn == nil {
+		return nil
+	}
+	return rcv.conn.LocalAddr()
+}
+
+func (rcv *Collectd) Stat(send func(metric string, value float64)) {
+	rcv.SendStat(send, "metricsReceived", "messagesReceived", "errors", "futureDropped", "pastDropped",
+		"tooLongDropped", "backpressureDropped")
+}
+
+// configure prepares naming template, auth users and types
+func (rcv *Collectd) configure() error {
+	var err error
+
+	template := rcv.collectdTemplate
+	if template == "" {
+		template = CollectdDefaultTemplate
+	}
+	if rcv.template, err = newCollectdTemplate(template); err != nil {
+		return err
+	}
+
+	level, ok := collectdSecurityLevels[strings.ToLower(rcv.collectdSecurityLevel)]
+	if !ok {
+		return fmt.Errorf("unknown collectd security level %#v", rcv.collectdSecurityLevel)
+	}
+	rcv.parser.securityLevel = level
+
+	rcv.parser.users = make(map[string]string)
+	if rcv.collectdAuthFile != "" {
+		if rcv.parser.users, err = ReadCollectdAuthFile(rcv.collectdAuthFile); err != nil {
+			return err
+		}
+	} else if level != CollectdSecurityNone {
+		return fmt.Errorf("collectd security level %#v requires auth file", rcv.collectdSecurityLevel)
+	}
+
+	rcv.types = make(map[string][]string)
+	for k, v := range collectdDefaultTypes {
+		rcv.types[k] = v
+	}
+	for _, fn := range rcv.collectdTypesDB {
+		if err = ReadCollectdTypesDB(fn, rcv.types); err != nil {
+			return err
+		}
+	}
+
+	rcv.rates = newCollectdRates()
+
+	return nil
+}
+
+func (rcv *Collectd) dsName(vl *CollectdValueList, i int) string {
+	if len(vl.Values) == 1 {
+		return ""
+	}
+	if ds, ok := rcv.types[vl.Type]; ok && len(ds) == len(vl.Values) {
+		return ds[i]
+	}
+	return fmt.Sprintf("%d", i)
+}
+
+// ParseBuffer decodes collectd packet and sends points to writer
+func (rcv *Collectd) ParseBuffer(ctx context.Context, b *Buffer) {
+	metricCount := uint32(0)
+	errorCount := uint32(0)
+
+	wb := RowBinary.GetWriteBuffer()
+
+	flush := func() {
+		if wb.Empty() {
+			wb.Release()
+		} else {
+			select {
+			case rcv.writeChan <- wb:
+				// pass
+			case <-ctx.Done():
+				// pass
+			}
+		}
+		wb = nil
+	}
+
+	err := rcv.parser.Parse(b.Body[:b.Used], func(vl *CollectdValueList) {
+		t := vl.Time
+		if t == 0 {
+			t = float64(b.Time)
+		}
+		timestamp := uint32(t)
+
+		for i := 0; i < len(vl.Values); i++ {
+			name := rcv.template.Name(vl, rcv.dsName(vl, i))
+
+			value, ok := rcv.rates.Rate(name, vl.DSTypes[i], vl.RawValues[i], vl.Values[i], t, vl.Interval)
+			if !ok || math.IsNaN(value) {
+				continue
+			}
+
+			name, err := tags.Graphite(rcv.Tags, name)
+			if err != nil {
+				errorCount++
+				continue
+			}
+
+			if rcv.isDropString(name, b.Time, timestamp, value) {
+				continue
+			}
+
+			if !wb.CanWriteGraphitePoint(len(name)) {
+				flush()
+				if len(name) > RowBinary.WriteBufferSize-50 {
+					errorCount++
+					wb = RowBinary.GetWriteBuffer()
+					continue
+				}
+				wb = RowBinary.GetWriteBuffer()
+			}
+
+			wb.WriteGraphitePoint([]byte(name), value, timestamp, b.Time)
+			metricCount++
+		}
+	})
+
+	if err != nil {
+		errorCount++
+		rcv.logger.Debug("collectd packet parse failed", zap.Error(err))
+	}
+
+	flush()
+
+	atomic.AddUint64(&rcv.stat.messagesReceived, 1)
+	if metricCount > 0 {
+		atomic.AddUint64(&rcv.stat.metricsReceived, uint64(metricCount))
+	}
+	if errorCount > 0 {
+		atomic.AddUint64(&rcv.stat.errors, uint64(errorCount))
+	}
+}
+
+func (rcv *Collectd) parseWorker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case b := <-rcv.parseChan:
+			rcv.ParseBuffer(ctx, b)
+			b.Release()
+		}
+	}
+}
+
+func (rcv *Collectd) receiveWorker(ctx context.Context) {
+	defer rcv.conn.Close()
+
+ReceiveLoop:
+	for {
+		buffer := GetBuffer()
+
+		n, peer, err := rcv.conn.ReadFromUDP(buffer.Body[:])
+		if err != nil {
+			buffer.Release()
+			if strings.Contains(err.Error(), "use of closed network connection") {
+				break ReceiveLoop
+			}
+			atomic.AddUint64(&rcv.stat.errors, 1)
+			rcv.logger.Error("ReadFromUDP failed", zap.Error(err), zap.String("peer", peer.String()))
+			continue ReceiveLoop
+		}
+
+		if n == 0 || rcv.dropOverloaded() {
+			buffer.Release()
+			continue ReceiveLoop
+		}
+
+		buffer.Used = n
+		buffer.Time = uint32(time.Now().Unix())
+
+		select {
+		case rcv.parseChan <- buffer:
+		case <-ctx.Done():
+			buffer.Release()
+			break ReceiveLoop
+		}
+	}
+}
+
+func (rcv *Collectd) expireWorker(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rcv.rates.Expire(time.Now().Add(-collectdRateTTL))
+		}
+	}
+}
+
+// Listen bind port. Receive messages and send to out channel
+func (rcv *Collectd) Listen(addr *net.UDPAddr) error {
+	return rcv.StartFunc(func() error {
+		var err error
+
+		if err = rcv.configure(); err != nil {
+			return err
+		}
+
+		rcv.conn, err = net.ListenUDP("udp", addr)
+		if err != nil {
+			return err
+		}
+
+		rcv.Go(func(ctx context.Context) {
+			<-ctx.Done()
+			rcv.conn.Close()
+		})
+
+		for i := 0; i < rcv.parseThreads; i++ {
+			rcv.Go(rcv.parseWorker)
+		}
+
+		rcv.Go(rcv.expireWorker)
+		rcv.Go(rcv.receiveWorker)
+
+		return nil
+	})
+}
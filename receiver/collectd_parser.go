@@ -0,0 +1,586 @@
+package receiver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// collectd binary protocol part types, https://collectd.org/wiki/index.php/Binary_protocol
+const (
+	collectdTypeHost           = 0x0000
+	collectdTypeTime           = 0x0001
+	collectdTypePlugin         = 0x0002
+	collectdTypePluginInstance = 0x0003
+	collectdTypeType           = 0x0004
+	collectdTypeTypeInstance   = 0x0005
+	collectdTypeValues         = 0x0006
+	collectdTypeInterval       = 0x0007
+	collectdTypeTimeHR         = 0x0008
+	collectdTypeIntervalHR     = 0x0009
+	collectdTypeSignSHA256     = 0x0200
+	collectdTypeEncrAES256     = 0x0210
+)
+
+// collectd data source types
+const (
+	collectdCounter  = 0
+	collectdGauge    = 1
+	collectdDerive   = 2
+	collectdAbsolute = 3
+)
+
+// collectd security levels
+const (
+	CollectdSecurityNone = iota
+	CollectdSecuritySign
+	CollectdSecurityEncrypt
+)
+
+var collectdSecurityLevels = map[string]int{
+	"":        CollectdSecurityNone,
+	"none":    CollectdSecurityNone,
+	"sign":    CollectdSecuritySign,
+	"encrypt": CollectdSecurityEncrypt,
+}
+
+var (
+	errCollectdTruncated = errors.New("collectd packet truncated")
+	errCollectdSign      = errors.New("collectd packet signature verification failed")
+	errCollectdDecrypt   = errors.New("collectd packet decryption failed")
+	errCollectdUser      = errors.New("collectd packet user unknown")
+)
+
+// data source names of widely used collectd types with several values. Used if typesdb is not configured
+var collectdDefaultTypes = map[string][]string{
+	"load":           {"shortterm", "midterm", "longterm"},
+	"if_octets":      {"rx", "tx"},
+	"if_packets":     {"rx", "tx"},
+	"if_errors":      {"rx", "tx"},
+	"if_dropped":     {"rx", "tx"},
+	"io_octets":      {"rx", "tx"},
+	"io_packets":     {"rx", "tx"},
+	"node_octets":    {"rx", "tx"},
+	"disk_octets":    {"read", "write"},
+	"disk_ops":       {"read", "write"},
+	"disk_time":      {"read", "write"},
+	"disk_merged":    {"read", "write"},
+	"disk_io_time":   {"io_time", "weighted_io_time"},
+	"ps_cputime":     {"user", "syst"},
+	"ps_count":       {"processes", "threads"},
+	"ps_disk_octets": {"read", "write"},
+	"ps_disk_ops":    {"read", "write"},
+	"ps_pagefaults":  {"minflt", "majflt"},
+	"df":             {"used", "free"},
+}
+
+// CollectdValueList is one decoded VALUES part with actual identifier
+type CollectdValueList struct {
+	Host           string
+	Plugin         string
+	PluginInstance string
+	Type           string
+	TypeInstance   string
+	Time           float64 // seconds
+	Interval       float64 // seconds
+	DSTypes        []uint8
+	Values         []float64 // GAUGE as is, COUNTER, DERIVE and ABSOLUTE as raw counter (converted to float64)
+	RawValues      []uint64
+}
+
+// ReadCollectdAuthFile reads collectd AuthFile ("user: password" per line)
+func ReadCollectdAuthFile(filename string) (map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[string]string)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		p := strings.IndexByte(line, ':')
+		if p < 1 {
+			return nil, fmt.Errorf("%s: can't parse line %#v", filename, line)
+		}
+		users[strings.TrimSpace(line[:p])] = strings.TrimSpace(line[p+1:])
+	}
+
+	return users, scanner.Err()
+}
+
+// ReadCollectdTypesDB reads collectd types.db file and returns data source names for every type
+func ReadCollectdTypesDB(filename string, types map[string][]string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return fmt.Errorf("%s: can't parse line %#v", filename, line)
+		}
+
+		ds := make([]string, 0, len(fields)-1)
+		for _, d := range strings.Split(strings.Join(fields[1:], ""), ",") {
+			p := strings.IndexByte(d, ':')
+			if p < 1 {
+				return fmt.Errorf("%s: can't parse data source %#v", filename, d)
+			}
+			ds = append(ds, d[:p])
+		}
+		types[fields[0]] = ds
+	}
+
+	return scanner.Err()
+}
+
+// collectdParser decodes collectd binary packets
+type collectdParser struct {
+	securityLevel int
+	users         map[string]string
+}
+
+func collectdString(b []byte) string {
+	if n := bytes.IndexByte(b, 0); n >= 0 {
+		b = b[:n]
+	}
+	return string(b)
+}
+
+func collectdHR(v uint64) float64 {
+	return float64(v>>30) + float64(v&0x3fffffff)/float64(1<<30)
+}
+
+func (p *collectdParser) verify(b []byte) ([]byte, error) {
+	// hmac{32}, username
+	if len(b) < sha256.Size {
+		return nil, errCollectdTruncated
+	}
+	user := string(b[sha256.Size:])
+	password, ok := p.users[user]
+	if !ok {
+		return nil, errCollectdUser
+	}
+
+	return []byte(password), nil
+}
+
+func (p *collectdParser) decrypt(b []byte) ([]byte, error) {
+	// username length{2}, username, iv{16}, encrypted(sha1{20}, data)
+	if len(b) < 2 {
+		return nil, errCollectdTruncated
+	}
+	userLen := int(binary.BigEndian.Uint16(b))
+	if len(b) < 2+userLen+aes.BlockSize+sha1.Size {
+		return nil, errCollectdTruncated
+	}
+	user := string(b[2 : 2+userLen])
+	password, ok := p.users[user]
+	if !ok {
+		return nil, errCollectdUser
+	}
+	iv := b[2+userLen : 2+userLen+aes.BlockSize]
+
+	key := sha256.Sum256([]byte(password))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := b[2+userLen+aes.BlockSize:]
+	plain := make([]byte, len(encrypted))
+	cipher.NewOFB(block, iv).XORKeyStream(plain, encrypted)
+
+	checksum := sha1.Sum(plain[sha1.Size:])
+	if !bytes.Equal(checksum[:], plain[:sha1.Size]) {
+		return nil, errCollectdDecrypt
+	}
+
+	return plain[sha1.Size:], nil
+}
+
+// Parse decodes packet and calls callback for every values part
+func (p *collectdParser) Parse(b []byte, callback func(vl *CollectdValueList)) error {
+	var vl CollectdValueList
+	return p.parse(b, CollectdSecurityNone, &vl, callback)
+}
+
+// parse decodes parts. level is security level already reached by packet (signed or encrypted)
+func (p *collectdParser) parse(b []byte, level int, vl *CollectdValueList, callback func(vl *CollectdValueList)) error {
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return errCollectdTruncated
+		}
+		partType := binary.BigEndian.Uint16(b)
+		partLen := int(binary.BigEndian.Uint16(b[2:]))
+		if partLen < 4 || partLen > len(b) {
+			return errCollectdTruncated
+		}
+		part := b[4:partLen]
+		rest := b[partLen:]
+		b = rest
+
+		switch partType {
+		case collectdTypeSignSHA256:
+			password, err := p.verify(part)
+			if err == errCollectdUser && p.securityLevel == CollectdSecurityNone {
+				// can't verify, accept as unsigned
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			mac := hmac.New(sha256.New, password)
+			mac.Write(part[sha256.Size:])
+			mac.Write(rest)
+			if !hmac.Equal(mac.Sum(nil), part[:sha256.Size]) {
+				return errCollectdSign
+			}
+			if level < CollectdSecuritySign {
+				level = CollectdSecuritySign
+			}
+			continue
+		case collectdTypeEncrAES256:
+			plain, err := p.decrypt(part)
+			if err != nil {
+				return err
+			}
+			if err = p.parse(plain, CollectdSecurityEncrypt, vl, callback); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if level < p.securityLevel {
+			// skip parts without required signature or encryption
+			continue
+		}
+
+		switch partType {
+		case collectdTypeHost:
+			vl.Host = collectdString(part)
+		case collectdTypePlugin:
+			vl.Plugin = collectdString(part)
+		case collectdTypePluginInstance:
+			vl.PluginInstance = collectdString(part)
+		case collectdTypeType:
+			vl.Type = collectdString(part)
+		case collectdTypeTypeInstance:
+			vl.TypeInstance = collectdString(part)
+		case collectdTypeTime, collectdTypeInterval, collectdTypeTimeHR, collectdTypeIntervalHR:
+			if len(part) != 8 {
+				return errCollectdTruncated
+			}
+			v := binary.BigEndian.Uint64(part)
+			switch partType {
+			case collectdTypeTime:
+				vl.Time = float64(v)
+			case collectdTypeInterval:
+				vl.Interval = float64(v)
+			case collectdTypeTimeHR:
+				vl.Time = collectdHR(v)
+			case collectdTypeIntervalHR:
+				vl.Interval = collectdHR(v)
+			}
+		case collectdTypeValues:
+			if len(part) < 2 {
+				return errCollectdTruncated
+			}
+			n := int(binary.BigEndian.Uint16(part))
+			if len(part) != 2+n*9 {
+				return errCollectdTruncated
+			}
+			vl.DSTypes = vl.DSTypes[:0]
+			vl.Values = vl.Values[:0]
+			vl.RawValues = vl.RawValues[:0]
+			for i := 0; i < n; i++ {
+				dsType := part[2+i]
+				raw := part[2+n+i*8 : 2+n+i*8+8]
+				var v float64
+				var u uint64
+				switch dsType {
+				case collectdGauge:
+					// gauge is little endian double
+					v = math.Float64frombits(binary.LittleEndian.Uint64(raw))
+				case collectdDerive:
+					u = binary.BigEndian.Uint64(raw)
+					v = float64(int64(u))
+				case collectdCounter, collectdAbsolute:
+					u = binary.BigEndian.Uint64(raw)
+					v = float64(u)
+				default:
+					return fmt.Errorf("unknown collectd data source type %d", dsType)
+				}
+				vl.DSTypes = append(vl.DSTypes, dsType)
+				vl.Values = append(vl.Values, v)
+				vl.RawValues = append(vl.RawValues, u)
+			}
+			callback(vl)
+		default:
+			// notifications and unknown parts are ignored
+		}
+	}
+
+	return nil
+}
+
+type collectdRateValue struct {
+	raw  uint64
+	time float64
+}
+
+// collectdRates converts COUNTER, DERIVE and ABSOLUTE values to per second rates
+type collectdRates struct {
+	sync.Mutex
+	prev map[string]collectdRateValue
+}
+
+func newCollectdRates() *collectdRates {
+	return &collectdRates{
+		prev: make(map[string]collectdRateValue),
+	}
+}
+
+// Rate returns (rate, true) for value of collectd data source. Rate is unknown for first value of counter or derive
+func (r *collectdRates) Rate(key string, dsType uint8, raw uint64, value float64, t float64, interval float64) (float64, bool) {
+	switch dsType {
+	case collectdGauge:
+		return value, true
+	case collectdAbsolute:
+		if interval <= 0 {
+			return value, true
+		}
+		return value / interval, true
+	}
+
+	r.Lock()
+	prev, ok := r.prev[key]
+	if ok && t <= prev.time {
+		// out of order or duplicate value, newer value is kept
+		r.Unlock()
+		return 0, false
+	}
+	r.prev[key] = collectdRateValue{raw: raw, time: t}
+	r.Unlock()
+
+	if !ok {
+		return 0, false
+	}
+
+	var diff float64
+	if dsType == collectdDerive {
+		diff = float64(int64(raw - prev.raw))
+	} else if raw >= prev.raw {
+		diff = float64(raw - prev.raw)
+	} else if prev.raw <= math.MaxUint32 {
+		// 32 bit counter wrap
+		diff = float64(math.MaxUint32 - prev.raw + raw + 1)
+	} else {
+		// 64 bit counter wrap
+		diff = float64(math.MaxUint64 - prev.raw + raw + 1)
+	}
+
+	return diff / (t - prev.time), true
+}
+
+// Expire removes counters not updated since deadline
+func (r *collectdRates) Expire(deadline time.Time) {
+	ts := float64(deadline.Unix())
+	r.Lock()
+	for k, v := range r.prev {
+		if v.time < ts {
+			delete(r.prev, k)
+		}
+	}
+	r.Unlock()
+}
+
+// collectdTemplate makes metric name from collectd identifier.
+// Placeholders: {host}, {plugin}, {plugin_instance}, {type}, {type_instance}, {ds}.
+// Path part (before first ';') is dotted, empty segments are removed and dots in values replaced by '_'.
+// Optional graphite tags part (after ';') is key=value list, tags with empty values are removed.
+// Template without {ds} gets ".{ds}" appended to path.
+type collectdTemplate struct {
+	path []collectdTemplatePart
+	tags [][]collectdTemplatePart
+}
+
+// collectdTemplatePart is literal text or placeholder of template
+type collectdTemplatePart struct {
+	literal string
+	field   int // placeholder, collectdLiteral for literal text
+}
+
+const (
+	collectdLiteral = iota
+	collectdHost
+	collectdPlugin
+	collectdPluginInstance
+	collectdType
+	collectdTypeInstance
+	collectdDS
+)
+
+var collectdPlaceholders = map[string]int{
+	"{host}":            collectdHost,
+	"{plugin}":          collectdPlugin,
+	"{plugin_instance}": collectdPluginInstance,
+	"{type}":            collectdType,
+	"{type_instance}":   collectdTypeInstance,
+	"{ds}":              collectdDS,
+}
+
+func newCollectdTemplate(s string) (*collectdTemplate, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, errors.New("collectd template is empty")
+	}
+
+	t := &collectdTemplate{}
+	parts := strings.Split(s, ";")
+	if parts[0] == "" {
+		return nil, fmt.Errorf("collectd template %#v has empty path", s)
+	}
+	t.path = parseCollectdTemplate(parts[0])
+	for _, tag := range parts[1:] {
+		if strings.IndexByte(tag, '=') < 1 {
+			return nil, fmt.Errorf("collectd template %#v has invalid tag %#v", s, tag)
+		}
+		t.tags = append(t.tags, parseCollectdTemplate(tag))
+	}
+
+	// values of multi-value types must have different names (and keys of rates)
+	if !t.has(collectdDS) {
+		t.path = append(t.path, collectdTemplatePart{literal: "."}, collectdTemplatePart{field: collectdDS})
+	}
+
+	return t, nil
+}
+
+// has checks placeholder is used in path or tags
+func (t *collectdTemplate) has(field int) bool {
+	for _, parts := range append([][]collectdTemplatePart{t.path}, t.tags...) {
+		for _, p := range parts {
+			if p.field == field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseCollectdTemplate splits template to literal text and placeholders, unknown placeholders are literal text
+func parseCollectdTemplate(s string) []collectdTemplatePart {
+	var parts []collectdTemplatePart
+	literal := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] != '{' {
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			break
+		}
+		field, ok := collectdPlaceholders[s[i:i+end+1]]
+		if !ok {
+			continue
+		}
+		if literal < i {
+			parts = append(parts, collectdTemplatePart{literal: s[literal:i]})
+		}
+		parts = append(parts, collectdTemplatePart{field: field})
+		i += end
+		literal = i + 1
+	}
+	if literal < len(s) {
+		parts = append(parts, collectdTemplatePart{literal: s[literal:]})
+	}
+	return parts
+}
+
+func collectdField(vl *CollectdValueList, ds string, field int) string {
+	switch field {
+	case collectdHost:
+		return vl.Host
+	case collectdPlugin:
+		return vl.Plugin
+	case collectdPluginInstance:
+		return vl.PluginInstance
+	case collectdType:
+		return vl.Type
+	case collectdTypeInstance:
+		return vl.TypeInstance
+	case collectdDS:
+		return ds
+	}
+	return ""
+}
+
+// expandCollectdTemplate appends template with values of placeholders to b
+func expandCollectdTemplate(b []byte, parts []collectdTemplatePart, vl *CollectdValueList, ds string, sanitizer *strings.Replacer) []byte {
+	for _, p := range parts {
+		if p.field == collectdLiteral {
+			b = append(b, p.literal...)
+		} else {
+			b = append(b, sanitizer.Replace(collectdField(vl, ds, p.field))...)
+		}
+	}
+	return b
+}
+
+var collectdPathSanitizer = strings.NewReplacer(".", "_", " ", "_", ";", "_")
+var collectdTagSanitizer = strings.NewReplacer(";", "_", " ", "_")
+
+// Name returns metric name in graphite format (with optional ;tag=value tags)
+func (t *collectdTemplate) Name(vl *CollectdValueList, ds string) string {
+	path := expandCollectdTemplate(make([]byte, 0, 128), t.path, vl, ds, collectdPathSanitizer)
+
+	// remove empty segments
+	name := path[:0]
+	for _, c := range path {
+		if c == '.' && (len(name) == 0 || name[len(name)-1] == '.') {
+			continue
+		}
+		name = append(name, c)
+	}
+	if len(name) > 0 && name[len(name)-1] == '.' {
+		name = name[:len(name)-1]
+	}
+
+	for _, tag := range t.tags {
+		start := len(name)
+		name = append(name, ';')
+		name = expandCollectdTemplate(name, tag, vl, ds, collectdTagSanitizer)
+		kv := name[start+1:]
+		if p := bytes.IndexByte(kv, '='); p < 1 || p == len(kv)-1 {
+			name = name[:start]
+		}
+	}
+
+	return string(name)
+}
@@ -0,0 +1,249 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary/reader"
+	"github.com/lomik/carbon-clickhouse/helper/tags"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type collectdPacket struct {
+	bytes.Buffer
+}
+
+func (p *collectdPacket) part(partType uint16, body []byte) {
+	var h [4]byte
+	binary.BigEndian.PutUint16(h[:], partType)
+	binary.BigEndian.PutUint16(h[2:], uint16(len(body)+4))
+	p.Write(h[:])
+	p.Write(body)
+}
+
+func (p *collectdPacket) str(partType uint16, s string) {
+	p.part(partType, append([]byte(s), 0))
+}
+
+func (p *collectdPacket) number(partType uint16, v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	p.part(partType, b[:])
+}
+
+func (p *collectdPacket) values(dsTypes []uint8, values []float64) {
+	b := make([]byte, 2+len(dsTypes)*9)
+	binary.BigEndian.PutUint16(b, uint16(len(dsTypes)))
+	copy(b[2:], dsTypes)
+	for i, v := range values {
+		raw := b[2+len(dsTypes)+i*8:]
+		if dsTypes[i] == collectdGauge {
+			binary.LittleEndian.PutUint64(raw, math.Float64bits(v))
+		} else {
+			binary.BigEndian.PutUint64(raw, uint64(int64(v)))
+		}
+	}
+	p.part(collectdTypeValues, b)
+}
+
+func collectdTestPacket(ts uint64, load float64, rx, tx float64) []byte {
+	var p collectdPacket
+	p.str(collectdTypeHost, "host1.example.com")
+	p.number(collectdTypeTimeHR, ts<<30)
+	p.number(collectdTypeIntervalHR, 10<<30)
+	p.str(collectdTypePlugin, "load")
+	p.str(collectdTypeType, "load")
+	p.values([]uint8{collectdGauge, collectdGauge, collectdGauge}, []float64{load, 0.5, 0.25})
+	p.str(collectdTypePlugin, "interface")
+	p.str(collectdTypePluginInstance, "eth0")
+	p.str(collectdTypeType, "if_octets")
+	p.values([]uint8{collectdDerive, collectdCounter}, []float64{rx, tx})
+	return p.Bytes()
+}
+
+func collectdSign(payload []byte, user, password string) []byte {
+	mac := hmac.New(sha256.New, []byte(password))
+	mac.Write([]byte(user))
+	mac.Write(payload)
+
+	var p collectdPacket
+	p.part(collectdTypeSignSHA256, append(mac.Sum(nil), []byte(user)...))
+	p.Write(payload)
+	return p.Bytes()
+}
+
+func collectdEncrypt(payload []byte, user, password string) []byte {
+	key := sha256.Sum256([]byte(password))
+	block, _ := aes.NewCipher(key[:])
+	iv := bytes.Repeat([]byte{7}, aes.BlockSize)
+
+	checksum := sha1.Sum(payload)
+	plain := append(checksum[:], payload...)
+	encrypted := make([]byte, len(plain))
+	cipher.NewOFB(block, iv).XORKeyStream(encrypted, plain)
+
+	body := make([]byte, 2, 2+len(user)+len(iv)+len(encrypted))
+	binary.BigEndian.PutUint16(body, uint16(len(user)))
+	body = append(body, []byte(user)...)
+	body = append(body, iv...)
+	body = append(body, encrypted...)
+
+	var p collectdPacket
+	p.part(collectdTypeEncrAES256, body)
+	return p.Bytes()
+}
+
+func newTestCollectd(t *testing.T, template string, level int) *Collectd {
+	rcv := &Collectd{}
+	rcv.logger = zap.NewNop()
+	rcv.Tags = tags.DisabledTagConfig()
+	rcv.collectdTemplate = template
+	require.NoError(t, rcv.configure())
+	rcv.parser.securityLevel = level
+	rcv.parser.users = map[string]string{"user": "secret"}
+	rcv.writeChan = make(chan *RowBinary.WriteBuffer, 16)
+	return rcv
+}
+
+func collectdParse(rcv *Collectd, packet []byte) []reader.Point {
+	b := GetBuffer()
+	b.Write(packet)
+	b.Time = 1670348800
+	rcv.ParseBuffer(context.Background(), b)
+	b.Release()
+
+	points := make([]reader.Point, 0)
+	for {
+		select {
+		case wb := <-rcv.writeChan:
+			br := reader.NewReader(bytes.NewReader(wb.Bytes()))
+			for {
+				p, err := br.ReadGraphitePoint()
+				if err != nil {
+					break
+				}
+				p.Version = 0
+				points = append(points, *p)
+			}
+			wb.Release()
+			continue
+		default:
+		}
+		break
+	}
+	return points
+}
+
+func TestCollectdDotted(t *testing.T) {
+	rcv := newTestCollectd(t, "", CollectdSecurityNone)
+
+	points := collectdParse(rcv, collectdTestPacket(1670348700, 1.5, 1000, 4294967000))
+	assert.Equal(t, []reader.Point{
+		{Path: "host1_example_com.load.load.shortterm", Value: 1.5, Timestamp: 1670348700, Days: 19332},
+		{Path: "host1_example_com.load.load.midterm", Value: 0.5, Timestamp: 1670348700, Days: 19332},
+		{Path: "host1_example_com.load.load.longterm", Value: 0.25, Timestamp: 1670348700, Days: 19332},
+	}, points, "counters are skipped on first value")
+
+	// derive grows on 500 in 10 seconds, 32 bit counter wraps
+	points = collectdParse(rcv, collectdTestPacket(1670348710, 2, 1500, 200))
+	assert.Equal(t, []reader.Point{
+		{Path: "host1_example_com.load.load.shortterm", Value: 2, Timestamp: 1670348710, Days: 19332},
+		{Path: "host1_example_com.load.load.midterm", Value: 0.5, Timestamp: 1670348710, Days: 19332},
+		{Path: "host1_example_com.load.load.longterm", Value: 0.25, Timestamp: 1670348710, Days: 19332},
+		{Path: "host1_example_com.interface.eth0.if_octets.rx", Value: 50, Timestamp: 1670348710, Days: 19332},
+		{Path: "host1_example_com.interface.eth0.if_octets.tx", Value: 49.6, Timestamp: 1670348710, Days: 19332},
+	}, points)
+}
+
+func TestCollectdTagged(t *testing.T) {
+	rcv := newTestCollectd(t, "collectd.{plugin}.{type}.{ds};host={host};instance={plugin_instance}", CollectdSecurityNone)
+
+	points := collectdParse(rcv, collectdTestPacket(1670348700, 1.5, 1000, 2000))
+	require.Equal(t, 3, len(points))
+	assert.Equal(t, "collectd.load.load.shortterm?host=host1.example.com", points[0].Path)
+}
+
+func TestCollectdTemplateWithoutDS(t *testing.T) {
+	rcv := newTestCollectd(t, "{host}.{plugin}.{type}", CollectdSecurityNone)
+
+	collectdParse(rcv, collectdTestPacket(1670348700, 1.5, 1000, 4294967000))
+	points := collectdParse(rcv, collectdTestPacket(1670348710, 2, 1500, 200))
+	require.Equal(t, 5, len(points))
+	// rates of data sources are not mixed
+	assert.Equal(t, reader.Point{Path: "host1_example_com.interface.if_octets.rx", Value: 50, Timestamp: 1670348710, Days: 19332}, points[3])
+	assert.Equal(t, reader.Point{Path: "host1_example_com.interface.if_octets.tx", Value: 49.6, Timestamp: 1670348710, Days: 19332}, points[4])
+}
+
+func TestCollectdSecurity(t *testing.T) {
+	packet := collectdTestPacket(1670348700, 1.5, 1000, 2000)
+
+	tests := []struct {
+		name   string
+		level  int
+		packet []byte
+		want   int
+	}{
+		{"plain with none", CollectdSecurityNone, packet, 3},
+		{"plain with sign", CollectdSecuritySign, packet, 0},
+		{"signed with sign", CollectdSecuritySign, collectdSign(packet, "user", "secret"), 3},
+		{"bad signature", CollectdSecurityNone, collectdSign(packet, "user", "wrong"), 0},
+		{"unknown user with none", CollectdSecurityNone, collectdSign(packet, "unknown", "secret"), 3},
+		{"signed with encrypt", CollectdSecurityEncrypt, collectdSign(packet, "user", "secret"), 0},
+		{"encrypted with encrypt", CollectdSecurityEncrypt, collectdEncrypt(packet, "user", "secret"), 3},
+		{"bad password", CollectdSecurityNone, collectdEncrypt(packet, "user", "wrong"), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rcv := newTestCollectd(t, "", tt.level)
+			assert.Equal(t, tt.want, len(collectdParse(rcv, tt.packet)))
+		})
+	}
+}
+
+func TestCollectdTemplate(t *testing.T) {
+	vl := &CollectdValueList{Host: "web.example.com", Plugin: "cpu", PluginInstance: "", Type: "cpu", TypeInstance: "idle"}
+
+	tpl, err := newCollectdTemplate("collectd.{host}.{plugin}.{plugin_instance}.{type}.{type_instance}.{ds}.{unknown}")
+	require.NoError(t, err)
+	assert.Equal(t, "collectd.web_example_com.cpu.cpu.idle.value.{unknown}", tpl.Name(vl, "value"))
+
+	tpl, err = newCollectdTemplate(".{plugin_instance}.{plugin}..{type_instance}.;host={host};instance={plugin_instance};ds={ds}")
+	require.NoError(t, err)
+	assert.Equal(t, "cpu.idle;host=web.example.com;ds=rx_bytes", tpl.Name(vl, "rx bytes"))
+
+	// data sources of multi-value type get different names without {ds} in template
+	vl = &CollectdValueList{Host: "web", Plugin: "load", Type: "load"}
+	tpl, err = newCollectdTemplate("{host}.{plugin}.{type};instance={plugin_instance}")
+	require.NoError(t, err)
+	assert.Equal(t, "web.load.load", tpl.Name(vl, ""))
+	assert.Equal(t, "web.load.load.shortterm", tpl.Name(vl, "shortterm"))
+	assert.Equal(t, "web.load.load.midterm", tpl.Name(vl, "midterm"))
+}
+
+func TestCollectdRatesOutOfOrder(t *testing.T) {
+	r := newCollectdRates()
+
+	_, ok := r.Rate("a", collectdCounter, 100, 100, 10, 10)
+	assert.False(t, ok)
+	rate, ok := r.Rate("a", collectdCounter, 200, 200, 20, 10)
+	assert.True(t, ok)
+	assert.Equal(t, 10.0, rate)
+
+	// older value doesn't replace newer one
+	_, ok = r.Rate("a", collectdCounter, 150, 150, 15, 10)
+	assert.False(t, ok)
+	rate, ok = r.Rate("a", collectdCounter, 400, 400, 30, 10)
+	assert.True(t, ok)
+	assert.Equal(t, 20.0, rate)
+}
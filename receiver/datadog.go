@@ -0,0 +1,336 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	json "github.com/json-iterator/go"
+	"go.uber.org/zap"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/escape"
+)
+
+// Datadog metric types in /api/v2/series payload
+var datadogV2Types = map[int]string{
+	0: "",
+	1: "count",
+	2: "rate",
+	3: "gauge",
+}
+
+// DatadogSeriesV1 is element of /api/v1/series payload
+type DatadogSeriesV1 struct {
+	Metric   string       `json:"metric"`
+	Points   [][]*float64 `json:"points"` // [[timestamp, value], ...]
+	Type     string       `json:"type"`
+	Interval int64        `json:"interval"`
+	Host     string       `json:"host"`
+	Device   string       `json:"device"`
+	Tags     []string     `json:"tags"`
+}
+
+type DatadogPayloadV1 struct {
+	Series []DatadogSeriesV1 `json:"series"`
+}
+
+type DatadogPointV2 struct {
+	Timestamp int64    `json:"timestamp"`
+	Value     *float64 `json:"value"`
+}
+
+type DatadogResourceV2 struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// DatadogSeriesV2 is element of /api/v2/series payload
+type DatadogSeriesV2 struct {
+	Metric    string              `json:"metric"`
+	Points    []DatadogPointV2    `json:"points"`
+	Type      int                 `json:"type"`
+	Interval  int64               `json:"interval"`
+	Tags      []string            `json:"tags"`
+	Resources []DatadogResourceV2 `json:"resources"`
+}
+
+type DatadogPayloadV2 struct {
+	Series []DatadogSeriesV2 `json:"series"`
+}
+
+// Datadog receive metrics in Datadog series API format
+type Datadog struct {
+	Base
+	listener *net.TCPListener
+}
+
+type datadogTag struct {
+	key   string
+	value string
+}
+
+// DatadogPath makes tagged graphite path from datadog metric name and tags (["key:value", ...]).
+// Tags without value are stored as key=true
+func DatadogPath(metric string, metricType string, host string, device string, ddTags []string) string {
+	tags := make([]datadogTag, 0, len(ddTags)+3)
+
+	if host != "" {
+		tags = append(tags, datadogTag{"host", host})
+	}
+	if device != "" {
+		tags = append(tags, datadogTag{"device", device})
+	}
+	if metricType != "" {
+		tags = append(tags, datadogTag{"type", metricType})
+	}
+
+	for i := 0; i < len(ddTags); i++ {
+		t := ddTags[i]
+		if t == "" {
+			continue
+		}
+		p := strings.IndexByte(t, ':')
+		if p == 0 {
+			continue
+		} else if p < 0 || p == len(t)-1 {
+			tags = append(tags, datadogTag{strings.TrimSuffix(t, ":"), "true"})
+		} else {
+			tags = append(tags, datadogTag{t[:p], t[p+1:]})
+		}
+	}
+
+	for i := 0; i < len(tags); i++ {
+		// `name` is reserved for metric, replace it as tag name
+		if tags[i].key == "name" {
+			tags[i].key = "_name"
+		}
+	}
+
+	// explicit host, device and type has priority
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].key < tags[j].key })
+
+	var res bytes.Buffer
+	res.WriteString(escape.Path(metric))
+	res.WriteByte('?')
+
+	prevKey := ""
+	written := 0
+	for i := 0; i < len(tags); i++ {
+		key := tags[i].key
+		if key == prevKey {
+			continue
+		}
+		prevKey = key
+
+		if written > 0 {
+			res.WriteByte('&')
+		}
+		res.WriteString(escape.Query(key))
+		res.WriteByte('=')
+		res.WriteString(escape.Query(tags[i].value))
+		written++
+	}
+
+	if written == 0 {
+		// untagged metric
+		res.Truncate(res.Len() - 1)
+	}
+
+	return res.String()
+}
+
+func (rcv *Datadog) processV1(ctx context.Context, body []byte) error {
+	var data DatadogPayloadV1
+	if err := json.Unmarshal(body, &data); err != nil {
+		return err
+	}
+
+	writer := RowBinary.NewWriter(ctx, rcv.writeChan)
+
+	for i := 0; i < len(data.Series); i++ {
+		s := &data.Series[i]
+		if s.Metric == "" {
+			continue
+		}
+
+		name := DatadogPath(s.Metric, s.Type, s.Host, s.Device, s.Tags)
+
+		for j := 0; j < len(s.Points); j++ {
+			p := s.Points[j]
+			if len(p) < 2 || p[0] == nil || p[1] == nil {
+				continue
+			}
+
+			timestamp := int64(*p[0])
+			value := *p[1]
+
+			if math.IsNaN(value) {
+				continue
+			}
+
+			if rcv.isDropString(name, writer.Now(), uint32(timestamp), value) {
+				continue
+			}
+
+			writer.WritePoint(name, value, timestamp)
+		}
+	}
+
+	rcv.flush(writer)
+
+	return nil
+}
+
+func (rcv *Datadog) processV2(ctx context.Context, body []byte) error {
+	var data DatadogPayloadV2
+	if err := json.Unmarshal(body, &data); err != nil {
+		return err
+	}
+
+	writer := RowBinary.NewWriter(ctx, rcv.writeChan)
+
+	for i := 0; i < len(data.Series); i++ {
+		s := &data.Series[i]
+		if s.Metric == "" {
+			continue
+		}
+
+		var host string
+		for k := 0; k < len(s.Resources); k++ {
+			if s.Resources[k].Type == "host" {
+				host = s.Resources[k].Name
+				break
+			}
+		}
+
+		name := DatadogPath(s.Metric, datadogV2Types[s.Type], host, "", s.Tags)
+
+		for j := 0; j < len(s.Points); j++ {
+			p := s.Points[j]
+			if p.Value == nil || math.IsNaN(*p.Value) {
+				continue
+			}
+
+			if rcv.isDropString(name, writer.Now(), uint32(p.Timestamp), *p.Value) {
+				continue
+			}
+
+			writer.WritePoint(name, *p.Value, p.Timestamp)
+		}
+	}
+
+	rcv.flush(writer)
+
+	return nil
+}
+
+func (rcv *Datadog) flush(writer *RowBinary.Writer) {
+	writer.Flush()
+
+	if samplesCount := writer.PointsWritten(); samplesCount > 0 {
+		atomic.AddUint64(&rcv.stat.samplesReceived, uint64(samplesCount))
+	}
+
+	if writeErrors := writer.WriteErrors(); writeErrors > 0 {
+		atomic.AddUint64(&rcv.stat.errors, uint64(writeErrors))
+	}
+}
+
+func (rcv *Datadog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var process func(ctx context.Context, body []byte) error
+
+	switch r.URL.Path {
+	case "/api/v1/validate":
+		// api key check on agent startup, any key is valid
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"valid":true}`))
+		return
+	case "/api/v1/series":
+		process = rcv.processV1
+	case "/api/v2/series":
+		process = rcv.processV2
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if rcv.rejectOverloaded(w) {
+		return
+	}
+
+	body, err := readBody(w, r, rcv.maxBodySize)
+	if err != nil {
+		atomic.AddUint64(&rcv.stat.errors, 1)
+		http.Error(w, err.Error(), bodyStatus(err))
+		return
+	}
+
+	if err = process(r.Context(), body); err != nil {
+		atomic.AddUint64(&rcv.stat.errors, 1)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	w.Write([]byte(`{"errors":[]}`))
+}
+
+// Addr returns binded socket address. For bind port 0 in tests
+func (rcv *Datadog) Addr() net.Addr {
+	if rcv.listener == nil {
+		return nil
+	}
+	return rcv.listener.Addr()
+}
+
+func (rcv *Datadog) Stat(send func(metric string, value float64)) {
+	rcv.SendStat(send, "samplesReceived", "errors", "futureDropped", "pastDropped", "tooLongDropped",
+		"backpressureRejected")
+}
+
+// Listen bind port. Receive messages and send to out channel
+func (rcv *Datadog) Listen(addr *net.TCPAddr) error {
+	return rcv.StartFunc(func() error {
+
+		tcpListener, err := net.ListenTCP("tcp", addr)
+		if err != nil {
+			return err
+		}
+
+		s := &http.Server{
+			Handler:        rcv,
+			ReadTimeout:    10 * time.Second,
+			WriteTimeout:   10 * time.Second,
+			MaxHeaderBytes: 1 << 20,
+		}
+
+		rcv.Go(func(ctx context.Context) {
+			<-ctx.Done()
+			tcpListener.Close()
+		})
+
+		rcv.Go(func(ctx context.Context) {
+			if err := s.Serve(tcpListener); err != nil {
+				rcv.logger.Fatal("failed to serve", zap.Error(err))
+			}
+
+		})
+
+		rcv.listener = tcpListener
+
+		return nil
+	})
+}
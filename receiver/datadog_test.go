@@ -0,0 +1,120 @@
+package receiver
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary/reader"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDatadogPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		metric     string
+		metricType string
+		host       string
+		tags       []string
+		want       string
+	}{
+		{
+			name:   "untagged",
+			metric: "system.load.1",
+			want:   "system.load.1",
+		},
+		{
+			name:       "tags sorted and escaped",
+			metric:     "system.disk.free",
+			metricType: "gauge",
+			host:       "host1",
+			tags:       []string{"device:/dev/sda1", "env:prod", "name:x", "standalone"},
+			want:       "system.disk.free?_name=x&device=%2Fdev%2Fsda1&env=prod&host=host1&standalone=true&type=gauge",
+		},
+		{
+			name:   "explicit host has priority",
+			metric: "m",
+			host:   "host1",
+			tags:   []string{"host:host2", "env:a", "env:b"},
+			want:   "m?env=a&host=host1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, DatadogPath(tt.metric, tt.metricType, tt.host, "", tt.tags))
+		})
+	}
+}
+
+func readDatadogPoints(t *testing.T, writeChan chan *RowBinary.WriteBuffer) []reader.Point {
+	var rawBuf bytes.Buffer
+	for {
+		select {
+		case b := <-writeChan:
+			rawBuf.Write(b.Bytes())
+			b.Release()
+			continue
+		default:
+		}
+		break
+	}
+
+	points := make([]reader.Point, 0)
+	br := reader.NewReader(&rawBuf)
+	for {
+		p, err := br.ReadGraphitePoint()
+		if err != nil {
+			break
+		}
+		p.Version = 0
+		points = append(points, *p)
+	}
+	return points
+}
+
+func TestDatadogSeries(t *testing.T) {
+	rcv := &Datadog{}
+	rcv.writeChan = make(chan *RowBinary.WriteBuffer, 16)
+	rcv.dropPastSeconds = 3600 * 24 * 365 * 10 // drop point with ancient timestamp
+
+	v1 := `{"series":[
+		{"metric":"system.load.1","points":[[1670348700,1.5],[1670348710,null],[1,2]],"type":"gauge","host":"h1","tags":["env:prod"]},
+		{"metric":"","points":[[1670348700,1]]}
+	]}`
+
+	v2 := `{"series":[
+		{"metric":"requests","type":1,"points":[{"timestamp":1670348700,"value":10}],"resources":[{"name":"h2","type":"host"}],"tags":["env:dev"]}
+	]}`
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/series", bytes.NewBufferString(v1))
+	w := httptest.NewRecorder()
+	rcv.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	var compressed bytes.Buffer
+	zw := zlib.NewWriter(&compressed)
+	zw.Write([]byte(v2))
+	zw.Close()
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v2/series", &compressed)
+	req.Header.Set("Content-Encoding", "deflate")
+	w = httptest.NewRecorder()
+	rcv.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	want := []reader.Point{
+		{Path: "system.load.1?env=prod&host=h1&type=gauge", Value: 1.5, Timestamp: 1670348700, Days: 19332},
+		{Path: "requests?env=dev&host=h2&type=count", Value: 10, Timestamp: 1670348700, Days: 19332},
+	}
+	assert.Equal(t, want, readDatadogPoints(t, rcv.writeChan))
+	assert.Equal(t, uint64(1), rcv.stat.pastDropped)
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v2/series", bytes.NewBufferString("{"))
+	w = httptest.NewRecorder()
+	rcv.ServeHTTP(w, req.WithContext(context.Background()))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
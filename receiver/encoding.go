@@ -0,0 +1,101 @@
+package receiver
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// errBodyTooLarge is returned by readBody if request body or decompressed body exceeds limit
+var errBodyTooLarge = errors.New("request body too large")
+
+// bodyStatus returns HTTP status for error of readBody
+func bodyStatus(err error) int {
+	if errors.Is(err, errBodyTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// readAll reads r up to limit bytes, zero limit is unlimited
+func readAll(r io.Reader, limit int64) ([]byte, error) {
+	if limit <= 0 {
+		return ioutil.ReadAll(r)
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, errBodyTooLarge
+		}
+		return nil, err
+	}
+	if int64(len(b)) > limit {
+		return nil, errBodyTooLarge
+	}
+	return b, nil
+}
+
+// readBody reads full request body and decompress it according to Content-Encoding header.
+// Both request body and decompressed body are limited by limit bytes, zero limit is unlimited
+func readBody(w http.ResponseWriter, r *http.Request, limit int64) ([]byte, error) {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+
+	body := r.Body
+	if limit > 0 {
+		body = http.MaxBytesReader(w, r.Body, limit)
+	}
+
+	switch encoding {
+	case "", "identity":
+		return readAll(body, limit)
+	case "gzip", "x-gzip":
+		gr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		return readAll(gr, limit)
+	case "deflate":
+		// Datadog agent and most of http clients send zlib wrapped deflate stream
+		zr, err := zlib.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return readAll(zr, limit)
+	case "zstd":
+		zr, err := zstd.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return readAll(zr, limit)
+	case "snappy":
+		compressed, err := readAll(body, limit)
+		if err != nil {
+			return nil, err
+		}
+		n, err := snappy.DecodedLen(compressed)
+		if err != nil {
+			return nil, err
+		}
+		if limit > 0 && int64(n) > limit {
+			return nil, errBodyTooLarge
+		}
+		return snappy.Decode(nil, compressed)
+	}
+
+	// drain body for keep-alive connection
+	io.Copy(ioutil.Discard, body)
+
+	return nil, fmt.Errorf("unsupported Content-Encoding %#v", encoding)
+}
@@ -2,17 +2,25 @@ package receiver
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 
 	"github.com/golang/protobuf/ptypes/empty"
 	pb "github.com/lomik/carbon-clickhouse/grpc"
 	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/prompb"
 	"github.com/lomik/carbon-clickhouse/helper/tags"
 	"go.uber.org/zap"
 )
@@ -32,19 +40,69 @@ func (g *GRPC) Addr() net.Addr {
 }
 
 func (g *GRPC) Stat(send func(metric string, value float64)) {
-	g.SendStat(send, "metricsReceived", "errors", "futureDropped", "pastDropped", "tooLongDropped")
+	g.SendStat(send, "metricsReceived", "errors", "futureDropped", "pastDropped", "tooLongDropped",
+		"backpressureRejected")
+}
+
+// serverOptions returns grpc server options from receiver config
+func (g *GRPC) serverOptions() ([]grpc.ServerOption, error) {
+	var opts []grpc.ServerOption
+
+	if g.grpcMaxMessageSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(g.grpcMaxMessageSize), grpc.MaxSendMsgSize(g.grpcMaxMessageSize))
+	}
+
+	if g.grpcKeepaliveTime > 0 || g.grpcKeepaliveTimeout > 0 {
+		opts = append(opts, grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:    g.grpcKeepaliveTime,
+			Timeout: g.grpcKeepaliveTimeout,
+		}))
+	}
+
+	if g.grpcKeepaliveMinTime > 0 {
+		opts = append(opts, grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             g.grpcKeepaliveMinTime,
+			PermitWithoutStream: true,
+		}))
+	}
+
+	switch g.grpcCompression {
+	case "", "none":
+		// gzip compressed requests are accepted anyway, response is compressed like request
+	case gzip.Name:
+		opts = append(opts,
+			grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				// ignore error, client don't support compression
+				grpc.SetSendCompressor(ctx, gzip.Name)
+				return handler(ctx, req)
+			}),
+			grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				grpc.SetSendCompressor(ss.Context(), gzip.Name)
+				return handler(srv, ss)
+			}),
+		)
+	default:
+		return nil, fmt.Errorf("unknown grpc compression %#v", g.grpcCompression)
+	}
+
+	return opts, nil
 }
 
 // Listen bind port. Receive messages and send to out channel
 func (g *GRPC) Listen(addr *net.TCPAddr) error {
 	return g.StartFunc(func() error {
 
+		opts, err := g.serverOptions()
+		if err != nil {
+			return err
+		}
+
 		tcpListener, err := net.ListenTCP("tcp", addr)
 		if err != nil {
 			return err
 		}
 
-		s := grpc.NewServer()
+		s := grpc.NewServer(opts...)
 		pb.RegisterCarbonServer(s, g)
 		// Register reflection service on gRPC server.
 		reflection.Register(s)
@@ -69,14 +127,48 @@ func (g *GRPC) Listen(addr *net.TCPAddr) error {
 	})
 }
 
-func (g *GRPC) doStore(requestCtx context.Context, in *pb.Payload, confirmRequired bool) error {
+// labelsPath builds tagged path from metric name and labels, like prometheus receiver
+func labelsPath(name string, labels map[string]string) (string, error) {
+	l := make([]*prompb.Label, 0, len(labels)+1)
+	l = append(l, &prompb.Label{Name: "__name__", Value: name})
+	for k, v := range labels {
+		if k == "__name__" {
+			continue
+		}
+		l = append(l, &prompb.Label{Name: k, Value: v})
+	}
+	return tags.Prometheus(l)
+}
+
+// storePayload validates carbon.proto payload and sends points to writer.
+// If confirmRequired, waits until all points are written to disk
+func (base *Base) storePayload(requestCtx context.Context, in *pb.Payload, confirmRequired bool) error {
+	wait, err := base.sendPayload(requestCtx, in, confirmRequired)
+	if err != nil {
+		return err
+	}
+	return wait()
+}
+
+func noWait() error {
+	return nil
+}
+
+// sendPayload validates carbon.proto payload and sends points to writer. Returned wait waits until
+// all points are written to disk if confirmRequired
+func (base *Base) sendPayload(requestCtx context.Context, in *pb.Payload, confirmRequired bool) (wait func() error, err error) {
 	// validate
 	if in == nil {
-		return nil
+		return noWait, nil
 	}
 
 	if in.Metrics == nil {
-		return nil
+		return noWait, nil
+	}
+
+	if base.backpressure.Active() {
+		atomic.AddUint64(&base.stat.backpressureRejected, 1)
+		return nil, status.Errorf(codes.ResourceExhausted, "overloaded, retry after %s", base.backpressure.RetryAfter())
 	}
 
 	pointsCount := uint32(0)
@@ -85,24 +177,29 @@ func (g *GRPC) doStore(requestCtx context.Context, in *pb.Payload, confirmRequir
 		m := in.Metrics[i]
 
 		if m == nil {
-			return errors.New("metric is empty")
+			return nil, errors.New("metric is empty")
 		}
 
 		if len(m.Metric) == 0 {
-			return errors.New("name is empty")
+			return nil, errors.New("name is empty")
 		}
 
 		if len(m.Metric) > 16384 {
-			return errors.New("name too long")
+			return nil, errors.New("name too long")
 		}
 
 		if m.Points == nil || len(m.Points) == 0 {
-			return errors.New("points is empty")
+			return nil, errors.New("points is empty")
 		}
 
-		name, err := tags.Graphite(g.Tags, m.Metric)
+		var name string
+		if len(m.Labels) > 0 {
+			name, err = labelsPath(m.Metric, m.Labels)
+		} else {
+			name, err = tags.Graphite(base.Tags, m.Metric)
+		}
 		if err != nil {
-			return err
+			return nil, err
 		}
 		m.Metric = name
 
@@ -122,7 +219,7 @@ func (g *GRPC) doStore(requestCtx context.Context, in *pb.Payload, confirmRequir
 
 	var receverCtx context.Context
 	// hack for get private exit channel
-	g.WithCtx(func(c context.Context) {
+	base.WithCtx(func(c context.Context) {
 		receverCtx = c
 	})
 
@@ -131,18 +228,19 @@ func (g *GRPC) doStore(requestCtx context.Context, in *pb.Payload, confirmRequir
 		m := in.Metrics[i]
 
 		for j := 0; j < len(m.Points); j++ {
-			if g.isDropString(m.Metric, now, m.Points[j].Timestamp, m.Points[j].Value) {
+			if base.isDropString(m.Metric, now, m.Points[j].Timestamp, m.Points[j].Value) {
+				pointsCount--
 				continue
 			}
 
 			if !wb.CanWriteGraphitePoint(len(m.Metric)) {
 				select {
-				case g.writeChan <- wb:
+				case base.writeChan <- wb:
 					// pass
 				case <-receverCtx.Done():
-					return errors.New("receiver stopped")
+					return nil, errors.New("receiver stopped")
 				case <-requestCtx.Done():
-					return errors.New("request canceled")
+					return nil, errors.New("request canceled")
 				}
 
 				wb = RowBinary.GetWriterBufferWithConfirm(wg, errorChan)
@@ -157,20 +255,29 @@ func (g *GRPC) doStore(requestCtx context.Context, in *pb.Payload, confirmRequir
 		}
 	}
 
+	atomic.AddUint64(&base.stat.metricsReceived, uint64(pointsCount))
+
 	if wb.Empty() {
+		if wb.ConfirmRequired() {
+			wb.Confirm()
+		}
 		wb.Release()
 	} else {
 		select {
-		case g.writeChan <- wb:
+		case base.writeChan <- wb:
 			// pass
 		case <-receverCtx.Done():
-			return errors.New("receiver stopped")
+			return nil, errors.New("receiver stopped")
 		case <-requestCtx.Done():
-			return errors.New("request canceled")
+			return nil, errors.New("request canceled")
 		}
 	}
 
-	if confirmRequired {
+	if !confirmRequired {
+		return noWait, nil
+	}
+
+	return func() error {
 		wg.Wait()
 
 		select {
@@ -178,13 +285,12 @@ func (g *GRPC) doStore(requestCtx context.Context, in *pb.Payload, confirmRequir
 			return err
 		default:
 		}
-	}
-
-	return nil
+		return nil
+	}, nil
 }
 
 func (g *GRPC) Store(ctx context.Context, in *pb.Payload) (*empty.Empty, error) {
-	err := g.doStore(ctx, in, false)
+	err := g.storePayload(ctx, in, false)
 	if err != nil {
 		return nil, err
 	}
@@ -193,10 +299,63 @@ func (g *GRPC) Store(ctx context.Context, in *pb.Payload) (*empty.Empty, error)
 }
 
 func (g *GRPC) StoreSync(ctx context.Context, in *pb.Payload) (*empty.Empty, error) {
-	err := g.doStore(ctx, in, true)
+	err := g.storePayload(ctx, in, true)
 	if err != nil {
 		return nil, err
 	}
 
 	return &empty.Empty{}, nil
 }
+
+// streamInFlight is max count of payloads of stream not acked yet. Payloads are written concurrently,
+// acks are sent in order of payloads
+const streamInFlight = 64
+
+type streamAck struct {
+	id   uint64
+	wait func() error
+}
+
+// StoreStream stores every received payload like StoreSync and sends ack with payload id.
+// Next payloads are received and sent to writer while previous ones are written to disk
+func (g *GRPC) StoreStream(stream pb.Carbon_StoreStreamServer) error {
+	acks := make(chan streamAck, streamInFlight)
+	sent := make(chan error, 1)
+
+	go func() {
+		var err error
+		for a := range acks {
+			ack := &pb.Ack{Id: a.id}
+			if werr := a.wait(); werr != nil {
+				ack.Error = werr.Error()
+			}
+			if err == nil {
+				err = stream.Send(ack)
+			}
+		}
+		sent <- err
+	}()
+
+	var err error
+	for {
+		in, rerr := stream.Recv()
+		if rerr != nil {
+			if rerr != io.EOF {
+				err = rerr
+			}
+			break
+		}
+
+		wait, serr := g.sendPayload(stream.Context(), in, true)
+		if serr != nil {
+			wait = func() error { return serr }
+		}
+		acks <- streamAck{id: in.Id, wait: wait}
+	}
+
+	close(acks)
+	if serr := <-sent; err == nil {
+		err = serr
+	}
+	return err
+}
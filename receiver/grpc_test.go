@@ -0,0 +1,228 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+
+	pb "github.com/lomik/carbon-clickhouse/grpc"
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary/reader"
+	"github.com/lomik/carbon-clickhouse/helper/tags"
+	"github.com/lomik/carbon-clickhouse/helper/tests"
+)
+
+func TestLabelsPath(t *testing.T) {
+	name, err := labelsPath("cpu.usage", map[string]string{"host": "h1", "__name__": "ignored", "dc": "a b"})
+	require.NoError(t, err)
+	assert.Equal(t, "cpu.usage?dc=a+b&host=h1", name)
+}
+
+func TestGRPCStoreStream(t *testing.T) {
+	writeChan := make(chan *RowBinary.WriteBuffer, 16)
+	address, err := tests.GetFreeTCPPort("")
+	require.NoError(t, err)
+
+	rcv, err := New(
+		"grpc://"+address,
+		tags.DisabledTagConfig(),
+		WriteChan(writeChan),
+		GrpcMaxMessageSize(1024*1024),
+		GrpcKeepalive(time.Minute, 10*time.Second, 10*time.Second),
+		GrpcCompression("gzip"),
+	)
+	require.NoError(t, err)
+	defer rcv.Stop()
+
+	var rawBuf bytes.Buffer
+	var writeErr error
+
+	// writer emulation
+	go func() {
+		for wb := range writeChan {
+			if writeErr != nil {
+				wb.Fail(writeErr)
+			} else {
+				rawBuf.Write(wb.Bytes())
+				wb.Confirm()
+			}
+			wb.Release()
+		}
+	}()
+
+	conn, err := grpc.Dial(
+		address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name)),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := pb.NewCarbonClient(conn).StoreStream(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, stream.Send(&pb.Payload{
+		Id: 1,
+		Metrics: []*pb.Metric{
+			{Metric: "hello.world", Points: []*pb.Point{{Timestamp: 1559465760, Value: 42}}},
+			{
+				Metric: "requests",
+				Labels: map[string]string{"host": "h1", "app": "carbon"},
+				Points: []*pb.Point{{Timestamp: 1662098177, Value: 15}},
+			},
+		},
+	}))
+	ack, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(1), ack.Id)
+	assert.Equal(t, "", ack.Error)
+
+	verifyIndexUploaded(t, &rawBuf, []reader.Point{
+		{Path: "hello.world", Value: 42, Timestamp: 1559465760, Days: 18049},
+		{Path: "requests?app=carbon&host=h1", Value: 15, Timestamp: 1662098177, Days: 19237},
+	}, 0, uint32(time.Now().Unix()))
+
+	require.NoError(t, stream.Send(&pb.Payload{Id: 2, Metrics: []*pb.Metric{{Metric: "empty"}}}))
+	ack, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), ack.Id)
+	assert.Equal(t, "points is empty", ack.Error)
+
+	writeErr = errors.New("disk is full")
+	require.NoError(t, stream.Send(&pb.Payload{
+		Id:      3,
+		Metrics: []*pb.Metric{{Metric: "hello.world", Points: []*pb.Point{{Timestamp: 1559465760, Value: 42}}}},
+	}))
+	ack, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, uint64(3), ack.Id)
+	assert.Equal(t, "disk is full", ack.Error)
+
+	require.NoError(t, stream.CloseSend())
+}
+
+func TestGRPCStoreSyncDropped(t *testing.T) {
+	writeChan := make(chan *RowBinary.WriteBuffer, 16)
+	address, err := tests.GetFreeTCPPort("")
+	require.NoError(t, err)
+
+	rcv, err := New(
+		"grpc://"+address,
+		tags.DisabledTagConfig(),
+		WriteChan(writeChan),
+		DropPast(3600),
+	)
+	require.NoError(t, err)
+	defer rcv.Stop()
+
+	var rawBuf bytes.Buffer
+	go func() {
+		for wb := range writeChan {
+			rawBuf.Write(wb.Bytes())
+			wb.Confirm()
+			wb.Release()
+		}
+	}()
+
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client := pb.NewCarbonClient(conn)
+
+	stat := func() map[string]float64 {
+		m := make(map[string]float64)
+		rcv.Stat(func(metric string, value float64) { m[metric] = value })
+		return m
+	}
+
+	// all points are dropped: nothing is written, confirmation isn't waited for
+	_, err = client.StoreSync(ctx, &pb.Payload{
+		Metrics: []*pb.Metric{{Metric: "hello.world", Points: []*pb.Point{{Timestamp: 1559465760, Value: 42}}}},
+	})
+	require.NoError(t, err)
+	s := stat()
+	assert.Equal(t, float64(0), s["metricsReceived"])
+	assert.Equal(t, float64(1), s["pastDropped"])
+
+	// dropped points are not counted as received
+	now := uint32(time.Now().Unix())
+	_, err = client.StoreSync(ctx, &pb.Payload{
+		Metrics: []*pb.Metric{{Metric: "hello.world", Points: []*pb.Point{
+			{Timestamp: 1559465760, Value: 42},
+			{Timestamp: now, Value: 43},
+		}}},
+	})
+	require.NoError(t, err)
+	s = stat()
+	assert.Equal(t, float64(1), s["metricsReceived"])
+	assert.Equal(t, float64(1), s["pastDropped"])
+
+	verifyIndexUploaded(t, &rawBuf, []reader.Point{
+		{Path: "hello.world", Value: 43, Timestamp: now, Days: RowBinary.TimestampToDays(now)},
+	}, 0, uint32(time.Now().Unix()))
+}
+
+func TestGRPCStoreStreamPipelined(t *testing.T) {
+	writeChan := make(chan *RowBinary.WriteBuffer, 16)
+	address, err := tests.GetFreeTCPPort("")
+	require.NoError(t, err)
+
+	rcv, err := New("grpc://"+address, tags.DisabledTagConfig(), WriteChan(writeChan))
+	require.NoError(t, err)
+	defer rcv.Stop()
+
+	// writer emulation confirms buffers only when buffers of all payloads are received
+	const payloads = 4
+	go func() {
+		var pending []*RowBinary.WriteBuffer
+		for wb := range writeChan {
+			pending = append(pending, wb)
+			if len(pending) < payloads {
+				continue
+			}
+			for _, wb := range pending {
+				wb.Confirm()
+				wb.Release()
+			}
+			pending = nil
+		}
+	}()
+
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	stream, err := pb.NewCarbonClient(conn).StoreStream(ctx)
+	require.NoError(t, err)
+
+	for i := 1; i <= payloads; i++ {
+		require.NoError(t, stream.Send(&pb.Payload{
+			Id:      uint64(i),
+			Metrics: []*pb.Metric{{Metric: "hello.world", Points: []*pb.Point{{Timestamp: 1559465760, Value: 42}}}},
+		}))
+	}
+	for i := 1; i <= payloads; i++ {
+		ack, err := stream.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, uint64(i), ack.Id)
+		assert.Equal(t, "", ack.Error)
+	}
+	require.NoError(t, stream.CloseSend())
+}
@@ -33,7 +33,7 @@ func (rcv *Pickle) Addr() net.Addr {
 
 func (rcv *Pickle) Stat(send func(metric string, value float64)) {
 	rcv.SendStat(send, "metricsReceived", "messagesReceived", "errors", "active", "futureDropped", "pastDropped",
-		"tooLongDropped")
+		"tooLongDropped", "backpressurePaused")
 }
 
 func (rcv *Pickle) HandleConnection(conn net.Conn) {
@@ -65,6 +65,7 @@ func (rcv *Pickle) HandleConnection(conn net.Conn) {
 	framedConn.MaxFrameSize = uint(maxPickleMessageSize)
 
 	for {
+		rcv.pauseOverloaded()
 		conn.SetReadDeadline(time.Now().Add(2 * time.Minute))
 		data, err := framedConn.ReadFrame()
 		if err == framing.ErrPrefixLength {
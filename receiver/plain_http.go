@@ -0,0 +1,225 @@
+package receiver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	json "github.com/json-iterator/go"
+	"go.uber.org/zap"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/tags"
+)
+
+// PlainHttpResponse is summary of processed request
+type PlainHttpResponse struct {
+	Accepted uint64 `json:"accepted"`
+	Rejected uint64 `json:"rejected"` // can't parse line
+	Dropped  uint64 `json:"dropped"`  // dropped by drop-future, drop-past or drop-longer-than rules
+	Error    string `json:"error,omitempty"`
+}
+
+// PlainHttp receive newline separated graphite plain lines in POST body
+type PlainHttp struct {
+	Base
+	listener *net.TCPListener
+}
+
+func (rcv *PlainHttp) process(ctx context.Context, body []byte, res *PlainHttpResponse) error {
+	var tagBuf tags.GraphiteBuf
+	tagBuf.Resize(128, 4096)
+
+	now := uint32(time.Now().Unix())
+
+	var wg *sync.WaitGroup
+	var errorChan chan error
+
+	if rcv.syncWrite {
+		wg = new(sync.WaitGroup)
+		errorChan = make(chan error, 1)
+	}
+
+	var receiverCtx context.Context
+	rcv.WithCtx(func(c context.Context) {
+		receiverCtx = c
+	})
+
+	send := func(wb *RowBinary.WriteBuffer) error {
+		if wb.Empty() {
+			if wb.ConfirmRequired() {
+				wb.Confirm()
+			}
+			wb.Release()
+			return nil
+		}
+		select {
+		case rcv.writeChan <- wb:
+			return nil
+		case <-receiverCtx.Done():
+			return errors.New("receiver stopped")
+		case <-ctx.Done():
+			return errors.New("request canceled")
+		}
+	}
+
+	wb := RowBinary.GetWriterBufferWithConfirm(wg, errorChan)
+
+	for len(body) > 0 {
+		var line []byte
+		lineEnd := bytes.IndexByte(body, '\n')
+		if lineEnd < 0 {
+			line = body
+			body = nil
+		} else {
+			line = body[:lineEnd+1]
+			body = body[lineEnd+1:]
+		}
+
+		if len(line) == 0 || line[0] == '\n' || (line[0] == '\r' && len(line) <= 2) {
+			// skip empty line
+			continue
+		}
+
+		name, value, timestamp, err := rcv.PlainParseLine(line, now, &tagBuf)
+		if err != nil {
+			res.Rejected++
+			continue
+		}
+
+		if rcv.isDropBytes(name, now, timestamp, value) {
+			res.Dropped++
+			continue
+		}
+
+		if !wb.CanWriteGraphitePoint(len(name)) {
+			if err = send(wb); err != nil {
+				return err
+			}
+			wb = RowBinary.GetWriterBufferWithConfirm(wg, errorChan)
+			if !wb.CanWriteGraphitePoint(len(name)) {
+				res.Rejected++
+				continue
+			}
+		}
+
+		wb.WriteGraphitePoint(name, value, timestamp, now)
+		res.Accepted++
+	}
+
+	if err := send(wb); err != nil {
+		return err
+	}
+
+	if rcv.syncWrite {
+		wg.Wait()
+
+		select {
+		case err := <-errorChan:
+			return err
+		default:
+		}
+	}
+
+	return nil
+}
+
+func (rcv *PlainHttp) writeResponse(w http.ResponseWriter, status int, res *PlainHttpResponse) {
+	body, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func (rcv *PlainHttp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var res PlainHttpResponse
+
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		res.Error = "method not allowed"
+		rcv.writeResponse(w, http.StatusMethodNotAllowed, &res)
+		return
+	}
+
+	if rcv.rejectOverloaded(w) {
+		return
+	}
+
+	atomic.AddUint64(&rcv.stat.messagesReceived, 1)
+
+	body, err := readBody(w, r, rcv.maxBodySize)
+	if err != nil {
+		atomic.AddUint64(&rcv.stat.errors, 1)
+		res.Error = err.Error()
+		rcv.writeResponse(w, bodyStatus(err), &res)
+		return
+	}
+
+	err = rcv.process(r.Context(), body, &res)
+
+	atomic.AddUint64(&rcv.stat.metricsReceived, res.Accepted)
+	if res.Rejected > 0 {
+		atomic.AddUint64(&rcv.stat.errors, res.Rejected)
+	}
+
+	if err != nil {
+		atomic.AddUint64(&rcv.stat.errors, 1)
+		rcv.logger.Error("write failed", zap.Error(err))
+		res.Error = err.Error()
+		rcv.writeResponse(w, http.StatusInternalServerError, &res)
+		return
+	}
+
+	rcv.writeResponse(w, http.StatusOK, &res)
+}
+
+// Addr returns binded socket address. For bind port 0 in tests
+func (rcv *PlainHttp) Addr() net.Addr {
+	if rcv.listener == nil {
+		return nil
+	}
+	return rcv.listener.Addr()
+}
+
+func (rcv *PlainHttp) Stat(send func(metric string, value float64)) {
+	rcv.SendStat(send, "metricsReceived", "messagesReceived", "errors", "futureDropped", "pastDropped", "tooLongDropped",
+		"backpressureRejected")
+}
+
+// Listen bind port. Receive messages and send to out channel
+func (rcv *PlainHttp) Listen(addr *net.TCPAddr) error {
+	return rcv.StartFunc(func() error {
+
+		tcpListener, err := net.ListenTCP("tcp", addr)
+		if err != nil {
+			return err
+		}
+
+		s := &http.Server{
+			Handler:        rcv,
+			ReadTimeout:    10 * time.Second,
+			WriteTimeout:   10 * time.Second,
+			MaxHeaderBytes: 1 << 20,
+		}
+
+		rcv.Go(func(ctx context.Context) {
+			<-ctx.Done()
+			s.Close()
+		})
+
+		rcv.Go(func(ctx context.Context) {
+			if err := s.Serve(tcpListener); err != nil && err != http.ErrServerClosed {
+				rcv.logger.Fatal("failed to serve", zap.Error(err))
+			}
+
+		})
+
+		rcv.listener = tcpListener
+
+		return nil
+	})
+}
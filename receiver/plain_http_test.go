@@ -0,0 +1,172 @@
+package receiver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	json "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary/reader"
+	"github.com/lomik/carbon-clickhouse/helper/backpressure"
+	"github.com/lomik/carbon-clickhouse/helper/tags"
+	"github.com/lomik/carbon-clickhouse/helper/tests"
+)
+
+func postPlainHttp(t *testing.T, address string, body io.Reader, encoding string) (int, PlainHttpResponse) {
+	req, err := http.NewRequest(http.MethodPost, "http://"+address+"/", body)
+	require.NoError(t, err)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var res PlainHttpResponse
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, &res))
+
+	return resp.StatusCode, res
+}
+
+func TestPlainHttp(t *testing.T) {
+	writeChan := make(chan *RowBinary.WriteBuffer, 16)
+	address, err := tests.GetFreeTCPPort("")
+	require.NoError(t, err)
+
+	rcv, err := New(
+		"http://"+address,
+		tags.DisabledTagConfig(),
+		WriteChan(writeChan),
+		DropFuture(uint32(0)),
+		DropPast(uint32(0)),
+		DropLongerThan(20),
+		SyncWrite(true),
+	)
+	require.NoError(t, err)
+	defer rcv.Stop()
+
+	var rawBuf bytes.Buffer
+	var writeErr error
+
+	// writer emulation
+	written := make(chan struct{})
+	go func() {
+		for wb := range writeChan {
+			if writeErr != nil {
+				wb.Fail(writeErr)
+			} else {
+				rawBuf.Write(wb.Bytes())
+				wb.Confirm()
+			}
+			wb.Release()
+			written <- struct{}{}
+		}
+	}()
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte("hello.world 42 1559465760\n\nbad_line\r\nhello.world.too.long.metric 1 1559465760\r\nerrors;app=carbon 15 1662098177"))
+	zw.Close()
+
+	status, res := postPlainHttp(t, address, &compressed, "gzip")
+	<-written
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, PlainHttpResponse{Accepted: 2, Rejected: 1, Dropped: 1}, res)
+
+	verifyIndexUploaded(t, &rawBuf, []reader.Point{
+		{Path: "hello.world", Value: 42, Timestamp: 1559465760, Days: 18049},
+		{Path: "errors?app=carbon", Value: 15, Timestamp: 1662098177, Days: 19237},
+	}, 0, uint32(time.Now().Unix()))
+
+	writeErr = errors.New("disk is full")
+	status, res = postPlainHttp(t, address, bytes.NewBufferString("hello.world 42 1559465760\n"), "")
+	<-written
+	assert.Equal(t, http.StatusInternalServerError, status)
+	assert.Equal(t, PlainHttpResponse{Accepted: 1, Error: "disk is full"}, res)
+
+	status, res = postPlainHttp(t, address, bytes.NewBufferString("hello.world 42 1559465760\n"), "br")
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, uint64(0), res.Accepted)
+}
+
+func TestPlainHttpBackpressure(t *testing.T) {
+	writeChan := make(chan *RowBinary.WriteBuffer, 16)
+	address, err := tests.GetFreeTCPPort("")
+	require.NoError(t, err)
+
+	bp := backpressure.New(3*time.Second, http.StatusServiceUnavailable,
+		backpressure.Check{Name: "queue", Value: func() int { return 1 }, Limit: 1},
+	)
+	bp.Start()
+	defer bp.Stop()
+
+	rcv, err := New(
+		"http://"+address,
+		tags.DisabledTagConfig(),
+		WriteChan(writeChan),
+		Backpressure(bp),
+	)
+	require.NoError(t, err)
+	defer rcv.Stop()
+
+	require.Eventually(t, bp.Active, time.Second, 10*time.Millisecond)
+
+	resp, err := http.Post("http://"+address+"/", "text/plain", bytes.NewReader([]byte("hello.world 42 1559465760\n")))
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "3", resp.Header.Get("Retry-After"))
+	assert.Len(t, writeChan, 0)
+
+	stat := make(map[string]float64)
+	rcv.Stat(func(metric string, value float64) { stat[metric] = value })
+	assert.Equal(t, float64(1), stat["backpressureRejected"])
+	assert.Equal(t, float64(0), stat["messagesReceived"])
+}
+
+func TestPlainHttpMaxBodySize(t *testing.T) {
+	writeChan := make(chan *RowBinary.WriteBuffer, 16)
+	address, err := tests.GetFreeTCPPort("")
+	require.NoError(t, err)
+
+	rcv, err := New(
+		"http://"+address,
+		tags.DisabledTagConfig(),
+		WriteChan(writeChan),
+		MaxBodySize(1024),
+	)
+	require.NoError(t, err)
+	defer rcv.Stop()
+
+	// large body
+	code, res := postPlainHttp(t, address, bytes.NewReader(bytes.Repeat([]byte("a"), 2048)), "")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Equal(t, errBodyTooLarge.Error(), res.Error)
+
+	// small compressed body with large decompressed body
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(bytes.Repeat([]byte("hello.world 42 1559465760\n"), 1024))
+	zw.Close()
+	require.Less(t, compressed.Len(), 1024)
+	code, res = postPlainHttp(t, address, &compressed, "gzip")
+	assert.Equal(t, http.StatusRequestEntityTooLarge, code)
+	assert.Equal(t, errBodyTooLarge.Error(), res.Error)
+
+	// body within limit
+	code, res = postPlainHttp(t, address, bytes.NewReader([]byte("hello.world 42 1559465760\n")), "")
+	assert.Equal(t, http.StatusOK, code, res.Error)
+	assert.Equal(t, uint64(1), res.Accepted)
+}
@@ -13,7 +13,6 @@ import (
 	"github.com/golang/snappy"
 	"go.uber.org/zap"
 
-	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
 	"github.com/lomik/carbon-clickhouse/helper/pb"
 	"github.com/lomik/carbon-clickhouse/helper/prompb"
 	"github.com/lomik/carbon-clickhouse/helper/tags"
@@ -28,7 +27,7 @@ type PrometheusRemoteWrite struct {
 
 func (rcv *PrometheusRemoteWrite) unpackFast(ctx context.Context, bufBody []byte) error {
 	// The writer is created first to have the writer.now written at execution time
-	writer := RowBinary.NewWriter(ctx, rcv.writeChan)
+	writer := rcv.newWriter(ctx)
 
 	b := bufBody
 	var err error
@@ -120,12 +119,12 @@ TimeSeriesLoop:
 		atomic.AddUint64(&rcv.stat.errors, uint64(writeErrors))
 	}
 
-	return nil
+	return writer.Wait()
 }
 
 func (rcv *PrometheusRemoteWrite) unpackDefault(ctx context.Context, bufBody []byte) error {
 	// The writer is created first to have the writer.now written at execution time, not after unmarshalling
-	writer := RowBinary.NewWriter(ctx, rcv.writeChan)
+	writer := rcv.newWriter(ctx)
 	var req prompb.WriteRequest
 
 	if err := proto.Unmarshal(bufBody, &req); err != nil {
@@ -167,10 +166,14 @@ func (rcv *PrometheusRemoteWrite) unpackDefault(ctx context.Context, bufBody []b
 		atomic.AddUint64(&rcv.stat.errors, uint64(writeErrors))
 	}
 
-	return nil
+	return writer.Wait()
 }
 
 func (rcv *PrometheusRemoteWrite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rcv.rejectOverloaded(w) {
+		return
+	}
+
 	compressed, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -199,7 +202,8 @@ func (rcv *PrometheusRemoteWrite) Addr() net.Addr {
 }
 
 func (rcv *PrometheusRemoteWrite) Stat(send func(metric string, value float64)) {
-	rcv.SendStat(send, "samplesReceived", "errors", "futureDropped", "pastDropped", "tooLongDropped")
+	rcv.SendStat(send, "samplesReceived", "errors", "futureDropped", "pastDropped", "tooLongDropped",
+		"backpressureRejected")
 }
 
 // Listen bind port. Receive messages and send to out channel
@@ -1,8 +1,12 @@
 package receiver
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/gogo/protobuf/proto"
@@ -159,3 +163,41 @@ func BenchmarkProm1UnpackSlow(b *testing.B) {
 		}
 	}
 }
+
+func TestPromSyncWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	compressed, err := base64.StdEncoding.DecodeString(prom1)
+	assert.NoError(err)
+
+	h := &PrometheusRemoteWrite{}
+	h.syncWrite = true
+	h.writeChan = make(chan *RowBinary.WriteBuffer)
+
+	// writer emulation, fails every buffer after writeErr set
+	var writeErr error
+	var written int
+	go func() {
+		for wb := range h.writeChan {
+			if writeErr != nil {
+				wb.Fail(writeErr)
+			} else {
+				written++
+				wb.Confirm()
+			}
+			wb.Release()
+		}
+	}()
+	defer close(h.writeChan)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(compressed)))
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Greater(written, 0, "response sent before buffers are written")
+
+	writeErr = errors.New("disk is full")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(compressed)))
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.Equal("disk is full\n", w.Body.String())
+}
@@ -0,0 +1,165 @@
+package receiver
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/lomik/graphite-pickle/framing"
+	"go.uber.org/zap"
+	"golang.org/x/net/context"
+
+	pb "github.com/lomik/carbon-clickhouse/grpc"
+)
+
+const maxProtobufMessageSize = 67108864
+
+// Protobuf receive carbon.proto Payload messages with 4-byte length prefix from TCP connections
+// (protobuf listener of graphite carbon and carbon-relay-ng)
+type Protobuf struct {
+	Base
+	listener  *net.TCPListener
+	parseChan chan []byte
+}
+
+// Addr returns binded socket address. For bind port 0 in tests
+func (rcv *Protobuf) Addr() net.Addr {
+	if rcv.listener == nil {
+		return nil
+	}
+	return rcv.listener.Addr()
+}
+
+func (rcv *Protobuf) Stat(send func(metric string, value float64)) {
+	rcv.SendStat(send, "metricsReceived", "messagesReceived", "errors", "active", "futureDropped", "pastDropped",
+		"tooLongDropped", "backpressurePaused")
+}
+
+func (rcv *Protobuf) HandleConnection(conn net.Conn) {
+	framedConn, _ := framing.NewConn(conn, byte(4), binary.BigEndian)
+	defer func() {
+		if r := recover(); r != nil {
+			rcv.logger.Error("panic recovered", zap.String("traceback", fmt.Sprint(r)))
+		}
+	}()
+
+	atomic.AddInt64(&rcv.stat.active, 1)
+	defer atomic.AddInt64(&rcv.stat.active, -1)
+
+	defer conn.Close()
+
+	finished := make(chan bool)
+	defer close(finished)
+
+	rcv.Go(func(ctx context.Context) {
+		select {
+		case <-finished:
+			return
+		case <-ctx.Done():
+			conn.Close()
+			return
+		}
+	})
+
+	framedConn.MaxFrameSize = uint(maxProtobufMessageSize)
+
+	for {
+		rcv.pauseOverloaded()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Minute))
+		data, err := framedConn.ReadFrame()
+		if err == framing.ErrPrefixLength {
+			atomic.AddUint64(&rcv.stat.errors, 1)
+			rcv.logger.Warn("bad message size")
+			return
+		} else if err != nil {
+			if err != io.EOF {
+				atomic.AddUint64(&rcv.stat.errors, 1)
+				rcv.logger.Warn("can't read message body", zap.Error(err))
+			}
+			return
+		}
+
+		rcv.parseChan <- data
+	}
+}
+
+// ProtobufParseBytes unmarshal Payload message and sends points to writer
+func (rcv *Protobuf) ProtobufParseBytes(ctx context.Context, b []byte) {
+	atomic.AddUint64(&rcv.stat.messagesReceived, 1)
+
+	var payload pb.Payload
+	if err := payload.Unmarshal(b); err != nil {
+		atomic.AddUint64(&rcv.stat.errors, 1)
+		rcv.logger.Warn("can't unmarshal message", zap.Error(err))
+		return
+	}
+
+	if err := rcv.storePayload(ctx, &payload, false); err != nil {
+		atomic.AddUint64(&rcv.stat.errors, 1)
+		rcv.logger.Warn("can't store message", zap.Error(err))
+	}
+}
+
+func (rcv *Protobuf) ProtobufParser(ctx context.Context, in chan []byte) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case b := <-in:
+			rcv.ProtobufParseBytes(ctx, b)
+		}
+	}
+}
+
+// Listen bind port. Receive messages and send to out channel
+func (rcv *Protobuf) Listen(addr *net.TCPAddr) error {
+	return rcv.StartFunc(func() error {
+
+		tcpListener, err := net.ListenTCP("tcp", addr)
+		if err != nil {
+			return err
+		}
+
+		rcv.Go(func(ctx context.Context) {
+			<-ctx.Done()
+			tcpListener.Close()
+		})
+
+		handler := rcv.HandleConnection
+
+		rcv.Go(func(ctx context.Context) {
+			defer tcpListener.Close()
+
+			for {
+
+				conn, err := tcpListener.Accept()
+				if err != nil {
+					if strings.Contains(err.Error(), "use of closed network connection") {
+						break
+					}
+					rcv.logger.Warn("failed to accept connection", zap.Error(err))
+					continue
+				}
+
+				rcv.Go(func(ctx context.Context) {
+					handler(conn)
+				})
+			}
+
+		})
+
+		for i := 0; i < rcv.parseThreads; i++ {
+			rcv.Go(func(ctx context.Context) {
+				rcv.ProtobufParser(ctx, rcv.parseChan)
+			})
+		}
+
+		rcv.listener = tcpListener
+
+		return nil
+	})
+}
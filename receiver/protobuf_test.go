@@ -0,0 +1,112 @@
+package receiver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	pb "github.com/lomik/carbon-clickhouse/grpc"
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary/reader"
+	"github.com/lomik/carbon-clickhouse/helper/tags"
+	"github.com/lomik/carbon-clickhouse/helper/tests"
+)
+
+func TestProtobuf(t *testing.T) {
+	writeChan := make(chan *RowBinary.WriteBuffer, 16)
+	address, err := tests.GetFreeTCPPort("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rcv, err := New(
+		"protobuf://"+address,
+		tags.DisabledTagConfig(),
+		ParseThreads(1),
+		WriteChan(writeChan),
+		DropFuture(uint32(0)),
+		DropPast(uint32(0)),
+		DropLongerThan(0),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rcv.Stop()
+
+	payloads := []*pb.Payload{
+		{
+			Metrics: []*pb.Metric{
+				{
+					Metric: "carbon.agents.carbon-clickhouse.writer.writtenBytes",
+					Points: []*pb.Point{{Timestamp: 1559465760, Value: 1}, {Timestamp: 1559465800, Value: 2}},
+				},
+			},
+		},
+		{
+			Metrics: []*pb.Metric{
+				{
+					Metric: "errors;scope=protobuf;app=carbon-clickhouse",
+					Points: []*pb.Point{{Timestamp: 1662098177, Value: 3}},
+				},
+			},
+		},
+	}
+
+	points := []reader.Point{
+		{
+			Path:      "carbon.agents.carbon-clickhouse.writer.writtenBytes",
+			Value:     1,
+			Timestamp: 1559465760,
+			Days:      18049,
+		},
+		{
+			Path:      "carbon.agents.carbon-clickhouse.writer.writtenBytes",
+			Value:     2,
+			Timestamp: 1559465800,
+			Days:      18049,
+		},
+		{
+			Path:      "errors?app=carbon-clickhouse&scope=protobuf",
+			Value:     3,
+			Timestamp: 1662098177,
+			Days:      19237,
+		},
+	}
+
+	start := uint32(time.Now().Unix())
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, payload := range payloads {
+		body, err := payload.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(body)))
+		if _, err = conn.Write(append(size[:], body...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.Close()
+
+	var rawBuf bytes.Buffer
+	for i := 0; i < len(payloads); i++ {
+		select {
+		case b := <-writeChan:
+			rawBuf.Write(b.Bytes())
+			b.Release()
+		case <-time.After(5 * time.Second):
+			t.Fatal("timeout")
+		}
+	}
+
+	end := uint32(time.Now().Unix())
+
+	verifyIndexUploaded(t, &rawBuf, points, start, end)
+}
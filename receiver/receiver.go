@@ -6,8 +6,10 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/backpressure"
 	"github.com/lomik/carbon-clickhouse/helper/tags"
 	"github.com/lomik/zapwriter"
 )
@@ -90,6 +92,108 @@ func ConcatChar(concat string) Option {
 	}
 }
 
+// SyncWrite creates option for New constructor
+func SyncWrite(enabled bool) Option {
+	return func(r interface{}) error {
+		if t, ok := r.(*Base); ok {
+			t.syncWrite = enabled
+		}
+		return nil
+	}
+}
+
+// CollectdTemplate creates option for New constructor
+func CollectdTemplate(template string) Option {
+	return func(r interface{}) error {
+		if t, ok := r.(*Base); ok {
+			t.collectdTemplate = template
+		}
+		return nil
+	}
+}
+
+// CollectdSecurityLevel creates option for New constructor
+func CollectdSecurityLevel(level string) Option {
+	return func(r interface{}) error {
+		if t, ok := r.(*Base); ok {
+			t.collectdSecurityLevel = level
+		}
+		return nil
+	}
+}
+
+// CollectdAuthFile creates option for New constructor
+func CollectdAuthFile(filename string) Option {
+	return func(r interface{}) error {
+		if t, ok := r.(*Base); ok {
+			t.collectdAuthFile = filename
+		}
+		return nil
+	}
+}
+
+// CollectdTypesDB creates option for New constructor
+func CollectdTypesDB(filenames []string) Option {
+	return func(r interface{}) error {
+		if t, ok := r.(*Base); ok {
+			t.collectdTypesDB = filenames
+		}
+		return nil
+	}
+}
+
+// GrpcMaxMessageSize creates option for New constructor
+func GrpcMaxMessageSize(size int) Option {
+	return func(r interface{}) error {
+		if t, ok := r.(*Base); ok {
+			t.grpcMaxMessageSize = size
+		}
+		return nil
+	}
+}
+
+// GrpcKeepalive creates option for New constructor
+func GrpcKeepalive(keepaliveTime, keepaliveTimeout, minTime time.Duration) Option {
+	return func(r interface{}) error {
+		if t, ok := r.(*Base); ok {
+			t.grpcKeepaliveTime = keepaliveTime
+			t.grpcKeepaliveTimeout = keepaliveTimeout
+			t.grpcKeepaliveMinTime = minTime
+		}
+		return nil
+	}
+}
+
+// GrpcCompression creates option for New constructor
+func GrpcCompression(compression string) Option {
+	return func(r interface{}) error {
+		if t, ok := r.(*Base); ok {
+			t.grpcCompression = compression
+		}
+		return nil
+	}
+}
+
+// MaxBodySize creates option for New constructor
+func MaxBodySize(size int64) Option {
+	return func(r interface{}) error {
+		if t, ok := r.(*Base); ok {
+			t.maxBodySize = size
+		}
+		return nil
+	}
+}
+
+// Backpressure creates option for New constructor
+func Backpressure(bp *backpressure.Backpressure) Option {
+	return func(r interface{}) error {
+		if t, ok := r.(*Base); ok {
+			t.backpressure = bp
+		}
+		return nil
+	}
+}
+
 // New creates udp, tcp, pickle receiver
 func New(dsn string, config tags.TagConfig, opts ...Option) (Receiver, error) {
 	u, err := url.Parse(dsn)
@@ -133,6 +237,23 @@ func New(dsn string, config tags.TagConfig, opts ...Option) (Receiver, error) {
 
 		return r, err
 
+	} else if u.Scheme == "protobuf" {
+		addr, err := net.ResolveTCPAddr("tcp", u.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		r := &Protobuf{
+			parseChan: make(chan []byte),
+		}
+		r.Init(logger, config, opts...)
+
+		if err = r.Listen(addr); err != nil {
+			return nil, err
+		}
+
+		return r, err
+
 	} else if u.Scheme == "udp" {
 		addr, err := net.ResolveUDPAddr("udp", u.Host)
 		if err != nil {
@@ -193,6 +314,53 @@ func New(dsn string, config tags.TagConfig, opts ...Option) (Receiver, error) {
 			return nil, err
 		}
 
+		return r, err
+
+	} else if u.Scheme == "http" {
+		addr, err := net.ResolveTCPAddr("tcp", u.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		r := &PlainHttp{}
+		r.Init(logger, config, opts...)
+
+		if err = r.Listen(addr); err != nil {
+			return nil, err
+		}
+
+		return r, err
+
+	} else if u.Scheme == "datadog" {
+		addr, err := net.ResolveTCPAddr("tcp", u.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		r := &Datadog{}
+		r.Init(logger, config, opts...)
+
+		if err = r.Listen(addr); err != nil {
+			return nil, err
+		}
+
+		return r, err
+
+	} else if u.Scheme == "collectd" {
+		addr, err := net.ResolveUDPAddr("udp", u.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		r := &Collectd{
+			parseChan: make(chan *Buffer),
+		}
+		r.Init(logger, config, opts...)
+
+		if err = r.Listen(addr); err != nil {
+			return nil, err
+		}
+
 		return r, err
 	}
 
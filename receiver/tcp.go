@@ -28,7 +28,8 @@ func (rcv *TCP) Addr() net.Addr {
 }
 
 func (rcv *TCP) Stat(send func(metric string, value float64)) {
-	rcv.SendStat(send, "metricsReceived", "errors", "active", "futureDropped", "pastDropped", "tooLongDropped")
+	rcv.SendStat(send, "metricsReceived", "errors", "active", "futureDropped", "pastDropped", "tooLongDropped",
+		"backpressurePaused")
 }
 
 func (rcv *TCP) HandleConnection(conn net.Conn) {
@@ -58,6 +59,7 @@ func (rcv *TCP) HandleConnection(conn net.Conn) {
 	var err error
 
 	for {
+		rcv.pauseOverloaded()
 		if rcv.readTimeoutSeconds == 0 {
 			conn.SetReadDeadline(time.Time{})
 		} else {
@@ -12,7 +12,6 @@ import (
 	"time"
 
 	json "github.com/json-iterator/go"
-	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
 	"github.com/lomik/carbon-clickhouse/helper/escape"
 	"go.uber.org/zap"
 )
@@ -80,7 +79,7 @@ func (rcv *TelegrafHttpJson) process(ctx context.Context, body []byte) (err erro
 		return
 	}
 
-	writer := RowBinary.NewWriter(ctx, rcv.writeChan)
+	writer := rcv.newWriter(ctx)
 
 	var pathBuf bytes.Buffer
 
@@ -135,10 +134,15 @@ metricsLoop:
 		atomic.AddUint64(&rcv.stat.errors, uint64(writeErrors))
 	}
 
+	err = writer.Wait()
 	return
 }
 
 func (rcv *TelegrafHttpJson) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rcv.rejectOverloaded(w) {
+		return
+	}
+
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -158,7 +162,8 @@ func (rcv *TelegrafHttpJson) Addr() net.Addr {
 }
 
 func (rcv *TelegrafHttpJson) Stat(send func(metric string, value float64)) {
-	rcv.SendStat(send, "samplesReceived", "errors", "futureDropped", "pastDropped", "tooLongDropped")
+	rcv.SendStat(send, "samplesReceived", "errors", "futureDropped", "pastDropped", "tooLongDropped",
+		"backpressureRejected")
 }
 
 // Listen bind port. Receive messages and send to out channel
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
+	"net/http/httptest"
 	"runtime"
 	"sync"
 	"testing"
@@ -213,3 +215,43 @@ func BenchmarkTelegrafHttpJson5(b *testing.B) {
 	cancel()
 	wg.Wait()
 }
+
+func TestTelegrafSyncWrite(t *testing.T) {
+	assert := assert.New(t)
+
+	body := []byte(`{"metrics":[{"name":"cpu","timestamp":1559465760,"fields":{"value":42},"tags":{"host":"h1"}}]}`)
+
+	h := &TelegrafHttpJson{}
+	h.syncWrite = true
+	h.writeChan = make(chan *RowBinary.WriteBuffer)
+
+	// writer emulation, fails every buffer after writeErr set
+	var writeErr error
+	var rawBuf bytes.Buffer
+	go func() {
+		for wb := range h.writeChan {
+			if writeErr != nil {
+				wb.Fail(writeErr)
+			} else {
+				rawBuf.Write(wb.Bytes())
+				wb.Confirm()
+			}
+			wb.Release()
+		}
+	}()
+	defer close(h.writeChan)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+	assert.Equal(http.StatusOK, w.Code)
+	// response is sent after buffers are written
+	verifyIndexUploaded(t, &rawBuf, []reader.Point{
+		{Path: "cpu?host=h1", Value: 42, Timestamp: 1559465760, Days: RowBinary.TimestampToDays(1559465760)},
+	}, 0, uint32(time.Now().Unix()))
+
+	writeErr = errors.New("disk is full")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)))
+	assert.Equal(http.StatusInternalServerError, w.Code)
+	assert.Equal("disk is full\n", w.Body.String())
+}
@@ -28,7 +28,7 @@ func (rcv *UDP) Addr() net.Addr {
 
 func (rcv *UDP) Stat(send func(metric string, value float64)) {
 	rcv.SendStat(send, "metricsReceived", "errors", "incompleteReceived", "futureDropped", "pastDropped",
-		"tooLongDropped")
+		"tooLongDropped", "backpressureDropped")
 }
 
 func (rcv *UDP) receiveWorker(ctx context.Context) {
@@ -49,6 +49,10 @@ ReceiveLoop:
 			continue ReceiveLoop
 		}
 
+		if n > 0 && rcv.dropOverloaded() {
+			continue ReceiveLoop
+		}
+
 		if n > 0 {
 			chunkSize := bytes.LastIndexByte(buffer.Body[:n], '\n') + 1
 
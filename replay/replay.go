@@ -0,0 +1,191 @@
+// Package replay uploads chunks (archived or external) with uploader outside of live pipeline
+package replay
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lomik/zapwriter"
+	"go.uber.org/zap"
+)
+
+// Uploader uploads single chunk
+type Uploader interface {
+	Upload(ctx context.Context, filename string) (uint64, error)
+}
+
+// Options of replay
+type Options struct {
+	// Rate is limit of uploaded metrics per second, 0 - unlimited
+	Rate int
+	// Retries of failed chunk upload before stop
+	Retries int
+	// RetryDelay is pause before retry
+	RetryDelay time.Duration
+	// Progress is file with list of uploaded chunks. Uploaded chunks are skipped, so interrupted replay can be resumed
+	Progress string
+}
+
+// Report of replay
+type Report struct {
+	Files   int    `json:"files"`
+	Skipped int    `json:"skipped"`
+	Metrics uint64 `json:"metrics"`
+	// Failed is chunk upload of which failed
+	Failed string `json:"failed,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// progress is append-only list of uploaded chunks, one absolute filename per line
+type progress struct {
+	f    *os.File
+	done map[string]bool
+}
+
+func progressKey(filename string) string {
+	if abs, err := filepath.Abs(filename); err == nil {
+		return abs
+	}
+	return filename
+}
+
+func openProgress(filename string) (*progress, error) {
+	p := &progress{done: make(map[string]bool)}
+	if filename == "" {
+		return p, nil
+	}
+
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// torn last line never matches filename
+		p.done[s.Text()] = true
+	}
+	if err := s.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	p.f = f
+	return p, nil
+}
+
+func (p *progress) isDone(filename string) bool {
+	return p.done[progressKey(filename)]
+}
+
+func (p *progress) markDone(filename string) error {
+	key := progressKey(filename)
+	p.done[key] = true
+	if p.f == nil {
+		return nil
+	}
+	if _, err := p.f.WriteString(key + "\n"); err != nil {
+		return err
+	}
+	return p.f.Sync()
+}
+
+func (p *progress) close() {
+	if p.f != nil {
+		p.f.Close()
+	}
+}
+
+// Run uploads files in order. Replay stops on context cancel or after failed retries of chunk
+func Run(ctx context.Context, up Uploader, files []string, opts Options) (*Report, error) {
+	logger := zapwriter.Logger("replay")
+	report := &Report{}
+
+	fail := func(filename string, err error) (*Report, error) {
+		report.Failed = filename
+		report.Error = err.Error()
+		return report, err
+	}
+
+	p, err := openProgress(opts.Progress)
+	if err != nil {
+		return fail("", err)
+	}
+	defer p.close()
+
+	start := time.Now()
+
+	for _, filename := range files {
+		if p.isDone(filename) {
+			report.Skipped++
+			continue
+		}
+
+		var n uint64
+		for attempt := 0; ; attempt++ {
+			n, err = up.Upload(ctx, filename)
+			if err == nil || attempt >= opts.Retries || ctx.Err() != nil {
+				break
+			}
+			logger.Warn("upload failed, retry",
+				zap.String("filename", filename),
+				zap.Int("attempt", attempt+1),
+				zap.Error(err),
+			)
+			select {
+			case <-ctx.Done():
+			case <-time.After(opts.RetryDelay):
+			}
+		}
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			logger.Error("upload failed", zap.String("filename", filename), zap.Error(err))
+			return fail(filename, err)
+		}
+
+		if err = p.markDone(filename); err != nil {
+			return fail(filename, fmt.Errorf("progress write failed: %w", err))
+		}
+
+		report.Files++
+		report.Metrics += n
+		logger.Info("chunk replayed", zap.String("filename", filename), zap.Uint64("metrics", n))
+
+		if opts.Rate > 0 {
+			// average rate from start
+			expected := time.Duration(float64(report.Metrics) / float64(opts.Rate) * float64(time.Second))
+			if wait := expected - time.Since(start); wait > 0 {
+				select {
+				case <-ctx.Done():
+					return fail("", ctx.Err())
+				case <-time.After(wait):
+				}
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// ParseTime parses RFC3339 time, date (2006-01-02) or unix timestamp
+func ParseTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.UTC); err == nil {
+		return t, nil
+	}
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(ts, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %#v, RFC3339, 2006-01-02 or unix timestamp expected", s)
+}
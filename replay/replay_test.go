@@ -0,0 +1,74 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testUploader struct {
+	uploaded []string
+	fail     map[string]int // failures count before success
+}
+
+func (u *testUploader) Upload(ctx context.Context, filename string) (uint64, error) {
+	if u.fail[filename] > 0 {
+		u.fail[filename]--
+		return 0, errors.New("upload failed")
+	}
+	u.uploaded = append(u.uploaded, filename)
+	return 100, nil
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		filepath.Join(dir, "default.1"),
+		filepath.Join(dir, "default.2"),
+		filepath.Join(dir, "default.3"),
+	}
+	opts := Options{Retries: 1, Progress: filepath.Join(dir, "progress")}
+
+	up := &testUploader{fail: map[string]int{files[1]: 2}}
+	report, err := Run(context.Background(), up, files, opts)
+	assert.Error(t, err)
+	assert.Equal(t, files[:1], up.uploaded)
+	assert.Equal(t, 1, report.Files)
+	assert.Equal(t, files[1], report.Failed)
+
+	// resume from failed chunk
+	up.fail = nil
+	up.uploaded = nil
+	report, err = Run(context.Background(), up, files, opts)
+	require.NoError(t, err)
+	assert.Equal(t, files[1:], up.uploaded)
+	assert.Equal(t, &Report{Files: 2, Skipped: 1, Metrics: 200}, report)
+
+	// rate limit: 300 metrics with 1000 per second
+	up.uploaded = nil
+	start := time.Now()
+	report, err = Run(context.Background(), up, files, Options{Rate: 1000})
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Files)
+	assert.GreaterOrEqual(t, time.Since(start), 250*time.Millisecond)
+}
+
+func TestParseTime(t *testing.T) {
+	for s, expected := range map[string]int64{
+		"2019-06-02T08:56:00Z": 1559465760,
+		"2019-06-02":           1559433600,
+		"1559465760":           1559465760,
+	} {
+		tm, err := ParseTime(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, expected, tm.Unix(), s)
+	}
+
+	_, err := ParseTime("yesterday")
+	assert.Error(t, err)
+}
@@ -0,0 +1,437 @@
+package state
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lomik/zapwriter"
+	"go.uber.org/zap"
+)
+
+const (
+	journalName = "upload.journal"
+
+	opLink   = "link"
+	opDone   = "done"
+	opRemove = "remove"
+
+	// journal is compacted if records count is more than live records count by this factor
+	journalCompactFactor = 4
+	journalCompactMin    = 1024
+)
+
+// journalRecord is line of journal
+type journalRecord struct {
+	Op        string   `json:"op"`
+	Chunk     string   `json:"chunk"`
+	Uploaders []string `json:"uploaders,omitempty"`
+	Time      int64    `json:"time,omitempty"`
+}
+
+type journalChunk struct {
+	linked    time.Time
+	uploaders map[string]bool // uploaded
+}
+
+// Journal keeps status in append-only journal of json records in data directory.
+// Journal is compacted on open and when it contains too many outdated records
+type Journal struct {
+	sync.Mutex
+	path      string
+	uploaders []string
+	filename  string
+	f         *os.File
+	records   int
+	chunks    map[string]*journalChunk
+	logger    *zap.Logger
+}
+
+func journalFilename(path string) string {
+	return filepath.Join(path, journalName)
+}
+
+func (c *journalChunk) apply(r *journalRecord) {
+	switch r.Op {
+	case opLink:
+		for _, u := range r.Uploaders {
+			if _, ok := c.uploaders[u]; !ok {
+				c.uploaders[u] = false
+			}
+		}
+	case opDone:
+		for _, u := range r.Uploaders {
+			c.uploaders[u] = true
+		}
+	}
+}
+
+// readJournal returns state and size of valid part of journal. Torn tail of journal is ignored
+func readJournal(filename string) (map[string]*journalChunk, int, int64, error) {
+	chunks := make(map[string]*journalChunk)
+
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return chunks, 0, 0, nil
+		}
+		return nil, 0, 0, err
+	}
+	defer f.Close()
+
+	var records int
+	var size int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, 0, 0, err
+		}
+
+		var rec journalRecord
+		if json.Unmarshal(line, &rec) != nil {
+			break
+		}
+		size += int64(len(line))
+		records++
+
+		switch rec.Op {
+		case opRemove:
+			delete(chunks, rec.Chunk)
+		case opLink, opDone:
+			c := chunks[rec.Chunk]
+			if c == nil {
+				c = &journalChunk{linked: time.Unix(0, rec.Time), uploaders: make(map[string]bool)}
+				chunks[rec.Chunk] = c
+			}
+			c.apply(&rec)
+		}
+	}
+
+	return chunks, records, size, nil
+}
+
+// NewJournal opens journal in data directory. On first open state of symlinks is migrated to journal
+func NewJournal(path string, uploaders []string) (*Journal, error) {
+	j := &Journal{
+		path:      path,
+		uploaders: uploaders,
+		filename:  journalFilename(path),
+		logger:    zapwriter.Logger("state"),
+	}
+
+	migrate := !exists(j.filename)
+
+	var size int64
+	var err error
+	j.chunks, j.records, size, err = readJournal(j.filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if migrate {
+		if err = j.importSymlinks(); err != nil {
+			return nil, err
+		}
+	} else if st, err := os.Stat(j.filename); err == nil && st.Size() != size {
+		j.logger.Warn("torn journal tail ignored", zap.String("filename", j.filename), zap.Int64("size", st.Size()-size))
+	}
+
+	// compaction rewrites torn tail too
+	if err = j.compact(); err != nil {
+		return nil, err
+	}
+
+	if migrate {
+		j.removeSymlinks()
+	}
+
+	return j, nil
+}
+
+// importSymlinks reads status from symlinks of uploaders
+func (j *Journal) importSymlinks() error {
+	s := NewSymlink(j.path, j.uploaders)
+	for _, u := range j.uploaders {
+		flist, err := ioutil.ReadDir(filepath.Join(j.path, u))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for _, f := range flist {
+			name := strings.TrimPrefix(f.Name(), "_")
+			if !strings.HasPrefix(name, "default.") || !exists(chunkFilename(j.path, name)) {
+				continue
+			}
+			c := j.chunks[name]
+			if c == nil {
+				c = &journalChunk{linked: f.ModTime(), uploaders: make(map[string]bool)}
+				j.chunks[name] = c
+			}
+			c.uploaders[u] = s.Status(name, u) == Uploaded
+		}
+	}
+
+	if len(j.chunks) > 0 {
+		j.logger.Info("symlinks migrated to journal", zap.String("filename", j.filename), zap.Int("chunks", len(j.chunks)))
+	}
+	return nil
+}
+
+// removeSymlinks removes migrated symlinks, journal is already synced
+func (j *Journal) removeSymlinks() {
+	for _, u := range j.uploaders {
+		flist, err := ioutil.ReadDir(filepath.Join(j.path, u))
+		if err != nil {
+			continue
+		}
+		for _, f := range flist {
+			if f.Mode()&os.ModeSymlink != 0 && strings.HasPrefix(strings.TrimPrefix(f.Name(), "_"), "default.") {
+				os.Remove(filepath.Join(j.path, u, f.Name()))
+			}
+		}
+	}
+}
+
+// snapshot returns records of live state
+func (j *Journal) snapshot() []journalRecord {
+	names := make([]string, 0, len(j.chunks))
+	for name := range j.chunks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	records := make([]journalRecord, 0, len(names))
+	for _, name := range names {
+		c := j.chunks[name]
+		link := journalRecord{Op: opLink, Chunk: name, Time: c.linked.UnixNano()}
+		done := journalRecord{Op: opDone, Chunk: name}
+		for u, uploaded := range c.uploaders {
+			link.Uploaders = append(link.Uploaders, u)
+			if uploaded {
+				done.Uploaders = append(done.Uploaders, u)
+			}
+		}
+		sort.Strings(link.Uploaders)
+		sort.Strings(done.Uploaders)
+		records = append(records, link)
+		if len(done.Uploaders) > 0 {
+			records = append(records, done)
+		}
+	}
+	return records
+}
+
+// compact atomically replaces journal with snapshot of live state and reopens it for append
+func (j *Journal) compact() error {
+	records := j.snapshot()
+
+	tmp := j.filename + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for i := range records {
+		if err = enc.Encode(&records[i]); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, j.filename)
+	}
+	if err == nil {
+		err = syncDir(j.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if j.f != nil {
+		j.f.Close()
+	}
+	j.f, err = os.OpenFile(j.filename, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	j.records = len(records)
+
+	return nil
+}
+
+func (j *Journal) maybeCompact() error {
+	if j.records < journalCompactMin || j.records < journalCompactFactor*len(j.chunks) {
+		return nil
+	}
+	return j.compact()
+}
+
+// append writes record with single write call, so record is never interleaved
+func (j *Journal) append(r *journalRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err = j.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	j.records++
+	return nil
+}
+
+func (j *Journal) Link(name string, uploaders []string) error {
+	j.Lock()
+	defer j.Unlock()
+
+	c := j.chunks[name]
+	if c == nil {
+		c = &journalChunk{linked: time.Now(), uploaders: make(map[string]bool)}
+	}
+
+	r := journalRecord{Op: opLink, Chunk: name, Time: c.linked.UnixNano()}
+	for _, u := range uploaders {
+		if _, ok := c.uploaders[u]; !ok {
+			r.Uploaders = append(r.Uploaders, u)
+		}
+	}
+	if len(r.Uploaders) == 0 {
+		return nil
+	}
+
+	if err := j.append(&r); err != nil {
+		return err
+	}
+	j.chunks[name] = c
+	c.apply(&r)
+
+	return nil
+}
+
+func (j *Journal) Pending(uploader string) ([]Chunk, error) {
+	j.Lock()
+	defer j.Unlock()
+
+	chunks := make([]Chunk, 0)
+	for name, c := range j.chunks {
+		if uploaded, ok := c.uploaders[uploader]; ok && !uploaded {
+			chunks = append(chunks, Chunk{
+				Name:     name,
+				Filename: chunkFilename(j.path, name),
+				Linked:   c.linked,
+			})
+		}
+	}
+
+	sort.Slice(chunks, func(i, k int) bool { return chunks[i].Name < chunks[k].Name })
+
+	return chunks, nil
+}
+
+func (j *Journal) Done(name string, uploader string) error {
+	j.Lock()
+	defer j.Unlock()
+
+	c := j.chunks[name]
+	if c == nil {
+		return os.ErrNotExist
+	}
+	if uploaded, ok := c.uploaders[uploader]; !ok || uploaded {
+		return nil
+	}
+
+	r := journalRecord{Op: opDone, Chunk: name, Uploaders: []string{uploader}}
+	if err := j.append(&r); err != nil {
+		return err
+	}
+	c.apply(&r)
+
+	return nil
+}
+
+func (j *Journal) Status(name string, uploader string) Status {
+	j.Lock()
+	defer j.Unlock()
+
+	c := j.chunks[name]
+	if c == nil {
+		return NotLinked
+	}
+	uploaded, ok := c.uploaders[uploader]
+	switch {
+	case !ok:
+		return NotLinked
+	case uploaded:
+		return Uploaded
+	}
+	return Pending
+}
+
+func (j *Journal) Remove(name string) error {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.chunks[name] == nil {
+		return nil
+	}
+	if err := j.append(&journalRecord{Op: opRemove, Chunk: name}); err != nil {
+		return err
+	}
+	delete(j.chunks, name)
+
+	return j.maybeCompact()
+}
+
+// Cleanup removes status of deleted chunks
+func (j *Journal) Cleanup() error {
+	j.Lock()
+	defer j.Unlock()
+
+	for name := range j.chunks {
+		if exists(chunkFilename(j.path, name)) {
+			continue
+		}
+		j.logger.Info("remove status of deleted chunk", zap.String("filename", chunkFilename(j.path, name)))
+		if err := j.append(&journalRecord{Op: opRemove, Chunk: name}); err != nil {
+			return err
+		}
+		delete(j.chunks, name)
+	}
+
+	return j.maybeCompact()
+}
+
+func (j *Journal) Sync(uploaders []string) error {
+	j.Lock()
+	defer j.Unlock()
+	return j.f.Sync()
+}
+
+func (j *Journal) Close() error {
+	j.Lock()
+	defer j.Unlock()
+	return j.f.Close()
+}
@@ -0,0 +1,115 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func chunkNames(chunks []Chunk) []string {
+	names := make([]string, 0, len(chunks))
+	for _, c := range chunks {
+		names = append(names, c.Name)
+	}
+	return names
+}
+
+func TestJournal(t *testing.T) {
+	dir := t.TempDir()
+	uploaders := []string{"points", "index"}
+
+	for _, fn := range []string{"default.1", "default.2"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, fn), nil, 0644))
+	}
+
+	j, err := NewJournal(dir, uploaders)
+	require.NoError(t, err)
+
+	require.NoError(t, j.Link("default.1", uploaders))
+	require.NoError(t, j.Link("default.2", []string{"points"}))
+	require.NoError(t, j.Done("default.1", "index"))
+
+	pending, err := j.Pending("points")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default.1", "default.2"}, chunkNames(pending))
+	assert.Equal(t, filepath.Join(dir, "default.1"), pending[0].Filename)
+
+	pending, err = j.Pending("index")
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+
+	assert.Equal(t, Uploaded, j.Status("default.1", "index"))
+	assert.Equal(t, NotLinked, j.Status("default.2", "index"))
+	require.NoError(t, j.Close())
+
+	// torn tail of journal
+	f, err := os.OpenFile(journalFilename(dir), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"op":"done","chunk":"def`)
+	require.NoError(t, err)
+	f.Close()
+
+	// replay
+	j, err = NewJournal(dir, uploaders)
+	require.NoError(t, err)
+	assert.Equal(t, Pending, j.Status("default.1", "points"))
+	assert.Equal(t, Uploaded, j.Status("default.1", "index"))
+	assert.Equal(t, Pending, j.Status("default.2", "points"))
+
+	require.NoError(t, j.Done("default.1", "points"))
+	assert.True(t, Finished(j, "default.1", uploaders))
+
+	// deleted chunk
+	require.NoError(t, os.Remove(filepath.Join(dir, "default.2")))
+	require.NoError(t, j.Cleanup())
+	assert.Equal(t, NotLinked, j.Status("default.2", "points"))
+	require.NoError(t, j.Close())
+
+	j, err = NewJournal(dir, uploaders)
+	require.NoError(t, err)
+	assert.Equal(t, 1, len(j.chunks))
+	assert.Equal(t, 2, j.records)
+	require.NoError(t, j.Close())
+}
+
+func TestMigration(t *testing.T) {
+	dir := t.TempDir()
+	uploaders := []string{"points", "index"}
+
+	for _, fn := range []string{"default.1", "default.2"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, fn), nil, 0644))
+		require.NoError(t, Link(filepath.Join(dir, fn), uploaders))
+	}
+	s := NewSymlink(dir, uploaders)
+	require.NoError(t, s.Done("default.1", "points"))
+
+	// symlinks to journal
+	st, err := New(BackendJournal, dir, uploaders)
+	require.NoError(t, err)
+	assert.Equal(t, Uploaded, st.Status("default.1", "points"))
+	assert.Equal(t, Pending, st.Status("default.1", "index"))
+	assert.Equal(t, Pending, st.Status("default.2", "points"))
+	require.NoError(t, st.Close())
+
+	links, err := filepath.Glob(filepath.Join(dir, "*", "*default.*"))
+	require.NoError(t, err)
+	assert.Empty(t, links)
+
+	// journal to symlinks
+	st, err = New(BackendSymlink, dir, uploaders)
+	require.NoError(t, err)
+	assert.Equal(t, Uploaded, st.Status("default.1", "points"))
+	assert.Equal(t, Pending, st.Status("default.1", "index"))
+	assert.Equal(t, Pending, st.Status("default.2", "points"))
+	assert.Equal(t, Pending, st.Status("default.2", "index"))
+
+	pending, err := st.Pending("index")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default.1", "default.2"}, chunkNames(pending))
+
+	_, err = os.Stat(journalFilename(dir))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,117 @@
+package state
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Memory keeps status in memory only. It's state of uploader outside of live pipeline (replay),
+// status is lost on exit and nothing is written to data directory
+type Memory struct {
+	sync.Mutex
+	path   string
+	chunks map[string]*journalChunk
+}
+
+// NewMemory creates empty state of chunks in path
+func NewMemory(path string) *Memory {
+	return &Memory{
+		path:   path,
+		chunks: make(map[string]*journalChunk),
+	}
+}
+
+func (m *Memory) Link(name string, uploaders []string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	c := m.chunks[name]
+	if c == nil {
+		c = &journalChunk{linked: time.Now(), uploaders: make(map[string]bool)}
+		m.chunks[name] = c
+	}
+	c.apply(&journalRecord{Op: opLink, Uploaders: uploaders})
+	return nil
+}
+
+func (m *Memory) Pending(uploader string) ([]Chunk, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	chunks := make([]Chunk, 0)
+	for name, c := range m.chunks {
+		if uploaded, ok := c.uploaders[uploader]; ok && !uploaded {
+			chunks = append(chunks, Chunk{
+				Name:     name,
+				Filename: chunkFilename(m.path, name),
+				Linked:   c.linked,
+			})
+		}
+	}
+
+	sort.Slice(chunks, func(i, k int) bool { return chunks[i].Name < chunks[k].Name })
+
+	return chunks, nil
+}
+
+func (m *Memory) Done(name string, uploader string) error {
+	m.Lock()
+	defer m.Unlock()
+
+	c := m.chunks[name]
+	if c == nil {
+		return os.ErrNotExist
+	}
+	if _, ok := c.uploaders[uploader]; ok {
+		c.uploaders[uploader] = true
+	}
+	return nil
+}
+
+func (m *Memory) Status(name string, uploader string) Status {
+	m.Lock()
+	defer m.Unlock()
+
+	c := m.chunks[name]
+	if c == nil {
+		return NotLinked
+	}
+	uploaded, ok := c.uploaders[uploader]
+	switch {
+	case !ok:
+		return NotLinked
+	case uploaded:
+		return Uploaded
+	}
+	return Pending
+}
+
+func (m *Memory) Remove(name string) error {
+	m.Lock()
+	delete(m.chunks, name)
+	m.Unlock()
+	return nil
+}
+
+// Cleanup removes status of deleted chunks
+func (m *Memory) Cleanup() error {
+	m.Lock()
+	defer m.Unlock()
+
+	for name := range m.chunks {
+		if !exists(chunkFilename(m.path, name)) {
+			delete(m.chunks, name)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Sync(uploaders []string) error {
+	return nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
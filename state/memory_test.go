@@ -0,0 +1,43 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemory(t *testing.T) {
+	dir := t.TempDir()
+	m := NewMemory(dir)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "default.1"), nil, 0644))
+
+	require.NoError(t, m.Link("default.1", []string{"points", "index"}))
+	require.NoError(t, m.Done("default.1", "points"))
+	assert.Equal(t, Uploaded, m.Status("default.1", "points"))
+	assert.Equal(t, Pending, m.Status("default.1", "index"))
+	assert.Equal(t, NotLinked, m.Status("default.2", "points"))
+
+	pending, err := m.Pending("index")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"default.1"}, chunkNames(pending))
+
+	// link keeps status of uploaded chunk
+	require.NoError(t, m.Link("default.1", []string{"points"}))
+	assert.Equal(t, Uploaded, m.Status("default.1", "points"))
+
+	// nothing is written to directory
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	require.NoError(t, m.Remove("default.1"))
+	assert.Equal(t, NotLinked, m.Status("default.1", "points"))
+
+	assert.Error(t, m.Done("default.3", "points"))
+	require.NoError(t, m.Link("default.3", []string{"points"}))
+	require.NoError(t, m.Cleanup())
+	assert.Equal(t, NotLinked, m.Status("default.3", "points"))
+}
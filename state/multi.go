@@ -0,0 +1,186 @@
+package state
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/lomik/zapwriter"
+	"go.uber.org/zap"
+)
+
+// Multi is state of several data directories. Every directory keeps own state (own uploaders subfolders
+// or journal), chunk is handled by state of directory containing it. Chunk names are unique across directories
+type Multi struct {
+	sync.Mutex
+	paths  []string
+	states []State
+	linked map[int]bool // states changed by Link since last Sync
+	logger *zap.Logger
+}
+
+// NewMulti opens state in every data directory. Unavailable directory is logged and skipped,
+// error is returned only if no directory is available
+func NewMulti(backend string, paths []string, uploaders []string) (*Multi, error) {
+	m := &Multi{
+		linked: make(map[int]bool),
+		logger: zapwriter.Logger("state"),
+	}
+
+	var lastErr error
+	for _, p := range paths {
+		s, err := m.open(backend, p, uploaders)
+		if err != nil {
+			m.logger.Error("data directory unavailable, skipped", zap.String("path", p), zap.Error(err))
+			lastErr = err
+			continue
+		}
+		m.paths = append(m.paths, p)
+		m.states = append(m.states, s)
+	}
+
+	if len(m.states) == 0 {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("data directories list is empty")
+		}
+		return nil, lastErr
+	}
+
+	return m, nil
+}
+
+func (m *Multi) open(backend string, path string, uploaders []string) (State, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	return New(backend, path, uploaders)
+}
+
+// Paths returns available data directories
+func (m *Multi) Paths() []string {
+	return m.paths
+}
+
+// stateOf returns index of directory containing chunk file
+func (m *Multi) stateOf(name string) int {
+	for i, p := range m.paths {
+		if exists(chunkFilename(p, name)) {
+			return i
+		}
+	}
+	return -1
+}
+
+// linkedState returns state with any status of chunk for uploader
+func (m *Multi) linkedState(name string, uploader string) State {
+	for _, s := range m.states {
+		if s.Status(name, uploader) != NotLinked {
+			return s
+		}
+	}
+	return nil
+}
+
+func (m *Multi) Link(name string, uploaders []string) error {
+	i := m.stateOf(name)
+	if i < 0 {
+		return fmt.Errorf("chunk %#v not found in data directories", name)
+	}
+
+	m.Lock()
+	m.linked[i] = true
+	m.Unlock()
+
+	return m.states[i].Link(name, uploaders)
+}
+
+// Pending returns chunks of all directories. Directory with read error is skipped
+func (m *Multi) Pending(uploader string) ([]Chunk, error) {
+	chunks := make([]Chunk, 0)
+	var lastErr error
+	var failed int
+	for i, s := range m.states {
+		c, err := s.Pending(uploader)
+		if err != nil {
+			m.logger.Error("read pending chunks failed", zap.String("path", m.paths[i]), zap.Error(err))
+			lastErr = err
+			failed++
+			continue
+		}
+		chunks = append(chunks, c...)
+	}
+
+	if failed == len(m.states) {
+		return nil, lastErr
+	}
+
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Name < chunks[j].Name })
+
+	return chunks, nil
+}
+
+func (m *Multi) Done(name string, uploader string) error {
+	s := m.linkedState(name, uploader)
+	if s == nil {
+		return os.ErrNotExist
+	}
+	return s.Done(name, uploader)
+}
+
+func (m *Multi) Status(name string, uploader string) Status {
+	if s := m.linkedState(name, uploader); s != nil {
+		return s.Status(name, uploader)
+	}
+	return NotLinked
+}
+
+func (m *Multi) Remove(name string) error {
+	var err error
+	for _, s := range m.states {
+		if rerr := s.Remove(name); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// Cleanup cleans every directory, error of one directory doesn't stop cleanup of others
+func (m *Multi) Cleanup() error {
+	var err error
+	for i, s := range m.states {
+		if cerr := s.Cleanup(); cerr != nil {
+			m.logger.Error("cleanup failed", zap.String("path", m.paths[i]), zap.Error(cerr))
+			if err == nil {
+				err = cerr
+			}
+		}
+	}
+	return err
+}
+
+// Sync flushes directories with chunks linked since last Sync
+func (m *Multi) Sync(uploaders []string) error {
+	m.Lock()
+	linked := m.linked
+	m.linked = make(map[int]bool)
+	m.Unlock()
+
+	var err error
+	for i := range linked {
+		if serr := m.states[i].Sync(uploaders); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+func (m *Multi) Close() error {
+	var err error
+	for _, s := range m.states {
+		if cerr := s.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,68 @@
+package state
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMulti(t *testing.T) {
+	for _, backend := range []string{BackendSymlink, BackendJournal} {
+		t.Run(backend, func(t *testing.T) {
+			root := t.TempDir()
+			dirs := []string{filepath.Join(root, "data1"), filepath.Join(root, "data2")}
+			uploaders := []string{"points"}
+
+			// unavailable directory is skipped
+			broken := filepath.Join(root, "broken")
+			require.NoError(t, os.WriteFile(broken, nil, 0644))
+
+			m, err := NewMulti(backend, append(dirs, broken), uploaders)
+			require.NoError(t, err)
+			defer m.Close()
+			assert.Equal(t, dirs, m.Paths())
+
+			require.NoError(t, os.WriteFile(filepath.Join(dirs[0], "default.1"), nil, 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(dirs[1], "default.2"), nil, 0644))
+			require.NoError(t, os.WriteFile(filepath.Join(dirs[0], "default.3"), nil, 0644))
+
+			for _, name := range []string{"default.1", "default.2", "default.3"} {
+				require.NoError(t, m.Link(name, uploaders))
+			}
+			assert.Error(t, m.Link("default.4", uploaders))
+			require.NoError(t, m.Sync(uploaders))
+
+			pending, err := m.Pending("points")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"default.1", "default.2", "default.3"}, chunkNames(pending))
+
+			require.NoError(t, m.Done("default.2", "points"))
+			assert.Equal(t, Uploaded, m.Status("default.2", "points"))
+			assert.Equal(t, Pending, m.Status("default.1", "points"))
+			assert.Equal(t, NotLinked, m.Status("default.4", "points"))
+
+			pending, err = m.Pending("points")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"default.1", "default.3"}, chunkNames(pending))
+
+			// state of every directory is kept in directory
+			s, err := New(backend, dirs[1], uploaders)
+			require.NoError(t, err)
+			assert.Equal(t, Uploaded, s.Status("default.2", "points"))
+			assert.Equal(t, NotLinked, s.Status("default.1", "points"))
+			s.Close()
+
+			require.NoError(t, os.Remove(filepath.Join(dirs[0], "default.1")))
+			require.NoError(t, m.Cleanup())
+			assert.Equal(t, NotLinked, m.Status("default.1", "points"))
+
+			require.NoError(t, m.Remove("default.3"))
+			pending, err = m.Pending("points")
+			require.NoError(t, err)
+			assert.Empty(t, pending)
+		})
+	}
+}
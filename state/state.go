@@ -0,0 +1,98 @@
+// Package state tracks upload status of finished chunks for every uploader
+package state
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// BackendSymlink is symlink of chunk in directory of every uploader, renamed with "_" prefix after upload
+	BackendSymlink = "symlink"
+	// BackendJournal is append-only journal in data directory
+	BackendJournal = "journal"
+)
+
+// Status of chunk for uploader
+type Status int
+
+const (
+	NotLinked Status = iota
+	Pending
+	Uploaded
+)
+
+// Chunk is not uploaded chunk
+type Chunk struct {
+	Name string
+	// Filename is path for read
+	Filename string
+	// Linked is time of chunk finish
+	Linked time.Time
+}
+
+// State is storage of upload status. Chunks are identified by base name of file in data directory
+type State interface {
+	// Link adds finished chunk to queues of uploaders. Uploaders with any status of chunk are skipped
+	Link(name string, uploaders []string) error
+	// Pending returns chunks not uploaded by uploader, sorted by name
+	Pending(uploader string) ([]Chunk, error)
+	// Done marks chunk as uploaded by uploader
+	Done(name string, uploader string) error
+	Status(name string, uploader string) Status
+	// Remove forgets chunk for all uploaders
+	Remove(name string) error
+	// Cleanup removes status of deleted chunks
+	Cleanup() error
+	// Sync flushes status of uploaders to disk
+	Sync(uploaders []string) error
+	Close() error
+}
+
+// New opens state in data directory. State of other backend is migrated
+func New(backend string, path string, uploaders []string) (State, error) {
+	switch backend {
+	case BackendSymlink, "":
+		s := NewSymlink(path, uploaders)
+		if err := s.importJournal(); err != nil {
+			return nil, err
+		}
+		return s, nil
+	case BackendJournal:
+		return NewJournal(path, uploaders)
+	}
+	return nil, fmt.Errorf("unknown state backend %#v", backend)
+}
+
+// Finished checks chunk is uploaded by all uploaders
+func Finished(s State, name string, uploaders []string) bool {
+	for _, u := range uploaders {
+		if s.Status(name, u) != Uploaded {
+			return false
+		}
+	}
+	return true
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func exists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+func chunkFilename(path, name string) string {
+	return filepath.Join(path, name)
+}
@@ -0,0 +1,213 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lomik/zapwriter"
+	"go.uber.org/zap"
+)
+
+// Symlink keeps status in directory of every uploader: symlink to chunk is pending chunk,
+// symlink with "_" prefix is uploaded chunk
+type Symlink struct {
+	path      string
+	uploaders []string
+	logger    *zap.Logger
+}
+
+// NewSymlink creates state in data directory
+func NewSymlink(path string, uploaders []string) *Symlink {
+	return &Symlink{
+		path:      path,
+		uploaders: uploaders,
+		logger:    zapwriter.Logger("state"),
+	}
+}
+
+// Link writes symlink to file to all table subfolders
+func Link(filename string, tables []string) error {
+	d, fn := filepath.Split(filename)
+
+	var err error
+
+	for _, t := range tables {
+		if _, err = os.Stat(filepath.Join(d, t)); os.IsNotExist(err) {
+			err = os.Mkdir(filepath.Join(d, t), 0755)
+			if err != nil {
+				return err
+			}
+		}
+
+		if _, err := os.Stat(filepath.Join(d, t, fn)); !os.IsNotExist(err) {
+			// symlink or file already exists
+			continue
+		}
+
+		if _, err := os.Stat(filepath.Join(d, t, "_"+fn)); !os.IsNotExist(err) {
+			// finished symlink or file already exists
+			continue
+		}
+
+		err = os.Symlink(filepath.Join("..", fn), filepath.Join(d, t, fn))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Symlink) Link(name string, uploaders []string) error {
+	return Link(chunkFilename(s.path, name), uploaders)
+}
+
+func (s *Symlink) Pending(uploader string) ([]Chunk, error) {
+	dir := filepath.Join(s.path, uploader)
+	flist, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make([]Chunk, 0, len(flist))
+	for _, f := range flist {
+		if f.IsDir() {
+			continue
+		}
+		if !strings.HasPrefix(f.Name(), "default.") {
+			continue
+		}
+		// ReadDir uses Lstat, ModTime is time of link creation
+		chunks = append(chunks, Chunk{
+			Name:     f.Name(),
+			Filename: filepath.Join(dir, f.Name()),
+			Linked:   f.ModTime(),
+		})
+	}
+
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].Name < chunks[j].Name })
+
+	return chunks, nil
+}
+
+func (s *Symlink) Done(name string, uploader string) error {
+	dir := filepath.Join(s.path, uploader)
+	return os.Rename(filepath.Join(dir, name), filepath.Join(dir, "_"+name))
+}
+
+func (s *Symlink) Status(name string, uploader string) Status {
+	if _, err := os.Lstat(filepath.Join(s.path, uploader, "_"+name)); err == nil {
+		return Uploaded
+	}
+	if _, err := os.Lstat(filepath.Join(s.path, uploader, name)); err == nil {
+		return Pending
+	}
+	return NotLinked
+}
+
+func (s *Symlink) Remove(name string) error {
+	var err error
+	for _, t := range s.uploaders {
+		for _, fn := range []string{name, "_" + name} {
+			if rerr := os.Remove(filepath.Join(s.path, t, fn)); rerr != nil && !os.IsNotExist(rerr) && err == nil {
+				err = rerr
+			}
+		}
+	}
+	return err
+}
+
+// Cleanup creates uploaders directories and removes broken links
+func (s *Symlink) Cleanup() error {
+	for _, t := range s.uploaders {
+		if _, err := os.Stat(filepath.Join(s.path, t)); os.IsNotExist(err) {
+			err = os.Mkdir(filepath.Join(s.path, t), 0755)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, t := range s.uploaders {
+		flist, err := ioutil.ReadDir(filepath.Join(s.path, t))
+		if err != nil {
+			s.logger.Error("ReadDir failed", zap.Error(err))
+			return err
+		}
+
+		for _, f := range flist {
+			full := filepath.Join(s.path, t, f.Name())
+			_, err := filepath.EvalSymlinks(full)
+			if err != nil {
+				s.logger.Info("remove broken link", zap.String("filename", full))
+				if err := os.Remove(full); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Sync flushes directory entries of uploaders
+func (s *Symlink) Sync(uploaders []string) error {
+	for _, t := range uploaders {
+		if err := syncDir(filepath.Join(s.path, t)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Symlink) Close() error {
+	return nil
+}
+
+// importJournal converts journal to symlinks, journal is removed after
+func (s *Symlink) importJournal() error {
+	filename := journalFilename(s.path)
+	if !exists(filename) {
+		return nil
+	}
+
+	chunks, _, _, err := readJournal(filename)
+	if err != nil {
+		return err
+	}
+
+	for name, c := range chunks {
+		if !exists(chunkFilename(s.path, name)) {
+			continue
+		}
+		for u, uploaded := range c.uploaders {
+			if err := Link(chunkFilename(s.path, name), []string{u}); err != nil {
+				return err
+			}
+			if uploaded && s.Status(name, u) == Pending {
+				if err := s.Done(name, u); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	dirs := make(map[string]bool)
+	for _, c := range chunks {
+		for u := range c.uploaders {
+			dirs[u] = true
+		}
+	}
+	for u := range dirs {
+		if err := syncDir(filepath.Join(s.path, u)); err != nil {
+			return err
+		}
+	}
+
+	s.logger.Info("journal migrated to symlinks", zap.String("filename", filename), zap.Int("chunks", len(chunks)))
+
+	return os.Remove(filename)
+}
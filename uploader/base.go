@@ -5,13 +5,10 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"sort"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -19,6 +16,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/lomik/carbon-clickhouse/helper/stop"
+	"github.com/lomik/carbon-clickhouse/state"
 )
 
 type Base struct {
@@ -26,20 +24,34 @@ type Base struct {
 	sync.Mutex
 	name    string
 	path    string
+	state   state.State
 	config  *Config
 	queue   chan string
-	inQueue map[string]bool
+	inQueue map[string]bool // by chunk name
+	pending map[string]pendingChunk
+	wake    chan struct{}
 	logger  *zap.Logger
 	handler func(ctx context.Context, logger *zap.Logger, filename string) (uint64, error) // upload single file
 	query   string
 
+	// client-side sharding, see startInsert
+	layout *rowLayout
+
+	// fast path, see OpenStream
+	streamHandler func(ctx context.Context, logger *zap.Logger, filename string, r io.Reader, settings url.Values) (uint64, error)
+	inFast        map[string]bool   // chunks uploaded by fast path now
+	fallbacks     map[string]string // chunks of failed fast path (name to filename), may be not linked yet
+	fastFailed    uint32            // atomic, fast path is disabled until disk backlog is uploaded
+	running       bool              // guarded by stop.Struct lock
+
 	stat struct {
 		uploaded        uint32
 		uploadedMetrics uint64
 		uploadTime      uint64
 		errors          uint32
-		delay           int64
 		unhandled       uint32 // @TODO: maxUnhandled
+		fastUploaded    uint32
+		fastFallbacks   uint32
 	}
 }
 
@@ -56,68 +68,123 @@ func (u *Base) Stat(send func(metric string, value float64)) {
 	errors := atomic.SwapUint32(&u.stat.errors, 0)
 	send("errors", float64(errors))
 
-	delay := atomic.LoadInt64(&u.stat.delay)
-	send("delay", float64(delay))
+	send("delay", float64(u.delay()))
 
 	send("unhandled", float64(atomic.LoadUint32(&u.stat.unhandled)))
+
+	if len(u.config.Shards) > 0 {
+		for i, sh := range u.config.Shards {
+			sh.endpoints.stat(fmt.Sprintf("shard.%d.", i+1), send)
+		}
+	} else if len(u.config.endpoints.list) > 1 {
+		u.config.endpoints.stat("", send)
+	}
+
+	if u.config.FastPath {
+		send("fast_uploaded", float64(atomic.SwapUint32(&u.stat.fastUploaded, 0)))
+		send("fast_fallbacks", float64(atomic.SwapUint32(&u.stat.fastFallbacks, 0)))
+	}
 }
 
+// pendingChunk is chunk in queue of uploader
+type pendingChunk struct {
+	name     string
+	filename string
+	linked   time.Time
+}
+
+// ChunkLinked adds chunk linked by writer to pending chunks (see writer.Notifier)
+func (u *Base) ChunkLinked(name string, filename string) {
+	u.Lock()
+	u.pending[name] = pendingChunk{name: name, filename: filename, linked: time.Now()}
+	u.Unlock()
+	u.wakeUp()
+}
+
+func (u *Base) wakeUp() {
+	select {
+	case u.wake <- struct{}{}:
+	default:
+	}
+}
+
+// scanDir reads pending chunks from state. Chunks are added by writer notifications, scan is safety net
+// for lost notifications and chunks linked before start
 func (u *Base) scanDir(ctx context.Context) {
-	var delay int64
-	now := time.Now().Unix()
-	flist, err := ioutil.ReadDir(u.path)
+	start := time.Now()
+	chunks, err := u.state.Pending(u.name)
 	if err != nil {
-		u.logger.Error("ReadDir failed", zap.Error(err))
+		u.logger.Error("read pending chunks failed", zap.Error(err))
 		return
 	}
 
-	files := make([]string, 0)
-	for _, f := range flist {
-		if f.IsDir() {
-			continue
+	pending := make(map[string]pendingChunk, len(chunks))
+	for _, c := range chunks {
+		pending[c.Name] = pendingChunk{name: c.Name, filename: c.Filename, linked: c.Linked}
+	}
+
+	u.Lock()
+	for name, c := range u.pending {
+		if _, ok := pending[name]; !ok && !c.linked.Before(start) {
+			// notified while state was read
+			pending[name] = c
 		}
-		if !strings.HasPrefix(f.Name(), "default.") {
-			continue
+	}
+	u.pending = pending
+	for name, filename := range u.fallbacks {
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			// removed without link
+			delete(u.fallbacks, name)
 		}
+	}
+	u.Unlock()
 
-		fileName := filepath.Join(u.path, f.Name())
-		stat, err := os.Lstat(fileName)
-		if err != nil {
-			u.logger.Error("Lstat failed", zap.Error(err))
-			return
+	u.enqueue(ctx)
+}
+
+// enqueue passes pending chunks to upload workers
+func (u *Base) enqueue(ctx context.Context) {
+	u.Lock()
+	chunks := make([]pendingChunk, 0, len(u.pending))
+	backlog := 0
+	for name, c := range u.pending {
+		if u.inFast[name] {
+			// uploaded by fast path now, returned to disk path on failure
+			continue
 		}
-		d := now - stat.ModTime().Unix()
-		if delay < d {
-			delay = d
+		backlog++
+		if !u.inQueue[name] {
+			chunks = append(chunks, c)
 		}
-		files = append(files, fileName)
 	}
-
-	if delay >= 0 {
-		atomic.StoreInt64(&u.stat.delay, delay)
+	if backlog == 0 && len(u.inQueue) == 0 && len(u.fallbacks) == 0 {
+		atomic.StoreUint32(&u.fastFailed, 0)
 	}
-	n := uint32(len(files))
+	u.Unlock()
+
+	// not taken by upload workers yet
+	n := uint32(len(chunks))
 	atomic.StoreUint32(&u.stat.unhandled, n)
 
-	if len(files) == 0 {
+	if len(chunks) == 0 {
 		return
 	}
 
-	// TODO (msaf1980): maybe load newest files first ?
-	sort.Strings(files)
+	// oldest first
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].name < chunks[j].name })
 
-	for _, fn := range files {
+	for _, c := range chunks {
 		u.Lock()
-		if u.inQueue[fn] {
+		if u.inQueue[c.name] {
 			u.Unlock()
 			continue
 		} else {
-			u.inQueue[fn] = true
+			u.inQueue[c.name] = true
 		}
 		u.Unlock()
 
 		select {
-		case u.queue <- fn:
+		case u.queue <- c.filename:
 			n--
 			atomic.StoreUint32(&u.stat.unhandled, n)
 			// pass
@@ -127,14 +194,34 @@ func (u *Base) scanDir(ctx context.Context) {
 	}
 }
 
+// delay returns age of oldest pending chunk in seconds
+func (u *Base) delay() int64 {
+	var delay int64
+	now := time.Now().Unix()
+
+	u.Lock()
+	for _, c := range u.pending {
+		if d := now - c.linked.Unix(); delay < d {
+			delay = d
+		}
+	}
+	u.Unlock()
+
+	return delay
+}
+
 func (u *Base) watchWorker(ctx context.Context) {
-	ticker := time.NewTicker(time.Second)
+	ticker := time.NewTicker(u.config.ScanInterval.Value())
 	defer ticker.Stop()
 
+	u.scanDir(ctx)
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-u.wake:
+			u.enqueue(ctx)
 		case <-ticker.C:
 			u.scanDir(ctx)
 		}
@@ -142,24 +229,35 @@ func (u *Base) watchWorker(ctx context.Context) {
 }
 
 func (u *Base) MarkAsFinished(filename string) {
-	dn, fn := filepath.Split(filename)
-	err := os.Rename(filename, filepath.Join(dn, "_"+fn))
+	name := filepath.Base(filename)
+	err := u.state.Done(name, u.name)
 	if err != nil {
-		u.logger.Error("rename file failed",
+		u.logger.Error("mark as finished failed",
 			zap.String("filename", filename),
 			zap.Error(err),
 		)
+		return
 	}
+
+	u.Lock()
+	delete(u.pending, name)
+	delete(u.fallbacks, name)
+	u.Unlock()
 }
 
 func (u *Base) RemoveFromQueue(filename string) {
 	u.Lock()
-	delete(u.inQueue, filename)
+	delete(u.inQueue, filepath.Base(filename))
 	u.Unlock()
+	// failed chunk is queued again, fast path is enabled after upload of backlog
+	u.wakeUp()
 }
 
 func (u *Base) Start() error {
 	return u.StartFunc(func() error {
+		u.running = true
+		// fast path waits for first scan of backlog
+		atomic.StoreUint32(&u.fastFailed, 1)
 		u.Go(u.watchWorker)
 
 		for i := 0; i < u.config.Threads; i++ {
@@ -172,12 +270,28 @@ func (u *Base) Start() error {
 	})
 }
 
+// Stop ...
+func (u *Base) Stop() {
+	u.StopFunc(func() {
+		u.running = false
+	})
+}
+
 func (u *Base) uploadWorker(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case filename := <-u.queue:
+			if u.state.Status(filepath.Base(filename), u.name) == state.Uploaded {
+				// uploaded (by other worker or fast path) after read of pending chunks
+				u.Lock()
+				delete(u.pending, filepath.Base(filename))
+				u.Unlock()
+				u.RemoveFromQueue(filename)
+				continue
+			}
+
 			startTime := time.Now()
 			logger := u.logger.With(zap.String("filename", filename))
 			logger.Info("start handle")
@@ -213,7 +327,7 @@ func (u *Base) uploadWorker(ctx context.Context) {
 	}
 }
 
-func compress(data *io.PipeReader) io.Reader {
+func compress(data *pipeData) io.Reader {
 	pr, pw := io.Pipe()
 	gw := gzip.NewWriter(pw)
 
@@ -239,45 +353,8 @@ func compress(data *io.PipeReader) io.Reader {
 	return pr
 }
 
-func (u *Base) insertRowBinary(table string, data *io.PipeReader) error {
-	p, err := url.Parse(u.config.URL)
-	if err != nil {
-		return err
-	}
-
-	q := p.Query()
-
-	q.Set("query", fmt.Sprintf("INSERT INTO %s FORMAT RowBinary", table))
-	p.RawQuery = q.Encode()
-	queryURL := p.String()
-
-	var req *http.Request
-
-	if u.config.CompressData {
-		req, err = http.NewRequest("POST", queryURL, compress(data))
-		req.Header.Add("Content-Encoding", "gzip")
-	} else {
-		req, err = http.NewRequest("POST", queryURL, data)
-	}
-
-	if err != nil {
-		return err
-	}
-	resp, err := u.config.client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	body, _ := ioutil.ReadAll(resp.Body)
-
-	if exceptionCode := resp.Header.Get("X-Clickhouse-Exception-Code"); exceptionCode != "" && exceptionCode != "0" {
-		return fmt.Errorf("clickhouse exception code %s, response status %d: %s", exceptionCode, resp.StatusCode, string(body))
-	}
-
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("clickhouse response status %d: %s", resp.StatusCode, string(body))
-	}
-
-	return nil
+// insertRowBinary sends INSERT with data to one of endpoints. Settings are added to query parameters (HTTP)
+// or query settings (native protocol)
+func (u *Base) insertRowBinary(table string, data *io.PipeReader, settings url.Values) error {
+	return u.config.endpoints.insert(table, data, settings)
 }
@@ -1,15 +1,17 @@
 package uploader
 
 import (
-	"bufio"
 	"context"
 	"fmt"
 	"io"
+	"net/url"
 	"runtime/debug"
 	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
 )
 
 type DebugCacheDumper interface {
@@ -19,13 +21,15 @@ type DebugCacheDumper interface {
 type cached struct {
 	*Base
 	existsCache CMap // store known keys and don't load it to clickhouse tree
-	parser      func(filename string, out io.Writer) (uint64, map[string]bool, error)
+	parser      func(filename string, reader *RowBinary.Reader, out io.Writer) (uint64, map[string]bool, error)
+	reverse     bool   // read names reversed
 	expired     uint32 // atomic counter
 }
 
 func newCached(base *Base) *cached {
 	u := &cached{Base: base}
 	u.Base.handler = u.upload
+	u.Base.streamHandler = u.uploadStream
 	u.existsCache = NewCMap()
 	u.query = fmt.Sprintf("%s (Date, Level, Path, Version)", u.config.TableName)
 	return u
@@ -60,42 +64,47 @@ func (u *cached) Reset() {
 	debug.FreeOSMemory()
 }
 
+func (u *cached) parseFile(filename string, out io.Writer) (uint64, map[string]bool, error) {
+	reader, err := RowBinary.NewReader(filename, u.reverse)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer reader.Close()
+
+	return u.parser(filename, reader, out)
+}
+
 func (u *cached) upload(ctx context.Context, logger *zap.Logger, filename string) (uint64, error) {
+	reader, err := RowBinary.NewReader(filename, u.reverse)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	return u.uploadReader(ctx, filename, reader, nil)
+}
+
+// uploadStream uploads records of chunk in progress, see FastStream
+func (u *cached) uploadStream(ctx context.Context, logger *zap.Logger, filename string, r io.Reader, settings url.Values) (uint64, error) {
+	return u.uploadReader(ctx, filename, RowBinary.NewStreamReader(r, u.reverse), settings)
+}
+
+func (u *cached) uploadReader(ctx context.Context, filename string, reader *RowBinary.Reader, settings url.Values) (uint64, error) {
 	var n uint64
 	var err error
 	var newSeries map[string]bool
 
-	pipeReader, pipeWriter := io.Pipe()
-	writer := bufio.NewWriter(pipeWriter)
 	startTime := time.Now()
 
-	uploadResult := make(chan error, 1)
-
-	u.Go(func(ctx context.Context) {
-		err = u.insertRowBinary(
-			u.query,
-			pipeReader,
-		)
-		uploadResult <- err
-		if err != nil {
-			pipeReader.CloseWithError(err)
-		}
-	})
+	ins := u.startInsert(filename, settings)
 
-	n, newSeries, err = u.parser(filename, writer)
+	n, newSeries, err = u.parser(filename, reader, ins)
 	if err == nil {
-		err = writer.Flush()
+		// incomplete stream or chunk without encryption key must not be inserted
+		err = reader.Err()
 	}
-	pipeWriter.CloseWithError(err)
 
-	var uploadErr error
-
-	select {
-	case uploadErr = <-uploadResult:
-		// pass
-	case <-ctx.Done():
-		return n, fmt.Errorf("upload aborted")
-	}
+	uploadErr := ins.finish(ctx, err)
 
 	if err != nil {
 		return n, err
@@ -1,6 +1,7 @@
 package uploader
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -23,14 +24,24 @@ type Config struct {
 	TLS                  *config.TLS         `toml:"tls"`            // for secure connection to uploader
 	Threads              int                 `toml:"threads"`
 	URL                  string              `toml:"url"`
+	URLs                 []string            `toml:"urls"`         // several endpoints, one of them is used for every insert
+	Balance              string              `toml:"balance"`      // selection of endpoint: first-healthy, round-robin, random, least-errors
+	MaxFails             int                 `toml:"max-fails"`    // consecutive errors before ejection of endpoint
+	FailTimeout          *config.Duration    `toml:"fail-timeout"` // time of ejection of endpoint
+	Shards               []*Shard            `toml:"shards"`       // client-side sharding, url and urls are not used
+	ShardingKey          string              `toml:"sharding-key"` // path, tag, cityHash64(Path)
 	CacheTTL             *config.Duration    `toml:"cache-ttl"`
 	IgnoredPatterns      []string            `toml:"ignored-patterns,omitempty"` // points, points-reverse
 	CompressData         bool                `toml:"compress-data"`              // compress data while sending to clickhouse
 	IgnoredTaggedMetrics []string            `toml:"ignored-tagged-metrics"`     // for tagged table; create only `__name__` tag for these metrics and ignore others
 	Hash                 string              `toml:"hash"`                       // in index uploader store hash in memory instead of full metric
 	DisableDailyIndex    bool                `toml:"disable-daily-index"`        // do not calculate and upload daily index to ClickHouse
+	FastPath             bool                `toml:"fast-path"`                  // stream data of chunk to ClickHouse while chunk is written
+	FastPathMaxSize      config.Size         `toml:"fast-path-max-size"`         // chunk size limit of fast path, larger chunk is uploaded from disk
+	ScanInterval         *config.Duration    `toml:"scan-interval"`              // scan of pending chunks, new chunks are notified by writer
 	hashFunc             func(string) string `toml:"-"`
 	client               *http.Client        `toml:"-"`
+	endpoints            *endpoints          `toml:"-"`
 }
 
 func (cfg *Config) Parse() error {
@@ -47,30 +58,88 @@ func (cfg *Config) Parse() error {
 		cfg.Timeout = &config.Duration{Duration: time.Minute}
 	}
 
+	if cfg.ScanInterval == nil || cfg.ScanInterval.Value() <= 0 {
+		cfg.ScanInterval = &config.Duration{Duration: time.Minute}
+	}
+
+	if cfg.FastPathMaxSize == 0 {
+		cfg.FastPathMaxSize = 64 * 1024 * 1024
+	}
+
 	var known bool
 	cfg.hashFunc, known = knownHash[cfg.Hash]
 	if !known {
 		return fmt.Errorf("unknown hash function %#v", cfg.Hash)
 	}
 
+	var urls []string
+	if len(cfg.Shards) > 0 {
+		if cfg.URL != "" || len(cfg.URLs) > 0 {
+			return fmt.Errorf("url and urls can't be used with shards")
+		}
+		if cfg.ShardingKey == "" {
+			cfg.ShardingKey = ShardingKeyPath
+		}
+		if _, known := shardingKeys[cfg.ShardingKey]; !known {
+			return fmt.Errorf("unknown sharding-key %#v", cfg.ShardingKey)
+		}
+		for i, sh := range cfg.Shards {
+			if sh.Weight < 0 {
+				return fmt.Errorf("weight of shard %d must be non-negative", i+1)
+			}
+			if sh.Weight == 0 {
+				sh.Weight = 1
+			}
+			if len(sh.URLs) == 0 {
+				return fmt.Errorf("urls of shard %d are not specified", i+1)
+			}
+			urls = append(urls, sh.URLs...)
+		}
+	} else {
+		urls = cfg.URLs
+		if cfg.URL != "" || len(urls) == 0 {
+			urls = append([]string{cfg.URL}, urls...)
+		}
+	}
+
+	switch cfg.Balance {
+	case "":
+		cfg.Balance = BalanceFirstHealthy
+	case BalanceFirstHealthy, BalanceRoundRobin, BalanceRandom, BalanceLeastErrors:
+	default:
+		return fmt.Errorf("unknown balance %#v", cfg.Balance)
+	}
+	if cfg.MaxFails < 0 {
+		return fmt.Errorf("max-fails must be non-negative")
+	}
+	if cfg.MaxFails == 0 {
+		cfg.MaxFails = 1
+	}
+	if cfg.FailTimeout == nil {
+		cfg.FailTimeout = &config.Duration{Duration: 10 * time.Second}
+	}
+
 	cfg.client = &http.Client{
 		Timeout: cfg.Timeout.Value(),
 	}
+	var tlsConfig *tls.Config
 	if cfg.TLS != nil {
-		tlsConfig, warns, err := config.ParseClientTLSConfig(cfg.TLS)
+		var warns []string
+		tlsConfig, warns, err = config.ParseClientTLSConfig(cfg.TLS)
 		if err != nil {
 			return err
 		}
-		p, err := url.Parse(cfg.URL)
-		if err != nil {
-			return err
+		cfg.client.Transport = &http.Transport{
+			TLSClientConfig: tlsConfig,
 		}
-		if p.Scheme == "https" {
-			cfg.client.Transport = &http.Transport{
-				TLSClientConfig: tlsConfig,
+		for _, u := range urls {
+			p, err := url.Parse(u)
+			if err != nil {
+				return err
+			}
+			if p.Scheme != "https" && p.Scheme != "clickhouse" {
+				warns = append(warns, fmt.Sprintf("TLS configurations is ignored for %s because scheme is not HTTPS", u))
 			}
-		} else {
-			warns = append(warns, "TLS configurations is ignored because scheme is not HTTPS")
 		}
 		if len(warns) > 0 {
 			logger := zapwriter.Logger("config")
@@ -80,5 +149,20 @@ func (cfg *Config) Parse() error {
 		}
 	}
 
+	if len(cfg.Shards) > 0 {
+		for _, sh := range cfg.Shards {
+			sh.endpoints, err = newEndpoints(sh.URLs, cfg, tlsConfig)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	cfg.endpoints, err = newEndpoints(urls, cfg, tlsConfig)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
@@ -0,0 +1,80 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/config"
+)
+
+func loadTestKey(t *testing.T, id string, material byte) *RowBinary.EncryptionKey {
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(keyFile, []byte(id+" "+hex.EncodeToString(bytes.Repeat([]byte{material}, 32))+"\n"), 0600))
+	key, err := RowBinary.LoadEncryptionKeys(keyFile)
+	require.NoError(t, err)
+	return key
+}
+
+func TestUploadWithoutEncryptionKey(t *testing.T) {
+	srv := &insertServer{}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "default.1")
+	f, err := os.Create(filename)
+	require.NoError(t, err)
+	w, err := RowBinary.NewEncryptedChunkWriter(f, config.CompAlgoNone, 0, nil, loadTestKey(t, "233", 1))
+	require.NoError(t, err)
+	wb := RowBinary.GetWriteBuffer()
+	wb.WriteGraphitePoint([]byte("hello.world"), 42, 1559465760, 1559465761)
+	wb.WriteGraphitePoint([]byte("cpu?host=a"), 43, 1559465760, 1559465761)
+	_, err = w.Write(wb.Bytes())
+	require.NoError(t, err)
+	wb.Release()
+	require.NoError(t, w.Close())
+	require.NoError(t, f.Close())
+
+	// same id, other key material
+	loadTestKey(t, "233", 2)
+
+	for _, typ := range []string{"points", "points-reverse", "tagged", "index", "tree", "series", "series-reverse"} {
+		cfg := &Config{Type: typ, TableName: "graphite", URL: ts.URL, Timeout: &config.Duration{Duration: time.Minute}}
+		require.NoError(t, cfg.Parse())
+		up, err := New(filepath.Join(dir, typ), typ, cfg, nil)
+		require.NoError(t, err)
+		require.NoError(t, up.Start())
+
+		var base *Base
+		switch u := up.(type) {
+		case *Points:
+			base = u.Base
+		case *Tagged:
+			base = u.Base
+		case *Index:
+			base = u.Base
+		case *Tree:
+			base = u.Base
+		case *Series:
+			base = u.Base
+		}
+
+		// chunk is kept until key is loaded
+		_, err = base.handler(context.Background(), zap.NewNop(), filename)
+		up.Stop()
+		assert.True(t, errors.Is(err, RowBinary.ErrEncryptionKey), typ)
+	}
+	assert.Equal(t, 0, srv.inserts())
+}
@@ -0,0 +1,282 @@
+package uploader
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/lomik/carbon-clickhouse/helper/clickhouse"
+)
+
+// Strategies of endpoint selection
+const (
+	BalanceFirstHealthy = "first-healthy"
+	BalanceRoundRobin   = "round-robin"
+	BalanceRandom       = "random"
+	BalanceLeastErrors  = "least-errors"
+)
+
+// errorsDecay is time of decay of errors for least-errors strategy
+const errorsDecay = time.Minute
+
+// endpoint is ClickHouse server of uploader
+type endpoint struct {
+	url    string
+	name   string             // name in stats
+	native *clickhouse.Client // native protocol for URL with clickhouse scheme
+
+	stat struct {
+		success    uint32
+		errors     uint32
+		uploadTime uint64
+	}
+
+	// guarded by endpoints lock
+	fails     int       // consecutive errors
+	ejected   time.Time // not used until
+	score     float64   // decayed errors
+	scoreTime time.Time
+}
+
+// endpoints are ClickHouse servers of uploader with passive health tracking: endpoint is ejected for fail-timeout
+// after max-fails consecutive errors
+type endpoints struct {
+	sync.Mutex
+	list        []*endpoint
+	balance     string
+	maxFails    int
+	failTimeout time.Duration
+	compress    bool
+	client      *http.Client
+	next        uint32 // atomic, round-robin
+}
+
+func newEndpoints(urls []string, cfg *Config, tlsConfig *tls.Config) (*endpoints, error) {
+	s := &endpoints{
+		balance:     cfg.Balance,
+		maxFails:    cfg.MaxFails,
+		failTimeout: cfg.FailTimeout.Value(),
+		compress:    cfg.CompressData,
+		client:      cfg.client,
+	}
+
+	for _, u := range urls {
+		p, err := url.Parse(u)
+		if err != nil {
+			return nil, err
+		}
+		e := &endpoint{url: u, name: endpointName(p)}
+		if p.Scheme == "clickhouse" {
+			e.native, err = clickhouse.NewClient(u, tlsConfig, cfg.Timeout.Value())
+			if err != nil {
+				return nil, err
+			}
+		}
+		s.list = append(s.list, e)
+	}
+
+	if len(s.list) == 0 {
+		return nil, fmt.Errorf("url is not specified")
+	}
+
+	return s, nil
+}
+
+// endpointName returns host and port of URL as one node of metric name
+func endpointName(p *url.URL) string {
+	return strings.NewReplacer(".", "_", ":", "_").Replace(p.Host)
+}
+
+// pick selects endpoint for next insert. If all endpoints are ejected, endpoint with earliest end of ejection is used
+func (s *endpoints) pick() *endpoint {
+	if len(s.list) == 1 {
+		return s.list[0]
+	}
+
+	now := time.Now()
+
+	s.Lock()
+	defer s.Unlock()
+
+	healthy := make([]*endpoint, 0, len(s.list))
+	for _, e := range s.list {
+		if !e.ejected.After(now) {
+			healthy = append(healthy, e)
+		}
+	}
+
+	if len(healthy) == 0 {
+		first := s.list[0]
+		for _, e := range s.list[1:] {
+			if e.ejected.Before(first.ejected) {
+				first = e
+			}
+		}
+		return first
+	}
+
+	switch s.balance {
+	case BalanceRoundRobin:
+		return healthy[int(atomic.AddUint32(&s.next, 1)-1)%len(healthy)]
+	case BalanceRandom:
+		return healthy[rand.Intn(len(healthy))]
+	case BalanceLeastErrors:
+		// ties are balanced with round-robin
+		offset := int(atomic.AddUint32(&s.next, 1) - 1)
+		var best *endpoint
+		bestScore := 0.0
+		for i := range healthy {
+			e := healthy[(offset+i)%len(healthy)]
+			score := e.decayedScore(now)
+			if best == nil || score < bestScore {
+				best, bestScore = e, score
+			}
+		}
+		return best
+	}
+
+	return healthy[0]
+}
+
+func (e *endpoint) decayedScore(now time.Time) float64 {
+	if e.score == 0 {
+		return 0
+	}
+	return e.score * math.Exp(-float64(now.Sub(e.scoreTime))/float64(errorsDecay))
+}
+
+// done updates health of endpoint after insert
+func (s *endpoints) done(e *endpoint, err error, duration time.Duration) {
+	atomic.AddUint64(&e.stat.uploadTime, uint64(duration.Milliseconds()))
+	if err == nil {
+		atomic.AddUint32(&e.stat.success, 1)
+	} else {
+		atomic.AddUint32(&e.stat.errors, 1)
+	}
+
+	now := time.Now()
+
+	s.Lock()
+	defer s.Unlock()
+
+	if err == nil {
+		e.fails = 0
+		return
+	}
+
+	e.score = e.decayedScore(now) + 1
+	e.scoreTime = now
+	e.fails++
+	if e.fails >= s.maxFails {
+		e.ejected = now.Add(s.failTimeout)
+		e.fails = 0
+	}
+}
+
+// stat reports stats of every endpoint
+func (s *endpoints) stat(prefix string, send func(metric string, value float64)) {
+	now := time.Now()
+	for _, e := range s.list {
+		prefix := prefix + "endpoint." + e.name + "."
+		send(prefix+"success", float64(atomic.SwapUint32(&e.stat.success, 0)))
+		send(prefix+"errors", float64(atomic.SwapUint32(&e.stat.errors, 0)))
+		send(prefix+"upload_time", float64(atomic.SwapUint64(&e.stat.uploadTime, 0)))
+
+		s.Lock()
+		ejected := e.ejected.After(now)
+		s.Unlock()
+		if ejected {
+			send(prefix+"ejected", 1)
+		} else {
+			send(prefix+"ejected", 0)
+		}
+	}
+}
+
+// pipeData is data of INSERT. Errors of data source are not errors of endpoint
+type pipeData struct {
+	*io.PipeReader
+	err error
+}
+
+func (d *pipeData) Read(p []byte) (int, error) {
+	n, err := d.PipeReader.Read(p)
+	if err != nil && err != io.EOF && err != io.ErrClosedPipe {
+		d.err = err
+	}
+	return n, err
+}
+
+// insert sends INSERT to selected endpoint
+func (s *endpoints) insert(table string, data *io.PipeReader, settings url.Values) error {
+	e := s.pick()
+	d := &pipeData{PipeReader: data}
+	start := time.Now()
+
+	var err error
+	if e.native != nil {
+		err = e.native.Insert(fmt.Sprintf("INSERT INTO %s", table), d, settings)
+	} else {
+		err = s.insertHTTP(e, table, d, settings)
+	}
+
+	if err == nil || d.err == nil {
+		s.done(e, err, time.Since(start))
+	}
+	return err
+}
+
+func (s *endpoints) insertHTTP(e *endpoint, table string, data *pipeData, settings url.Values) error {
+	p, err := url.Parse(e.url)
+	if err != nil {
+		return err
+	}
+
+	q := p.Query()
+	for k, v := range settings {
+		q[k] = v
+	}
+
+	q.Set("query", fmt.Sprintf("INSERT INTO %s FORMAT RowBinary", table))
+	p.RawQuery = q.Encode()
+	queryURL := p.String()
+
+	var req *http.Request
+
+	if s.compress {
+		req, err = http.NewRequest("POST", queryURL, compress(data))
+		req.Header.Add("Content-Encoding", "gzip")
+	} else {
+		req, err = http.NewRequest("POST", queryURL, data)
+	}
+
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if exceptionCode := resp.Header.Get("X-Clickhouse-Exception-Code"); exceptionCode != "" && exceptionCode != "0" {
+		return fmt.Errorf("clickhouse exception code %s, response status %d: %s", exceptionCode, resp.StatusCode, string(body))
+	}
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("clickhouse response status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
@@ -0,0 +1,121 @@
+package uploader
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lomik/carbon-clickhouse/helper/config"
+)
+
+func testEndpoints(t *testing.T, balance string, urls ...string) *endpoints {
+	cfg := &Config{
+		URLs:        urls,
+		Balance:     balance,
+		MaxFails:    2,
+		FailTimeout: &config.Duration{Duration: time.Hour},
+	}
+	require.NoError(t, cfg.Parse())
+	return cfg.endpoints
+}
+
+func pickNames(s *endpoints, n int) []string {
+	var names []string
+	for i := 0; i < n; i++ {
+		names = append(names, s.pick().name)
+	}
+	return names
+}
+
+func TestEndpointsBalance(t *testing.T) {
+	urls := []string{"http://ch1:8123/", "http://ch2:8123/", "clickhouse://ch3"}
+
+	s := testEndpoints(t, "", urls...)
+	assert.Equal(t, []string{"ch1_8123", "ch1_8123"}, pickNames(s, 2))
+	assert.NotNil(t, s.list[2].native)
+
+	// ejected after max-fails consecutive errors
+	s.done(s.list[0], errors.New("fail"), time.Millisecond)
+	assert.Equal(t, "ch1_8123", s.pick().name)
+	s.done(s.list[0], errors.New("fail"), time.Millisecond)
+	assert.Equal(t, []string{"ch2_8123", "ch2_8123"}, pickNames(s, 2))
+
+	// all ejected: earliest end of ejection
+	for _, e := range s.list[1:] {
+		s.done(e, errors.New("fail"), time.Millisecond)
+		s.done(e, errors.New("fail"), time.Millisecond)
+	}
+	assert.Equal(t, "ch1_8123", s.pick().name)
+
+	// end of ejection
+	s.list[0].ejected = time.Time{}
+	assert.Equal(t, "ch1_8123", s.pick().name)
+
+	s = testEndpoints(t, BalanceRoundRobin, urls...)
+	assert.Equal(t, []string{"ch1_8123", "ch2_8123", "ch3", "ch1_8123"}, pickNames(s, 4))
+
+	s = testEndpoints(t, BalanceRandom, urls...)
+	assert.Len(t, pickNames(s, 10), 10)
+
+	// errors are counted, but endpoint isn't ejected after success
+	s = testEndpoints(t, BalanceLeastErrors, urls...)
+	s.done(s.list[0], errors.New("fail"), time.Millisecond)
+	s.done(s.list[0], nil, time.Millisecond)
+	s.done(s.list[1], errors.New("fail"), time.Millisecond)
+	assert.Equal(t, []string{"ch3", "ch3"}, pickNames(s, 2))
+	s.done(s.list[2], errors.New("fail"), time.Millisecond)
+	s.done(s.list[2], errors.New("fail"), time.Millisecond)
+	assert.Contains(t, []string{"ch1_8123", "ch2_8123"}, s.pick().name)
+
+	cfg := &Config{URLs: urls, Balance: "fastest"}
+	assert.Error(t, cfg.Parse())
+}
+
+func TestEndpointsFailover(t *testing.T) {
+	failed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failed.Close()
+	srv := &insertServer{}
+	ok := httptest.NewServer(srv)
+	defer ok.Close()
+
+	s := testEndpoints(t, BalanceFirstHealthy, failed.URL, ok.URL)
+
+	insert := func(data string, dataErr error) error {
+		pr, pw := io.Pipe()
+		go func() {
+			pw.Write([]byte(data))
+			pw.CloseWithError(dataErr)
+		}()
+		return s.insert("graphite", pr, nil)
+	}
+
+	// error of data source doesn't eject endpoint
+	require.Error(t, insert("data", errors.New("broken chunk")))
+	require.Error(t, insert("data", errors.New("broken chunk")))
+	assert.Equal(t, s.list[0], s.pick())
+
+	require.Error(t, insert("data", nil))
+	require.Error(t, insert("data", nil))
+	require.NoError(t, insert("data", nil))
+	assert.Equal(t, 1, srv.inserts())
+
+	stat := make(map[string]float64)
+	s.stat("", func(metric string, value float64) { stat[metric] = value })
+	name := s.list[0].name
+	assert.Equal(t, float64(0), stat["endpoint."+name+".success"])
+	assert.Equal(t, float64(2), stat["endpoint."+name+".errors"])
+	assert.Equal(t, float64(1), stat["endpoint."+name+".ejected"])
+	name = s.list[1].name
+	assert.Equal(t, float64(1), stat["endpoint."+name+".success"])
+	assert.Equal(t, float64(0), stat["endpoint."+name+".ejected"])
+	assert.Contains(t, stat, "endpoint."+name+".upload_time")
+}
@@ -0,0 +1,184 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lomik/carbon-clickhouse/writer"
+)
+
+const (
+	// fastPathQueue is max count of data buffers not sent to ClickHouse yet. Stream is aborted on overflow
+	fastPathQueue = 256
+	// fastPathBlockSize is max_insert_block_size of fast path INSERT: all rows of chunk are inserted as one block,
+	// so INSERT aborted in the middle leaves no rows
+	fastPathBlockSize = 1 << 40
+)
+
+var errFastPathAborted = errors.New("fast path aborted")
+
+var _ writer.FastPath = &Base{}
+
+// fastStream streams chunk data from writer to INSERT
+type fastStream struct {
+	u        *Base
+	filename string
+	name     string
+	data     chan []byte
+	size     int64
+	closed   bool   // Finish or Abort called
+	aborted  uint32 // atomic
+	failed   uint32 // atomic, upload is finished with error
+}
+
+// OpenStream starts upload of chunk in progress (see writer.FastPath). Stream isn't started if fast path is disabled,
+// uploader isn't running, disk backlog exists or fast path failed since last upload of backlog
+func (u *Base) OpenStream(filename string) writer.FastStream {
+	if !u.config.FastPath || u.streamHandler == nil || atomic.LoadUint32(&u.fastFailed) != 0 {
+		return nil
+	}
+
+	u.Struct.RLock()
+	defer u.Struct.RUnlock()
+
+	if !u.running {
+		return nil
+	}
+
+	s := &fastStream{
+		u:        u,
+		filename: filename,
+		name:     filepath.Base(filename),
+		data:     make(chan []byte, fastPathQueue),
+	}
+
+	u.Lock()
+	if len(u.inQueue) > 0 {
+		u.Unlock()
+		return nil
+	}
+	u.inFast[s.name] = true
+	u.Unlock()
+
+	u.Go(s.run)
+
+	return s
+}
+
+func (s *fastStream) Write(p []byte) bool {
+	if s.closed {
+		return false
+	}
+	if atomic.LoadUint32(&s.failed) != 0 {
+		s.Abort()
+		return false
+	}
+
+	s.size += int64(len(p))
+	if max := s.u.config.FastPathMaxSize.Value(); max > 0 && s.size > max {
+		s.Abort()
+		return false
+	}
+
+	select {
+	case s.data <- append([]byte(nil), p...):
+		return true
+	default:
+		// uploader doesn't keep up
+		s.Abort()
+		return false
+	}
+}
+
+func (s *fastStream) Finish() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.data)
+}
+
+func (s *fastStream) Abort() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	atomic.StoreUint32(&s.aborted, 1)
+	close(s.data)
+}
+
+// feed copies data to pipe. Pipe is closed with error on abort, so incomplete chunk is not inserted
+func (s *fastStream) feed(pw *io.PipeWriter) {
+	for p := range s.data {
+		if _, err := pw.Write(p); err != nil {
+			// upload failed, wait close by writer
+			for range s.data {
+			}
+			return
+		}
+	}
+
+	if atomic.LoadUint32(&s.aborted) != 0 {
+		pw.CloseWithError(errFastPathAborted)
+	} else {
+		pw.Close()
+	}
+}
+
+func (s *fastStream) run(ctx context.Context) {
+	u := s.u
+	logger := u.logger.With(zap.String("filename", s.filename), zap.Bool("fast_path", true))
+	startTime := time.Now()
+
+	pr, pw := io.Pipe()
+	go s.feed(pw)
+
+	settings := url.Values{
+		"input_format_parallel_parsing": []string{"0"},
+		"max_insert_block_size":         []string{strconv.FormatInt(fastPathBlockSize, 10)},
+	}
+	n, err := u.streamHandler(ctx, logger, s.filename, pr, settings)
+	if err != nil {
+		atomic.StoreUint32(&s.failed, 1)
+		pr.CloseWithError(err)
+	}
+
+	duration := time.Since(startTime)
+
+	if err == nil {
+		// Finish is called after link of chunk
+		u.MarkAsFinished(s.filename)
+
+		atomic.AddUint32(&u.stat.uploaded, 1)
+		atomic.AddUint32(&u.stat.fastUploaded, 1)
+		atomic.AddUint64(&u.stat.uploadedMetrics, n)
+		atomic.AddUint64(&u.stat.uploadTime, uint64(duration.Milliseconds()))
+		logger.Info("handle success", zap.Uint64("metrics", n), zap.Duration("time", duration))
+	} else {
+		// disk path until backlog is uploaded
+		atomic.StoreUint32(&u.fastFailed, 1)
+		atomic.AddUint32(&u.stat.fastFallbacks, 1)
+		if atomic.LoadUint32(&s.aborted) != 0 {
+			logger.Info("fast path aborted, chunk is uploaded from disk")
+		} else {
+			atomic.AddUint32(&u.stat.errors, 1)
+			logger.Error("fast path failed, chunk is uploaded from disk", zap.Error(err), zap.Duration("time", duration))
+		}
+	}
+
+	u.Lock()
+	delete(u.inFast, s.name)
+	if err != nil {
+		u.fallbacks[s.name] = s.filename
+	}
+	u.Unlock()
+	u.wakeUp()
+}
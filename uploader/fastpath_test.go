@@ -0,0 +1,114 @@
+package uploader
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/config"
+	"github.com/lomik/carbon-clickhouse/state"
+)
+
+// insertServer accepts INSERT only if request body is read completely
+type insertServer struct {
+	sync.Mutex
+	inserted [][]byte
+	queries  []string
+}
+
+func (s *insertServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.Lock()
+	s.inserted = append(s.inserted, body)
+	s.queries = append(s.queries, r.URL.RawQuery)
+	s.Unlock()
+}
+
+func (s *insertServer) inserts() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.inserted)
+}
+
+func TestFastPath(t *testing.T) {
+	dir := t.TempDir()
+	srv := &insertServer{}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	cfg := &Config{
+		Type:      "points",
+		TableName: "graphite",
+		URL:       ts.URL,
+		Timeout:   &config.Duration{Duration: time.Minute},
+		FastPath:  true,
+	}
+	require.NoError(t, cfg.Parse())
+
+	st := state.NewSymlink(dir, []string{"graphite"})
+	up, err := New(filepath.Join(dir, "graphite"), "graphite", cfg, st)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "graphite"), 0755))
+	require.NoError(t, up.Start())
+	defer up.Stop()
+	base := up.(*Points).Base
+
+	wb := RowBinary.GetWriteBuffer()
+	wb.WriteGraphitePoint([]byte("hello.world"), 42, 1559465760, 1559465760)
+	record := append([]byte(nil), wb.Body[:wb.Used]...)
+	wb.Release()
+
+	// disabled before first scan of backlog
+	assert.Nil(t, base.OpenStream(filepath.Join(dir, "default.1")))
+	require.Eventually(t, func() bool { return atomic.LoadUint32(&base.fastFailed) == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	// chunk is uploaded by stream and marked as uploaded after link
+	fn := filepath.Join(dir, "default.1")
+	s := base.OpenStream(fn)
+	require.NotNil(t, s)
+	require.True(t, s.Write(record))
+	require.True(t, s.Write(record))
+	require.NoError(t, os.WriteFile(fn, append(append([]byte(nil), record...), record...), 0644))
+	require.NoError(t, st.Link("default.1", []string{"graphite"}))
+	s.Finish()
+
+	require.Eventually(t, func() bool { return st.Status("default.1", "graphite") == state.Uploaded }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 1, srv.inserts())
+	assert.Equal(t, 2*len(record), len(srv.inserted[0]))
+	assert.Contains(t, srv.queries[0], "input_format_parallel_parsing=0")
+	assert.Equal(t, uint32(1), atomic.LoadUint32(&base.stat.fastUploaded))
+
+	// aborted stream inserts nothing, chunk is uploaded from disk once
+	fn = filepath.Join(dir, "default.2")
+	s = base.OpenStream(fn)
+	require.NotNil(t, s)
+	require.True(t, s.Write(record))
+	require.NoError(t, os.WriteFile(fn, record, 0644))
+	s.Abort()
+
+	require.Eventually(t, func() bool { return atomic.LoadUint32(&base.stat.fastFallbacks) == 1 }, 5*time.Second, 10*time.Millisecond)
+	// fast path is disabled until backlog is uploaded
+	assert.Nil(t, base.OpenStream(filepath.Join(dir, "default.3")))
+
+	require.NoError(t, st.Link("default.2", []string{"graphite"}))
+	base.ChunkLinked("default.2", fn)
+	require.Eventually(t, func() bool { return st.Status("default.2", "graphite") == state.Uploaded }, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool { return atomic.LoadUint32(&base.fastFailed) == 0 }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, 2, srv.inserts())
+	assert.Equal(t, len(record), len(srv.inserted[1]))
+	assert.NotContains(t, srv.queries[1], "input_format_parallel_parsing")
+}
@@ -27,7 +27,7 @@ const DefaultTreeDate = 42 // 1970-02-12
 func NewIndex(base *Base) *Index {
 	u := &Index{}
 	u.cached = newCached(base)
-	u.cached.parser = u.parseFile
+	u.cached.parser = u.parse
 	return u
 }
 
@@ -136,17 +136,9 @@ func releaseIndexBuffer(b *indexBuffers) {
 	indexBufferPool.Put(b)
 }
 
-func (u *Index) parseFile(filename string, out io.Writer) (uint64, map[string]bool, error) {
-	var reader *RowBinary.Reader
-	var err error
+func (u *Index) parse(filename string, reader *RowBinary.Reader, out io.Writer) (uint64, map[string]bool, error) {
 	var n uint64
 
-	reader, err = RowBinary.NewReader(filename, false)
-	if err != nil {
-		return n, nil, err
-	}
-	defer reader.Close()
-
 	version := uint32(time.Now().Unix())
 	newSeries := make(map[string]bool)
 	indexBuf := getIndexBuffer()
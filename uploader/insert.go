@@ -0,0 +1,207 @@
+package uploader
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+
+	"go.uber.org/zap"
+
+	"github.com/lomik/carbon-clickhouse/state"
+)
+
+// insert is INSERT of rows of chunk. With sharding rows are split by Path to INSERT of every shard, shards which
+// accepted rows of chunk before are skipped
+type insert struct {
+	u        *Base
+	chunk    string
+	settings url.Values
+	out      *bufio.Writer
+	streams  []*insertStream // by shard, single stream without sharding
+	skip     []bool          // shards which accepted rows of chunk before
+	buf      []byte          // incomplete row
+	key      func(path []byte) uint64
+	weight   uint64 // total weight of shards
+}
+
+// insertStream is INSERT to one shard
+type insertStream struct {
+	shard  int
+	pw     *io.PipeWriter
+	out    *bufio.Writer
+	result chan error
+	err    error // write failed
+}
+
+// startInsert starts INSERT of rows written to returned insert. INSERT is completed by finish
+func (u *Base) startInsert(filename string, settings url.Values) *insert {
+	ins := &insert{
+		u:        u,
+		chunk:    filepath.Base(filename),
+		settings: settings,
+	}
+
+	shards := u.config.Shards
+	if len(shards) == 0 {
+		s := ins.startStream(-1)
+		ins.streams = []*insertStream{s}
+		ins.out = s.out
+		return ins
+	}
+
+	ins.key = shardingKeys[u.config.ShardingKey]
+	for _, sh := range shards {
+		ins.weight += uint64(sh.Weight)
+	}
+	ins.streams = make([]*insertStream, len(shards))
+	ins.skip = make([]bool, len(shards))
+	for i := range shards {
+		ins.skip[i] = u.shardDone(ins.chunk, i)
+	}
+	ins.out = bufio.NewWriter(writerFunc(ins.split))
+	return ins
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func (ins *insert) Write(p []byte) (int, error) {
+	return ins.out.Write(p)
+}
+
+func (ins *insert) startStream(shard int) *insertStream {
+	u := ins.u
+	pr, pw := io.Pipe()
+	s := &insertStream{
+		shard:  shard,
+		pw:     pw,
+		out:    bufio.NewWriter(pw),
+		result: make(chan error, 1),
+	}
+
+	u.Go(func(ctx context.Context) {
+		var err error
+		if shard < 0 {
+			err = u.insertRowBinary(u.query, pr, ins.settings)
+		} else {
+			err = u.config.Shards[shard].endpoints.insert(u.query, pr, ins.settings)
+		}
+		s.result <- err
+		if err != nil {
+			pr.CloseWithError(err)
+		}
+	})
+
+	return s
+}
+
+// split writes complete rows to streams of shards. INSERT of shard is started on first row
+func (ins *insert) split(p []byte) (int, error) {
+	data := p
+	if len(ins.buf) > 0 {
+		ins.buf = append(ins.buf, p...)
+		data = ins.buf
+	}
+
+	for len(data) > 0 {
+		size, path, err := ins.u.layout.next(data)
+		if err != nil {
+			return 0, err
+		}
+		if size == 0 {
+			break
+		}
+
+		shard := shardOf(ins.u.config.Shards, ins.weight, ins.key(path))
+		s := ins.streams[shard]
+		if s == nil && !ins.skip[shard] {
+			s = ins.startStream(shard)
+			ins.streams[shard] = s
+		}
+		if s != nil && s.err == nil {
+			// rows of failed shard are dropped, other shards are not aborted
+			_, s.err = s.out.Write(data[:size])
+		}
+		data = data[size:]
+	}
+
+	ins.buf = append(ins.buf[:0], data...)
+
+	return len(p), nil
+}
+
+// finish completes INSERT, err is error of data source. Nothing is inserted if err is not nil
+func (ins *insert) finish(ctx context.Context, err error) error {
+	if err == nil {
+		err = ins.out.Flush()
+	}
+	if err == nil && len(ins.buf) > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+
+	for _, s := range ins.streams {
+		if s == nil {
+			continue
+		}
+		if err == nil && s.err == nil {
+			s.err = s.out.Flush()
+		}
+		s.pw.CloseWithError(err)
+	}
+
+	var errs []error
+	for _, s := range ins.streams {
+		if s == nil {
+			continue
+		}
+
+		var uploadErr error
+		select {
+		case uploadErr = <-s.result:
+			// pass
+		case <-ctx.Done():
+			return fmt.Errorf("upload aborted")
+		}
+
+		if s.shard < 0 {
+			return uploadErr
+		}
+		if uploadErr == nil {
+			uploadErr = s.err
+		}
+		if uploadErr != nil {
+			errs = append(errs, fmt.Errorf("shard %d: %w", s.shard+1, uploadErr))
+		} else if err == nil {
+			ins.u.setShardDone(ins.chunk, s.shard)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (u *Base) shardDone(chunk string, shard int) bool {
+	return u.state.Status(chunk, shardStateName(u.name, shard)) == state.Uploaded
+}
+
+func (u *Base) setShardDone(chunk string, shard int) {
+	name := shardStateName(u.name, shard)
+	err := u.state.Link(chunk, []string{name})
+	if err == nil {
+		err = u.state.Done(chunk, name)
+	}
+	if err != nil {
+		// rows are inserted into shard again on retry
+		u.logger.Error("mark shard as finished failed",
+			zap.String("chunk", chunk),
+			zap.Int("shard", shard+1),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,68 @@
+package uploader
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/internal/clickhousetest"
+	"github.com/lomik/carbon-clickhouse/state"
+)
+
+func TestNativeProtocol(t *testing.T) {
+	srv, err := clickhousetest.NewServer()
+	require.NoError(t, err)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	uploaders := map[string]*Config{
+		"graphite":        {Type: "points", TableName: "graphite", URL: srv.URL("compress=zstd")},
+		"graphite_tagged": {Type: "tagged", TableName: "graphite_tagged", URL: srv.URL("")},
+	}
+	names := []string{"graphite", "graphite_tagged"}
+	st := state.NewSymlink(dir, names)
+
+	wb := RowBinary.GetWriteBuffer()
+	wb.WriteGraphitePoint([]byte("hello.world"), 42, 1559465760, 1559465761)
+	wb.WriteGraphitePoint([]byte("cpu?host=a"), 43, 1559465760, 1559465761)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "default.1"), wb.Body[:wb.Used], 0644))
+	wb.Release()
+	require.NoError(t, st.Link("default.1", names))
+
+	for _, name := range names {
+		cfg := uploaders[name]
+		require.NoError(t, cfg.Parse())
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, name), 0755))
+		up, err := New(filepath.Join(dir, name), name, cfg, st)
+		require.NoError(t, err)
+		require.NoError(t, up.Start())
+		defer up.Stop()
+	}
+
+	for _, name := range names {
+		require.Eventually(t, func() bool { return st.Status("default.1", name) == state.Uploaded }, 5*time.Second, 10*time.Millisecond, name)
+	}
+
+	inserts := srv.Inserts()
+	require.Len(t, inserts, 2)
+	for _, ins := range inserts {
+		switch ins.Columns[0] {
+		case "Path":
+			assert.True(t, ins.Compression)
+			require.Len(t, ins.Rows, 2)
+			assert.Equal(t, []interface{}{"hello.world", float64(42), uint32(1559465760)}, ins.Rows[0][:3])
+			assert.Equal(t, uint32(1559465761), ins.Rows[0][4])
+		case "Date":
+			require.Len(t, ins.Rows, 2)
+			assert.Equal(t, "cpu?host=a", ins.Rows[0][2])
+			assert.Equal(t, []string{"__name__=cpu", "host=a"}, ins.Rows[0][3])
+		default:
+			t.Fatalf("unexpected insert %s", ins.Query)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package uploader
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/config"
+	"github.com/lomik/carbon-clickhouse/state"
+)
+
+func TestChunkLinked(t *testing.T) {
+	dir := t.TempDir()
+	srv := &insertServer{}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	cfg := &Config{
+		Type:         "points",
+		TableName:    "graphite",
+		URL:          ts.URL,
+		ScanInterval: &config.Duration{Duration: time.Hour},
+	}
+	require.NoError(t, cfg.Parse())
+
+	st := state.NewSymlink(dir, []string{"graphite"})
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "graphite"), 0755))
+
+	wb := RowBinary.GetWriteBuffer()
+	wb.WriteGraphitePoint([]byte("hello.world"), 42, 1559465760, 1559465760)
+	record := append([]byte(nil), wb.Body[:wb.Used]...)
+	wb.Release()
+
+	// linked before start, found by first scan
+	for _, name := range []string{"default.1", "default.2"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), record, 0644))
+		require.NoError(t, st.Link(name, []string{"graphite"}))
+	}
+
+	up, err := New(filepath.Join(dir, "graphite"), "graphite", cfg, st)
+	require.NoError(t, err)
+	require.NoError(t, up.Start())
+	defer up.Stop()
+	base := up.(*Points).Base
+
+	require.Eventually(t, func() bool { return srv.inserts() == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	// linked after start, uploaded without scan
+	fn := filepath.Join(dir, "default.3")
+	require.NoError(t, os.WriteFile(fn, record, 0644))
+	require.NoError(t, st.Link("default.3", []string{"graphite"}))
+	base.ChunkLinked("default.3", fn)
+
+	require.Eventually(t, func() bool { return st.Status("default.3", "graphite") == state.Uploaded }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 3, srv.inserts())
+
+	stat := make(map[string]float64)
+	up.Stat(func(metric string, value float64) { stat[metric] = value })
+	assert.Equal(t, float64(3), stat["uploaded"])
+	assert.Equal(t, float64(0), stat["unhandled"])
+	assert.Equal(t, float64(0), stat["delay"])
+}
@@ -1,11 +1,11 @@
 package uploader
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"net/url"
 
 	"go.uber.org/zap"
 
@@ -21,6 +21,7 @@ type Points struct {
 func NewPoints(base *Base, reverse bool) *Points {
 	u := &Points{Base: base}
 	u.Base.handler = u.upload
+	u.Base.streamHandler = u.uploadStream
 	u.reverse = reverse
 	u.query = fmt.Sprintf("%s (Path, Value, Time, Date, Timestamp)", u.config.TableName)
 
@@ -32,15 +33,9 @@ func NewPoints(base *Base, reverse bool) *Points {
 }
 
 // parseAndFilter reads points data and excludes those ones which match blacklist
-func (u *Points) parseAndFilter(filename string, out io.Writer) (uint64, error) {
+func (u *Points) parseAndFilter(reader *RowBinary.Reader, out io.Writer) (uint64, error) {
 	var n uint64
 
-	reader, err := RowBinary.NewReader(filename, u.reverse)
-	if err != nil {
-		return n, err
-	}
-
-	defer reader.Close()
 	reader.SetZeroVersion(u.config.ZeroTimestamp)
 
 	wb := RowBinary.GetWriteBuffer()
@@ -73,39 +68,30 @@ func (u *Points) parseAndFilter(filename string, out io.Writer) (uint64, error)
 }
 
 func (u *Points) upload(ctx context.Context, logger *zap.Logger, filename string) (uint64, error) {
-	var (
-		err, uploadErr error
-		uploadResult   chan error
-		n              uint64
-	)
-
-	pipeReader, pipeWriter := io.Pipe()
-	out := bufio.NewWriter(pipeWriter)
-	uploadResult = make(chan error, 1)
-
-	u.Go(func(ctx context.Context) {
-		err := u.insertRowBinary(
-			u.query,
-			pipeReader,
-		)
-		uploadResult <- err
-		if err != nil {
-			_ = pipeReader.CloseWithError(err)
-		}
-	})
+	reader, err := RowBinary.NewReader(filename, u.reverse)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	return u.uploadReader(ctx, filename, reader, nil)
+}
 
-	n, err = u.parseAndFilter(filename, out)
+// uploadStream uploads records of chunk in progress, see FastStream
+func (u *Points) uploadStream(ctx context.Context, logger *zap.Logger, filename string, r io.Reader, settings url.Values) (uint64, error) {
+	return u.uploadReader(ctx, filename, RowBinary.NewStreamReader(r, u.reverse), settings)
+}
+
+func (u *Points) uploadReader(ctx context.Context, filename string, reader *RowBinary.Reader, settings url.Values) (uint64, error) {
+	ins := u.startInsert(filename, settings)
+
+	n, err := u.parseAndFilter(reader, ins)
 	if err == nil {
-		err = out.Flush()
+		// incomplete stream or chunk without encryption key must not be inserted
+		err = reader.Err()
 	}
-	_ = pipeWriter.CloseWithError(err)
 
-	select {
-	case uploadErr = <-uploadResult:
-		// pass
-	case <-ctx.Done():
-		return n, fmt.Errorf("upload aborted")
-	}
+	uploadErr := ins.finish(ctx, err)
 
 	if err != nil {
 		return n, err
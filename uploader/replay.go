@@ -0,0 +1,50 @@
+package uploader
+
+import (
+	"context"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lomik/carbon-clickhouse/state"
+)
+
+// Replayer uploads chunks outside of live pipeline, upload status of chunks is not changed
+type Replayer interface {
+	// StartReplay starts uploader without scan of pending chunks and upload workers
+	StartReplay() error
+	// Upload uploads single chunk with handler of uploader
+	Upload(ctx context.Context, filename string) (uint64, error)
+	Stop()
+	Stat(send func(metric string, value float64))
+}
+
+// StartReplay starts uploader with own state in memory. Shards which accepted rows of chunk are skipped
+// only on retry of chunk within replay
+func (u *Base) StartReplay() error {
+	return u.StartFunc(func() error {
+		u.state = state.NewMemory(filepath.Dir(u.path))
+		return nil
+	})
+}
+
+func (u *Base) Upload(ctx context.Context, filename string) (uint64, error) {
+	startTime := time.Now()
+	logger := u.logger.With(zap.String("filename", filename))
+
+	n, err := u.handler(ctx, logger, filename)
+
+	atomic.AddUint64(&u.stat.uploadTime, uint64(time.Since(startTime).Milliseconds()))
+	if err != nil {
+		atomic.AddUint32(&u.stat.errors, 1)
+	} else {
+		atomic.AddUint32(&u.stat.uploaded, 1)
+		atomic.AddUint64(&u.stat.uploadedMetrics, n)
+		// chunk with same name fed again is uploaded again
+		u.state.Remove(filepath.Base(filename))
+	}
+
+	return n, err
+}
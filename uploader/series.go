@@ -11,7 +11,6 @@ import (
 
 type Series struct {
 	*cached
-	isReverse bool
 }
 
 var _ Uploader = &Series{}
@@ -20,22 +19,14 @@ var _ UploaderWithReset = &Series{}
 func NewSeries(base *Base, reverse bool) *Series {
 	u := &Series{}
 	u.cached = newCached(base)
-	u.cached.parser = u.parseFile
-	u.isReverse = reverse
+	u.cached.parser = u.parse
+	u.cached.reverse = reverse
 	return u
 }
 
-func (u *Series) parseFile(filename string, out io.Writer) (uint64, map[string]bool, error) {
-	var reader *RowBinary.Reader
-	var err error
+func (u *Series) parse(filename string, reader *RowBinary.Reader, out io.Writer) (uint64, map[string]bool, error) {
 	var n uint64
 
-	reader, err = RowBinary.NewReader(filename, u.isReverse)
-	if err != nil {
-		return n, nil, err
-	}
-	defer reader.Close()
-
 	version := uint32(time.Now().Unix())
 	newSeries := make(map[string]bool)
 	wb := RowBinary.GetWriteBuffer()
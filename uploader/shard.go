@@ -0,0 +1,176 @@
+package uploader
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/go-faster/city"
+)
+
+// Sharding keys
+const (
+	ShardingKeyPath = "path"             // FNV-1a of Path
+	ShardingKeyTag  = "tag"              // FNV-1a of metric name (Path without tags), series of name are on same shard
+	ShardingKeyCity = "cityHash64(Path)" // same as sharding key cityHash64(Path) of Distributed table
+)
+
+// Shard is group of replicas of shard. Rows are inserted into one of replicas
+type Shard struct {
+	Weight    int        `toml:"weight"`
+	URLs      []string   `toml:"urls"`
+	endpoints *endpoints `toml:"-"`
+}
+
+// ShardStateNames returns names of shards of uploader in state. Shards which accepted rows of chunk are marked
+// as uploaded in state, so chunk is retried only for failed shards after restart
+func ShardStateNames(name string, cfg *Config) []string {
+	names := make([]string, 0, len(cfg.Shards))
+	for i := range cfg.Shards {
+		names = append(names, shardStateName(name, i))
+	}
+	return names
+}
+
+func shardStateName(name string, shard int) string {
+	return fmt.Sprintf("%s#shard-%d", name, shard+1)
+}
+
+var shardingKeys = map[string]func(path []byte) uint64{
+	ShardingKeyPath: fnvHash64,
+	ShardingKeyTag: func(path []byte) uint64 {
+		if p := bytes.IndexByte(path, '?'); p >= 0 {
+			path = path[:p]
+		}
+		return fnvHash64(path)
+	},
+	ShardingKeyCity: city.CH64,
+}
+
+func fnvHash64(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+	return h.Sum64()
+}
+
+// shardOf selects shard by key like Distributed table: remainder of division by total weight selects range of shard
+func shardOf(shards []*Shard, totalWeight uint64, key uint64) int {
+	r := key % totalWeight
+	for i, s := range shards {
+		if r < uint64(s.Weight) {
+			return i
+		}
+		r -= uint64(s.Weight)
+	}
+	return len(shards) - 1
+}
+
+// sizes of RowBinary values of columns, variable sizes are negative
+const (
+	columnString      = -1
+	columnStringArray = -2
+)
+
+var rowColumns = map[string]int{
+	"Path":      columnString,
+	"Value":     8,
+	"Time":      4,
+	"Date":      2,
+	"Timestamp": 4,
+	"Level":     4,
+	"Version":   4,
+	"Tag1":      columnString,
+	"Tags":      columnStringArray,
+}
+
+// rowLayout is columns of RowBinary rows of uploader
+type rowLayout struct {
+	columns []int
+	path    int // index of Path column
+}
+
+// newRowLayout parses columns of query like "table (Path, Value, ...)"
+func newRowLayout(query string) (*rowLayout, error) {
+	start := strings.IndexByte(query, '(')
+	end := strings.LastIndexByte(query, ')')
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("columns not found in %#v", query)
+	}
+
+	l := &rowLayout{path: -1}
+	for i, name := range strings.Split(query[start+1:end], ",") {
+		name = strings.TrimSpace(name)
+		size, ok := rowColumns[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %#v", name)
+		}
+		if name == "Path" {
+			l.path = i
+		}
+		l.columns = append(l.columns, size)
+	}
+	if l.path < 0 {
+		return nil, fmt.Errorf("column Path not found in %#v", query)
+	}
+
+	return l, nil
+}
+
+// next returns size and Path of first row of b. Zero size is returned for incomplete row
+func (l *rowLayout) next(b []byte) (int, []byte, error) {
+	var path []byte
+	offset := 0
+
+	readString := func() ([]byte, bool, error) {
+		n, k := binary.Uvarint(b[offset:])
+		if k == 0 {
+			return nil, false, nil
+		}
+		if k < 0 {
+			return nil, false, fmt.Errorf("invalid length of string")
+		}
+		if uint64(len(b)-offset-k) < n {
+			return nil, false, nil
+		}
+		s := b[offset+k : offset+k+int(n)]
+		offset += k + int(n)
+		return s, true, nil
+	}
+
+	for i, size := range l.columns {
+		switch size {
+		case columnString:
+			s, ok, err := readString()
+			if !ok {
+				return 0, nil, err
+			}
+			if i == l.path {
+				path = s
+			}
+		case columnStringArray:
+			n, k := binary.Uvarint(b[offset:])
+			if k == 0 {
+				return 0, nil, nil
+			}
+			if k < 0 {
+				return 0, nil, fmt.Errorf("invalid size of array")
+			}
+			offset += k
+			for j := uint64(0); j < n; j++ {
+				_, ok, err := readString()
+				if !ok {
+					return 0, nil, err
+				}
+			}
+		default:
+			if len(b)-offset < size {
+				return 0, nil, nil
+			}
+			offset += size
+		}
+	}
+
+	return offset, path, nil
+}
@@ -0,0 +1,276 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-faster/city"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/config"
+	"github.com/lomik/carbon-clickhouse/internal/clickhousetest"
+	"github.com/lomik/carbon-clickhouse/state"
+)
+
+func TestRowLayout(t *testing.T) {
+	wb := RowBinary.GetWriteBuffer()
+	defer wb.Release()
+
+	wb.WriteGraphitePoint([]byte("hello.world"), 42, 1559465760, 1559465761)
+	l, err := newRowLayout("graphite (Path, Value, Time, Date, Timestamp)")
+	require.NoError(t, err)
+	size, path, err := l.next(wb.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, wb.Len(), size)
+	assert.Equal(t, "hello.world", string(path))
+
+	// incomplete row
+	size, _, err = l.next(wb.Bytes()[:wb.Len()-1])
+	require.NoError(t, err)
+	assert.Equal(t, 0, size)
+
+	wb.Reset()
+	wb.WriteUint16(18049)
+	wb.WriteString("__name__=cpu")
+	wb.WriteString("cpu?host=a")
+	wb.WriteUVarint(2)
+	wb.WriteString("__name__=cpu")
+	wb.WriteString("host=a")
+	wb.WriteUint32(1559465761)
+	l, err = newRowLayout("graphite_tagged (Date, Tag1, Path, Tags, Version)")
+	require.NoError(t, err)
+	size, path, err = l.next(wb.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, wb.Len(), size)
+	assert.Equal(t, "cpu?host=a", string(path))
+	for i := 0; i < wb.Len(); i++ {
+		size, _, err = l.next(wb.Bytes()[:i])
+		require.NoError(t, err)
+		assert.Equal(t, 0, size)
+	}
+
+	_, err = newRowLayout("graphite_tree (Level, Name, Version)")
+	assert.Error(t, err)
+	_, err = newRowLayout("graphite_tree (Level, Version)")
+	assert.Error(t, err)
+}
+
+func TestShardOf(t *testing.T) {
+	shards := []*Shard{{Weight: 1}, {Weight: 2}}
+	var got []int
+	for key := uint64(0); key < 6; key++ {
+		got = append(got, shardOf(shards, 3, key))
+	}
+	assert.Equal(t, []int{0, 1, 1, 0, 1, 1}, got)
+
+	// SELECT cityHash64('')
+	assert.Equal(t, uint64(11160318154034397263), shardingKeys[ShardingKeyCity](nil))
+	assert.Equal(t, shardingKeys[ShardingKeyPath]([]byte("cpu")), shardingKeys[ShardingKeyTag]([]byte("cpu?host=a")))
+}
+
+// countingState counts reads of status
+type countingState struct {
+	state.State
+	status uint32 // atomic
+}
+
+func (s *countingState) Status(name string, uploader string) state.Status {
+	atomic.AddUint32(&s.status, 1)
+	return s.State.Status(name, uploader)
+}
+
+func TestSharding(t *testing.T) {
+	var servers []*clickhousetest.Server
+	var shards []*Shard
+	for i := 0; i < 2; i++ {
+		srv, err := clickhousetest.NewServer()
+		require.NoError(t, err)
+		defer srv.Close()
+		servers = append(servers, srv)
+		shards = append(shards, &Shard{URLs: []string{srv.URL("")}})
+	}
+
+	dir := t.TempDir()
+	newConfig := func() *Config {
+		cfg := &Config{
+			Type:        "points",
+			TableName:   "graphite",
+			Timeout:     &config.Duration{Duration: time.Minute},
+			Shards:      shards,
+			ShardingKey: ShardingKeyCity,
+		}
+		require.NoError(t, cfg.Parse())
+		return cfg
+	}
+	cfg := newConfig()
+	st := state.NewSymlink(dir, append([]string{"graphite"}, ShardStateNames("graphite", cfg)...))
+
+	wb := RowBinary.GetWriteBuffer()
+	expected := make(map[string]int)
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("hello.world%d", i)
+		wb.WriteGraphitePoint([]byte(name), float64(i), 1559465760, 1559465761)
+		expected[name] = int(city.CH64([]byte(name)) % 2)
+	}
+	filename := filepath.Join(dir, "default.1")
+	require.NoError(t, os.WriteFile(filename, wb.Body[:wb.Used], 0644))
+	wb.Release()
+
+	up, err := New(filepath.Join(dir, "graphite"), "graphite", cfg, st)
+	require.NoError(t, err)
+	require.NoError(t, up.Start())
+	p := up.(*Points)
+
+	// rows accepted by first shard are not sent again, even after restart
+	servers[1].SetException("unavailable")
+	_, err = p.upload(context.Background(), zap.NewNop(), filename)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "shard 2")
+	assert.Len(t, servers[0].Inserts(), 1)
+	assert.True(t, p.shardDone("default.1", 0))
+	assert.False(t, p.shardDone("default.1", 1))
+	assert.Equal(t, state.Uploaded, st.Status("default.1", "graphite#shard-1"))
+
+	stat := make(map[string]float64)
+	up.Stat(func(metric string, value float64) { stat[metric] = value })
+	name := endpointName(&url.URL{Host: servers[1].Addr})
+	assert.Equal(t, float64(1), stat["shard.2.endpoint."+name+".errors"])
+	up.Stop()
+
+	// restart
+	restarted := &countingState{State: state.NewSymlink(dir, []string{"graphite"})}
+	up, err = New(filepath.Join(dir, "graphite"), "graphite", newConfig(), restarted)
+	require.NoError(t, err)
+	require.NoError(t, up.Start())
+	defer up.Stop()
+	p = up.(*Points)
+
+	servers[1].SetException("")
+	n, err := p.upload(context.Background(), zap.NewNop(), filename)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(20), n)
+	// status of shard is read once per insert, not per row
+	assert.Equal(t, uint32(2), atomic.LoadUint32(&restarted.status))
+
+	rows := 0
+	for i, srv := range servers {
+		inserts := srv.Inserts()
+		require.Len(t, inserts, 1)
+		for _, row := range inserts[0].Rows {
+			assert.Equal(t, i, expected[row[0].(string)], row[0])
+			rows++
+		}
+	}
+	assert.Equal(t, 20, rows)
+
+	stat = make(map[string]float64)
+	up.Stat(func(metric string, value float64) { stat[metric] = value })
+	assert.Equal(t, float64(1), stat["shard.2.endpoint."+name+".success"])
+	assert.Equal(t, float64(0), stat["shard.2.endpoint."+name+".errors"])
+
+	// status of shards is removed with chunk
+	require.NoError(t, st.Remove("default.1"))
+	assert.Equal(t, state.NotLinked, st.Status("default.1", "graphite#shard-1"))
+}
+
+func TestReplaySharding(t *testing.T) {
+	var servers []*clickhousetest.Server
+	var shards []*Shard
+	for i := 0; i < 2; i++ {
+		srv, err := clickhousetest.NewServer()
+		require.NoError(t, err)
+		defer srv.Close()
+		servers = append(servers, srv)
+		shards = append(shards, &Shard{URLs: []string{srv.URL("")}})
+	}
+
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	newReplayer := func() Replayer {
+		cfg := &Config{
+			Type:      "points",
+			TableName: "graphite",
+			Timeout:   &config.Duration{Duration: time.Minute},
+			Shards:    shards,
+		}
+		require.NoError(t, cfg.Parse())
+		up, err := New(os.TempDir(), "graphite", cfg, nil)
+		require.NoError(t, err)
+		r := up.(Replayer)
+		require.NoError(t, r.StartReplay())
+		return r
+	}
+
+	wb := RowBinary.GetWriteBuffer()
+	for i := 0; i < 20; i++ {
+		wb.WriteGraphitePoint([]byte(fmt.Sprintf("hello.world%d", i)), float64(i), 1559465760, 1559465761)
+	}
+	filename := filepath.Join(t.TempDir(), "default.1")
+	require.NoError(t, os.WriteFile(filename, wb.Body[:wb.Used], 0644))
+	wb.Release()
+
+	// retry of chunk skips shards which accepted rows
+	r := newReplayer()
+	servers[1].SetException("unavailable")
+	_, err := r.Upload(context.Background(), filename)
+	require.Error(t, err)
+	servers[1].SetException("")
+	n, err := r.Upload(context.Background(), filename)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(20), n)
+	assert.Len(t, servers[0].Inserts(), 1)
+	assert.Len(t, servers[1].Inserts(), 1)
+
+	// chunk fed again is inserted into all shards
+	n, err = r.Upload(context.Background(), filename)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(20), n)
+	assert.Len(t, servers[0].Inserts(), 2)
+	assert.Len(t, servers[1].Inserts(), 2)
+	r.Stop()
+
+	// next replay doesn't see status of previous one
+	r = newReplayer()
+	defer r.Stop()
+	n, err = r.Upload(context.Background(), filename)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(20), n)
+	assert.Len(t, servers[0].Inserts(), 3)
+	assert.Len(t, servers[1].Inserts(), 3)
+
+	// nothing is written outside of data directory
+	entries, err := os.ReadDir(filepath.Dir(dir))
+	require.NoError(t, err)
+	for _, e := range entries {
+		assert.NotContains(t, e.Name(), "#shard-")
+	}
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestShardingConfig(t *testing.T) {
+	cfg := &Config{URL: "http://localhost:8123/", Shards: []*Shard{{URLs: []string{"http://ch1:8123/"}}}}
+	assert.Error(t, cfg.Parse())
+
+	cfg = &Config{Shards: []*Shard{{URLs: []string{"http://ch1:8123/"}}}, ShardingKey: "rand()"}
+	assert.Error(t, cfg.Parse())
+
+	cfg = &Config{Shards: []*Shard{{URLs: []string{"http://ch1:8123/"}}, {}}}
+	assert.Error(t, cfg.Parse())
+
+	cfg = &Config{Shards: []*Shard{{URLs: []string{"http://ch1:8123/", "http://ch2:8123/"}}}}
+	require.NoError(t, cfg.Parse())
+	assert.Equal(t, ShardingKeyPath, cfg.ShardingKey)
+	assert.Equal(t, 1, cfg.Shards[0].Weight)
+	assert.Len(t, cfg.Shards[0].endpoints.list, 2)
+}
@@ -28,7 +28,7 @@ var errBufOverflow = fmt.Errorf("output buffer overflow")
 func NewTagged(base *Base) *Tagged {
 	u := &Tagged{}
 	u.cached = newCached(base)
-	u.cached.parser = u.parseFile
+	u.cached.parser = u.parse
 	u.query = fmt.Sprintf("%s (Date, Tag1, Path, Tags, Version)", u.config.TableName)
 
 	u.ignoredMetrics = make(map[string]bool, len(u.config.IgnoredTaggedMetrics))
@@ -214,19 +214,11 @@ func releaseTagsBuffer(b *tagsBuffers) {
 	tagsBufferPool.Put(b)
 }
 
-func (u *Tagged) parseFile(filename string, out io.Writer) (uint64, map[string]bool, error) {
-	var reader *RowBinary.Reader
-	var err error
+func (u *Tagged) parse(filename string, reader *RowBinary.Reader, out io.Writer) (uint64, map[string]bool, error) {
 	var n uint64
 
 	version := uint32(time.Now().Unix())
 
-	reader, err = RowBinary.NewReader(filename, false)
-	if err != nil {
-		return n, nil, err
-	}
-	defer reader.Close()
-
 	newTagged := make(map[string]bool)
 
 	tagsBuf := getTagsBuffer()
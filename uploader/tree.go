@@ -19,7 +19,7 @@ var _ UploaderWithReset = &Tree{}
 func NewTree(base *Base) *Tree {
 	u := &Tree{}
 	u.cached = newCached(base)
-	u.cached.parser = u.parseFile
+	u.cached.parser = u.parse
 	if u.config.TreeDate.IsZero() {
 		u.query = fmt.Sprintf("%s (Level, Path, Version)", u.config.TableName)
 	} else {
@@ -28,15 +28,9 @@ func NewTree(base *Base) *Tree {
 	return u
 }
 
-func (u *Tree) parseFile(filename string, out io.Writer) (uint64, map[string]bool, error) {
+func (u *Tree) parse(filename string, reader *RowBinary.Reader, out io.Writer) (uint64, map[string]bool, error) {
 	var n uint64
 
-	reader, err := RowBinary.NewReader(filename, false)
-	if err != nil {
-		return n, nil, err
-	}
-	defer reader.Close()
-
 	version := uint32(time.Now().Unix())
 
 	var days uint16
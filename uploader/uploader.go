@@ -2,9 +2,12 @@ package uploader
 
 import (
 	"fmt"
+	"path/filepath"
 
 	"github.com/lomik/zapwriter"
 	"go.uber.org/zap"
+
+	"github.com/lomik/carbon-clickhouse/state"
 )
 
 type Uploader interface {
@@ -17,21 +20,31 @@ type UploaderWithReset interface {
 	Reset()
 }
 
-func New(path string, name string, config *Config) (Uploader, error) {
+// New creates uploader. Upload status is kept in memory only if st is nil
+func New(path string, name string, config *Config, st state.State) (Uploader, error) {
 	c := *config
 
 	if c.Threads < 1 {
 		c.Threads = 1
 	}
 
+	if st == nil {
+		st = state.NewMemory(filepath.Dir(path))
+	}
+
 	logger := zapwriter.Logger("upload").With(zap.String("name", name))
 	u := &Base{
-		path:    path,
-		name:    name,
-		queue:   make(chan string, 1024),
-		inQueue: make(map[string]bool),
-		logger:  logger,
-		config:  &c,
+		path:      path,
+		state:     st,
+		name:      name,
+		queue:     make(chan string, 1024),
+		inQueue:   make(map[string]bool),
+		pending:   make(map[string]pendingChunk),
+		wake:      make(chan struct{}, 1),
+		inFast:    make(map[string]bool),
+		fallbacks: make(map[string]string),
+		logger:    logger,
+		config:    &c,
 	}
 
 	if c.Type != "points" && c.Type != "points-reverse" && len(c.IgnoredPatterns) > 0 {
@@ -63,5 +76,12 @@ func New(path string, name string, config *Config) (Uploader, error) {
 		return nil, fmt.Errorf("unknown uploader type %#v", c.Type)
 	}
 
+	if len(c.Shards) > 0 {
+		var err error
+		if u.layout, err = newRowLayout(u.query); err != nil {
+			return nil, err
+		}
+	}
+
 	return res, nil
 }
@@ -0,0 +1,66 @@
+package writer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Align creates option for New constructor. Chunks are rotated on wall-clock multiples of chunk interval
+// (every 10s on :00, :10, ...), time window of chunk is added to chunk name
+func Align(enabled bool) Option {
+	return func(w *Writer) {
+		w.align = enabled
+	}
+}
+
+// alignWindow returns wall-clock window [from, until) of interval containing t
+func alignWindow(t time.Time, interval time.Duration) (time.Time, time.Time) {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ns := t.UnixNano()
+	from := time.Unix(0, ns-ns%int64(interval))
+	return from, from.Add(interval)
+}
+
+// formatWindow returns name part of chunk window, it starts with digit and never matches route name
+func formatWindow(from, until time.Time) string {
+	return fmt.Sprintf("%d-%d", from.Unix(), until.Unix())
+}
+
+func parseWindow(part string) (time.Time, time.Time, bool) {
+	p := strings.IndexByte(part, '-')
+	if p < 1 {
+		return time.Time{}, time.Time{}, false
+	}
+	from, err := strconv.ParseInt(part[:p], 10, 64)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	until, err := strconv.ParseInt(part[p+1:], 10, 64)
+	if err != nil || until < from {
+		return time.Time{}, time.Time{}, false
+	}
+	return time.Unix(from, 0), time.Unix(until, 0), true
+}
+
+// ChunkWindow returns wall-clock window of aligned chunk.
+// Chunk name is default.<time>.<from>-<until>[.<route>][.<shard>][.<extension>], from and until are unix seconds
+func ChunkWindow(name string) (from time.Time, until time.Time, ok bool) {
+	parts := strings.SplitN(strings.TrimPrefix(name, "default."), ".", 3)
+	if len(parts) < 2 {
+		return time.Time{}, time.Time{}, false
+	}
+	return parseWindow(parts[1])
+}
+
+// ChunkInWindow checks aligned chunk intersects [from, until). Chunks without window always match
+func ChunkInWindow(name string, from, until time.Time) bool {
+	cf, cu, ok := ChunkWindow(name)
+	if !ok {
+		return true
+	}
+	return cf.Before(until) && cu.After(from)
+}
@@ -0,0 +1,101 @@
+package writer
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/config"
+)
+
+func TestAlignWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	from, until := alignWindow(time.Unix(1559465767, 123), 10*time.Second)
+	assert.Equal(int64(1559465760), from.Unix())
+	assert.Equal(int64(1559465770), until.Unix())
+
+	from, until = alignWindow(time.Unix(1559465760, 0), time.Minute)
+	assert.Equal(int64(1559465760), from.Unix())
+	assert.Equal(int64(1559465820), until.Unix())
+}
+
+func TestChunkWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct {
+		name  string
+		from  int64
+		until int64
+		ok    bool
+		route string
+	}{
+		{"default.1559465767000000000", 0, 0, false, ""},
+		{"default.1559465767000000000.lz4", 0, 0, false, ""},
+		{"default.1559465767000000000.route.1", 0, 0, false, "route"},
+		{"default.1559465767000000000.1559465760-1559465770", 1559465760, 1559465770, true, ""},
+		{"default.1559465767000000000.1559465760-1559465770.zst", 1559465760, 1559465770, true, ""},
+		{"default.1559465767000000000.1559465760-1559465770.route.1.lz4", 1559465760, 1559465770, true, "route"},
+	}
+
+	for _, tt := range tests {
+		from, until, ok := ChunkWindow(tt.name)
+		assert.Equal(tt.ok, ok, tt.name)
+		if tt.ok {
+			assert.Equal(tt.from, from.Unix(), tt.name)
+			assert.Equal(tt.until, until.Unix(), tt.name)
+		}
+	}
+
+	w := &Writer{routes: []*Route{{Name: "route"}}}
+	for _, tt := range tests {
+		route := ""
+		if r := w.routeOf(tt.name); r != nil {
+			route = r.Name
+		}
+		assert.Equal(tt.route, route, tt.name)
+	}
+
+	name := "default.1559465767000000000.1559465760-1559465770"
+	assert.True(ChunkInWindow(name, time.Unix(1559465700, 0), time.Unix(1559465761, 0)))
+	assert.False(ChunkInWindow(name, time.Unix(1559465700, 0), time.Unix(1559465760, 0)))
+	assert.False(ChunkInWindow(name, time.Unix(1559465770, 0), time.Unix(1559465800, 0)))
+	assert.True(ChunkInWindow("default.1559465767000000000", time.Unix(0, 0), time.Unix(1, 0)))
+}
+
+func TestWriterAlign(t *testing.T) {
+	dir := t.TempDir()
+	in := make(chan *RowBinary.WriteBuffer)
+
+	autoInterval := config.NewChunkAutoInterval()
+	autoInterval.SetDefault(time.Second)
+
+	w := New(in, dir, 0, autoInterval, config.CompAlgoNone, 0, nil, []string{"points"}, nil,
+		Align(true),
+	)
+	require.NoError(t, w.Start())
+
+	time.Sleep(1500 * time.Millisecond)
+	w.Stop()
+
+	files, err := filepath.Glob(filepath.Join(dir, "default.*"))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(files), 2)
+
+	// windows of sequential chunks are adjacent
+	var prev time.Time
+	for _, fn := range files {
+		from, until, ok := ChunkWindow(filepath.Base(fn))
+		require.True(t, ok, fn)
+		assert.Equal(t, time.Second, until.Sub(from))
+		assert.Equal(t, int64(0), from.UnixNano()%int64(time.Second))
+		if !prev.IsZero() {
+			assert.Equal(t, prev, from)
+		}
+		prev = until
+	}
+}
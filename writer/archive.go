@@ -0,0 +1,281 @@
+package writer
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/config"
+)
+
+const (
+	archiveDayLayout  = "2006-01-02"
+	archiveHourLayout = "15"
+
+	// archiveMaxChunkAge is max time between chunk creation and finish, used for select chunks of bucket
+	archiveMaxChunkAge = 24 * time.Hour
+)
+
+// Archive creates option for New constructor. Chunks uploaded by all uploaders are moved to
+// <path>/<YYYY-MM-DD>/<HH>/ (by chunk creation time, UTC) instead of remove. Archived chunks older than
+// retention are removed, zero retention keeps archive forever. If recompress is true, archived chunks are
+// rewritten in v2 chunk format with compAlgo compression
+func Archive(path string, retention time.Duration, recompress bool, compAlgo config.CompAlgo, compLevel int) Option {
+	return func(w *Writer) {
+		w.archive.path = path
+		w.archive.retention = retention
+		w.archive.recompress = recompress
+		w.archive.compAlgo = compAlgo
+		w.archive.compLevel = compLevel
+	}
+}
+
+type archiveConfig struct {
+	path       string
+	retention  time.Duration
+	recompress bool
+	compAlgo   config.CompAlgo
+	compLevel  int
+}
+
+// chunkCreated returns creation time from chunk name default.<time>...
+func chunkCreated(name string) (time.Time, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(name, "default."), ".", 2)
+	ns, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || !strings.HasPrefix(name, "default.") {
+		return time.Time{}, false
+	}
+	return time.Unix(0, ns), true
+}
+
+// archiveBucket returns directory of archived chunk
+func archiveBucket(archivePath string, name string, modTime time.Time) string {
+	t, ok := chunkCreated(name)
+	if !ok {
+		t = modTime
+	}
+	t = t.UTC()
+	return filepath.Join(archivePath, t.Format(archiveDayLayout), t.Format(archiveHourLayout))
+}
+
+// archiveChunk moves uploaded chunk to archive
+func (w *Writer) archiveChunk(filename string) error {
+	st, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Base(filename)
+	dir := archiveBucket(w.archive.path, name, st.ModTime())
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	if w.archive.recompress {
+		err = recompressChunk(filename, filepath.Join(dir, RowBinary.RepairedFilename(name)), w.archive.compAlgo, w.archive.compLevel, w.encryptionKey)
+	} else {
+		err = moveFile(filename, filepath.Join(dir, name))
+	}
+	if err != nil {
+		return err
+	}
+
+	if w.fsyncEnabled() {
+		if err := w.syncDir(dir); err != nil {
+			w.logger.Error("fsync directory failed", zap.String("path", dir), zap.Error(err))
+		}
+	}
+
+	atomic.AddUint32(&w.stat.archived, 1)
+	return nil
+}
+
+// tmpFilename returns name of incomplete archived file, ignored by ArchivedChunks
+func tmpFilename(filename string) string {
+	dir, name := filepath.Split(filename)
+	return filepath.Join(dir, ".tmp."+name)
+}
+
+// moveFile renames file, file is copied to other file system
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	var linkErr *os.LinkError
+	if err == nil || !errors.As(err, &linkErr) || !errors.Is(linkErr.Err, syscall.EXDEV) {
+		return err
+	}
+
+	return writeArchived(src, dst, func(out *os.File) error {
+		in, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		_, err = io.Copy(out, in)
+		return err
+	})
+}
+
+// recompressChunk rewrites chunk of any format in v2 format, encrypted if key is not nil
+func recompressChunk(src, dst string, compAlgo config.CompAlgo, compLevel int, key *RowBinary.EncryptionKey) error {
+	return writeArchived(src, dst, func(out *os.File) error {
+		r, err := RowBinary.NewReader(src, false)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		cw, err := RowBinary.NewEncryptedChunkWriter(out, compAlgo, compLevel, nil, key)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(cw, r); err != nil {
+			cw.Close()
+			return err
+		}
+		return cw.Close()
+	})
+}
+
+// writeArchived writes dst by temporary file and removes src. Modification time of src is kept
+func writeArchived(src, dst string, write func(out *os.File) error) error {
+	st, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	tmp := tmpFilename(dst)
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	err = write(out)
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chtimes(tmp, st.ModTime(), st.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// archiveBuckets returns hour buckets of archive with bucket start time, oldest first
+func archiveBuckets(archivePath string) ([]string, []time.Time, error) {
+	days, err := ioutil.ReadDir(archivePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	var dirs []string
+	var times []time.Time
+	for _, d := range days {
+		day, err := time.ParseInLocation(archiveDayLayout, d.Name(), time.UTC)
+		if err != nil || !d.IsDir() {
+			continue
+		}
+		hours, err := ioutil.ReadDir(filepath.Join(archivePath, d.Name()))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, h := range hours {
+			hour, err := strconv.Atoi(h.Name())
+			if err != nil || !h.IsDir() || hour < 0 || hour > 23 {
+				continue
+			}
+			dirs = append(dirs, filepath.Join(archivePath, d.Name(), h.Name()))
+			times = append(times, day.Add(time.Duration(hour)*time.Hour))
+		}
+	}
+
+	return dirs, times, nil
+}
+
+// cleanupArchive removes buckets older than retention
+func (w *Writer) cleanupArchive() {
+	if w.archive.path == "" || w.archive.retention <= 0 {
+		return
+	}
+
+	dirs, times, err := archiveBuckets(w.archive.path)
+	if err != nil {
+		w.logger.Error("read archive failed", zap.String("path", w.archive.path), zap.Error(err))
+		return
+	}
+
+	deadline := time.Now().Add(-w.archive.retention)
+	for i, dir := range dirs {
+		if times[i].Add(time.Hour).After(deadline) {
+			continue
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			w.logger.Error("remove archive bucket failed", zap.String("path", dir), zap.Error(err))
+			continue
+		}
+		w.logger.Info("archive bucket removed by retention", zap.String("path", dir))
+		// remove day directory after last hour
+		os.Remove(filepath.Dir(dir))
+	}
+}
+
+// ArchivedChunks returns archived chunks written in [from, until), ordered by creation time.
+// Chunk is written from its creation time (or window start of aligned chunk) to modification time
+func ArchivedChunks(archivePath string, from, until time.Time) ([]string, error) {
+	dirs, times, err := archiveBuckets(archivePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// buckets and files of bucket are sorted by name
+	var chunks []string
+	for i, dir := range dirs {
+		if !times[i].Before(until) || times[i].Add(time.Hour+archiveMaxChunkAge).Before(from) {
+			continue
+		}
+		flist, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range flist {
+			if f.IsDir() || !strings.HasPrefix(f.Name(), "default.") {
+				continue
+			}
+			start, ok := chunkCreated(f.Name())
+			if !ok {
+				start = f.ModTime()
+			}
+			if windowStart, _, ok := ChunkWindow(f.Name()); ok {
+				start = windowStart
+			}
+			if !start.Before(until) || f.ModTime().Before(from) {
+				continue
+			}
+			chunks = append(chunks, filepath.Join(dir, f.Name()))
+		}
+	}
+
+	return chunks, nil
+}
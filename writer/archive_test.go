@@ -0,0 +1,100 @@
+package writer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/config"
+)
+
+func TestArchive(t *testing.T) {
+	dir := t.TempDir()
+	archive := t.TempDir()
+
+	wb := RowBinary.GetWriteBuffer()
+	wb.WriteGraphitePoint([]byte("hello.world"), 42, 1559465760, 1559465760)
+	body := append([]byte(nil), wb.Body[:wb.Used]...)
+	wb.Release()
+
+	created := []time.Time{
+		time.Date(2019, 6, 2, 8, 30, 0, 0, time.UTC),
+		time.Date(2019, 6, 2, 9, 10, 0, 0, time.UTC),
+		time.Date(2019, 6, 2, 10, 50, 0, 0, time.UTC),
+	}
+	var names []string
+	for _, c := range created {
+		name := fmt.Sprintf("default.%d", c.UnixNano())
+		fn := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(fn, body, 0644))
+		require.NoError(t, os.Chtimes(fn, c.Add(time.Minute), c.Add(time.Minute)))
+		names = append(names, name)
+	}
+
+	for _, recompress := range []bool{false, true} {
+		w := New(nil, dir, 0, config.NewChunkAutoInterval(), config.CompAlgoNone, 0, nil, []string{"points"}, nil,
+			Archive(archive, 0, recompress, config.CompAlgoZstd, 0),
+		)
+		require.NoError(t, w.LinkAll())
+
+		name := names[0]
+		if recompress {
+			name = names[1]
+		}
+		require.NoError(t, w.state.Done(name, "points"))
+		require.NoError(t, w.Cleanup())
+
+		_, err := os.Stat(filepath.Join(dir, name))
+		assert.True(t, os.IsNotExist(err))
+		assert.Equal(t, uint32(1), w.stat.archived)
+	}
+
+	// not uploaded chunk is kept
+	_, err := os.Stat(filepath.Join(dir, names[2]))
+	assert.NoError(t, err)
+
+	files, err := ArchivedChunks(archive, created[0], created[2])
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		filepath.Join(archive, "2019-06-02", "08", names[0]),
+		filepath.Join(archive, "2019-06-02", "09", names[1]),
+	}, files)
+
+	// both archived chunks are readable, modification time is kept
+	for i, fn := range files {
+		r, err := RowBinary.NewReader(fn, false)
+		require.NoError(t, err)
+		b, err := io.ReadAll(r)
+		r.Close()
+		require.NoError(t, err)
+		assert.Equal(t, body, b)
+
+		st, err := os.Stat(fn)
+		require.NoError(t, err)
+		assert.True(t, st.ModTime().Equal(created[i].Add(time.Minute)))
+	}
+	h, err := RowBinary.ReadChunkHeader(files[1])
+	require.NoError(t, err)
+	assert.Equal(t, config.CompAlgoZstd, h.Compression)
+
+	files, err = ArchivedChunks(archive, created[0].Add(2*time.Minute), created[2])
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(archive, "2019-06-02", "09", names[1])}, files)
+
+	// retention
+	w := New(nil, dir, 0, config.NewChunkAutoInterval(), config.CompAlgoNone, 0, nil, []string{"points"}, nil,
+		Archive(archive, time.Since(created[1]), false, config.CompAlgoNone, 0),
+	)
+	w.cleanupArchive()
+
+	files, err = ArchivedChunks(archive, created[0], time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(archive, "2019-06-02", "09", names[1])}, files)
+}
@@ -2,80 +2,51 @@ package writer
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync/atomic"
 
 	"go.uber.org/zap"
+
+	"github.com/lomik/carbon-clickhouse/state"
 )
 
-func Cleanup(filename string, tables []string) (bool, error) {
+// cleanupChunk removes chunk uploaded by all tables
+func (w *Writer) cleanupChunk(filename string, tables []string) (bool, error) {
+	name := filepath.Base(filename)
 	if len(tables) == 0 {
 		return false, fmt.Errorf("upload destination list is empty")
 	}
 
-	d, fn := filepath.Split(filename)
-
-	var err error
+	if !state.Finished(w.state, name, tables) {
+		// file not finished
+		return false, nil
+	}
 
-	for _, t := range tables {
-		if _, err := os.Stat(filepath.Join(d, t, "_"+fn)); os.IsNotExist(err) {
-			// file not finished
+	if w.archive.path != "" {
+		if err := w.archiveChunk(filename); err != nil {
+			// chunk is kept, archive is retried on next cleanup
+			atomic.AddUint32(&w.stat.archiveErrors, 1)
+			w.logger.Error("chunk archive failed", zap.String("filename", filename), zap.Error(err))
 			return false, nil
 		}
-	}
-
-	err = os.Remove(filename)
-	if err != nil {
+	} else if err := os.Remove(filename); err != nil {
 		return false, err
 	}
 
-	for _, t := range tables {
-		err = os.Remove(filepath.Join(d, t, "_"+fn))
-		if err != nil {
-			return true, err
-		}
-	}
-
-	return true, nil
+	return true, w.state.Remove(name)
 }
 
 func (w *Writer) Cleanup() error {
-	// check and create table directories
-	for _, t := range w.uploaders {
-		if _, err := os.Stat(filepath.Join(w.path, t)); os.IsNotExist(err) {
-			err = os.Mkdir(filepath.Join(w.path, t), 0755)
-			if err != nil {
-				return err
-			}
-		}
-	}
-
-	flist, err := ioutil.ReadDir(w.path)
+	unhandledList, err := w.chunks()
 	if err != nil {
-		w.logger.Error("ReadDir failed", zap.Error(err))
 		return err
 	}
 
-	unhandledList := make([]string, 0, len(flist))
-	for _, f := range flist {
-		if f.IsDir() {
-			continue
-		}
-
-		if !strings.HasPrefix(f.Name(), "default.") {
-			continue
-		}
-
-		unhandledList = append(unhandledList, f.Name())
-	}
-
 	unhandledCount := len(unhandledList)
 	// remove finished files
-	for _, fn := range unhandledList {
-		removed, err := Cleanup(filepath.Join(w.path, fn), w.uploaders)
+	for _, c := range unhandledList {
+		removed, err := w.cleanupChunk(filepath.Join(c.dir, c.name), w.chunkUploaders(c.name))
 		if removed {
 			unhandledCount--
 		}
@@ -86,25 +57,6 @@ func (w *Writer) Cleanup() error {
 	}
 	atomic.StoreUint32(&w.stat.unhandled, uint32(unhandledCount))
 
-	// remove broken links
-	for _, t := range w.uploaders {
-		flist, err := ioutil.ReadDir(filepath.Join(w.path, t))
-		if err != nil {
-			w.logger.Error("ReadDir failed", zap.Error(err))
-			return err
-		}
-
-		for _, f := range flist {
-			full := filepath.Join(w.path, t, f.Name())
-			_, err := filepath.EvalSymlinks(full)
-			if err != nil {
-				w.logger.Info("remove broken link", zap.String("filename", full))
-				if err := os.Remove(full); err != nil {
-					return err
-				}
-			}
-		}
-	}
-
-	return nil
+	// remove status of removed files (broken links)
+	return w.state.Cleanup()
 }
@@ -0,0 +1,68 @@
+package writer
+
+import "path/filepath"
+
+// FastPath is uploader accepting data of chunk while chunk is written
+type FastPath interface {
+	// OpenStream starts upload of new chunk. Nil is returned if uploader can't take stream now (backlog, recent failure)
+	OpenStream(filename string) FastStream
+}
+
+// FastStream is upload of chunk in progress. Methods are called sequentially by writer
+type FastStream interface {
+	// Write passes chunk data, p is not retained. False means stream is aborted: chunk is uploaded from disk after finish
+	Write(p []byte) bool
+	// Finish is called after chunk is finished and linked. Chunk is marked as uploaded after successful upload
+	Finish()
+	// Abort cancels upload. Chunk is uploaded from disk
+	Abort()
+}
+
+// FastPaths creates option for New constructor. Data of new chunks is streamed to uploaders while it is written to disk.
+// Chunk file is kept for fallback: it is uploaded as usual if stream is aborted or failed
+func FastPaths(fastPaths map[string]FastPath) Option {
+	return func(w *Writer) {
+		w.fastPaths = fastPaths
+	}
+}
+
+// openStreams starts fast path uploads of new chunk
+func (w *Writer) openStreams(filename string) []FastStream {
+	if len(w.fastPaths) == 0 {
+		return nil
+	}
+
+	var streams []FastStream
+	for _, u := range w.chunkUploaders(filepath.Base(filename)) {
+		fp := w.fastPaths[u]
+		if fp == nil {
+			continue
+		}
+		if s := fp.OpenStream(filename); s != nil {
+			streams = append(streams, s)
+		}
+	}
+	return streams
+}
+
+// writeStreams passes data to streams, aborted streams are removed from result
+func writeStreams(streams []FastStream, p []byte) []FastStream {
+	alive := streams[:0]
+	for _, s := range streams {
+		if s.Write(p) {
+			alive = append(alive, s)
+		}
+	}
+	return alive
+}
+
+// closeStreams finishes streams of linked chunk or aborts them
+func closeStreams(streams []FastStream, linked bool) {
+	for _, s := range streams {
+		if linked {
+			s.Finish()
+		} else {
+			s.Abort()
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package writer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/config"
+	"github.com/lomik/carbon-clickhouse/state"
+)
+
+type testFastPath struct {
+	sync.Mutex
+	st      state.State
+	streams []*testStream
+}
+
+type testStream struct {
+	fp       *testFastPath
+	filename string
+	data     bytes.Buffer
+	finished bool
+	aborted  bool
+	linked   bool // chunk is linked on Finish
+}
+
+func (fp *testFastPath) OpenStream(filename string) FastStream {
+	fp.Lock()
+	defer fp.Unlock()
+	s := &testStream{fp: fp, filename: filename}
+	fp.streams = append(fp.streams, s)
+	return s
+}
+
+func (s *testStream) Write(p []byte) bool {
+	s.fp.Lock()
+	defer s.fp.Unlock()
+	s.data.Write(p)
+	return true
+}
+
+func (s *testStream) Finish() {
+	linked := s.fp.st.Status(filepath.Base(s.filename), "points") == state.Pending
+	s.fp.Lock()
+	defer s.fp.Unlock()
+	s.finished = true
+	s.linked = linked
+}
+
+func (s *testStream) Abort() {
+	s.fp.Lock()
+	defer s.fp.Unlock()
+	s.aborted = true
+}
+
+func TestWriterFastPath(t *testing.T) {
+	dir := t.TempDir()
+	in := make(chan *RowBinary.WriteBuffer)
+
+	autoInterval := config.NewChunkAutoInterval()
+	autoInterval.SetDefault(200 * time.Millisecond)
+
+	st := state.NewSymlink(dir, []string{"points"})
+	fp := &testFastPath{st: st}
+	w := New(in, dir, 0, autoInterval, config.CompAlgoLZ4, 0, nil, []string{"points"}, nil,
+		State(st),
+		FastPaths(map[string]FastPath{"points": fp}),
+	)
+	require.NoError(t, w.Start())
+
+	wb := RowBinary.GetWriteBuffer()
+	wb.WriteGraphitePoint([]byte("hello.world"), 42, 1559465760, 1559465760)
+	body := append([]byte(nil), wb.Body[:wb.Used]...)
+	in <- wb
+
+	// first chunk is finished after link, stream of next chunk is aborted on stop
+	require.Eventually(t, func() bool {
+		fp.Lock()
+		defer fp.Unlock()
+		return len(fp.streams) > 1 && fp.streams[0].finished
+	}, 5*time.Second, 10*time.Millisecond)
+	w.Stop()
+
+	fp.Lock()
+	defer fp.Unlock()
+	first := fp.streams[0]
+	assert.True(t, first.linked)
+	assert.False(t, first.aborted)
+	assert.Equal(t, body, first.data.Bytes())
+
+	last := fp.streams[len(fp.streams)-1]
+	assert.True(t, last.aborted)
+
+	// chunk file is written for fallback
+	_, err := os.Stat(first.filename)
+	require.NoError(t, err)
+}
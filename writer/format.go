@@ -0,0 +1,26 @@
+package writer
+
+import "github.com/lomik/carbon-clickhouse/helper/RowBinary"
+
+const (
+	// ChunkFormatLegacy is stream of RowBinary records compressed as whole file (version 1)
+	ChunkFormatLegacy = "legacy"
+	// ChunkFormatV2 is chunk with header and independently compressed blocks with checksums.
+	// Reader skips corrupted block instead of the rest of file
+	ChunkFormatV2 = "v2"
+)
+
+// ChunkFormat creates option for New constructor
+func ChunkFormat(format string) Option {
+	return func(w *Writer) {
+		w.chunkFormat = format
+	}
+}
+
+// Encryption creates option for New constructor. Chunks of format v2 are encrypted with key, nil key disables encryption.
+// Repaired and recompressed archived chunks are encrypted too
+func Encryption(key *RowBinary.EncryptionKey) Option {
+	return func(w *Writer) {
+		w.encryptionKey = key
+	}
+}
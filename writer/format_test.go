@@ -0,0 +1,66 @@
+package writer
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/config"
+)
+
+func TestWriterEncryption(t *testing.T) {
+	dir := t.TempDir()
+	in := make(chan *RowBinary.WriteBuffer)
+
+	keyFile := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.WriteFile(keyFile, []byte("7 "+hex.EncodeToString(bytes.Repeat([]byte{7}, 32))+"\n"), 0600))
+	key, err := RowBinary.LoadEncryptionKeys(keyFile)
+	require.NoError(t, err)
+
+	autoInterval := config.NewChunkAutoInterval()
+	autoInterval.SetDefault(time.Hour)
+
+	w := New(in, dir, 0, autoInterval, config.CompAlgoLZ4, 0, nil, []string{"points"}, nil,
+		ChunkFormat(ChunkFormatV2),
+		Encryption(key),
+	)
+	require.NoError(t, w.Start())
+
+	wg := new(sync.WaitGroup)
+	errorChan := make(chan error, 1)
+	wb := RowBinary.GetWriterBufferWithConfirm(wg, errorChan)
+	wb.WriteGraphitePoint([]byte("customer.secret.metric"), 42, 1559465760, 1559465760)
+	body := append([]byte(nil), wb.Body[:wb.Used]...)
+	in <- wb
+	wg.Wait()
+	w.Stop()
+
+	files, err := filepath.Glob(filepath.Join(dir, "default.*"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(files))
+
+	raw, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	assert.False(t, strings.Contains(string(raw), "customer.secret"))
+
+	h, err := RowBinary.ReadChunkHeader(files[0])
+	require.NoError(t, err)
+	assert.Equal(t, uint32(7), h.KeyID)
+
+	r, err := RowBinary.NewReader(files[0], false)
+	require.NoError(t, err)
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, body, got)
+}
@@ -0,0 +1,95 @@
+package writer
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// FsyncNever leaves data in page cache, OS writes it to disk
+	FsyncNever = "never"
+	// FsyncOnRotate syncs chunk before close and directory entries of chunk and uploader links
+	FsyncOnRotate = "on-rotate"
+	// FsyncInterval syncs current chunk every fsync-interval in addition to on-rotate
+	FsyncInterval = "interval"
+	// FsyncOnConfirm syncs current chunk before confirmation of every confirmable buffer (gRPC StoreSync, sync-write)
+	// in addition to on-rotate
+	FsyncOnConfirm = "on-confirm"
+)
+
+// Fsync creates option for New constructor
+func Fsync(policy string, interval time.Duration) Option {
+	return func(w *Writer) {
+		w.fsync = policy
+		w.fsyncInterval = interval
+	}
+}
+
+func (w *Writer) fsyncEnabled() bool {
+	return w.fsync != "" && w.fsync != FsyncNever
+}
+
+// timedSync calls sync and counts it in sync latency stat
+func (w *Writer) timedSync(sync func() error) error {
+	start := time.Now()
+	err := sync()
+	d := uint64(time.Since(start).Microseconds())
+
+	atomic.AddUint32(&w.stat.syncs, 1)
+	atomic.AddUint64(&w.stat.syncTime, d)
+	for {
+		max := atomic.LoadUint64(&w.stat.syncTimeMax)
+		if d <= max || atomic.CompareAndSwapUint64(&w.stat.syncTimeMax, max, d) {
+			break
+		}
+	}
+	if err != nil {
+		atomic.AddUint32(&w.stat.syncErrors, 1)
+	}
+
+	return err
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// syncFile flushes file data to disk
+func (w *Writer) syncFile(f *os.File) error {
+	return w.timedSync(f.Sync)
+}
+
+// syncDir flushes directory entries (created chunks and links) to disk
+func (w *Writer) syncDir(dir string) error {
+	return w.timedSync(func() error { return syncDir(dir) })
+}
+
+// link adds chunk to queues of uploaders and syncs state
+func (w *Writer) link(filename string) error {
+	fn := filepath.Base(filename)
+	uploaders := w.chunkUploaders(fn)
+	notified := w.unlinked(fn, uploaders)
+
+	if err := w.state.Link(fn, uploaders); err != nil {
+		return err
+	}
+
+	if w.fsyncEnabled() {
+		if err := w.timedSync(func() error { return w.state.Sync(uploaders) }); err != nil {
+			return err
+		}
+	}
+
+	w.notify(fn, filename, notified)
+	return nil
+}
@@ -0,0 +1,57 @@
+package writer
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/config"
+)
+
+func TestFsyncOnConfirm(t *testing.T) {
+	dir := t.TempDir()
+	in := make(chan *RowBinary.WriteBuffer)
+
+	autoInterval := config.NewChunkAutoInterval()
+	autoInterval.SetDefault(time.Hour)
+
+	w := New(in, dir, 0, autoInterval, config.CompAlgoNone, 0, nil, []string{"points"}, nil,
+		Fsync(FsyncOnConfirm, 0),
+	)
+	require.NoError(t, w.Start())
+	defer w.Stop()
+
+	wg := new(sync.WaitGroup)
+	errorChan := make(chan error, 1)
+
+	wb := RowBinary.GetWriterBufferWithConfirm(wg, errorChan)
+	wb.WriteGraphitePoint([]byte("hello.world"), 42, 1559465760, 1559465760)
+	size := int64(wb.Len())
+	in <- wb
+	wg.Wait()
+
+	select {
+	case err := <-errorChan:
+		t.Fatal(err)
+	default:
+	}
+
+	// directory entry of new chunk and chunk data
+	assert.GreaterOrEqual(t, atomic.LoadUint32(&w.stat.syncs), uint32(2))
+	assert.Equal(t, uint32(0), atomic.LoadUint32(&w.stat.syncErrors))
+
+	files, err := filepath.Glob(filepath.Join(dir, "default.*"))
+	require.NoError(t, err)
+	require.Equal(t, 1, len(files))
+
+	st, err := os.Stat(files[0])
+	require.NoError(t, err)
+	assert.Equal(t, size, st.Size())
+}
@@ -1,66 +1,29 @@
 package writer
 
 import (
-	"io/ioutil"
-	"os"
 	"path/filepath"
-	"strings"
 
-	"go.uber.org/zap"
+	"github.com/lomik/carbon-clickhouse/state"
 )
 
-// Write symlink to file to all table subfolders
-func Link(filename string, tables []string) error {
-	d, fn := filepath.Split(filename)
-
-	var err error
-
-	for _, t := range tables {
-		if _, err = os.Stat(filepath.Join(d, t)); os.IsNotExist(err) {
-			err = os.Mkdir(filepath.Join(d, t), 0755)
-			if err != nil {
-				return err
-			}
-		}
-
-		if _, err := os.Stat(filepath.Join(d, t, fn)); !os.IsNotExist(err) {
-			// symlink or file already exists
-			continue
-		}
-
-		if _, err := os.Stat(filepath.Join(d, t, "_"+fn)); !os.IsNotExist(err) {
-			// finished symlink or file already exists
-			continue
-		}
-
-		err = os.Symlink(filepath.Join("..", fn), filepath.Join(d, t, fn))
-		if err != nil {
-			return err
-		}
-	}
-
-	return nil
-}
-
 func (w *Writer) LinkAll() error {
-	flist, err := ioutil.ReadDir(w.path)
+	chunks, err := w.chunks()
 	if err != nil {
-		w.logger.Error("ReadDir failed", zap.Error(err))
 		return err
 	}
 
-	for _, f := range flist {
-		if f.IsDir() {
-			continue
-		}
-		if !strings.HasPrefix(f.Name(), "default.") {
-			continue
-		}
-
-		if err := Link(filepath.Join(w.path, f.Name()), w.uploaders); err != nil {
+	for _, c := range chunks {
+		if err := w.link(filepath.Join(c.dir, c.name)); err != nil {
 			return err
 		}
 	}
 
 	return nil
 }
+
+// State creates option for New constructor. Symlinks in uploaders directories are used by default
+func State(st state.State) Option {
+	return func(w *Writer) {
+		w.state = st
+	}
+}
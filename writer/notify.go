@@ -0,0 +1,40 @@
+package writer
+
+import "github.com/lomik/carbon-clickhouse/state"
+
+// Notifier is uploader waiting for new chunks
+type Notifier interface {
+	// ChunkLinked is called after chunk is added to queue of uploader. Must not block
+	ChunkLinked(name string, filename string)
+}
+
+// Notify creates option for New constructor. Uploaders are notified about linked chunks directly,
+// so they don't wait for scan of pending chunks
+func Notify(notifiers map[string]Notifier) Option {
+	return func(w *Writer) {
+		w.notifiers = notifiers
+	}
+}
+
+// unlinked returns uploaders without chunk in queue. Chunks are relinked on start, already uploaded
+// and queued chunks are not notified again
+func (w *Writer) unlinked(name string, uploaders []string) []string {
+	if len(w.notifiers) == 0 {
+		return nil
+	}
+
+	var res []string
+	for _, u := range uploaders {
+		if w.notifiers[u] != nil && w.state.Status(name, u) == state.NotLinked {
+			res = append(res, u)
+		}
+	}
+	return res
+}
+
+// notify passes linked chunk to uploaders
+func (w *Writer) notify(name string, filename string, uploaders []string) {
+	for _, u := range uploaders {
+		w.notifiers[u].ChunkLinked(name, filename)
+	}
+}
@@ -0,0 +1,48 @@
+package writer
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lomik/carbon-clickhouse/helper/config"
+	"github.com/lomik/carbon-clickhouse/state"
+)
+
+type testNotifier struct {
+	sync.Mutex
+	linked []string
+}
+
+func (n *testNotifier) ChunkLinked(name string, filename string) {
+	n.Lock()
+	defer n.Unlock()
+	n.linked = append(n.linked, name+" "+filename)
+}
+
+func TestWriterNotify(t *testing.T) {
+	dir := t.TempDir()
+	st := state.NewSymlink(dir, []string{"points", "index"})
+	points := &testNotifier{}
+	w := New(nil, dir, 0, config.NewChunkAutoInterval(), config.CompAlgoNone, 0, nil, []string{"points", "index"}, nil,
+		State(st),
+		Notify(map[string]Notifier{"points": points}),
+	)
+
+	fn := filepath.Join(dir, "default.1")
+	require.NoError(t, os.WriteFile(fn, []byte("data"), 0644))
+
+	require.NoError(t, w.link(fn))
+	assert.Equal(t, []string{"default.1 " + fn}, points.linked)
+	assert.Equal(t, state.Pending, st.Status("default.1", "index"))
+
+	// relink of queued or uploaded chunk (LinkAll on start) is not notified
+	require.NoError(t, w.link(fn))
+	require.NoError(t, st.Done("default.1", "points"))
+	require.NoError(t, w.LinkAll())
+	assert.Len(t, points.linked, 1)
+}
@@ -0,0 +1,151 @@
+package writer
+
+import (
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// PlacementRoundRobin places new chunks to data directories in turn
+	PlacementRoundRobin = "round-robin"
+	// PlacementFreeSpace places new chunk to data directory with most free space
+	PlacementFreeSpace = "free-space"
+
+	// dataPathRetry is pause of failed data directory usage
+	dataPathRetry = 30 * time.Second
+)
+
+// dataPath is data directory
+type dataPath struct {
+	path   string
+	failed int64  // atomic, unix nano time of last failure
+	full   uint32 // atomic, min-free-space is breached
+}
+
+func (p *dataPath) fail() {
+	atomic.StoreInt64(&p.failed, time.Now().UnixNano())
+}
+
+func (p *dataPath) isFailed() bool {
+	failed := atomic.LoadInt64(&p.failed)
+	return failed != 0 && time.Since(time.Unix(0, failed)) < dataPathRetry
+}
+
+func (p *dataPath) isFull() bool {
+	return atomic.LoadUint32(&p.full) != 0
+}
+
+// DataPaths creates option for New constructor. Chunks are written to several data directories
+// (on different disks), every directory has own uploaders state. First path replaces path of New
+func DataPaths(paths []string, placement string) Option {
+	return func(w *Writer) {
+		if len(paths) == 0 {
+			return
+		}
+		w.path = paths[0]
+		w.paths = make([]*dataPath, 0, len(paths))
+		for _, p := range paths {
+			w.paths = append(w.paths, &dataPath{path: p})
+		}
+		w.placement = placement
+	}
+}
+
+// choosePath returns data directory of new chunk. Failed and full directories are used only if all directories are
+func (w *Writer) choosePath() *dataPath {
+	if len(w.paths) == 1 {
+		return w.paths[0]
+	}
+
+	candidates := make([]*dataPath, 0, len(w.paths))
+	for _, p := range w.paths {
+		if !p.isFailed() && !p.isFull() {
+			candidates = append(candidates, p)
+		}
+	}
+	if len(candidates) == 0 {
+		for _, p := range w.paths {
+			if !p.isFailed() {
+				candidates = append(candidates, p)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = w.paths
+	}
+
+	if w.placement == PlacementFreeSpace {
+		var best *dataPath
+		var bestFree int64
+		for _, p := range candidates {
+			free, err := freeSpace(p.path)
+			if err != nil {
+				w.logger.Error("statfs failed", zap.String("path", p.path), zap.Error(err))
+				p.fail()
+				continue
+			}
+			if best == nil || free > bestFree {
+				best, bestFree = p, free
+			}
+		}
+		if best != nil {
+			return best
+		}
+	}
+
+	n := atomic.AddUint32(&w.nextPath, 1)
+	return candidates[int(n-1)%len(candidates)]
+}
+
+// hasHealthyPath checks any data directory is not failed
+func (w *Writer) hasHealthyPath() bool {
+	for _, p := range w.paths {
+		if !p.isFailed() {
+			return true
+		}
+	}
+	return false
+}
+
+// chunks returns chunk files of all data directories, oldest first.
+// Unreadable directory is logged and skipped, error is returned if all directories are unreadable
+func (w *Writer) chunks() ([]chunkInfo, error) {
+	var chunks []chunkInfo
+	var lastErr error
+	var failed int
+
+	for _, p := range w.paths {
+		flist, err := ioutil.ReadDir(p.path)
+		if err != nil {
+			w.logger.Error("ReadDir failed", zap.String("path", p.path), zap.Error(err))
+			p.fail()
+			lastErr = err
+			failed++
+			continue
+		}
+
+		for _, f := range flist {
+			if f.IsDir() {
+				continue
+			}
+			if !strings.HasPrefix(f.Name(), "default.") {
+				continue
+			}
+			chunks = append(chunks, chunkInfo{dir: p.path, name: f.Name(), size: f.Size(), modTime: f.ModTime()})
+		}
+	}
+
+	if failed == len(w.paths) {
+		return nil, lastErr
+	}
+
+	// names contains creation time in nanoseconds
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].name < chunks[j].name })
+
+	return chunks, nil
+}
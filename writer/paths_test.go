@@ -0,0 +1,95 @@
+package writer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/config"
+	"github.com/lomik/carbon-clickhouse/state"
+)
+
+func TestChoosePath(t *testing.T) {
+	assert := assert.New(t)
+
+	w := New(nil, "", 0, config.NewChunkAutoInterval(), config.CompAlgoNone, 0, nil, []string{"points"}, nil,
+		DataPaths([]string{"/data1", "/data2", "/data3"}, PlacementRoundRobin),
+		State(state.NewSymlink("/data1", []string{"points"})),
+	)
+	assert.Equal("/data1", w.path)
+
+	var chosen []string
+	for i := 0; i < 4; i++ {
+		chosen = append(chosen, w.choosePath().path)
+	}
+	assert.Equal([]string{"/data1", "/data2", "/data3", "/data1"}, chosen)
+
+	// failed and full directories are skipped
+	w.paths[1].fail()
+	w.paths[2].full = 1
+	for i := 0; i < 3; i++ {
+		assert.Equal("/data1", w.choosePath().path)
+	}
+
+	// full directory is used if others are full or failed
+	w.paths[0].full = 1
+	assert.NotEqual("/data2", w.choosePath().path)
+
+	// failed directory is retried after pause
+	w.paths[1].failed = time.Now().Add(-dataPathRetry).UnixNano()
+	assert.Equal("/data2", w.choosePath().path)
+}
+
+func TestWriterDataPaths(t *testing.T) {
+	root := t.TempDir()
+	dirs := []string{filepath.Join(root, "data1"), filepath.Join(root, "data2")}
+	uploaders := []string{"points"}
+
+	// chunks left from previous run
+	for i, d := range dirs {
+		require.NoError(t, os.MkdirAll(d, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(d, fmt.Sprintf("default.100000000%d", i)), []byte("x"), 0644))
+	}
+
+	st, err := state.NewMulti(state.BackendSymlink, dirs, uploaders)
+	require.NoError(t, err)
+
+	in := make(chan *RowBinary.WriteBuffer)
+	autoInterval := config.NewChunkAutoInterval()
+	autoInterval.SetDefault(time.Hour)
+
+	w := New(in, dirs[0], 0, autoInterval, config.CompAlgoNone, 0, nil, uploaders, nil,
+		DataPaths(dirs, PlacementFreeSpace),
+		Threads(2),
+		State(st),
+	)
+	require.NoError(t, w.Start())
+
+	// pre-existing chunks of all directories are linked
+	pending, err := st.Pending("points")
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.Equal(t, filepath.Join(dirs[0], "points", "default.1000000000"), pending[0].Filename)
+	assert.Equal(t, filepath.Join(dirs[1], "points", "default.1000000001"), pending[1].Filename)
+
+	for _, c := range pending {
+		require.NoError(t, st.Done(c.Name, "points"))
+	}
+	require.NoError(t, w.Cleanup())
+
+	w.Stop()
+
+	files, err := filepath.Glob(filepath.Join(root, "data*", "default.*"))
+	require.NoError(t, err)
+	// uploaded chunks are removed, chunks of workers are written
+	assert.Len(t, files, 2)
+	for _, fn := range files {
+		assert.NotContains(t, fn, "default.100000000")
+	}
+}
@@ -0,0 +1,243 @@
+package writer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/lomik/carbon-clickhouse/state"
+)
+
+const (
+	// OverflowBackpressure stops reading from receivers until disk usage is back under limits
+	OverflowBackpressure = "backpressure"
+	// OverflowDropOldest removes oldest finished chunks (data loss is logged)
+	OverflowDropOldest = "drop-oldest"
+)
+
+type chunkInfo struct {
+	dir     string
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+// DiskQuota creates option for New constructor. Zero limit is disabled
+func DiskQuota(maxDiskUsage, minFreeSpace int64, policy string) Option {
+	return func(w *Writer) {
+		w.maxDiskUsage = maxDiskUsage
+		w.minFreeSpace = minFreeSpace
+		w.overflowPolicy = policy
+	}
+}
+
+func freeSpace(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(uint64(st.Bavail) * uint64(st.Bsize)), nil
+}
+
+// pendingChunks counts chunks not uploaded yet by every uploader
+func (w *Writer) pendingChunks() map[string]int {
+	pending := make(map[string]int, len(w.uploaders))
+	for _, t := range w.uploaders {
+		chunks, err := w.state.Pending(t)
+		if err != nil {
+			continue
+		}
+		pending[t] = len(chunks)
+	}
+	return pending
+}
+
+// dropChunk removes chunk with all links of uploaders
+func (w *Writer) dropChunk(c chunkInfo) error {
+	notUploaded := make([]string, 0, len(w.uploaders))
+	for _, t := range w.uploaders {
+		if w.state.Status(c.name, t) == state.Pending {
+			notUploaded = append(notUploaded, t)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(c.dir, c.name)); err != nil {
+		return err
+	}
+
+	if err := w.state.Remove(c.name); err != nil {
+		w.logger.Error("remove status of chunk failed", zap.String("filename", c.name), zap.Error(err))
+	}
+
+	atomic.AddUint32(&w.stat.lostChunks, 1)
+	atomic.AddUint64(&w.stat.lostBytes, uint64(c.size))
+
+	w.logger.Error("chunk dropped by disk quota, data lost",
+		zap.String("filename", filepath.Join(c.dir, c.name)),
+		zap.Int64("size", c.size),
+		zap.Time("modified", c.modTime),
+		zap.Strings("not_uploaded", notUploaded),
+	)
+
+	return nil
+}
+
+// checkDisk updates disk usage stats and applies overflow policy on limits breach.
+// max-disk-usage is applied to sum of all data directories, min-free-space to every directory.
+// New chunks are placed to other directories while min-free-space of directory is breached,
+// overflow is reported if all directories are full
+func (w *Writer) checkDisk() {
+	chunks, err := w.chunks()
+	if err != nil {
+		return
+	}
+
+	var usage int64
+	for _, c := range chunks {
+		usage += c.size
+	}
+
+	// free space of available directories
+	free := make(map[string]int64, len(w.paths))
+	if w.minFreeSpace > 0 {
+		for _, p := range w.paths {
+			f, err := freeSpace(p.path)
+			if err != nil {
+				w.logger.Error("statfs failed", zap.String("path", p.path), zap.Error(err))
+				p.fail()
+				continue
+			}
+			free[p.path] = f
+		}
+		if len(free) == 0 {
+			return
+		}
+	}
+
+	full := func(dir string) bool {
+		f, ok := free[dir]
+		return w.minFreeSpace > 0 && ok && f < w.minFreeSpace
+	}
+
+	allFull := func() bool {
+		if w.minFreeSpace == 0 {
+			return false
+		}
+		for dir := range free {
+			if !full(dir) {
+				return false
+			}
+		}
+		return true
+	}
+
+	exceeded := func(dir string) bool {
+		return (w.maxDiskUsage > 0 && usage > w.maxDiskUsage) || full(dir)
+	}
+
+	anyExceeded := func() bool {
+		if w.maxDiskUsage > 0 && usage > w.maxDiskUsage {
+			return true
+		}
+		for dir := range free {
+			if full(dir) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if anyExceeded() && w.overflowPolicy == OverflowDropOldest {
+		kept := chunks[:0]
+		for _, c := range chunks {
+			if !exceeded(c.dir) || w.IsInProgress(filepath.Join(c.dir, c.name)) {
+				kept = append(kept, c)
+				continue
+			}
+			if err := w.dropChunk(c); err != nil {
+				w.logger.Error("chunk drop failed", zap.String("filename", c.name), zap.Error(err))
+				kept = append(kept, c)
+				continue
+			}
+			usage -= c.size
+			if _, ok := free[c.dir]; ok {
+				free[c.dir] += c.size
+			}
+		}
+		chunks = kept
+	}
+
+	for _, p := range w.paths {
+		var f uint32
+		if full(p.path) {
+			f = 1
+		}
+		if prev := atomic.SwapUint32(&p.full, f); prev != f && len(w.paths) > 1 {
+			if f == 1 {
+				w.logger.Warn("min-free-space breached, new chunks are placed to other directories",
+					zap.String("path", p.path),
+					zap.Int64("free", free[p.path]),
+				)
+			} else {
+				w.logger.Info("min-free-space restored", zap.String("path", p.path), zap.Int64("free", free[p.path]))
+			}
+		}
+	}
+
+	var totalFree int64
+	for _, f := range free {
+		totalFree += f
+	}
+
+	var oldestAge int64
+	if len(chunks) > 0 {
+		oldestAge = int64(time.Since(chunks[0].modTime).Seconds())
+	}
+
+	pending := w.pendingChunks()
+
+	w.Lock()
+	w.pending = pending
+	w.Unlock()
+
+	atomic.StoreInt64(&w.stat.bytesOnDisk, usage)
+	atomic.StoreInt64(&w.stat.oldestChunkAge, oldestAge)
+
+	var overflow uint32
+	if ((w.maxDiskUsage > 0 && usage > w.maxDiskUsage) || allFull()) && w.overflowPolicy == OverflowBackpressure {
+		overflow = 1
+	}
+
+	if prev := atomic.SwapUint32(&w.stat.overflow, overflow); prev != overflow {
+		if overflow == 1 {
+			w.logger.Warn("disk quota exceeded, stop receiving",
+				zap.Int64("usage", usage),
+				zap.Int64("free", totalFree),
+			)
+		} else {
+			w.logger.Info("disk usage under quota, continue receiving",
+				zap.Int64("usage", usage),
+				zap.Int64("free", totalFree),
+			)
+		}
+	}
+}
+
+func (w *Writer) diskWatcher(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.checkDisk()
+		}
+	}
+}
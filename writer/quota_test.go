@@ -0,0 +1,62 @@
+package writer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lomik/carbon-clickhouse/helper/config"
+)
+
+func newQuotaTestWriter(t *testing.T, policy string) *Writer {
+	dir := t.TempDir()
+
+	for _, fn := range []string{"default.1", "default.2", "default.3"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, fn), make([]byte, 1000), 0644))
+	}
+
+	w := New(nil, dir, 0, config.NewChunkAutoInterval(), config.CompAlgoNone, 0, nil, []string{"points", "index"}, nil,
+		DiskQuota(2500, 0, policy),
+	)
+	require.NoError(t, w.LinkAll())
+	// uploaded by index
+	require.NoError(t, os.Rename(filepath.Join(dir, "index", "default.1"), filepath.Join(dir, "index", "_default.1")))
+
+	return w
+}
+
+func TestDiskQuotaDropOldest(t *testing.T) {
+	w := newQuotaTestWriter(t, OverflowDropOldest)
+	w.inProgress[filepath.Join(w.path, "default.3")] = true
+
+	w.checkDisk()
+
+	_, err := os.Stat(filepath.Join(w.path, "default.1"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Lstat(filepath.Join(w.path, "index", "_default.1"))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Equal(t, int64(2000), w.stat.bytesOnDisk)
+	assert.Equal(t, uint32(1), w.stat.lostChunks)
+	assert.Equal(t, uint32(0), w.stat.overflow)
+	assert.Equal(t, map[string]int{"points": 2, "index": 2}, w.pending)
+}
+
+func TestDiskQuotaBackpressure(t *testing.T) {
+	w := newQuotaTestWriter(t, OverflowBackpressure)
+
+	w.checkDisk()
+	assert.Equal(t, int64(3000), w.stat.bytesOnDisk)
+	assert.Equal(t, uint32(1), w.stat.overflow)
+	assert.Equal(t, map[string]int{"points": 3, "index": 2}, w.pending)
+
+	require.NoError(t, os.Remove(filepath.Join(w.path, "default.1")))
+
+	w.checkDisk()
+	assert.Equal(t, int64(2000), w.stat.bytesOnDisk)
+	assert.Equal(t, uint32(0), w.stat.overflow)
+	assert.Equal(t, uint32(0), w.stat.lostChunks)
+}
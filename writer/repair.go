@@ -0,0 +1,63 @@
+package writer
+
+import (
+	"path/filepath"
+
+	"go.uber.org/zap"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/state"
+)
+
+// RepairOnStart creates option for New constructor
+func RepairOnStart(enabled bool) Option {
+	return func(w *Writer) {
+		w.repairOnStart = enabled
+	}
+}
+
+// isLinked checks chunk is linked to any uploader
+func (w *Writer) isLinked(name string) bool {
+	for _, t := range w.uploaders {
+		if w.state.Status(name, t) != state.NotLinked {
+			return true
+		}
+	}
+	return false
+}
+
+// repairUnlinked repairs chunks not linked to uploaders. Those are chunks written at the moment
+// of shutdown or crash. Must be called before LinkAll
+func (w *Writer) repairUnlinked() {
+	chunks, err := w.chunks()
+	if err != nil {
+		return
+	}
+
+	for _, c := range chunks {
+		if w.isLinked(c.name) {
+			continue
+		}
+
+		filename := filepath.Join(c.dir, c.name)
+		report, err := RowBinary.RepairEncryptedFile(filename, w.encryptionKey)
+		if err != nil {
+			w.logger.Error("chunk repair failed", zap.String("filename", filename), zap.Error(err))
+			continue
+		}
+		if !report.Damaged() {
+			continue
+		}
+
+		w.logger.Warn("damaged chunk repaired",
+			zap.String("filename", filename),
+			zap.String("output", report.Output),
+			zap.Uint64("recovered", report.Recovered),
+			zap.Uint64("corrupted_regions", report.CorruptedRegions),
+			zap.Uint64("lost_bytes", report.LostBytes),
+			zap.Int("corrupted_blocks", report.CorruptedBlocks),
+			zap.Bool("truncated", report.Truncated),
+			zap.String("read_error", report.ReadError),
+		)
+	}
+}
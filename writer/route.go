@@ -0,0 +1,270 @@
+package writer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pierrec/lz4"
+	"go.uber.org/zap"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+)
+
+const (
+	// MetricPlain matches metrics without tags
+	MetricPlain = "plain"
+	// MetricTagged matches tagged metrics (name?tag=value&...)
+	MetricTagged = "tagged"
+)
+
+var routeNameRe = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_-]*$`)
+
+// Route writes matched records to separate chunks, linked only to route uploaders.
+// All specified conditions must match
+type Route struct {
+	Name      string
+	Uploaders []string
+	// MetricType is MetricPlain, MetricTagged or empty for any
+	MetricType string
+	// Prefix of path (name for tagged metric)
+	Prefix string
+	// Glob of path (name for tagged metric) with *, ? and [...], wildcards don't match dot
+	Glob string
+	// Tag of tagged metric in "key=value" form
+	Tag string
+
+	glob string
+	tag  []byte
+}
+
+// NewRoute validates route
+func NewRoute(name string, uploaders []string, metricType, prefix, glob, tag string) (*Route, error) {
+	if !routeNameRe.MatchString(name) {
+		return nil, fmt.Errorf("invalid route name %#v", name)
+	}
+	switch "." + name {
+	case lz4.Extension, RowBinary.ZstdExtension, RowBinary.SnappyExtension:
+		return nil, fmt.Errorf("invalid route name %#v", name)
+	}
+	if len(uploaders) == 0 {
+		return nil, fmt.Errorf("route %#v: uploaders list is empty", name)
+	}
+
+	switch metricType {
+	case "", MetricPlain, MetricTagged:
+	default:
+		return nil, fmt.Errorf("route %#v: unknown metric type %#v", name, metricType)
+	}
+
+	r := &Route{
+		Name:       name,
+		Uploaders:  uploaders,
+		MetricType: metricType,
+		Prefix:     prefix,
+		Glob:       glob,
+		Tag:        tag,
+	}
+
+	if glob != "" {
+		// dots are path separators for path.Match, so wildcards match one node
+		r.glob = strings.ReplaceAll(glob, ".", "/")
+		if _, err := path.Match(r.glob, ""); err != nil {
+			return nil, fmt.Errorf("route %#v: invalid glob %#v: %s", name, glob, err.Error())
+		}
+	}
+
+	if tag != "" {
+		if strings.IndexByte(tag, '=') < 1 {
+			return nil, fmt.Errorf("route %#v: tag must be in key=value form", name)
+		}
+		r.tag = []byte(tag)
+	}
+
+	return r, nil
+}
+
+// Match checks record name
+func (r *Route) Match(name []byte) bool {
+	p := bytes.IndexByte(name, '?')
+	metric := name
+	if p >= 0 {
+		metric = name[:p]
+	}
+
+	switch r.MetricType {
+	case MetricPlain:
+		if p >= 0 {
+			return false
+		}
+	case MetricTagged:
+		if p < 0 {
+			return false
+		}
+	}
+
+	if r.Prefix != "" && !bytes.HasPrefix(metric, []byte(r.Prefix)) {
+		return false
+	}
+
+	if r.glob != "" {
+		if ok, _ := path.Match(r.glob, strings.ReplaceAll(string(metric), ".", "/")); !ok {
+			return false
+		}
+	}
+
+	if r.tag != nil {
+		if p < 0 {
+			return false
+		}
+		found := false
+		for tags := name[p+1:]; len(tags) > 0 && !found; {
+			var t []byte
+			if i := bytes.IndexByte(tags, '&'); i >= 0 {
+				t, tags = tags[:i], tags[i+1:]
+			} else {
+				t, tags = tags, nil
+			}
+			found = bytes.Equal(t, r.tag)
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Routes creates option for New constructor. Records are written to first matched route,
+// unmatched records to default chunks linked to all uploaders
+func Routes(routes ...*Route) Option {
+	return func(w *Writer) {
+		w.routes = routes
+	}
+}
+
+// stream is sequence of chunks linked to same uploaders
+type stream struct {
+	route     string
+	uploaders []string
+	in        chan *RowBinary.WriteBuffer
+	shards    []shardStat
+}
+
+// routeOf returns route name of chunk. Chunk name is default.<time>[.<from>-<until>][.<route>][.<shard>][.<extension>]
+func (w *Writer) routeOf(name string) *Route {
+	if len(w.routes) == 0 {
+		return nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(name, "default."), ".", 4)
+	if len(parts) > 2 {
+		if _, _, ok := parseWindow(parts[1]); ok {
+			parts = parts[1:]
+		}
+	}
+	if len(parts) < 2 {
+		return nil
+	}
+	for _, r := range w.routes {
+		if r.Name == parts[1] {
+			return r
+		}
+	}
+	return nil
+}
+
+// chunkUploaders returns uploaders of chunk. Chunks of unknown (removed) route are linked to all uploaders
+func (w *Writer) chunkUploaders(name string) []string {
+	if r := w.routeOf(name); r != nil {
+		return r.Uploaders
+	}
+	return w.uploaders
+}
+
+// routeIndex returns index of stream for record
+func (w *Writer) routeIndex(name []byte) int {
+	for i, r := range w.routes {
+		if r.Match(name) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// split sends records of buffer to streams
+func (w *Writer) split(ctx context.Context, b *RowBinary.WriteBuffer) {
+	body := b.Body[:b.Used]
+
+	first := -1
+	parts := make([]*RowBinary.WriteBuffer, len(w.streams))
+
+	for offset := 0; offset < len(body); {
+		namelen, n := binary.Uvarint(body[offset:])
+		end := offset + n + int(namelen) + 18
+		if n <= 0 || end > len(body) {
+			w.logger.Error("invalid record in write buffer, rest of buffer dropped", zap.Int("offset", offset))
+			break
+		}
+
+		idx := w.routeIndex(body[offset+n : offset+n+int(namelen)])
+		if first == -1 {
+			first = idx
+		}
+		if idx != first && parts[first] == nil {
+			// buffer has records of several routes
+			parts[first] = b.GetPart()
+			parts[first].Write(body[:offset])
+		}
+		if idx != first || parts[first] != nil {
+			if parts[idx] == nil {
+				parts[idx] = b.GetPart()
+			}
+			parts[idx].Write(body[offset:end])
+		}
+
+		offset = end
+	}
+
+	if first == -1 {
+		first = 0
+	}
+	if parts[first] == nil {
+		// fast path, all records of one route
+		parts[first] = b
+	} else {
+		if b.ConfirmRequired() {
+			b.Confirm()
+		}
+		b.Release()
+	}
+
+	for i, p := range parts {
+		if p == nil {
+			continue
+		}
+		select {
+		case w.streams[i].in <- p:
+		case <-ctx.Done():
+			if p.ConfirmRequired() {
+				p.Fail(ctx.Err())
+			}
+			p.Release()
+		}
+	}
+}
+
+// router reads input and splits buffers by routes
+func (w *Writer) router(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case b := <-w.inputChan:
+			w.split(ctx, b)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package writer
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/config"
+)
+
+func TestRouteMatch(t *testing.T) {
+	tests := []struct {
+		metricType, prefix, glob, tag string
+		name                          string
+		want                          bool
+	}{
+		{MetricPlain, "", "", "", "a.b.c", true},
+		{MetricPlain, "", "", "", "cpu?host=a", false},
+		{MetricTagged, "", "", "", "cpu?host=a", true},
+		{MetricTagged, "", "", "", "a.b.c", false},
+		{"", "a.b.", "", "", "a.b.c", true},
+		{"", "a.b.", "", "", "a.bc", false},
+		{"", "", "a.*.c", "", "a.b.c", true},
+		{"", "", "a.*.c", "", "a.b.d.c", false},
+		{"", "", "cpu", "", "cpu?host=a", true},
+		{"", "", "", "dc=eu", "cpu?dc=eu&host=a", true},
+		{"", "", "", "dc=eu", "cpu?dc=eu1&host=a", false},
+		{"", "", "", "host=a", "cpu?dc=eu&host=a", true},
+		{"", "", "", "host=a", "a.b.c", false},
+		{MetricTagged, "cpu", "", "dc=eu", "cpu_load?dc=eu", true},
+		{MetricTagged, "mem", "", "dc=eu", "cpu_load?dc=eu", false},
+	}
+
+	for _, tt := range tests {
+		r, err := NewRoute("test", []string{"points"}, tt.metricType, tt.prefix, tt.glob, tt.tag)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, r.Match([]byte(tt.name)), "%+v", tt)
+	}
+
+	for _, name := range []string{"", "1", "lz4", "a.b"} {
+		_, err := NewRoute(name, []string{"points"}, "", "", "", "")
+		assert.Error(t, err, name)
+	}
+}
+
+func readNames(t *testing.T, filename string) []string {
+	r, err := RowBinary.NewReader(filename, false)
+	require.NoError(t, err)
+	defer r.Close()
+
+	var names []string
+	for {
+		name, err := r.ReadRecord()
+		if err != nil {
+			break
+		}
+		names = append(names, string(name))
+	}
+	return names
+}
+
+func TestWriterRoutes(t *testing.T) {
+	dir := t.TempDir()
+	in := make(chan *RowBinary.WriteBuffer)
+
+	autoInterval := config.NewChunkAutoInterval()
+	autoInterval.SetDefault(time.Hour)
+
+	plain, err := NewRoute("plain", []string{"points", "index"}, MetricPlain, "", "", "")
+	require.NoError(t, err)
+	tagged, err := NewRoute("tagged", []string{"points", "tagged"}, MetricTagged, "", "", "")
+	require.NoError(t, err)
+
+	w := New(in, dir, 0, autoInterval, config.CompAlgoNone, 0, nil, []string{"points", "index", "tagged"}, nil,
+		Routes(plain, tagged),
+	)
+	require.NoError(t, w.Start())
+
+	wg := new(sync.WaitGroup)
+	errorChan := make(chan error, 1)
+
+	wb := RowBinary.GetWriterBufferWithConfirm(wg, errorChan)
+	wb.WriteGraphitePoint([]byte("a.b.c"), 1, 1559465760, 1559465760)
+	wb.WriteGraphitePoint([]byte("cpu?host=a"), 2, 1559465760, 1559465760)
+	wb.WriteGraphitePoint([]byte("a.b.d"), 3, 1559465760, 1559465760)
+	in <- wb
+
+	// fast path, one route
+	wb = RowBinary.GetWriterBufferWithConfirm(wg, errorChan)
+	wb.WriteGraphitePoint([]byte("mem?host=a"), 4, 1559465760, 1559465760)
+	in <- wb
+
+	wg.Wait()
+	select {
+	case err := <-errorChan:
+		t.Fatal(err)
+	default:
+	}
+
+	w.Stop()
+	require.NoError(t, w.LinkAll())
+
+	files, err := filepath.Glob(filepath.Join(dir, "default.*"))
+	require.NoError(t, err)
+	sort.Strings(files)
+
+	byRoute := make(map[string][]string)
+	for _, fn := range files {
+		name := filepath.Base(fn)
+		route := ""
+		if r := w.routeOf(name); r != nil {
+			route = r.Name
+		}
+		byRoute[route] = append(byRoute[route], readNames(t, fn)...)
+
+		for _, u := range []string{"points", "index", "tagged"} {
+			_, err := os.Lstat(filepath.Join(dir, u, name))
+			linked := err == nil
+			switch route {
+			case "plain":
+				assert.Equal(t, u != "tagged", linked, "%s %s", name, u)
+			case "tagged":
+				assert.Equal(t, u != "index", linked, "%s %s", name, u)
+			default:
+				assert.True(t, linked, "%s %s", name, u)
+			}
+		}
+	}
+
+	assert.Equal(t, []string{"a.b.c", "a.b.d"}, byRoute["plain"])
+	assert.Equal(t, []string{"cpu?host=a", "mem?host=a"}, byRoute["tagged"])
+	assert.Empty(t, byRoute[""])
+}
@@ -0,0 +1,62 @@
+package writer
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+type shardStat struct {
+	writtenBytes uint32
+	chunks       uint32
+}
+
+// Threads creates option for New constructor. Every worker writes own chunk with own rotation
+func Threads(n int) Option {
+	return func(w *Writer) {
+		w.threads = n
+	}
+}
+
+// chunkName returns name of new chunk: default.<time>[.<from>-<until>][.<route>][.<shard>]<extension>.
+// Suffix of shard prevents name collision of concurrent workers, names are still sorted by creation time.
+// Window from-until is added for aligned chunks only
+func (w *Writer) chunkName(t time.Time, from, until time.Time, s *stream, shard int, extension string) string {
+	name := fmt.Sprintf("default.%d", t.UnixNano())
+	if !from.IsZero() {
+		name += "." + formatWindow(from, until)
+	}
+	if s.route != "" {
+		name += "." + s.route
+	}
+	if len(s.shards) > 1 {
+		name += fmt.Sprintf(".%d", shard)
+	}
+	return name + extension
+}
+
+func (w *Writer) shardStat(send func(metric string, value float64)) {
+	for _, s := range w.streams {
+		prefix := ""
+		if s.route != "" {
+			prefix = fmt.Sprintf("route.%s.", s.route)
+		}
+
+		var writtenBytes, chunks uint32
+		for i := range s.shards {
+			b := atomic.SwapUint32(&s.shards[i].writtenBytes, 0)
+			c := atomic.SwapUint32(&s.shards[i].chunks, 0)
+			writtenBytes += b
+			chunks += c
+			if len(s.shards) > 1 {
+				send(fmt.Sprintf("%sshard.%d.writtenBytes", prefix, i), float64(b))
+				send(fmt.Sprintf("%sshard.%d.chunks", prefix, i), float64(c))
+			}
+		}
+
+		if s.route != "" {
+			send(prefix+"writtenBytes", float64(writtenBytes))
+			send(prefix+"chunks", float64(chunks))
+		}
+	}
+}
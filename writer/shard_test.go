@@ -0,0 +1,66 @@
+package writer
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
+	"github.com/lomik/carbon-clickhouse/helper/config"
+)
+
+func TestWriterThreads(t *testing.T) {
+	dir := t.TempDir()
+	in := make(chan *RowBinary.WriteBuffer)
+
+	autoInterval := config.NewChunkAutoInterval()
+	autoInterval.SetDefault(time.Hour)
+
+	w := New(in, dir, 0, autoInterval, config.CompAlgoNone, 0, nil, []string{"points"}, nil,
+		Threads(4),
+	)
+	require.NoError(t, w.Start())
+
+	var size int64
+	for i := 0; i < 1000; i++ {
+		wb := RowBinary.GetWriteBuffer()
+		wb.WriteGraphitePoint([]byte("hello.world"), float64(i), 1559465760, 1559465760)
+		size += int64(wb.Len())
+		in <- wb
+	}
+
+	// every worker has own chunk
+	assert.Eventually(t, func() bool {
+		w.RLock()
+		defer w.RUnlock()
+		return len(w.inProgress) == 4
+	}, time.Second, 10*time.Millisecond)
+
+	w.Stop()
+
+	files, err := filepath.Glob(filepath.Join(dir, "default.*"))
+	require.NoError(t, err)
+	require.Equal(t, 4, len(files))
+
+	var written int64
+	for _, fn := range files {
+		assert.Regexp(t, regexp.MustCompile(`/default\.[0-9]+\.[0-3]$`), fn)
+		st, err := os.Stat(fn)
+		require.NoError(t, err)
+		written += st.Size()
+	}
+	assert.Equal(t, size, written)
+
+	stat := make(map[string]float64)
+	w.Stat(func(metric string, value float64) { stat[metric] = value })
+	var shardBytes float64
+	for i := 0; i < 4; i++ {
+		shardBytes += stat["shard."+string(rune('0'+i))+".writtenBytes"]
+	}
+	assert.Equal(t, float64(size), shardBytes)
+}
@@ -11,9 +11,12 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
 	"github.com/lomik/carbon-clickhouse/helper/RowBinary"
 	"github.com/lomik/carbon-clickhouse/helper/config"
 	"github.com/lomik/carbon-clickhouse/helper/stop"
+	"github.com/lomik/carbon-clickhouse/state"
 	"github.com/lomik/zapwriter"
 	"github.com/pierrec/lz4"
 	"go.uber.org/zap"
@@ -30,26 +33,65 @@ type Writer struct {
 	stop.Struct
 	sync.RWMutex
 	stat struct {
-		writtenBytes  uint32
-		unhandled     uint32
-		chunkInterval uint32
+		writtenBytes   uint32
+		unhandled      uint32
+		chunkInterval  uint32
+		bytesOnDisk    int64
+		oldestChunkAge int64
+		lostChunks     uint32
+		lostBytes      uint64
+		overflow       uint32
+		syncs          uint32
+		syncErrors     uint32
+		syncTime       uint64 // microseconds
+		syncTimeMax    uint64 // microseconds
+		archived       uint32
+		archiveErrors  uint32
 	}
-	inputChan    chan *RowBinary.WriteBuffer
-	path         string
-	maxSize      int64
-	autoInterval *config.ChunkAutoInterval
-	compAlgo     config.CompAlgo
-	compLevel    int
-	lz4Header    lz4.Header
-	inProgress   map[string]bool // current writing files
-	logger       *zap.Logger
-	uploaders    []string
-	onFinish     func(string) error
+	inputChan     chan *RowBinary.WriteBuffer
+	path          string
+	paths         []*dataPath // data directories, path is first
+	placement     string
+	archive       archiveConfig
+	nextPath      uint32 // atomic, round-robin placement
+	maxSize       int64
+	autoInterval  *config.ChunkAutoInterval
+	compAlgo      config.CompAlgo
+	compLevel     int
+	compDict      []byte
+	chunkFormat   string
+	encryptionKey *RowBinary.EncryptionKey
+	lz4Header     lz4.Header
+	zstdOptions   []zstd.EOption
+	inProgress    map[string]bool // current writing files
+	logger        *zap.Logger
+	uploaders     []string
+	onFinish      func(string) error
+	// disk quota
+	maxDiskUsage   int64
+	minFreeSpace   int64
+	overflowPolicy string
+	pending        map[string]int // not uploaded chunks count by uploader
+	fsync          string
+	fsyncInterval  time.Duration
+	repairOnStart  bool
+	align          bool
+	threads        int
+	routes         []*Route
+	streams        []*stream // default stream and streams of routes
+	fastPaths      map[string]FastPath
+	notifiers      map[string]Notifier
+	state          state.State
 }
 
-func New(in chan *RowBinary.WriteBuffer, path string, switchSize int64, autoInterval *config.ChunkAutoInterval, compAlgo config.CompAlgo, compLevel int, uploaders []string, onFinish func(string) error) *Writer {
+// Option for New constructor
+type Option func(w *Writer)
+
+func New(in chan *RowBinary.WriteBuffer, path string, switchSize int64, autoInterval *config.ChunkAutoInterval, compAlgo config.CompAlgo, compLevel int, compDict []byte, uploaders []string, onFinish func(string) error, opts ...Option) *Writer {
+	var wr *Writer
+
 	finishCallback := func(fn string) error {
-		if err := Link(fn, uploaders); err != nil {
+		if err := wr.link(fn); err != nil {
 			return err
 		}
 
@@ -60,25 +102,80 @@ func New(in chan *RowBinary.WriteBuffer, path string, switchSize int64, autoInte
 		return nil
 	}
 
-	wr := &Writer{
+	wr = &Writer{
 		inputChan:    in,
 		path:         path,
 		maxSize:      switchSize,
 		autoInterval: autoInterval,
 		compAlgo:     compAlgo,
 		compLevel:    compLevel,
+		compDict:     compDict,
 		inProgress:   make(map[string]bool),
 		logger:       zapwriter.Logger("writer"),
 		uploaders:    uploaders,
 		onFinish:     finishCallback,
 	}
 
+	for _, o := range opts {
+		o(wr)
+	}
+
+	if len(wr.paths) == 0 {
+		wr.paths = []*dataPath{{path: path}}
+	}
+
+	if wr.state == nil && len(wr.paths) > 1 {
+		paths := make([]string, 0, len(wr.paths))
+		for _, p := range wr.paths {
+			paths = append(paths, p.path)
+		}
+		if st, err := state.NewMulti(state.BackendSymlink, paths, uploaders); err == nil {
+			wr.state = st
+		} else {
+			wr.logger.Error("state open failed", zap.Error(err))
+		}
+	}
+
+	if wr.state == nil {
+		wr.state = state.NewSymlink(wr.path, uploaders)
+	}
+
+	if wr.threads < 1 {
+		wr.threads = 1
+	}
+
+	wr.streams = []*stream{{uploaders: uploaders, in: in, shards: make([]shardStat, wr.threads)}}
+	if len(wr.routes) > 0 {
+		// router splits input
+		wr.streams[0].in = make(chan *RowBinary.WriteBuffer)
+		for _, r := range wr.routes {
+			wr.streams = append(wr.streams, &stream{
+				route:     r.Name,
+				uploaders: r.Uploaders,
+				in:        make(chan *RowBinary.WriteBuffer),
+				shards:    make([]shardStat, wr.threads),
+			})
+		}
+	}
+
 	switch compAlgo {
 	case config.CompAlgoLZ4:
 		wr.lz4Header = lz4.Header{
 			BlockMaxSize:     4 << 20,
 			CompressionLevel: compLevel,
 		}
+	case config.CompAlgoZstd:
+		level := zstd.SpeedDefault
+		if compLevel > 0 {
+			level = zstd.EncoderLevelFromZstd(compLevel)
+		}
+		wr.zstdOptions = []zstd.EOption{
+			zstd.WithEncoderLevel(level),
+			zstd.WithEncoderConcurrency(1),
+		}
+		if len(compDict) > 0 {
+			wr.zstdOptions = append(wr.zstdOptions, zstd.WithEncoderDict(compDict))
+		}
 	}
 
 	return wr
@@ -86,6 +183,9 @@ func New(in chan *RowBinary.WriteBuffer, path string, switchSize int64, autoInte
 
 func (w *Writer) Start() error {
 	return w.StartFunc(func() error {
+		if w.repairOnStart {
+			w.repairUnlinked()
+		}
 		// link pre-existing files
 		if err := w.LinkAll(); err != nil {
 			return err
@@ -93,12 +193,31 @@ func (w *Writer) Start() error {
 		if err := w.Cleanup(); err != nil {
 			return err
 		}
-		w.Go(w.worker)
+		w.checkDisk()
+		for _, s := range w.streams {
+			for i := range s.shards {
+				s, shard := s, i
+				w.Go(func(ctx context.Context) {
+					w.worker(ctx, s, shard)
+				})
+			}
+		}
+		if len(w.routes) > 0 {
+			for i := 0; i < w.threads; i++ {
+				w.Go(w.router)
+			}
+		}
 		w.Go(w.cleaner)
+		w.Go(w.diskWatcher)
 		return nil
 	})
 }
 
+// Unhandled returns count of finished chunks not removed yet
+func (w *Writer) Unhandled() int {
+	return int(atomic.LoadUint32(&w.stat.unhandled))
+}
+
 func (w *Writer) Stat(send func(metric string, value float64)) {
 	writtenBytes := atomic.LoadUint32(&w.stat.writtenBytes)
 	atomic.AddUint32(&w.stat.writtenBytes, -writtenBytes)
@@ -106,6 +225,35 @@ func (w *Writer) Stat(send func(metric string, value float64)) {
 
 	send("unhandled", float64(atomic.LoadUint32(&w.stat.unhandled)))
 	send("chunkInterval_s", float64(atomic.LoadUint32(&w.stat.chunkInterval)))
+
+	send("bytesOnDisk", float64(atomic.LoadInt64(&w.stat.bytesOnDisk)))
+	send("oldestChunkAge_s", float64(atomic.LoadInt64(&w.stat.oldestChunkAge)))
+	send("overflow", float64(atomic.LoadUint32(&w.stat.overflow)))
+	send("lostChunks", float64(atomic.SwapUint32(&w.stat.lostChunks, 0)))
+	send("lostBytes", float64(atomic.SwapUint64(&w.stat.lostBytes, 0)))
+	if w.archive.path != "" {
+		send("archived", float64(atomic.SwapUint32(&w.stat.archived, 0)))
+		send("archiveErrors", float64(atomic.SwapUint32(&w.stat.archiveErrors, 0)))
+	}
+
+	syncs := atomic.SwapUint32(&w.stat.syncs, 0)
+	syncTime := atomic.SwapUint64(&w.stat.syncTime, 0)
+	send("syncs", float64(syncs))
+	send("syncErrors", float64(atomic.SwapUint32(&w.stat.syncErrors, 0)))
+	if syncs > 0 {
+		send("syncTimeAvg_ms", float64(syncTime)/float64(syncs)/1000.0)
+	} else {
+		send("syncTimeAvg_ms", 0)
+	}
+	send("syncTimeMax_ms", float64(atomic.SwapUint64(&w.stat.syncTimeMax, 0))/1000.0)
+
+	w.shardStat(send)
+
+	w.RLock()
+	for t, n := range w.pending {
+		send(fmt.Sprintf("pending.%s", t), float64(n))
+	}
+	w.RUnlock()
 }
 
 func (w *Writer) IsInProgress(filename string) bool {
@@ -115,30 +263,73 @@ func (w *Writer) IsInProgress(filename string) bool {
 	return v
 }
 
-func (w *Writer) worker(ctx context.Context) {
+func (w *Writer) worker(ctx context.Context, s *stream, shard int) {
+	logger := w.logger
+	if s.route != "" {
+		logger = logger.With(zap.String("route", s.route))
+	}
+	if len(s.shards) > 1 {
+		logger = logger.With(zap.Int("shard", shard))
+	}
+	stat := &s.shards[shard]
+
 	var out *os.File
 	var cwr compWriter
 	var outBuf *bufio.Writer
 	var fn string // current filename
 	var size int64
 	var start time.Time
+	var from, until time.Time // window of aligned chunk
 	var chunkInterval time.Duration
+	var lastSync time.Time
+	var fast []FastStream // fast path uploads of current chunk
 
 	cwrClose := func() {
 		if cwr != nil {
 			if err := cwr.Close(); err != nil {
-				w.logger.Error("CompWriter close failed", zap.Error(err))
+				logger.Error("CompWriter close failed", zap.Error(err))
 			}
 		}
 	}
 
+	outSync := func() error {
+		err := w.syncFile(out)
+		if err != nil {
+			logger.Error("fsync failed", zap.String("filename", fn), zap.Error(err))
+		}
+		lastSync = time.Now()
+		return err
+	}
+
+	outClose := func() {
+		outBuf.Flush()
+		cwrClose()
+		if w.fsyncEnabled() {
+			outSync()
+		}
+		out.Close()
+	}
+
+	// fsync current chunk by interval
+	intervalSync := func() {
+		if w.fsync != FsyncInterval || out == nil || time.Since(lastSync) < w.fsyncInterval {
+			return
+		}
+		outBuf.Flush()
+		if cwr != nil {
+			cwr.Flush()
+		}
+		outSync()
+	}
+
 	defer func() {
+		// chunk is linked on next start
+		closeStreams(fast, false)
+
 		if out != nil {
-			outBuf.Flush()
-			cwrClose()
-			out.Close()
+			outClose()
 
-			w.logger.Info("chunk switched", zap.String("filename", fn), zap.Int64("size", size))
+			logger.Info("chunk switched", zap.String("filename", fn), zap.Int64("size", size))
 		}
 	}()
 
@@ -151,24 +342,27 @@ func (w *Writer) worker(ctx context.Context) {
 				u := int(atomic.LoadUint32(&w.stat.unhandled))
 				interval := w.autoInterval.GetInterval(u)
 				if interval != prevInterval {
-					w.logger.Info("chunk interval changed", zap.String("interval", interval.String()))
+					logger.Info("chunk interval changed", zap.String("interval", interval.String()))
 					prevInterval = interval
 					atomic.StoreUint32(&w.stat.chunkInterval, uint32(interval.Seconds()))
 				}
 
 				chunkInterval = now.Sub(start)
-				if chunkInterval < interval {
+				if w.align {
+					if now.Before(until) {
+						return
+					}
+				} else if chunkInterval < interval {
 					return
 				}
 			} else {
 				chunkInterval = time.Since(start)
 			}
 
-			outBuf.Flush()
-			cwrClose()
-			out.Close()
+			outClose()
 
-			w.logger.Info("chunk switched", zap.String("filename", fn), zap.Int64("size", size), zap.Float64("time", float64(chunkInterval.Nanoseconds())/1000000000.0))
+			logger.Info("chunk switched", zap.String("filename", fn), zap.Int64("size", size), zap.Float64("time", float64(chunkInterval.Nanoseconds())/1000000000.0))
+			atomic.AddUint32(&stat.chunks, 1)
 
 			out = nil
 			cwr = nil
@@ -180,37 +374,57 @@ func (w *Writer) worker(ctx context.Context) {
 
 	OpenLoop:
 		for {
-			go func(filename string) {
+			go func(filename string, streams []FastStream) {
 				if filename == "" || w.onFinish == nil {
+					closeStreams(streams, false)
 					return
 				}
 
-				err = w.onFinish(filename)
+				err := w.onFinish(filename)
 				if err != nil {
-					w.logger.Error("onFinish callback failed", zap.String("filename", filename), zap.Error(err))
+					logger.Error("onFinish callback failed", zap.String("filename", filename), zap.Error(err))
 				}
-			}(fn)
+				closeStreams(streams, err == nil)
+			}(fn, fast)
+			fast = nil
 
 			// replace fn in inProgress
 			w.Lock()
 			delete(w.inProgress, fn)
 
 			var fileExtension string
-			switch w.compAlgo {
-			case config.CompAlgoLZ4:
+			switch {
+			case w.chunkFormat == ChunkFormatV2:
+				// compression is described in header
+			case w.compAlgo == config.CompAlgoLZ4:
 				fileExtension = lz4.Extension
+			case w.compAlgo == config.CompAlgoZstd:
+				fileExtension = RowBinary.ZstdExtension
+			case w.compAlgo == config.CompAlgoSnappy:
+				fileExtension = RowBinary.SnappyExtension
 			}
 
-			fn = path.Join(w.path, fmt.Sprintf("default.%d%s", time.Now().UnixNano(), fileExtension))
+			now := time.Now()
+			if w.align {
+				from, until = alignWindow(now, w.autoInterval.GetInterval(int(atomic.LoadUint32(&w.stat.unhandled))))
+			}
+			dp := w.choosePath()
+			fn = path.Join(dp.path, w.chunkName(now, from, until, s, shard, fileExtension))
 			w.inProgress[fn] = true
 			w.Unlock()
 
-			out, err = os.OpenFile(fn, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+			if w.chunkFormat == ChunkFormatV2 {
+				// header is rewritten on close, O_APPEND forbids WriteAt
+				out, err = os.OpenFile(fn, os.O_CREATE|os.O_WRONLY, 0644)
+			} else {
+				out, err = os.OpenFile(fn, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+			}
 
 			start = time.Now()
 
 			if err != nil {
-				w.logger.Error("create failed", zap.String("filename", fn), zap.Error(err))
+				logger.Error("create failed", zap.String("filename", fn), zap.Error(err))
+				dp.fail()
 
 				// check exit channel
 				select {
@@ -219,24 +433,84 @@ func (w *Writer) worker(ctx context.Context) {
 				default:
 				}
 
+				if w.hasHealthyPath() {
+					// try other data directory
+					continue OpenLoop
+				}
+
 				// try and spam to error log every second
 				time.Sleep(time.Second)
 
 				continue OpenLoop
 			}
 
+			if w.fsyncEnabled() {
+				// directory entry of new chunk
+				if err := w.syncDir(dp.path); err != nil {
+					logger.Error("fsync directory failed", zap.String("path", dp.path), zap.Error(err))
+				}
+			}
+			lastSync = start
+
 			var wr io.Writer
-			switch w.compAlgo {
-			case config.CompAlgoNone:
+			switch {
+			case w.chunkFormat == ChunkFormatV2:
+				var cw *RowBinary.ChunkWriter
+				cw, err = RowBinary.NewEncryptedChunkWriter(out, w.compAlgo, w.compLevel, w.compDict, w.encryptionKey)
+				if err != nil {
+					logger.Error("chunk writer create failed", zap.String("filename", fn), zap.Error(err))
+					out.Close()
+					out = nil
+					os.Remove(fn)
+
+					select {
+					case <-ctx.Done():
+						break OpenLoop
+					default:
+					}
+
+					time.Sleep(time.Second)
+
+					continue OpenLoop
+				}
+				cwr = cw
+				wr = cw
+			case w.compAlgo == config.CompAlgoNone:
 				wr = out
-			case config.CompAlgoLZ4:
+			case w.compAlgo == config.CompAlgoLZ4:
 				lz4w := lz4.NewWriter(out)
 				lz4w.Header = w.lz4Header
 				cwr = lz4w
 				wr = lz4w
+			case w.compAlgo == config.CompAlgoZstd:
+				var zw *zstd.Encoder
+				zw, err = zstd.NewWriter(out, w.zstdOptions...)
+				if err != nil {
+					logger.Error("zstd writer create failed", zap.String("filename", fn), zap.Error(err))
+					out.Close()
+					out = nil
+					os.Remove(fn)
+
+					select {
+					case <-ctx.Done():
+						break OpenLoop
+					default:
+					}
+
+					time.Sleep(time.Second)
+
+					continue OpenLoop
+				}
+				cwr = zw
+				wr = zw
+			case w.compAlgo == config.CompAlgoSnappy:
+				sw := snappy.NewBufferedWriter(out)
+				cwr = sw
+				wr = sw
 			}
 
 			outBuf = bufio.NewWriterSize(wr, 1024*1024)
+			fast = w.openStreams(fn)
 			break OpenLoop
 		}
 	}
@@ -264,11 +538,27 @@ func (w *Writer) worker(ctx context.Context) {
 
 	write := func(b *RowBinary.WriteBuffer) {
 		_, err := outBuf.Write(b.Body[:b.Used])
+		if len(fast) > 0 {
+			if err != nil {
+				// stream must not contain data missing in chunk
+				closeStreams(fast, false)
+				fast = nil
+			} else {
+				fast = writeStreams(fast, b.Body[:b.Used])
+			}
+		}
 		if b.ConfirmRequired() {
 			if err != nil {
 				b.Fail(err)
 			} else {
 				err := outBuf.Flush()
+				if err == nil && cwr != nil {
+					// data must leave compressor buffer
+					err = cwr.Flush()
+				}
+				if err == nil && w.fsync == FsyncOnConfirm {
+					err = outSync()
+				}
 				if err != nil {
 					b.Fail(err)
 				} else {
@@ -279,15 +569,30 @@ func (w *Writer) worker(ctx context.Context) {
 		// @TODO: log error?
 		size += int64(b.Used)
 		atomic.AddUint32(&w.stat.writtenBytes, uint32(b.Used))
+		atomic.AddUint32(&stat.writtenBytes, uint32(b.Used))
 		b.Release()
 	}
 
 	for {
+		if atomic.LoadUint32(&w.stat.overflow) != 0 {
+			// backpressure: don't read input until disk usage under quota
+			outBuf.Flush()
+			select {
+			case <-tickerC:
+				rotateCheck()
+				intervalSync()
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
 		select {
-		case b := <-w.inputChan:
+		case b := <-s.in:
 			write(b)
 		case <-tickerC:
 			rotateCheck()
+			intervalSync()
 		case <-ctx.Done():
 			return
 		default: // outBuf flush if nothing received
@@ -295,15 +600,16 @@ func (w *Writer) worker(ctx context.Context) {
 
 			if cwr != nil {
 				if err := cwr.Flush(); err != nil {
-					w.logger.Error("CompWriter Flush() failed", zap.Error(err))
+					logger.Error("CompWriter Flush() failed", zap.Error(err))
 				}
 			}
 
 			select {
-			case b := <-w.inputChan:
+			case b := <-s.in:
 				write(b)
 			case <-tickerC:
 				rotateCheck()
+				intervalSync()
 			case <-ctx.Done():
 				return
 			}
@@ -321,6 +627,7 @@ func (w *Writer) cleaner(ctx context.Context) {
 			return
 		case <-ticker.C:
 			w.Cleanup()
+			w.cleanupArchive()
 		}
 	}
 }